@@ -0,0 +1,411 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package admin implements a web console for operating a Twister
+// application.
+//
+// The console ties together the operational features of the other Twister
+// packages: login with the auth package, a dashboard with server statistics,
+// the application's route table, admin sessions and the maintenance and
+// request recording switches from the web package. Changes made through the
+// console are recorded with the audit package.
+//
+// Mount the console in front of the application's router. Wrap the router,
+// not the console, with the recorder so that console requests, including
+// login passwords, are not recorded:
+//
+//  r := web.NewRouter()
+//  ...
+//  m := web.NewMaintenance(&web.MaintenanceOptions{Allow: []string{"/admin/"}})
+//  rec := web.NewRecorder(100, 4096)
+//  c := admin.New(&admin.Options{
+//      Users:       map[string]string{"root": rootPasswordHash},
+//      Secret:      secret,
+//      Router:      r,
+//      Maintenance: m,
+//      Recorder:    rec,
+//  })
+//  server.Run(":8080", m.Filter(c.Filter(rec.Filter(r))))
+package admin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/garyburd/twister/audit"
+	"github.com/garyburd/twister/auth"
+	"github.com/garyburd/twister/expvar"
+	"github.com/garyburd/twister/flags"
+	"github.com/garyburd/twister/web"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"template"
+	"time"
+)
+
+// Options configures the console.
+type Options struct {
+	// Path where the console is mounted. The default is "/admin".
+	Prefix string
+
+	// Users maps user names to password hashes created with
+	// auth.HashPassword. The application is required to set this field.
+	Users map[string]string
+
+	// Secret used to sign session cookies. The application is required to
+	// set this field.
+	Secret string
+
+	// Maximum age of a session in seconds. The default is 8 hours.
+	SessionMaxAge int
+
+	// Store for the login throttle. The default is an in-memory store.
+	LockoutStore auth.LockoutStore
+
+	// Router with the application's routes for the route table. Optional.
+	Router *web.Router
+
+	// Maintenance and Recorder are switched from the dashboard. Optional.
+	Maintenance *web.Maintenance
+	Recorder    *web.Recorder
+
+	// Feature flags served at the "flags" path. Optional.
+	Flags *flags.Set
+}
+
+// Session describes a logged in console user.
+type Session struct {
+	ID         string
+	User       string
+	RemoteAddr string
+	Created    int64
+}
+
+// Console is the admin console.
+type Console struct {
+	options Options
+	router  *web.Router
+	h       web.Handler
+	start   int64
+
+	// dummyHash is checked for unknown users so that the response time does
+	// not reveal whether a user exists.
+	dummyHash string
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+const (
+	sessionCookieName = "admin"
+	sessionEnvKey     = "twister.admin.session"
+	maxFormLen        = 16384
+)
+
+// New returns a new console.
+func New(options *Options) *Console {
+	if options.Users == nil || options.Secret == "" {
+		panic("twister.admin: New requires Users and Secret options")
+	}
+	c := &Console{
+		options:  *options,
+		router:   web.NewRouter(),
+		start:    web.Seconds(),
+		sessions: make(map[string]*Session),
+	}
+	if c.options.Prefix == "" {
+		c.options.Prefix = "/admin"
+	}
+	c.options.Prefix = strings.TrimRight(c.options.Prefix, "/")
+	if c.options.SessionMaxAge <= 0 {
+		c.options.SessionMaxAge = 8 * 60 * 60
+	}
+	// An error leaves dummyHash empty. CheckPassword rejects the empty hash.
+	c.dummyHash, _ = auth.HashPassword(web.RandomHex(16))
+	if c.options.LockoutStore == nil {
+		c.options.LockoutStore = auth.NewMemoryLockoutStore()
+	}
+
+	p := c.options.Prefix
+	login := auth.ThrottleHandler(&auth.ThrottleOptions{
+		Store:   c.options.LockoutStore,
+		Account: func(req *web.Request) string { return req.Param.Get("user") },
+	}, web.HandlerFunc(c.serveLogin))
+
+	c.router.Register(p+"/login", "GET", web.HandlerFunc(c.serveLoginForm), "POST", login)
+	c.router.Register(p+"/logout", "POST", c.protect(web.HandlerFunc(c.serveLogout)))
+	c.router.Register(p+"/", "GET", c.protect(web.HandlerFunc(c.serveDashboard)))
+	c.router.Register(p+"/stats", "GET", c.protect(web.HandlerFunc(expvar.ServeWeb)))
+	c.router.Register(p+"/routes", "GET", c.protect(web.HandlerFunc(c.serveRoutes)))
+	c.router.Register(p+"/sessions", "GET", c.protect(web.HandlerFunc(c.serveSessions)),
+		"POST", c.protect(c.audit("admin.session.revoke", web.HandlerFunc(c.serveRevoke))))
+	if c.options.Maintenance != nil {
+		c.router.Register(p+"/maintenance", "POST", c.protect(c.audit("admin.maintenance", web.HandlerFunc(c.serveMaintenance))))
+	}
+	if c.options.Recorder != nil {
+		c.router.Register(p+"/recorder", "GET", c.protect(c.options.Recorder),
+			"POST", c.protect(c.audit("admin.recorder", web.HandlerFunc(c.serveRecorder))))
+	}
+	if c.options.Flags != nil {
+		c.router.Register(p+"/flags", "GET", c.protect(c.options.Flags),
+			"POST", c.protect(c.audit("admin.flags", c.options.Flags)))
+	}
+	c.h = web.FormHandler(maxFormLen, true, c.router)
+	return c
+}
+
+// ServeWeb serves the console.
+func (c *Console) ServeWeb(req *web.Request) {
+	c.h.ServeWeb(req)
+}
+
+// Filter returns a handler that serves requests for paths below the console
+// prefix with the console and all other requests with h.
+func (c *Console) Filter(h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		if strings.HasPrefix(req.URL.Path, c.options.Prefix+"/") {
+			c.ServeWeb(req)
+		} else {
+			h.ServeWeb(req)
+		}
+	})
+}
+
+// Sessions returns the current sessions ordered by creation time.
+func (c *Console) Sessions() []*Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	result := make([]*Session, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		result = append(result, s)
+	}
+	sort.Sort(byCreated(result))
+	return result
+}
+
+// Revoke ends the session with the given id.
+func (c *Console) Revoke(id string) {
+	c.mu.Lock()
+	c.sessions[id] = nil, false
+	c.mu.Unlock()
+}
+
+type byCreated []*Session
+
+func (s byCreated) Len() int           { return len(s) }
+func (s byCreated) Less(i, j int) bool { return s[i].Created < s[j].Created }
+func (s byCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// expire removes expired sessions. The caller must hold c.mu.
+func (c *Console) expire() {
+	limit := web.Seconds() - int64(c.options.SessionMaxAge)
+	for id, s := range c.sessions {
+		if s.Created < limit {
+			c.sessions[id] = nil, false
+		}
+	}
+}
+
+func (c *Console) newSession(req *web.Request, user string) (*Session, os.Error) {
+	p := make([]byte, 16)
+	if err := web.ReadRandom(p); err != nil {
+		return nil, err
+	}
+	s := &Session{ID: hex.EncodeToString(p), User: user, RemoteAddr: req.RemoteAddr, Created: web.Seconds()}
+	c.mu.Lock()
+	c.expire()
+	c.sessions[s.ID] = s
+	c.mu.Unlock()
+	return s, nil
+}
+
+func (c *Console) session(req *web.Request) *Session {
+	id, err := web.VerifyValue(c.options.Secret, sessionCookieName, req.Cookie.Get(sessionCookieName))
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	return c.sessions[id]
+}
+
+func (c *Console) sessionCookie(value string) *web.Cookie {
+	return web.NewCookie(sessionCookieName, value).Path(c.options.Prefix + "/")
+}
+
+// protect returns a handler that calls h for requests with a valid session.
+// Other requests are redirected to the login page.
+func (c *Console) protect(h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		s := c.session(req)
+		if s == nil {
+			if req.Method != "GET" && req.Method != "HEAD" {
+				req.Error(web.StatusForbidden, os.NewError("twister.admin: not logged in"))
+				return
+			}
+			req.Redirect(c.options.Prefix+"/login", false)
+			return
+		}
+		req.Env[sessionEnvKey] = s
+		h.ServeWeb(req)
+	})
+}
+
+func (c *Console) audit(action string, h web.Handler) web.Handler {
+	return audit.Handler(action, func(req *web.Request) string {
+		if s, ok := req.Env[sessionEnvKey].(*Session); ok {
+			return s.User
+		}
+		return ""
+	}, h)
+}
+
+func (c *Console) render(req *web.Request, status int, t *template.Template, data map[string]interface{}) {
+	data["prefix"] = c.options.Prefix
+	data["xsrf"] = req.Param.Get(web.XSRFParamName)
+	data["user"] = ""
+	if s, ok := req.Env[sessionEnvKey].(*Session); ok {
+		data["user"] = s.User
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	w := req.Respond(status,
+		web.HeaderContentType, "text/html; charset=utf-8",
+		web.HeaderContentLength, strconv.Itoa(b.Len()))
+	w.Write(b.Bytes())
+}
+
+func (c *Console) serveLoginForm(req *web.Request) {
+	c.render(req, web.StatusOK, loginTemplate, map[string]interface{}{"error": ""})
+}
+
+func (c *Console) serveLogin(req *web.Request) {
+	user := req.Param.Get("user")
+	hash, ok := c.options.Users[user]
+	if !ok {
+		hash = c.dummyHash
+	}
+	if !auth.CheckPassword(req.Param.Get("password"), hash) || !ok {
+		c.render(req, web.StatusUnauthorized, loginTemplate, map[string]interface{}{"error": "Invalid user name or password."})
+		return
+	}
+	s, err := c.newSession(req, user)
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	value := web.SignValue(c.options.Secret, sessionCookieName, c.options.SessionMaxAge, s.ID)
+	req.Redirect(c.options.Prefix+"/", false,
+		web.HeaderSetCookie, c.sessionCookie(value).MaxAge(c.options.SessionMaxAge).String())
+}
+
+func (c *Console) serveLogout(req *web.Request) {
+	c.Revoke(req.Env[sessionEnvKey].(*Session).ID)
+	req.Redirect(c.options.Prefix+"/login", false,
+		web.HeaderSetCookie, c.sessionCookie("").Delete().String())
+}
+
+func (c *Console) serveDashboard(req *web.Request) {
+	data := map[string]interface{}{
+		"uptime":     web.FormatDeltaSeconds(int(web.Seconds() - c.start)),
+		"goroutines": runtime.Goroutines(),
+		"alloc":      runtime.MemStats.Alloc,
+		"sessions":   len(c.Sessions()),
+		// The template skips sections for switches set to false.
+		"maintenance": false,
+		"recorder":    false,
+	}
+	if m := c.options.Maintenance; m != nil {
+		enabled, message := m.Enabled()
+		data["maintenance"] = map[string]interface{}{"enabled": enabled, "message": message}
+	}
+	if rec := c.options.Recorder; rec != nil {
+		data["recorder"] = map[string]interface{}{"enabled": rec.Enabled(), "count": len(rec.Transcripts())}
+	}
+	c.render(req, web.StatusOK, dashboardTemplate, data)
+}
+
+func (c *Console) serveRoutes(req *web.Request) {
+	var routes []interface{}
+	if c.options.Router != nil {
+		for _, rd := range c.options.Router.Describe() {
+			methods := make([]string, len(rd.Methods))
+			for i, md := range rd.Methods {
+				methods[i] = md.Method
+			}
+			description := ""
+			if rd.Doc != nil {
+				description = rd.Doc.Description
+			}
+			routes = append(routes, map[string]interface{}{
+				"pattern":     rd.Pattern,
+				"methods":     strings.Join(methods, " "),
+				"description": description,
+			})
+		}
+	}
+	c.render(req, web.StatusOK, routesTemplate, map[string]interface{}{"routes": routes})
+}
+
+func (c *Console) serveSessions(req *web.Request) {
+	var sessions []interface{}
+	for _, s := range c.Sessions() {
+		sessions = append(sessions, map[string]interface{}{
+			"id":         s.ID,
+			"user":       s.User,
+			"remoteAddr": s.RemoteAddr,
+			"created":    time.SecondsToUTC(s.Created).Format(time.RFC3339),
+		})
+	}
+	c.render(req, web.StatusOK, sessionsTemplate, map[string]interface{}{"sessions": sessions})
+}
+
+func (c *Console) serveRevoke(req *web.Request) {
+	c.Revoke(req.Param.Get("id"))
+	req.Redirect(c.options.Prefix+"/sessions", false)
+}
+
+func (c *Console) serveMaintenance(req *web.Request) {
+	switch req.Param.Get("enabled") {
+	case "true":
+		c.options.Maintenance.SetEnabled(true, req.Param.Get("message"))
+	case "false":
+		c.options.Maintenance.SetEnabled(false, "")
+	default:
+		req.Error(web.StatusBadRequest, nil)
+		return
+	}
+	req.Redirect(c.options.Prefix+"/", false)
+}
+
+func (c *Console) serveRecorder(req *web.Request) {
+	switch req.Param.Get("enabled") {
+	case "true":
+		c.options.Recorder.SetEnabled(true)
+	case "false":
+		c.options.Recorder.SetEnabled(false)
+	}
+	if req.Param.Get("clear") != "" {
+		c.options.Recorder.Clear()
+	}
+	req.Redirect(c.options.Prefix+"/", false)
+}
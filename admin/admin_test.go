@@ -0,0 +1,88 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package admin
+
+import (
+	"github.com/garyburd/twister/auth"
+	"github.com/garyburd/twister/web"
+	"strings"
+	"testing"
+)
+
+const testToken = "abcd1234"
+
+func TestConsole(t *testing.T) {
+	hash, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := web.NewMaintenance(&web.MaintenanceOptions{})
+	c := New(&Options{
+		Users:       map[string]string{"root": hash},
+		Secret:      "test",
+		Maintenance: m,
+	})
+
+	run := func(method, url, cookie, body string) (int, web.Header, string) {
+		header := web.NewHeader(web.HeaderCookie, "xsrf="+testToken+cookie)
+		if method == "POST" {
+			header.Set(web.HeaderContentType, "application/x-www-form-urlencoded")
+			body = "xsrf=" + testToken + "&" + body
+		}
+		status, header, p := web.RunHandler("http://example.com"+url, method, header, []byte(body), c)
+		return status, header, string(p)
+	}
+
+	if status, header, _ := run("GET", "/admin/", "", ""); status != web.StatusFound || header.Get(web.HeaderLocation) != "/admin/login" {
+		t.Fatalf("dashboard without session = %d %q, want redirect to login", status, header.Get(web.HeaderLocation))
+	}
+	if status, _, _ := run("POST", "/admin/login", "", "user=root&password=wrong"); status != web.StatusUnauthorized {
+		t.Fatalf("login with bad password = %d, want %d", status, web.StatusUnauthorized)
+	}
+	if status, _, _ := run("POST", "/admin/login", "", "user=nobody&password="); status != web.StatusUnauthorized {
+		t.Fatalf("login with unknown user = %d, want %d", status, web.StatusUnauthorized)
+	}
+	status, header, _ := run("POST", "/admin/login", "", "user=root&password=secret")
+	if status != web.StatusFound {
+		t.Fatalf("login = %d, want %d", status, web.StatusFound)
+	}
+	cookie := header.Get(web.HeaderSetCookie)
+	if i := strings.Index(cookie, ";"); i >= 0 {
+		cookie = cookie[:i]
+	}
+	cookie = "; " + cookie
+
+	if status, _, body := run("GET", "/admin/", cookie, ""); status != web.StatusOK || !strings.Contains(body, "Maintenance mode") {
+		t.Fatalf("dashboard = %d %q", status, body)
+	}
+	if status, _, _ := run("POST", "/admin/maintenance", cookie, "enabled=true&message=upgrade"); status != web.StatusFound {
+		t.Fatalf("maintenance = %d, want %d", status, web.StatusFound)
+	}
+	if enabled, message := m.Enabled(); !enabled || message != "upgrade" {
+		t.Errorf("maintenance enabled = %v %q, want true \"upgrade\"", enabled, message)
+	}
+	if status, _, _ := run("POST", "/admin/maintenance", "", "enabled=false"); status != web.StatusForbidden {
+		t.Errorf("maintenance without session = %d, want %d", status, web.StatusForbidden)
+	}
+
+	sessions := c.Sessions()
+	if len(sessions) != 1 || sessions[0].User != "root" {
+		t.Fatalf("sessions = %v", sessions)
+	}
+	c.Revoke(sessions[0].ID)
+	if status, _, _ := run("GET", "/admin/", cookie, ""); status != web.StatusFound {
+		t.Errorf("dashboard after revoke = %d, want %d", status, web.StatusFound)
+	}
+}
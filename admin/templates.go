@@ -0,0 +1,75 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package admin
+
+import (
+	"template"
+)
+
+var fmap = template.FormatterMap{"": template.HTMLFormatter}
+
+const pageHeader = `<!DOCTYPE html>
+<html><head><title>Admin</title>
+<style>body{font-family:sans-serif} table{border-collapse:collapse} td,th{border:1px solid #ccc;padding:2px 6px;text-align:left}</style>
+</head><body>
+{.section user}<p><a href="{prefix}/">Dashboard</a> | <a href="{prefix}/routes">Routes</a> | <a href="{prefix}/sessions">Sessions</a> | <a href="{prefix}/stats">Stats</a>
+<form method="POST" action="{prefix}/logout" style="display:inline"><input type="hidden" name="xsrf" value="{xsrf}"> {@} <input type="submit" value="Log out"></form></p>{.end}
+`
+
+const pageFooter = `</body></html>
+`
+
+var loginTemplate = template.MustParse(pageHeader+`<h1>Log in</h1>
+{.section error}<p>{@}</p>{.end}
+<form method="POST" action="{prefix}/login">
+<input type="hidden" name="xsrf" value="{xsrf}">
+<p>User <input type="text" name="user"></p>
+<p>Password <input type="password" name="password"></p>
+<p><input type="submit" value="Log in"></p>
+</form>
+`+pageFooter, fmap)
+
+var dashboardTemplate = template.MustParse(pageHeader+`<h1>Dashboard</h1>
+<table>
+<tr><th>Uptime</th><td>{uptime}</td></tr>
+<tr><th>Goroutines</th><td>{goroutines}</td></tr>
+<tr><th>Allocated bytes</th><td>{alloc}</td></tr>
+<tr><th>Sessions</th><td>{sessions}</td></tr>
+</table>
+{.section maintenance}<h2>Maintenance mode</h2>
+<form method="POST" action="{prefix}/maintenance"><input type="hidden" name="xsrf" value="{xsrf}">
+{.section enabled}<p>Enabled: {message}</p><input type="hidden" name="enabled" value="false"><input type="submit" value="Disable">
+{.or}<p>Disabled</p><input type="hidden" name="enabled" value="true">Message <input type="text" name="message"> <input type="submit" value="Enable">{.end}
+</form>{.end}
+{.section recorder}<h2>Request recording</h2>
+<form method="POST" action="{prefix}/recorder"><input type="hidden" name="xsrf" value="{xsrf}">
+{.section enabled}<p>Enabled, {count} transcripts</p><input type="hidden" name="enabled" value="false"><input type="submit" value="Disable">
+{.or}<p>Disabled, {count} transcripts</p><input type="hidden" name="enabled" value="true"><input type="submit" value="Enable">{.end}
+<input type="submit" name="clear" value="Clear"> <a href="{prefix}/recorder">Transcripts</a>
+</form>{.end}
+`+pageFooter, fmap)
+
+var routesTemplate = template.MustParse(pageHeader+`<h1>Routes</h1>
+<table><tr><th>Pattern</th><th>Methods</th><th>Description</th></tr>
+{.repeated section routes}<tr><td>{pattern}</td><td>{methods}</td><td>{description}</td></tr>
+{.end}</table>
+`+pageFooter, fmap)
+
+var sessionsTemplate = template.MustParse(pageHeader+`<h1>Sessions</h1>
+<table><tr><th>User</th><th>Address</th><th>Created</th><th></th></tr>
+{.repeated section sessions}<tr><td>{user}</td><td>{remoteAddr}</td><td>{created}</td><td>
+<form method="POST" action="{prefix}/sessions"><input type="hidden" name="xsrf" value="{xsrf}"><input type="hidden" name="id" value="{id}"><input type="submit" value="Revoke"></form></td></tr>
+{.end}</table>
+`+pageFooter, fmap)
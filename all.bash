@@ -1,6 +1,6 @@
 #!/usr/bin/env bash
 
-for dir in web server oauth websocket expvar pprof examples/demo examples/twitter examples/facebook examples/wiki
+for dir in web config server oauth websocket expvar pprof webdav pubsub jwt blob thumbnail command vcr client trace proxy webhook mail audit auth flags admin useragent analytics sanitize markdown cdn s3 operation examples/demo examples/twitter examples/facebook examples/wiki
 do
     (cd $dir; pwd; make DEPS= $*)
 done
@@ -0,0 +1,323 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package analytics collects server-side analytics events.
+//
+// Handlers emit events to a Pipeline. The pipeline batches the events and
+// writes the batches to a sink from a background goroutine. When the queue is
+// full, new events are dropped so that a slow sink does not slow down
+// request handling.
+//
+//  p := analytics.New(&analytics.Options{Sink: analytics.CollectorSink("http://collector/events", nil)})
+//  defer p.Close()
+//  h := p.PageViewHandler(r)
+//
+//  func signupHandler(req *web.Request) {
+//      ...
+//      p.Track(req, "signup", "plan", plan)
+//  }
+package analytics
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/client"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"json"
+	"log"
+	"os"
+	"rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event is an analytics event.
+type Event struct {
+	// Time of the event in seconds since the epoch.
+	Time int64
+
+	// Name of the event, for example "pageview" or "signup".
+	Name string
+
+	Path       string
+	Referrer   string
+	UserAgent  string
+	RemoteAddr string
+	RequestID  string
+
+	// Event specific properties.
+	Properties map[string]string
+}
+
+func (e *Event) jsonValue() map[string]interface{} {
+	m := map[string]interface{}{
+		"time": e.Time,
+		"name": e.Name,
+	}
+	for k, v := range map[string]string{
+		"path":       e.Path,
+		"referrer":   e.Referrer,
+		"userAgent":  e.UserAgent,
+		"remoteAddr": e.RemoteAddr,
+		"requestId":  e.RequestID,
+	} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	if len(e.Properties) > 0 {
+		m["properties"] = e.Properties
+	}
+	return m
+}
+
+// Sink writes batches of events. Sinks are called from the pipeline's
+// background goroutine and must not retain the slice of events.
+type Sink interface {
+	Write(events []*Event) os.Error
+}
+
+// SinkFunc is a type adapter to allow the use of ordinary functions as Sink.
+type SinkFunc func(events []*Event) os.Error
+
+// Write calls f(events).
+func (f SinkFunc) Write(events []*Event) os.Error { return f(events) }
+
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WriterSink returns a sink that writes events to w as JSON objects, one per
+// line.
+func WriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Write(events []*Event) os.Error {
+	var b bytes.Buffer
+	for _, e := range events {
+		p, err := json.Marshal(e.jsonValue())
+		if err != nil {
+			return err
+		}
+		b.Write(p)
+		b.WriteByte('\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(b.Bytes())
+	return err
+}
+
+type collectorSink struct {
+	url    string
+	client *http.Client
+}
+
+// CollectorSink returns a sink that POSTs each batch to url as a JSON array.
+// Failed requests are retried by a client.Transport wrapping transport. If
+// transport is nil, http.DefaultTransport is used.
+func CollectorSink(url string, transport http.RoundTripper) Sink {
+	return &collectorSink{url: url, client: &http.Client{Transport: &client.Transport{Transport: transport, Timeout: 30e9}}}
+}
+
+func (s *collectorSink) Write(events []*Event) os.Error {
+	a := make([]interface{}, len(events))
+	for i, e := range events {
+		a[i] = e.jsonValue()
+	}
+	p, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewBuffer(p))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(web.HeaderContentType, "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return os.NewError("twister.analytics: collector status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// Options configures a Pipeline.
+type Options struct {
+	// Sink for the events. The application is required to set this field.
+	Sink Sink
+
+	// Fraction of events kept. The default is 1.
+	SampleRate float64
+
+	// Maximum number of queued events. Events emitted while the queue is
+	// full are dropped. The default is 10000.
+	QueueSize int
+
+	// Maximum number of events in a batch. The default is 100.
+	BatchSize int
+
+	// Maximum time in nanoseconds an event waits in a partial batch. The
+	// default is 10 seconds.
+	FlushInterval int64
+}
+
+// Pipeline batches events and writes them to a sink.
+type Pipeline struct {
+	dropped int64 // first for alignment of atomic operations
+	options Options
+	queue   chan *Event
+	done    chan bool
+	closed  chan bool
+}
+
+// New creates a pipeline and starts its background goroutine.
+func New(options *Options) *Pipeline {
+	if options.Sink == nil {
+		panic("twister.analytics: New requires Sink option")
+	}
+	p := &Pipeline{options: *options, done: make(chan bool), closed: make(chan bool)}
+	if p.options.SampleRate <= 0 || p.options.SampleRate > 1 {
+		p.options.SampleRate = 1
+	}
+	if p.options.QueueSize <= 0 {
+		p.options.QueueSize = 10000
+	}
+	if p.options.BatchSize <= 0 {
+		p.options.BatchSize = 100
+	}
+	if p.options.FlushInterval <= 0 {
+		p.options.FlushInterval = 10e9
+	}
+	p.queue = make(chan *Event, p.options.QueueSize)
+	go p.run()
+	return p
+}
+
+// Emit queues an event. Emit returns false if the event was dropped by
+// sampling or because the queue is full.
+func (p *Pipeline) Emit(e *Event) bool {
+	if p.options.SampleRate < 1 && rand.Float64() >= p.options.SampleRate {
+		return false
+	}
+	if e.Time == 0 {
+		e.Time = web.Seconds()
+	}
+	select {
+	case p.queue <- e:
+		return true
+	default:
+	}
+	atomic.AddInt64(&p.dropped, 1)
+	return false
+}
+
+// Track emits an event with the given name for the request. The properties
+// are specified as key-value pairs.
+func (p *Pipeline) Track(req *web.Request, name string, propertyKeysAndValues ...string) bool {
+	if len(propertyKeysAndValues)%2 == 1 {
+		panic("twister.analytics: even number of property keys and values required")
+	}
+	e := &Event{
+		Name:       name,
+		Path:       req.URL.Path,
+		Referrer:   req.Header.Get(web.HeaderReferer),
+		UserAgent:  req.Header.Get(web.HeaderUserAgent),
+		RemoteAddr: req.RemoteAddr,
+		RequestID:  web.RequestID(req),
+	}
+	if len(propertyKeysAndValues) > 0 {
+		e.Properties = make(map[string]string)
+		for i := 0; i < len(propertyKeysAndValues); i += 2 {
+			e.Properties[propertyKeysAndValues[i]] = propertyKeysAndValues[i+1]
+		}
+	}
+	return p.Emit(e)
+}
+
+// PageViewHandler returns a handler that emits a "pageview" event for each
+// GET request to h with a successful HTML response.
+func (p *Pipeline) PageViewHandler(h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		if req.Method == "GET" {
+			web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+				if status/100 == 2 && strings.HasPrefix(header.Get(web.HeaderContentType), "text/html") {
+					p.Track(req, "pageview")
+				}
+				return status, header
+			})
+		}
+		h.ServeWeb(req)
+	})
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (p *Pipeline) Dropped() int64 {
+	return atomic.AddInt64(&p.dropped, 0)
+}
+
+// Close writes the queued events to the sink and stops the pipeline.
+func (p *Pipeline) Close() {
+	close(p.done)
+	<-p.closed
+}
+
+func (p *Pipeline) write(batch []*Event) []*Event {
+	if len(batch) > 0 {
+		if err := p.options.Sink.Write(batch); err != nil {
+			log.Printf("twister.analytics: sink error %v, %d events lost", err, len(batch))
+		}
+	}
+	return batch[:0]
+}
+
+func (p *Pipeline) run() {
+	ticker := time.NewTicker(p.options.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*Event, 0, p.options.BatchSize)
+	for {
+		select {
+		case e := <-p.queue:
+			batch = append(batch, e)
+			if len(batch) >= p.options.BatchSize {
+				batch = p.write(batch)
+			}
+		case <-ticker.C:
+			batch = p.write(batch)
+		case <-p.done:
+			for {
+				select {
+				case e := <-p.queue:
+					batch = append(batch, e)
+					if len(batch) >= p.options.BatchSize {
+						batch = p.write(batch)
+					}
+				default:
+					p.write(batch)
+					close(p.closed)
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package analytics
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	var b bytes.Buffer
+	var batches []int
+	ws := WriterSink(&b)
+	p := New(&Options{
+		Sink: SinkFunc(func(events []*Event) os.Error {
+			batches = append(batches, len(events))
+			return ws.Write(events)
+		}),
+		BatchSize: 2,
+	})
+	h := p.PageViewHandler(web.HandlerFunc(func(req *web.Request) {
+		if req.URL.Path == "/signup" {
+			p.Track(req, "signup", "plan", "pro")
+		}
+		req.Respond(web.StatusOK, web.HeaderContentType, "text/html")
+	}))
+	web.RunHandler("http://example.com/", "GET", nil, nil, h)
+	web.RunHandler("http://example.com/signup", "POST", nil, nil, h)
+	web.RunHandler("http://example.com/about", "GET", nil, nil, h)
+	p.Close()
+
+	if len(batches) != 2 || batches[0] != 2 || batches[1] != 1 {
+		t.Errorf("batches = %v, want [2 1]", batches)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 ||
+		!strings.Contains(lines[0], `"name":"pageview"`) ||
+		!strings.Contains(lines[1], `"properties":{"plan":"pro"}`) ||
+		!strings.Contains(lines[2], `"path":"/about"`) {
+		t.Errorf("output = %q", b.String())
+	}
+}
+
+func TestPipelineDrop(t *testing.T) {
+	block := make(chan bool)
+	p := New(&Options{
+		Sink:      SinkFunc(func(events []*Event) os.Error { <-block; return nil }),
+		QueueSize: 1,
+		BatchSize: 1,
+	})
+	n := 0
+	for i := 0; i < 10; i++ {
+		if p.Emit(&Event{Name: "x"}) {
+			n++
+		}
+	}
+	if n >= 10 || p.Dropped() != int64(10-n) {
+		t.Errorf("accepted %d, dropped %d", n, p.Dropped())
+	}
+	close(block)
+	p.Close()
+}
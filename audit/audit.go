@@ -0,0 +1,185 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package audit records security relevant events.
+//
+// Events are passed to the sinks registered with AddSink. Register sinks
+// in the application's main function:
+//
+//  f, err := os.OpenFile("audit.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  audit.AddSink(audit.WriterSink(f))
+package audit
+
+import (
+	"github.com/garyburd/twister/web"
+	"github.com/garyburd/twister/webhook"
+	"io"
+	"json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event describes a security relevant event.
+type Event struct {
+	// Time of the event in seconds since the epoch.
+	Time int64
+
+	// The user or system component performing the action.
+	Actor string
+
+	// The action, for example "login" or "maintenance.enable".
+	Action string
+
+	// The object of the action, for example an account name.
+	Target string
+
+	// The result of the action, for example "success" or "failure".
+	Result string
+
+	RequestID  string
+	RemoteAddr string
+
+	// Additional information about the event.
+	Detail map[string]string
+}
+
+func (e *Event) jsonValue() map[string]interface{} {
+	m := map[string]interface{}{
+		"time":   time.SecondsToUTC(e.Time).Format(time.RFC3339),
+		"actor":  e.Actor,
+		"action": e.Action,
+		"target": e.Target,
+		"result": e.Result,
+	}
+	if e.RequestID != "" {
+		m["requestID"] = e.RequestID
+	}
+	if e.RemoteAddr != "" {
+		m["remoteAddr"] = e.RemoteAddr
+	}
+	if len(e.Detail) > 0 {
+		m["detail"] = e.Detail
+	}
+	return m
+}
+
+// Sink receives audit events.
+type Sink interface {
+	Audit(e *Event)
+}
+
+// SinkFunc is a type adapter to allow the use of ordinary functions as Sink.
+type SinkFunc func(e *Event)
+
+// Audit calls f(e).
+func (f SinkFunc) Audit(e *Event) { f(e) }
+
+var (
+	mutex sync.RWMutex
+	sinks []Sink
+)
+
+// AddSink adds a sink for events.
+func AddSink(s Sink) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sinks = append(sinks, s)
+}
+
+// Log passes the event to the registered sinks. The time is set if zero.
+func Log(e *Event) {
+	if e.Time == 0 {
+		e.Time = web.Seconds()
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, s := range sinks {
+		s.Audit(e)
+	}
+}
+
+// Record logs an event for the request. The request ID and remote address
+// are taken from the request.
+func Record(req *web.Request, actor, action, target, result string, detailKeysAndValues ...string) {
+	e := &Event{
+		Actor:      actor,
+		Action:     action,
+		Target:     target,
+		Result:     result,
+		RequestID:  web.RequestID(req),
+		RemoteAddr: req.RemoteAddr,
+	}
+	if len(detailKeysAndValues) > 0 {
+		e.Detail = make(map[string]string)
+		for i := 0; i+1 < len(detailKeysAndValues); i += 2 {
+			e.Detail[detailKeysAndValues[i]] = detailKeysAndValues[i+1]
+		}
+	}
+	Log(e)
+}
+
+// WriterSink returns a sink that writes events to w as JSON objects, one per
+// line. Use WriterSink with a file or a server.SyslogWriter.
+func WriterSink(w io.Writer) Sink {
+	var mu sync.Mutex
+	return SinkFunc(func(e *Event) {
+		p, err := json.Marshal(e.jsonValue())
+		if err != nil {
+			log.Print("twister.audit: encode failed: ", err)
+			return
+		}
+		p = append(p, '\n')
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(p)
+	})
+}
+
+// WebhookSink returns a sink that sends events to url using the dispatcher.
+// The webhook event name is "audit." followed by the action.
+func WebhookSink(d *webhook.Dispatcher, url string) Sink {
+	return SinkFunc(func(e *Event) {
+		if _, err := d.Send(url, "audit."+e.Action, e.jsonValue()); err != nil {
+			log.Print("twister.audit: webhook send failed: ", err)
+		}
+	})
+}
+
+// Handler returns a handler that records an event for each request to h
+// with a method other than GET or HEAD. The action of the event is the
+// given action, the actor is the value returned by actor and the result is
+// "success" or "failure" depending on the response status. Use Handler to
+// audit changes made through admin handlers.
+func Handler(action string, actor func(req *web.Request) string, h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		if req.Method == "GET" || req.Method == "HEAD" {
+			h.ServeWeb(req)
+			return
+		}
+		web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+			result := "success"
+			if status >= 400 {
+				result = "failure"
+			}
+			Record(req, actor(req), action, req.URL.Path, result, "method", req.Method, "status", strconv.Itoa(status))
+			return status, header
+		})
+		h.ServeWeb(req)
+	})
+}
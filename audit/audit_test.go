@@ -0,0 +1,45 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package audit
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var events []*Event
+	AddSink(SinkFunc(func(e *Event) { events = append(events, e) }))
+	var b bytes.Buffer
+	AddSink(WriterSink(&b))
+
+	h := Handler("maintenance", func(req *web.Request) string { return "admin" },
+		web.HandlerFunc(func(req *web.Request) { req.Respond(web.StatusOK) }))
+	web.RunHandler("http://example.com/admin/maintenance", "GET", nil, nil, h)
+	web.RunHandler("http://example.com/admin/maintenance", "POST", web.NewHeader("X-Request-Id", "r1"), nil, h)
+
+	if len(events) != 1 {
+		t.Fatalf("len(events) = %d, want 1", len(events))
+	}
+	e := events[0]
+	if e.Actor != "admin" || e.Action != "maintenance" || e.Target != "/admin/maintenance" || e.Result != "success" || e.RequestID != "r1" || e.Detail["method"] != "POST" {
+		t.Errorf("event = %+v", e)
+	}
+	if s := b.String(); !strings.Contains(s, `"action":"maintenance"`) || !strings.HasSuffix(s, "}\n") {
+		t.Errorf("writer sink output = %q", s)
+	}
+}
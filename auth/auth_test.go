@@ -0,0 +1,227 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"github.com/garyburd/twister/web"
+	"strings"
+	"testing"
+	"time"
+)
+
+var pbkdf2Tests = []struct {
+	password, salt string
+	iterations     int
+	key            string
+}{
+	{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+	{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+}
+
+func TestPBKDF2(t *testing.T) {
+	for _, tt := range pbkdf2Tests {
+		key := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations, 32))
+		if key != tt.key {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, key, tt.key)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	h := &PBKDF2Hasher{Iterations: 10}
+	hash, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Verify("secret", hash) {
+		t.Error("Verify(secret) = false")
+	}
+	if h.Verify("Secret", hash) {
+		t.Error("Verify(Secret) = true")
+	}
+	if h.NeedsRehash(hash) {
+		t.Error("NeedsRehash = true for same work factor")
+	}
+	if !(&PBKDF2Hasher{Iterations: 20}).NeedsRehash(hash) {
+		t.Error("NeedsRehash = false for different work factor")
+	}
+	for _, bad := range []string{
+		"pbkdf2-sha256$10$c2FsdA==$",
+		"pbkdf2-sha256$10$c2FsdA==$a2V5",
+		"pbkdf2-sha256$100000000$c2FsdA==$" + strings.Repeat("A", 43) + "=",
+	} {
+		if h.Verify("", bad) || h.Verify("secret", bad) {
+			t.Errorf("Verify(%q) = true", bad)
+		}
+	}
+}
+
+func TestResetToken(t *testing.T) {
+	secret := []byte("secret")
+	token := NewResetToken(secret, "user:1", "stamp", 60)
+	if id, err := ResetTokenUser(token); id != "user:1" || err != nil {
+		t.Errorf("ResetTokenUser = %q, %v", id, err)
+	}
+	if id, err := ValidateResetToken(secret, token, "stamp"); id != "user:1" || err != nil {
+		t.Errorf("ValidateResetToken = %q, %v", id, err)
+	}
+	if _, err := ValidateResetToken(secret, token, "newstamp"); err != ErrTokenInvalid {
+		t.Errorf("changed stamp, err = %v", err)
+	}
+	token = NewResetToken(secret, "user:1", "stamp", -1)
+	if _, err := ValidateResetToken(secret, token, "stamp"); err != ErrTokenExpired {
+		t.Errorf("expired, err = %v", err)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := &Lockout{Store: NewMemoryLockoutStore(), MaxFailures: 2}
+	for i := 0; i < 2; i++ {
+		if locked, _ := l.Locked("a"); locked {
+			t.Fatalf("locked after %d failures", i)
+		}
+		l.Fail("a")
+	}
+	if locked, _ := l.Locked("a"); !locked {
+		t.Error("not locked after 2 failures")
+	}
+	l.Reset("a")
+	if locked, _ := l.Locked("a"); locked {
+		t.Error("locked after reset")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// Test vectors from RFC 6238 truncated to six digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, tt := range []struct {
+		t    int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+	} {
+		code, err := TOTPCode(secret, tt.t)
+		if err != nil || code != tt.code {
+			t.Errorf("TOTPCode(%d) = %q, %v, want %q", tt.t, code, err, tt.code)
+		}
+	}
+
+	code, _ := TOTPCode(strings.ToLower(secret), time.Seconds()-30)
+	if !VerifyTOTP(secret, code, 1) {
+		t.Error("VerifyTOTP rejected code from previous period with skew 1")
+	}
+	if VerifyTOTP(secret, "000000x", 1) {
+		t.Error("VerifyTOTP accepted malformed code")
+	}
+}
+
+func TestSecondFactorHandler(t *testing.T) {
+	h := SecondFactorHandler(
+		func(req *web.Request) bool { return req.Param.Get("verified") == "1" },
+		"/2fa",
+		web.HandlerFunc(func(req *web.Request) { req.Respond(web.StatusOK) }))
+	if status, _, _ := web.RunHandler("http://example.com/a?verified=1", "GET", nil, nil, h); status != web.StatusOK {
+		t.Errorf("verified status = %d", status)
+	}
+	status, header, _ := web.RunHandler("http://example.com/a?b=c", "GET", nil, nil, h)
+	if status != web.StatusFound || header.Get(web.HeaderLocation) != "/2fa?next=%2Fa%3Fb%3Dc" {
+		t.Errorf("not verified status = %d, location = %q", status, header.Get(web.HeaderLocation))
+	}
+}
+
+func TestThrottleHandler(t *testing.T) {
+	h := ThrottleHandler(&ThrottleOptions{
+		Store:              NewMemoryLockoutStore(),
+		Account:            func(req *web.Request) string { return req.Param.Get("user") },
+		MaxAccountFailures: 2,
+		Delay:              1,
+	}, web.HandlerFunc(func(req *web.Request) {
+		if req.Param.Get("password") == "secret" {
+			req.Respond(web.StatusOK)
+		} else {
+			req.Respond(web.StatusUnauthorized)
+		}
+	}))
+	for i, tt := range []struct {
+		password string
+		status   int
+	}{
+		{"bad", web.StatusUnauthorized},
+		{"secret", web.StatusOK},
+		{"bad", web.StatusUnauthorized},
+		{"bad", web.StatusUnauthorized},
+		{"secret", web.StatusTooManyRequests},
+	} {
+		status, _, _ := web.RunHandler("http://example.com/login?user=a&password="+tt.password, "POST", nil, nil, h)
+		if status != tt.status {
+			t.Errorf("%d: status = %d, want %d", i, status, tt.status)
+		}
+	}
+}
+
+func TestThrottleConcurrent(t *testing.T) {
+	entered := make(chan bool)
+	done := make(chan bool)
+	h := ThrottleHandler(&ThrottleOptions{
+		Store:              NewMemoryLockoutStore(),
+		Account:            func(req *web.Request) string { return "a" },
+		MaxAccountFailures: 2,
+		Delay:              1,
+	}, web.HandlerFunc(func(req *web.Request) {
+		entered <- true
+		<-done
+		req.Respond(web.StatusUnauthorized)
+	}))
+
+	const n = 5
+	results := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			status, _, _ := web.RunHandler("http://example.com/login", "POST", nil, nil, h)
+			results <- status
+		}()
+	}
+
+	// Attempts beyond the limit are rejected while the first attempts are
+	// still in progress.
+	nentered := 0
+	for nrejected := 0; nrejected < n-2; {
+		select {
+		case <-entered:
+			nentered += 1
+		case status := <-results:
+			if status != web.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", status, web.StatusTooManyRequests)
+			}
+			nrejected += 1
+		case <-time.After(5e9):
+			t.Fatalf("timeout, entered = %d, rejected = %d", nentered, nrejected)
+		}
+	}
+	for nentered < 2 {
+		<-entered
+		nentered += 1
+	}
+	close(done)
+	for i := 0; i < 2; i++ {
+		if status := <-results; status != web.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", status, web.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"github.com/garyburd/twister/web"
+	"os"
+	"sync"
+)
+
+// LockoutStore stores failed login counters.
+type LockoutStore interface {
+	// Get returns the number of consecutive failures for key and the time
+	// of the last failure in seconds since the epoch.
+	Get(key string) (failures int, last int64, err os.Error)
+
+	// Set stores the counter for key. A zero failure count clears the
+	// counter.
+	Set(key string, failures int, last int64) os.Error
+}
+
+type lockoutRecord struct {
+	failures int
+	last     int64
+}
+
+type memoryLockoutStore struct {
+	mu sync.Mutex
+	m  map[string]lockoutRecord
+}
+
+// NewMemoryLockoutStore returns a LockoutStore that keeps counters in
+// memory. The counters are not shared between processes.
+func NewMemoryLockoutStore() LockoutStore {
+	return &memoryLockoutStore{m: make(map[string]lockoutRecord)}
+}
+
+func (s *memoryLockoutStore) Get(key string) (int, int64, os.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.m[key]
+	return r.failures, r.last, nil
+}
+
+func (s *memoryLockoutStore) Set(key string, failures int, last int64) os.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if failures == 0 {
+		s.m[key] = lockoutRecord{}, false
+	} else {
+		s.m[key] = lockoutRecord{failures, last}
+	}
+	return nil
+}
+
+// Lockout locks accounts after repeated login failures.
+//
+//  if locked, _ := lockout.Locked(username); locked {
+//      // reject
+//  }
+//  if !auth.CheckPassword(password, user.PasswordHash) {
+//      lockout.Fail(username)
+//      // reject
+//  }
+//  lockout.Reset(username)
+type Lockout struct {
+	// Store for the counters. The application is required to set this field.
+	Store LockoutStore
+
+	// Number of consecutive failures that locks the account. The default
+	// is 5.
+	MaxFailures int
+
+	// Number of seconds the account is locked after the last failure. The
+	// default is 900.
+	Duration int64
+}
+
+func (l *Lockout) maxFailures() int {
+	if l.MaxFailures <= 0 {
+		return 5
+	}
+	return l.MaxFailures
+}
+
+func (l *Lockout) duration() int64 {
+	if l.Duration <= 0 {
+		return 900
+	}
+	return l.Duration
+}
+
+// Locked returns true if the account with key is locked.
+func (l *Lockout) Locked(key string) (bool, os.Error) {
+	failures, last, err := l.Store.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return failures >= l.maxFailures() && web.Seconds() < last+l.duration(), nil
+}
+
+// Fail records a failed login for the account with key. Counters older than
+// the lockout duration are restarted.
+func (l *Lockout) Fail(key string) os.Error {
+	failures, last, err := l.Store.Get(key)
+	if err != nil {
+		return err
+	}
+	now := web.Seconds()
+	if now >= last+l.duration() {
+		failures = 0
+	}
+	return l.Store.Set(key, failures+1, now)
+}
+
+// Reset clears the failure counter for the account with key. Call Reset
+// after a successful login.
+func (l *Lockout) Reset(key string) os.Error {
+	return l.Store.Set(key, 0, 0)
+}
@@ -0,0 +1,158 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package auth implements helpers for authenticating users: password
+// hashing, password reset tokens, account lockout and TOTP two-factor
+// authentication.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/garyburd/twister/web"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Hasher hashes and verifies passwords.
+type Hasher interface {
+	// Hash returns an encoded hash of the password. The encoding includes
+	// the salt and the work factor.
+	Hash(password string) (string, os.Error)
+
+	// Verify returns true if the password matches the encoded hash.
+	Verify(password, hash string) bool
+
+	// NeedsRehash returns true if the encoded hash was created with a
+	// different work factor than the hasher's. Applications should rehash
+	// the password after a successful login when NeedsRehash returns true.
+	NeedsRehash(hash string) bool
+}
+
+// PBKDF2Hasher hashes passwords with PBKDF2-HMAC-SHA256 and a random salt.
+// The encoded hash has the format "pbkdf2-sha256$iterations$salt$key" where
+// salt and key are base64 encoded.
+type PBKDF2Hasher struct {
+	// Number of iterations. This is the work factor.
+	Iterations int
+}
+
+// DefaultHasher is the hasher used by HashPassword and CheckPassword.
+var DefaultHasher Hasher = &PBKDF2Hasher{Iterations: 10000}
+
+const (
+	pbkdf2Prefix  = "pbkdf2-sha256"
+	pbkdf2SaltLen = 16
+	pbkdf2KeyLen  = 32
+
+	// Hashes with more iterations are rejected so that a crafted hash
+	// cannot consume unbounded CPU time.
+	pbkdf2MaxIterations = 10000000
+)
+
+var errBadHash = os.NewError("twister.auth: bad password hash")
+
+// pbkdf2 implements the PBKDF2 key derivation function from RFC 2898 with
+// HMAC-SHA256 as the pseudorandom function.
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.NewSHA256(password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+	dk := make([]byte, 0, numBlocks*hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum()
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum()
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
+
+func (h *PBKDF2Hasher) Hash(password string) (string, os.Error) {
+	salt := make([]byte, pbkdf2SaltLen)
+	if err := web.ReadRandom(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(password), salt, h.Iterations, pbkdf2KeyLen)
+	return pbkdf2Prefix + "$" + strconv.Itoa(h.Iterations) + "$" +
+		base64.StdEncoding.EncodeToString(salt) + "$" +
+		base64.StdEncoding.EncodeToString(key), nil
+}
+
+// decode splits an encoded hash into its parts.
+func (h *PBKDF2Hasher) decode(hash string) (iterations int, salt, key []byte, err os.Error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != pbkdf2Prefix {
+		return 0, nil, nil, errBadHash
+	}
+	iterations, err = strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 || iterations > pbkdf2MaxIterations {
+		return 0, nil, nil, errBadHash
+	}
+	salt, err = base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, errBadHash
+	}
+	key, err = base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) != pbkdf2KeyLen {
+		return 0, nil, nil, errBadHash
+	}
+	return iterations, salt, key, nil
+}
+
+func (h *PBKDF2Hasher) Verify(password, hash string) bool {
+	iterations, salt, key, err := h.decode(hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2([]byte(password), salt, iterations, len(key)), key) == 1
+}
+
+func (h *PBKDF2Hasher) NeedsRehash(hash string) bool {
+	iterations, _, _, err := h.decode(hash)
+	return err != nil || iterations != h.Iterations
+}
+
+// HashPassword hashes the password with DefaultHasher.
+func HashPassword(password string) (string, os.Error) {
+	return DefaultHasher.Hash(password)
+}
+
+// CheckPassword returns true if the password matches a hash created by
+// DefaultHasher.
+func CheckPassword(password, hash string) bool {
+	return DefaultHasher.Verify(password, hash)
+}
+
+// Equal compares two strings in time that does not depend on the contents
+// of the strings. Use Equal to compare secrets such as tokens and API keys.
+func Equal(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,198 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"github.com/garyburd/twister/audit"
+	"github.com/garyburd/twister/web"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ThrottleOptions configures ThrottleHandler.
+type ThrottleOptions struct {
+	// Store for failure counters. Counters are kept per account and per
+	// client IP address. The application is required to set this field.
+	Store LockoutStore
+
+	// Account returns the account name in a login request. The application
+	// is required to set this field.
+	Account func(req *web.Request) string
+
+	// Number of consecutive failures for an account or IP address that
+	// locks out further attempts. The defaults are 5 per account and 50 per
+	// IP address.
+	MaxAccountFailures int
+	MaxIPFailures      int
+
+	// Number of seconds attempts are locked out after the last failure. The
+	// default is 900.
+	Duration int64
+
+	// Delay in nanoseconds applied to an attempt after the first failure.
+	// The delay doubles with each further failure up to MaxDelay. The
+	// defaults are 250 milliseconds and 5 seconds.
+	Delay    int64
+	MaxDelay int64
+}
+
+// ThrottleHandler returns a handler that defends the login handler h against
+// brute force attacks. Responses from h with status 401 or 403 are counted
+// as failures for the account and the client IP address. A response with
+// status less than 400 resets the account counter. Each attempt is counted
+// as a failure before h is called so that concurrent attempts cannot exceed
+// the limits; the count is released if h does not reject the login.
+//
+// Attempts after a failure are delayed and attempts from a locked out account
+// or address are rejected with status 429. Login results are recorded with
+// the audit package.
+func ThrottleHandler(options *ThrottleOptions, h web.Handler) web.Handler {
+	if options.Store == nil || options.Account == nil {
+		panic("twister.auth: ThrottleHandler requires Store and Account options")
+	}
+	th := &throttleHandler{options: *options, h: h}
+	if th.options.MaxAccountFailures <= 0 {
+		th.options.MaxAccountFailures = 5
+	}
+	if th.options.MaxIPFailures <= 0 {
+		th.options.MaxIPFailures = 50
+	}
+	if th.options.Duration <= 0 {
+		th.options.Duration = 900
+	}
+	if th.options.Delay <= 0 {
+		th.options.Delay = 250e6
+	}
+	if th.options.MaxDelay <= 0 {
+		th.options.MaxDelay = 5e9
+	}
+	th.account = &Lockout{Store: th.options.Store, MaxFailures: th.options.MaxAccountFailures, Duration: th.options.Duration}
+	th.ip = &Lockout{Store: th.options.Store, MaxFailures: th.options.MaxIPFailures, Duration: th.options.Duration}
+	return th
+}
+
+type throttleHandler struct {
+	options     ThrottleOptions
+	h           web.Handler
+	account, ip *Lockout
+
+	// mu serializes the check and reservation of attempts.
+	mu sync.Mutex
+}
+
+var errThrottled = os.NewError("twister.auth: too many failed attempts")
+
+func clientIP(req *web.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// reserve checks the counters for the account and IP address keys and
+// counts the attempt as a failure. If the attempt is locked out, reserve
+// responds to the request and returns false.
+func (th *throttleHandler) reserve(req *web.Request, account, accountKey, ipKey string) (failures int, ok bool) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	type counter struct {
+		l        *Lockout
+		key      string
+		failures int
+	}
+	counters := []counter{{l: th.account, key: accountKey}, {l: th.ip, key: ipKey}}
+	now := web.Seconds()
+	for i := range counters {
+		c := &counters[i]
+		n, last, err := th.options.Store.Get(c.key)
+		if err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return 0, false
+		}
+		if now >= last+th.options.Duration {
+			n = 0
+		} else if n >= c.l.MaxFailures {
+			audit.Record(req, account, "login", account, "locked", "key", c.key)
+			web.ThrottleError(req, web.StatusTooManyRequests, errThrottled, (last+th.options.Duration-now)*1e9)
+			return 0, false
+		}
+		c.failures = n
+		if n > failures {
+			failures = n
+		}
+	}
+	for _, c := range counters {
+		if err := th.options.Store.Set(c.key, c.failures+1, now); err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return 0, false
+		}
+	}
+	return failures, true
+}
+
+// release removes the failure counted by reserve for key.
+func (th *throttleHandler) release(key string) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	failures, last, err := th.options.Store.Get(key)
+	if err != nil || failures == 0 {
+		return
+	}
+	th.options.Store.Set(key, failures-1, last)
+}
+
+func (th *throttleHandler) ServeWeb(req *web.Request) {
+	account := th.options.Account(req)
+	accountKey := "account:" + account
+	ipKey := "ip:" + clientIP(req)
+
+	maxFailures, ok := th.reserve(req, account, accountKey, ipKey)
+	if !ok {
+		return
+	}
+
+	if maxFailures > 0 {
+		delay := th.options.Delay
+		for i := 1; i < maxFailures && delay < th.options.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > th.options.MaxDelay {
+			delay = th.options.MaxDelay
+		}
+		time.Sleep(delay)
+	}
+
+	web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+		switch {
+		case status == web.StatusUnauthorized || status == web.StatusForbidden:
+			// The failure was counted by reserve.
+			audit.Record(req, account, "login", account, "failure")
+		case status < 400:
+			audit.Record(req, account, "login", account, "success")
+			th.mu.Lock()
+			th.account.Reset(accountKey)
+			th.mu.Unlock()
+			th.release(ipKey)
+		default:
+			th.release(accountKey)
+			th.release(ipKey)
+		}
+		return status, header
+	})
+	th.h.ServeWeb(req)
+}
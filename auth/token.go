@@ -0,0 +1,95 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/garyburd/twister/web"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrTokenInvalid is returned for malformed tokens and tokens with a bad
+	// signature.
+	ErrTokenInvalid = os.NewError("twister.auth: invalid token")
+
+	// ErrTokenExpired is returned for tokens past their expiration time.
+	ErrTokenExpired = os.NewError("twister.auth: token expired")
+)
+
+func tokenSignature(secret []byte, userID, expires, stamp string) string {
+	h := hmac.NewSHA256(secret)
+	h.Write([]byte(userID + "\x00" + expires + "\x00" + stamp))
+	return hex.EncodeToString(h.Sum())
+}
+
+// NewResetToken returns a password reset token for the user that expires in
+// maxAge seconds. The stamp is a value that changes when the password
+// changes, typically the current password hash. Including the stamp in the
+// token ensures that the token cannot be used after the password is reset.
+func NewResetToken(secret []byte, userID, stamp string, maxAge int64) string {
+	expires := strconv.Itoa64(web.Seconds() + maxAge)
+	sig := tokenSignature(secret, userID, expires, stamp)
+	return base64.URLEncoding.EncodeToString([]byte(userID + ":" + expires + ":" + sig))
+}
+
+func parseResetToken(token string) (userID, expires, sig string, err os.Error) {
+	p, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", "", ErrTokenInvalid
+	}
+	s := string(p)
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", "", "", ErrTokenInvalid
+	}
+	j := strings.LastIndex(s[:i], ":")
+	if j < 0 {
+		return "", "", "", ErrTokenInvalid
+	}
+	return s[:j], s[j+1 : i], s[i+1:], nil
+}
+
+// ResetTokenUser returns the user ID in a token created by NewResetToken
+// without validating the token. Use the ID to look up the stamp for
+// ValidateResetToken.
+func ResetTokenUser(token string) (string, os.Error) {
+	userID, _, _, err := parseResetToken(token)
+	return userID, err
+}
+
+// ValidateResetToken checks a token created by NewResetToken against the
+// user's current stamp.
+func ValidateResetToken(secret []byte, token, stamp string) (string, os.Error) {
+	userID, expires, sig, err := parseResetToken(token)
+	if err != nil {
+		return "", err
+	}
+	if !Equal(sig, tokenSignature(secret, userID, expires, stamp)) {
+		return "", ErrTokenInvalid
+	}
+	t, err := strconv.Atoi64(expires)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	if web.Seconds() > t {
+		return "", ErrTokenExpired
+	}
+	return userID, nil
+}
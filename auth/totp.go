@@ -0,0 +1,125 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base32"
+	"github.com/garyburd/twister/web"
+	"http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+// NewTOTPSecret returns a random base32 encoded secret for TOTP two-factor
+// authentication.
+func NewTOTPSecret() (string, os.Error) {
+	p := make([]byte, 20)
+	if err := web.ReadRandom(p); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(p), nil
+}
+
+// TOTPURI returns an otpauth:// URI for provisioning an authenticator app
+// with the secret. The URI is typically displayed as a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	return "otpauth://totp/" + http.URLEscape(issuer+":"+account) +
+		"?secret=" + strings.TrimRight(secret, "=") +
+		"&issuer=" + http.URLEscape(issuer)
+}
+
+func decodeTOTPSecret(secret string) ([]byte, os.Error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	if n := len(secret) % 8; n != 0 {
+		secret += strings.Repeat("=", 8-n)
+	}
+	return base32.StdEncoding.DecodeString(secret)
+}
+
+// hotp returns the HOTP value from RFC 4226 for the key and counter.
+func hotp(key []byte, counter uint64) string {
+	h := hmac.NewSHA1(key)
+	var p [8]byte
+	for i := 7; i >= 0; i-- {
+		p[i] = byte(counter)
+		counter >>= 8
+	}
+	h.Write(p[:])
+	sum := h.Sum()
+	offset := sum[len(sum)-1] & 0xf
+	v := (uint32(sum[offset])&0x7f)<<24 |
+		uint32(sum[offset+1])<<16 |
+		uint32(sum[offset+2])<<8 |
+		uint32(sum[offset+3])
+	s := strconv.Uitoa(uint(v % 1000000))
+	return strings.Repeat("0", totpDigits-len(s)) + s
+}
+
+// TOTPCode returns the TOTP code for the secret at time t in seconds since
+// the epoch.
+func TOTPCode(secret string, t int64) (string, os.Error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t/totpPeriod)), nil
+}
+
+// VerifyTOTP returns true if code is valid for the secret at the current
+// time. Codes from skew periods before and after the current period are
+// accepted to allow for clock differences. A skew of 1 is typical.
+func VerifyTOTP(secret, code string, skew int) bool {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return false
+	}
+	counter := web.Seconds() / totpPeriod
+	for i := -skew; i <= skew; i++ {
+		if Equal(hotp(key, uint64(counter+int64(i))), code) {
+			return true
+		}
+	}
+	return false
+}
+
+// SecondFactorHandler returns a handler that calls h only if verified
+// returns true for the request. Other requests are redirected to url with
+// the original URL in the "next" parameter, or rejected with status 403 if
+// url is "". Use SecondFactorHandler on routes that require a verified
+// second factor.
+func SecondFactorHandler(verified func(req *web.Request) bool, url string, h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		if verified(req) {
+			h.ServeWeb(req)
+			return
+		}
+		if url == "" {
+			req.Error(web.StatusForbidden, os.NewError("twister.auth: second factor required"))
+			return
+		}
+		next := req.URL.Path
+		if req.URL.RawQuery != "" {
+			next += "?" + req.URL.RawQuery
+		}
+		req.Redirect(url+"?next="+http.URLEscape(next), false)
+	})
+}
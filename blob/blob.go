@@ -0,0 +1,124 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package blob defines an interface for storing blobs of data and an on-disk
+// implementation of the interface.
+//
+// Blobs are identified by application assigned keys. The package includes
+// helpers for saving uploaded files to a store and for adding blobs to a
+// streamed ZIP archive. The thumbnail package serves images from a store.
+package blob
+
+import (
+	"github.com/garyburd/twister/web"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrNotFound is returned when a blob does not exist.
+var ErrNotFound = os.NewError("twister.blob: not found")
+
+// Info describes a blob.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime int64 // seconds since the epoch
+}
+
+// Store is the interface implemented by blob stores.
+type Store interface {
+	// Put stores the data read from r as the blob with the given key,
+	// replacing any existing blob. Readers see the complete old or the
+	// complete new blob, never a partial blob.
+	Put(key string, r io.Reader) (*Info, os.Error)
+
+	// Get returns a reader for the blob and information about the blob. The
+	// caller must close the reader. Get returns ErrNotFound if the blob does
+	// not exist.
+	Get(key string) (io.ReadCloser, *Info, os.Error)
+
+	// Stat returns information about the blob. Stat returns ErrNotFound if
+	// the blob does not exist.
+	Stat(key string) (*Info, os.Error)
+
+	// Delete deletes the blob. Deleting a blob that does not exist is not
+	// an error.
+	Delete(key string) os.Error
+}
+
+// Upload describes an uploaded file saved by SaveUploads.
+type Upload struct {
+	Name        string // form field name
+	Filename    string // client file name
+	ContentType string
+	Info        *Info
+}
+
+// SaveUploads streams the files in a multipart/form-data request body to
+// store. The function key returns the blob key for a file. Form fields that
+// are not files are added to the request Param. Files are not buffered in
+// memory.
+func SaveUploads(req *web.Request, store Store, maxRequestBodyLen int, key func(name, filename string) string) ([]*Upload, os.Error) {
+	m, err := web.NewMultipartReader(req, maxRequestBodyLen)
+	if err != nil {
+		return nil, err
+	}
+	var uploads []*Upload
+	for {
+		header, r, err := m.Next()
+		if err == os.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		disp, dispParam := header.GetValueParam(web.HeaderContentDisposition)
+		name := dispParam["name"]
+		if disp != "form-data" || name == "" {
+			continue
+		}
+		filename := dispParam["filename"]
+		if filename == "" {
+			p, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			req.Param.Add(name, string(p))
+			continue
+		}
+		contentType, _ := header.GetValueParam(web.HeaderContentType)
+		info, err := store.Put(key(name, filename), r)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &Upload{Name: name, Filename: filename, ContentType: contentType, Info: info})
+	}
+	return uploads, nil
+}
+
+// WriteZipEntry copies the blob with the given key to a new entry in a ZIP
+// archive. The entry's modification time is the blob's modification time.
+func WriteZipEntry(zr *web.ZipResponse, store Store, key, name string, method int) os.Error {
+	r, info, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := zr.Create(name, method, info.ModTime)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
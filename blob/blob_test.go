@@ -0,0 +1,127 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package blob
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b/c", "../d"} {
+		info, err := s.Put(key, strings.NewReader("data "+key))
+		if err != nil {
+			t.Fatalf("Put(%q) returned error %v", key, err)
+		}
+		if info.Size != int64(len("data "+key)) {
+			t.Errorf("Put(%q) size=%d", key, info.Size)
+		}
+	}
+
+	r, info, err := s.Get("b/c")
+	if err != nil {
+		t.Fatalf("Get() returned error %v", err)
+	}
+	p, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(p) != "data b/c" || info.Key != "b/c" || info.Size != 8 {
+		t.Errorf("Get() = %q, %+v", p, info)
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Errorf("Delete() returned error %v", err)
+	}
+	if _, err := s.Stat("a"); err != ErrNotFound {
+		t.Errorf("Stat() of deleted blob returned %v, want ErrNotFound", err)
+	}
+	if err := s.Delete("a"); err != nil {
+		t.Errorf("Delete() of deleted blob returned error %v", err)
+	}
+
+	n, err := s.GC(func(key string) bool { return key == "b/c" }, -1)
+	if err != nil || n != 1 {
+		t.Errorf("GC() = %d, %v, want 1, nil", n, err)
+	}
+	if _, err := s.Stat("../d"); err != ErrNotFound {
+		t.Errorf("Stat() of collected blob returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Stat("b/c"); err != nil {
+		t.Errorf("Stat() of kept blob returned %v", err)
+	}
+}
+
+func TestSaveUploads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "--deadbeef\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"hello\r\n" +
+		"--deadbeef\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"file data\r\n" +
+		"--deadbeef--\r\n"
+	var uploads []*Upload
+	var title string
+	web.RunHandler("/", "POST",
+		web.NewHeader(web.HeaderContentType, "multipart/form-data; boundary=deadbeef"),
+		[]byte(body),
+		web.HandlerFunc(func(req *web.Request) {
+			uploads, err = SaveUploads(req, s, -1, func(name, filename string) string { return "uploads/" + filename })
+			title = req.Param.Get("title")
+			req.Respond(web.StatusOK)
+		}))
+	if err != nil {
+		t.Fatalf("SaveUploads() returned error %v", err)
+	}
+	if title != "hello" {
+		t.Errorf("title=%q, want %q", title, "hello")
+	}
+	if len(uploads) != 1 || uploads[0].Filename != "a.txt" || uploads[0].ContentType != "text/plain" {
+		t.Fatalf("uploads=%+v", uploads)
+	}
+	r, _, err := s.Get("uploads/a.txt")
+	if err != nil {
+		t.Fatalf("Get() returned error %v", err)
+	}
+	defer r.Close()
+	var b bytes.Buffer
+	b.ReadFrom(r)
+	if b.String() != "file data" {
+		t.Errorf("blob=%q, want %q", b.String(), "file data")
+	}
+}
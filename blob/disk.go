@@ -0,0 +1,206 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package blob
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/garyburd/twister/web"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DiskStore stores blobs in a directory tree. Blobs are sharded into
+// subdirectories by a hash of the key. Blobs are written to a temporary file
+// and renamed into place, so readers never see a partial blob.
+//
+// Keys are limited to 180 bytes.
+type DiskStore struct {
+	root string
+}
+
+// NewDiskStore returns a store for the directory root. The directory is
+// created if it does not exist.
+func NewDiskStore(root string) (*DiskStore, os.Error) {
+	s := &DiskStore{root: filepath.Clean(root)}
+	if err := os.MkdirAll(s.tmpDir(), 0700); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *DiskStore) tmpDir() string {
+	return filepath.Join(s.root, "tmp")
+}
+
+// filename returns the path of the file for key. The file name is the
+// base64 encoded key, so the key can be recovered from the name.
+func (s *DiskStore) filename(key string) (string, os.Error) {
+	if key == "" || len(key) > 180 {
+		return "", os.NewError("twister.blob: bad key")
+	}
+	h := sha1.New()
+	h.Write([]byte(key))
+	sum := hex.EncodeToString(h.Sum())
+	return filepath.Join(s.root, sum[0:2], sum[2:4], base64.URLEncoding.EncodeToString([]byte(key))), nil
+}
+
+func (s *DiskStore) Put(key string, r io.Reader) (*Info, os.Error) {
+	fname, err := s.filename(key)
+	if err != nil {
+		return nil, err
+	}
+	p := make([]byte, 8)
+	if err := web.ReadRandom(p); err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(s.tmpDir(), hex.EncodeToString(p))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(fname), 0700)
+	}
+	if err == nil {
+		err = os.Rename(tmp, fname)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return &Info{Key: key, Size: n, ModTime: web.Seconds()}, nil
+}
+
+func (s *DiskStore) Get(key string) (io.ReadCloser, *Info, os.Error) {
+	fname, err := s.filename(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, notFound(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, &Info{Key: key, Size: fi.Size, ModTime: fi.Mtime_ns / 1e9}, nil
+}
+
+func (s *DiskStore) Stat(key string) (*Info, os.Error) {
+	fname, err := s.filename(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &Info{Key: key, Size: fi.Size, ModTime: fi.Mtime_ns / 1e9}, nil
+}
+
+func (s *DiskStore) Delete(key string) os.Error {
+	fname, err := s.filename(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fname); err != nil && notFound(err) != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// notFound returns ErrNotFound if err is a file not found error. Otherwise,
+// err is returned.
+func notFound(err os.Error) os.Error {
+	if pe, ok := err.(*os.PathError); ok && pe.Error == os.ENOENT {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GC deletes blobs for which keep returns false and temporary files left by
+// failed writes. Blobs and temporary files modified in the last minAge
+// seconds are not deleted, so that blobs stored but not yet referenced by the
+// application are kept. GC returns the number of files deleted.
+func (s *DiskStore) GC(keep func(key string) bool, minAge int64) (int, os.Error) {
+	cutoff := (web.Seconds() - minAge) * 1e9
+	deleted := 0
+
+	tmp, err := readDir(s.tmpDir())
+	if err != nil {
+		return deleted, err
+	}
+	for _, fi := range tmp {
+		if fi.Mtime_ns < cutoff && os.Remove(filepath.Join(s.tmpDir(), fi.Name)) == nil {
+			deleted++
+		}
+	}
+
+	shards, err := readDir(s.root)
+	if err != nil {
+		return deleted, err
+	}
+	for _, shard := range shards {
+		if !shard.IsDirectory() || shard.Name == "tmp" {
+			continue
+		}
+		dir1 := filepath.Join(s.root, shard.Name)
+		subshards, err := readDir(dir1)
+		if err != nil {
+			return deleted, err
+		}
+		for _, subshard := range subshards {
+			dir2 := filepath.Join(dir1, subshard.Name)
+			files, err := readDir(dir2)
+			if err != nil {
+				return deleted, err
+			}
+			for _, fi := range files {
+				if fi.Mtime_ns >= cutoff {
+					continue
+				}
+				key, err := base64.URLEncoding.DecodeString(fi.Name)
+				if err != nil || keep(string(key)) {
+					continue
+				}
+				if os.Remove(filepath.Join(dir2, fi.Name)) == nil {
+					deleted++
+				}
+			}
+		}
+	}
+	return deleted, nil
+}
+
+func readDir(dir string) ([]os.FileInfo, os.Error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
@@ -0,0 +1,166 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package cdn implements signed URLs for protected content and a client for
+// purging content from a content delivery network cache.
+//
+// Sign URLs in the application and verify them at the origin:
+//
+//  url, err := cdn.SignURL(secret, "https://cdn.example.com/videos/1.mp4", 3600)
+//
+//  h := cdn.SignedURLHandler(secret, web.DirectoryHandler("videos", nil))
+//
+// Purge content when it changes:
+//
+//  purger := &cdn.Purger{Endpoint: "https://api.cdn.example.com/purge", Token: token}
+//  purger.PurgeLater("https://cdn.example.com/videos/1.mp4")
+package cdn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/garyburd/twister/client"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	errBadSignature = os.NewError("twister.cdn: bad URL signature")
+	errExpired      = os.NewError("twister.cdn: URL expired")
+)
+
+func urlSignature(secret, s string) string {
+	h := hmac.NewSHA256([]byte(secret))
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum())
+}
+
+// SignURL returns rawURL with "expires" and "signature" query parameters
+// added. The URL expires after maxAgeSeconds. The signature is the hex
+// encoded HMAC-SHA256 of the path and query, including the expires
+// parameter.
+func SignURL(secret, rawURL string, maxAgeSeconds int) (string, os.Error) {
+	u, err := http.ParseURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.RawQuery
+	if query != "" {
+		query += "&"
+	}
+	query += "expires=" + strconv.Itoa64(web.Seconds()+int64(maxAgeSeconds))
+	u.RawQuery = query + "&signature=" + urlSignature(secret, u.Path+"?"+query)
+	return u.String(), nil
+}
+
+// VerifyURL returns nil if the URL was signed by SignURL with secret and the
+// URL has not expired. The signature must be the last query parameter.
+func VerifyURL(secret string, u *http.URL) os.Error {
+	query := u.RawQuery
+	i := strings.LastIndex(query, "signature=")
+	if i <= 0 || query[i-1] != '&' {
+		return errBadSignature
+	}
+	sig := query[i+len("signature="):]
+	query = query[:i-1]
+	expected := urlSignature(secret, u.Path+"?"+query)
+	if len(sig) != len(expected) || subtle.ConstantTimeCompare([]byte(sig), []byte(expected)) != 1 {
+		return errBadSignature
+	}
+	values := make(web.Values)
+	if err := values.ParseFormEncodedBytes([]byte(query)); err != nil {
+		return errBadSignature
+	}
+	expires, err := strconv.Atoi64(values.Get("expires"))
+	if err != nil {
+		return errBadSignature
+	}
+	if expires < web.Seconds() {
+		return errExpired
+	}
+	return nil
+}
+
+// SignedURLHandler returns a handler that responds with status 403 to
+// requests with a URL that is not signed with secret or has expired. Other
+// requests are passed to h.
+func SignedURLHandler(secret string, h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		if err := VerifyURL(secret, req.URL); err != nil {
+			req.Error(web.StatusForbidden, err)
+			return
+		}
+		h.ServeWeb(req)
+	})
+}
+
+// Purger issues cache purge requests to a CDN.
+type Purger struct {
+	// URL of the CDN purge endpoint. The purger POSTs a JSON object of the
+	// form {"urls": ["https://cdn.example.com/a", ...]} to the endpoint.
+	Endpoint string
+
+	// If not empty, the purger sends the header "Authorization: Bearer "
+	// followed by Token.
+	Token string
+
+	// Transport used for purge requests. Failed requests are retried by a
+	// client.Transport wrapping Transport. If Transport is nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+// Purge removes the URLs from the CDN cache.
+func (p *Purger) Purge(urls ...string) os.Error {
+	body, err := json.Marshal(map[string]interface{}{"urls": urls})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.Endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(web.HeaderContentType, "application/json")
+	if p.Token != "" {
+		req.Header.Set(web.HeaderAuthorization, "Bearer "+p.Token)
+	}
+	c := &http.Client{Transport: &client.Transport{Transport: p.Transport, Timeout: 30e9}}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return os.NewError("twister.cdn: purge status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// PurgeLater removes the URLs from the CDN cache in the background. Errors
+// are logged. Use PurgeLater in handlers to avoid delaying the response.
+func (p *Purger) PurgeLater(urls ...string) {
+	go func() {
+		if err := p.Purge(urls...); err != nil {
+			log.Println("twister.cdn:", err)
+		}
+	}()
+}
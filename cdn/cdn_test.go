@@ -0,0 +1,77 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cdn
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSignedURLHandler(t *testing.T) {
+	h := SignedURLHandler("secret", web.HandlerFunc(func(req *web.Request) { req.Respond(web.StatusOK) }))
+	signed, err := SignURL("secret", "http://example.com/videos/1.mp4?q=hd", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := SignURL("secret", "http://example.com/videos/1.mp4", -60)
+	other, _ := SignURL("other", "http://example.com/videos/1.mp4", 60)
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{signed, web.StatusOK},
+		{signed + "x", web.StatusForbidden},
+		{"http://example.com/videos/2.mp4?" + signed[len("http://example.com/videos/1.mp4?"):], web.StatusForbidden},
+		{expired, web.StatusForbidden},
+		{other, web.StatusForbidden},
+		{"http://example.com/videos/1.mp4", web.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status, _, _ := web.RunHandler(tt.url, "GET", nil, nil, h); status != tt.status {
+			t.Errorf("%s status=%d, want %d", tt.url, status, tt.status)
+		}
+	}
+}
+
+type transportFunc func(req *http.Request) (*http.Response, os.Error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, os.Error) { return f(req) }
+
+func TestPurge(t *testing.T) {
+	var body, auth string
+	p := &Purger{
+		Endpoint: "http://api.example.com/purge",
+		Token:    "token",
+		Transport: transportFunc(func(req *http.Request) (*http.Response, os.Error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+			auth = req.Header.Get("Authorization")
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewBuffer(nil))}, nil
+		}),
+	}
+	if err := p.Purge("http://cdn.example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	if expect := `{"urls":["http://cdn.example.com/a"]}`; body != expect {
+		t.Errorf("body=%q, want %q", body, expect)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("Authorization=%q, want %q", auth, "Bearer token")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"github.com/garyburd/twister/web"
+	"os"
+	"sync"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed allows all requests.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen rejects all requests.
+	BreakerOpen
+
+	// BreakerHalfOpen allows a limited number of probe requests. The breaker
+	// closes if a probe succeeds and opens if a probe fails.
+	BreakerHalfOpen
+)
+
+var breakerStateNames = []string{"closed", "open", "half-open"}
+
+func (s BreakerState) String() string {
+	if s < 0 || int(s) >= len(breakerStateNames) {
+		return "unknown"
+	}
+	return breakerStateNames[s]
+}
+
+// ErrBreakerOpen is returned when a request is rejected by an open circuit
+// breaker.
+var ErrBreakerOpen = os.NewError("twister.client: circuit breaker open")
+
+// BreakerOptions configures a circuit breaker.
+type BreakerOptions struct {
+	// Failure rate between 0 and 1 at which the breaker opens. The default
+	// is 0.5.
+	FailureRate float64
+
+	// Minimum number of requests in a window before the breaker can open.
+	// The default is 10.
+	MinRequests int
+
+	// Length of the window in nanoseconds over which the failure rate is
+	// measured. The default is 10 seconds.
+	Window int64
+
+	// Time in nanoseconds that the breaker stays open before allowing probe
+	// requests. The default is 5 seconds.
+	OpenTimeout int64
+
+	// Maximum number of concurrent probe requests in the half-open state.
+	// The default is 1.
+	MaxProbes int
+}
+
+// Breaker is a circuit breaker. A breaker counts the failed requests to a
+// server. When the failure rate exceeds a threshold, the breaker opens and
+// requests fail fast without waiting for the server.
+type Breaker struct {
+	options BreakerOptions
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart int64
+	requests    int
+	failures    int
+	openUntil   int64
+	probes      int
+}
+
+// NewBreaker returns a closed circuit breaker.
+func NewBreaker(options *BreakerOptions) *Breaker {
+	b := &Breaker{}
+	if options != nil {
+		b.options = *options
+	}
+	if b.options.FailureRate <= 0 {
+		b.options.FailureRate = 0.5
+	}
+	if b.options.MinRequests <= 0 {
+		b.options.MinRequests = 10
+	}
+	if b.options.Window <= 0 {
+		b.options.Window = 10e9
+	}
+	if b.options.OpenTimeout <= 0 {
+		b.options.OpenTimeout = 5e9
+	}
+	if b.options.MaxProbes <= 0 {
+		b.options.MaxProbes = 1
+	}
+	return b
+}
+
+// update moves an open breaker to half-open when the open timeout expires.
+// The caller must hold b.mu.
+func (b *Breaker) update(t int64) {
+	if b.state == BreakerOpen && t >= b.openUntil {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.update(web.Nanoseconds())
+	return b.state
+}
+
+// RetryAfter returns the time in nanoseconds until an open breaker allows
+// probe requests. RetryAfter returns zero if the breaker is not open.
+func (b *Breaker) RetryAfter() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := web.Nanoseconds()
+	b.update(t)
+	if b.state != BreakerOpen {
+		return 0
+	}
+	return b.openUntil - t
+}
+
+// Ready returns true if Allow will allow a request. Unlike Allow, Ready does
+// not reserve a probe request.
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.update(web.Nanoseconds())
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.probes < b.options.MaxProbes
+	}
+	return true
+}
+
+// Allow returns true if a request is allowed. The caller must call Record
+// with the result of each allowed request.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.update(web.Nanoseconds())
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probes >= b.options.MaxProbes {
+			return false
+		}
+		b.probes += 1
+	}
+	return true
+}
+
+// Record records the result of a request allowed by Allow.
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := web.Nanoseconds()
+	b.update(t)
+	switch b.state {
+	case BreakerHalfOpen:
+		if success {
+			b.state = BreakerClosed
+			b.windowStart, b.requests, b.failures = t, 0, 0
+		} else {
+			b.open(t)
+		}
+	case BreakerClosed:
+		if t-b.windowStart >= b.options.Window {
+			b.windowStart, b.requests, b.failures = t, 0, 0
+		}
+		b.requests += 1
+		if !success {
+			b.failures += 1
+		}
+		if b.requests >= b.options.MinRequests &&
+			float64(b.failures) >= b.options.FailureRate*float64(b.requests) {
+			b.open(t)
+		}
+	}
+}
+
+func (b *Breaker) open(t int64) {
+	b.state = BreakerOpen
+	b.openUntil = t + b.options.OpenTimeout
+	b.requests, b.failures = 0, 0
+}
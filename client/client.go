@@ -0,0 +1,232 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package client implements an HTTP client transport with retries,
+// per-request timeouts, circuit breaking and request signing.
+//
+// A Transport is used as the transport for an http.Client:
+//
+//  client := &http.Client{Transport: &client.Transport{
+//      MaxRetries: 3,
+//      Timeout:    10e9,
+//      Signers:    []client.Signer{client.HMACSigner("X-Signature", key)},
+//  }}
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"github.com/garyburd/twister/oauth"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Signer signs outbound requests.
+type Signer interface {
+	// Sign modifies the request to include a signature. The request body is
+	// passed as a slice of bytes because the body reader is consumed when the
+	// request is sent.
+	Sign(req *http.Request, body []byte) os.Error
+}
+
+// SignerFunc is a type adapter to allow the use of ordinary functions as
+// Signer.
+type SignerFunc func(req *http.Request, body []byte) os.Error
+
+// Sign calls f(req, body).
+func (f SignerFunc) Sign(req *http.Request, body []byte) os.Error { return f(req, body) }
+
+// HMACSigner returns a signer that sets the header with name to "sha256="
+// followed by the hex encoded HMAC-SHA256 of the request body.
+func HMACSigner(name string, key []byte) Signer {
+	return SignerFunc(func(req *http.Request, body []byte) os.Error {
+		h := hmac.NewSHA256(key)
+		h.Write(body)
+		req.Header.Set(name, "sha256="+hex.EncodeToString(h.Sum()))
+		return nil
+	})
+}
+
+// OAuthSigner returns a signer that adds an OAuth 1.0 signature to the
+// request query parameters.
+func OAuthSigner(c *oauth.Client, credentials *oauth.Credentials) Signer {
+	return SignerFunc(func(req *http.Request, body []byte) os.Error {
+		param := make(web.Values)
+		if err := param.ParseFormEncodedBytes([]byte(req.URL.RawQuery)); err != nil {
+			return err
+		}
+		url := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+		c.SignParam(credentials, req.Method, url, param)
+		req.URL.RawQuery = param.FormEncodedString()
+		req.RawURL = ""
+		return nil
+	})
+}
+
+// Transport is an http.RoundTripper that retries failed requests, limits
+// the time for each attempt and signs requests.
+type Transport struct {
+	// Transport used to send requests. The default is http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Maximum number of times a request with an idempotent method is retried
+	// after an error or a 502, 503 or 504 response.
+	MaxRetries int
+
+	// Delay in nanoseconds before the first retry. The delay doubles with
+	// each retry. The default is 100 milliseconds.
+	Backoff int64
+
+	// Maximum time in nanoseconds for each attempt. No limit is imposed if
+	// Timeout is zero.
+	Timeout int64
+
+	// Signers called in order for each attempt.
+	Signers []Signer
+
+	// If not nil, attempts are rejected with ErrBreakerOpen while the
+	// breaker is open. Errors and 502, 503 and 504 responses are recorded
+	// as failures.
+	Breaker *Breaker
+}
+
+// ErrTimeout is returned when an attempt does not complete within
+// Transport.Timeout.
+var ErrTimeout = os.NewError("twister.client: request timeout")
+
+// Idempotent returns true if a request with the method can be retried
+// safely.
+func Idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func retryStatus(status int) bool {
+	return status == web.StatusBadGateway ||
+		status == web.StatusServiceUnavailable ||
+		status == web.StatusGatewayTimeout
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, os.Error) {
+	var body []byte
+	if req.Body != nil {
+		var err os.Error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(body))
+	}
+
+	retries := 0
+	if Idempotent(req.Method) {
+		retries = t.MaxRetries
+	}
+	backoff := t.Backoff
+	if backoff <= 0 {
+		backoff = 100e6
+	}
+
+	for attempt := 0; ; attempt++ {
+		r := newAttempt(req, body)
+		for _, s := range t.Signers {
+			if err := s.Sign(r, body); err != nil {
+				return nil, err
+			}
+		}
+		if t.Breaker != nil && !t.Breaker.Allow() {
+			return nil, ErrBreakerOpen
+		}
+		resp, err := t.roundTrip(r)
+		if t.Breaker != nil {
+			t.Breaker.Record(err == nil && !retryStatus(resp.StatusCode))
+		}
+		if attempt >= retries || (err == nil && !retryStatus(resp.StatusCode)) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	panic("unreachable")
+}
+
+// newAttempt returns a copy of req with a fresh body reader. Signers modify
+// the copy so that each attempt is signed from the original request.
+func newAttempt(req *http.Request, body []byte) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	if req.URL != nil {
+		url := *req.URL
+		r.URL = &url
+	}
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	}
+	return r
+}
+
+// roundTrip sends one attempt of the request.
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, os.Error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return RoundTripTimeout(transport, req, t.Timeout)
+}
+
+// RoundTripTimeout sends the request using transport and returns ErrTimeout
+// if the response is not received within timeout nanoseconds. No limit is
+// imposed if timeout is zero. The response to an abandoned request is closed
+// when it arrives.
+func RoundTripTimeout(transport http.RoundTripper, req *http.Request, timeout int64) (*http.Response, os.Error) {
+	if timeout <= 0 {
+		return transport.RoundTrip(req)
+	}
+	type result struct {
+		resp *http.Response
+		err  os.Error
+	}
+	c := make(chan result, 1)
+	go func() {
+		resp, err := transport.RoundTrip(req)
+		c <- result{resp, err}
+	}()
+	select {
+	case r := <-c:
+		return r.resp, r.err
+	case <-time.After(timeout):
+		go func() {
+			// Close the response when the abandoned attempt completes.
+			if r := <-c; r.resp != nil {
+				r.resp.Body.Close()
+			}
+		}()
+	}
+	return nil, ErrTimeout
+}
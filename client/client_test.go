@@ -0,0 +1,200 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/oauth"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type transportFunc func(req *http.Request) (*http.Response, os.Error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, os.Error) { return f(req) }
+
+func newResponse(status int) *http.Response {
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewBuffer(nil))}
+}
+
+var retryTests = []struct {
+	method   string
+	statuses []int
+	attempts int
+	status   int
+}{
+	{"GET", []int{200}, 1, 200},
+	{"GET", []int{503, 200}, 2, 200},
+	{"GET", []int{503, 503, 503, 503}, 3, 503},
+	{"POST", []int{503, 200}, 1, 503},
+	{"GET", []int{500, 200}, 1, 500},
+}
+
+func TestRetry(t *testing.T) {
+	for _, tt := range retryTests {
+		attempts := 0
+		var bodies []string
+		statuses := tt.statuses
+		transport := &Transport{
+			MaxRetries: 2,
+			Backoff:    1,
+			Transport: transportFunc(func(req *http.Request) (*http.Response, os.Error) {
+				p, _ := ioutil.ReadAll(req.Body)
+				bodies = append(bodies, string(p))
+				attempts += 1
+				status := statuses[0]
+				statuses = statuses[1:]
+				return newResponse(status), nil
+			}),
+		}
+		req := &http.Request{Method: tt.method, Header: make(http.Header), Body: ioutil.NopCloser(bytes.NewBufferString("body"))}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s %v returned error %v", tt.method, tt.statuses, err)
+			continue
+		}
+		if attempts != tt.attempts || resp.StatusCode != tt.status {
+			t.Errorf("%s %v attempts=%d status=%d, want %d %d", tt.method, tt.statuses, attempts, resp.StatusCode, tt.attempts, tt.status)
+		}
+		for _, body := range bodies {
+			if body != "body" {
+				t.Errorf("%s %v body=%q, want %q", tt.method, tt.statuses, body, "body")
+			}
+		}
+	}
+}
+
+func TestSignRetry(t *testing.T) {
+	var queries []string
+	var signatures []string
+	statuses := []int{503, 200}
+	transport := &Transport{
+		MaxRetries: 2,
+		Backoff:    1,
+		Signers: []Signer{
+			OAuthSigner(&oauth.Client{Credentials: oauth.Credentials{Token: "key", Secret: "secret"}}, nil),
+			HMACSigner("X-Signature", []byte("key")),
+		},
+		Transport: transportFunc(func(req *http.Request) (*http.Response, os.Error) {
+			queries = append(queries, req.URL.RawQuery)
+			signatures = append(signatures, strings.Join(req.Header["X-Signature"], ","))
+			status := statuses[0]
+			statuses = statuses[1:]
+			return newResponse(status), nil
+		}),
+	}
+	url, _ := http.ParseURL("http://example.com/a?b=c")
+	req := &http.Request{Method: "GET", URL: url, Header: make(http.Header)}
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("attempts = %d, want 2", len(queries))
+	}
+	for i, q := range queries {
+		if n := strings.Count(q, "oauth_signature="); n != 1 {
+			t.Errorf("attempt %d query %q has %d signatures, want 1", i, q, n)
+		}
+		if strings.Contains(signatures[i], ",") {
+			t.Errorf("attempt %d signature header = %q, want one value", i, signatures[i])
+		}
+	}
+	if req.URL.RawQuery != "b=c" || len(req.Header) != 0 {
+		t.Errorf("original request modified: query=%q header=%v", req.URL.RawQuery, req.Header)
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	req := &http.Request{Header: make(http.Header)}
+	HMACSigner("X-Signature", []byte("key")).Sign(req, []byte("The quick brown fox jumps over the lazy dog"))
+	const want = "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got := req.Header.Get("X-Signature"); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	clock := web.NewFakeClock(1e9)
+	defer web.SetClock(web.SetClock(clock))
+
+	b := NewBreaker(&BreakerOptions{MinRequests: 4, FailureRate: 0.5, Window: 10e9, OpenTimeout: 5e9})
+	for _, success := range []bool{true, false, true} {
+		if !b.Allow() {
+			t.Fatal("closed breaker did not allow request")
+		}
+		b.Record(success)
+	}
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("state=%v, want closed", s)
+	}
+	b.Allow()
+	b.Record(false)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("state=%v, want open", s)
+	}
+	if b.Allow() {
+		t.Error("open breaker allowed request")
+	}
+
+	clock.Advance(5e9)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("state=%v, want half-open", s)
+	}
+	if !b.Allow() {
+		t.Fatal("half-open breaker did not allow probe")
+	}
+	if b.Ready() || b.Allow() {
+		t.Error("half-open breaker allowed second probe")
+	}
+	b.Record(false)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("state=%v after failed probe, want open", s)
+	}
+
+	clock.Advance(5e9)
+	b.Allow()
+	b.Record(true)
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("state=%v after successful probe, want closed", s)
+	}
+}
+
+func TestTransportBreaker(t *testing.T) {
+	attempts := 0
+	transport := &Transport{
+		Breaker: NewBreaker(&BreakerOptions{MinRequests: 2, OpenTimeout: 60e9}),
+		Transport: transportFunc(func(req *http.Request) (*http.Response, os.Error) {
+			attempts += 1
+			return newResponse(503), nil
+		}),
+	}
+	for i := 0; i < 3; i++ {
+		req := &http.Request{Method: "GET", Header: make(http.Header)}
+		resp, err := transport.RoundTrip(req)
+		if i < 2 && (err != nil || resp.StatusCode != 503) {
+			t.Errorf("request %d returned %v, want 503 response", i, err)
+		}
+		if i == 2 && err != ErrBreakerOpen {
+			t.Errorf("request %d returned %v, want ErrBreakerOpen", i, err)
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("attempts=%d, want 2", attempts)
+	}
+}
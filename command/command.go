@@ -0,0 +1,228 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package command implements Twister handlers that stream the output of
+// external processes as the response body.
+package command
+
+import (
+	"bufio"
+	"exec"
+	"github.com/garyburd/twister/web"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Options configures the execution of a command.
+type Options struct {
+	// Content type of the response. The default is
+	// "application/octet-stream".
+	ContentType string
+
+	// Additional response headers.
+	Header web.Header
+
+	// The command is killed if it runs longer than Timeout nanoseconds. No
+	// timeout is applied if Timeout is zero.
+	Timeout int64
+
+	// Working directory and environment for the command. See exec.Cmd.
+	Dir string
+	Env []string
+
+	// If true, the request body is copied to the command's standard input.
+	Stdin bool
+
+	// ExitStatus maps a non-zero exit code to an HTTP status. ExitStatus is
+	// called only if the command exits before writing to standard output. If
+	// ExitStatus is nil, then all non-zero exit codes map to status 500.
+	ExitStatus func(code int) int
+}
+
+var defaultOptions Options
+
+// stderrLogger logs lines written to the command's standard error.
+type stderrLogger struct {
+	name string
+	buf  []byte
+}
+
+func (l *stderrLogger) Write(p []byte) (int, os.Error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := 0
+		for i < len(l.buf) && l.buf[i] != '\n' {
+			i += 1
+		}
+		if i == len(l.buf) {
+			break
+		}
+		log.Printf("twister.command: %s: %s", l.name, l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *stderrLogger) flush() {
+	if len(l.buf) > 0 {
+		log.Printf("twister.command: %s: %s", l.name, l.buf)
+		l.buf = nil
+	}
+}
+
+// start starts the command with the given options.
+func start(req *web.Request, options *Options, name string, args []string) (cmd *exec.Cmd, stdout io.ReadCloser, stderr *stderrLogger, timer *time.Timer, err os.Error) {
+	cmd = exec.Command(name, args...)
+	cmd.Dir = options.Dir
+	cmd.Env = options.Env
+	stderr = &stderrLogger{name: name}
+	cmd.Stderr = stderr
+	if options.Stdin {
+		cmd.Stdin = req.Body
+	}
+	stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	if options.Timeout > 0 {
+		timer = time.AfterFunc(options.Timeout, func() {
+			log.Printf("twister.command: %s: killed after timeout", name)
+			cmd.Process.Kill()
+		})
+	}
+	return
+}
+
+// wait waits for the command to exit and returns the exit code.
+func wait(cmd *exec.Cmd, stderr *stderrLogger, timer *time.Timer) (int, os.Error) {
+	err := cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+	stderr.flush()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitStatus(), nil
+	}
+	return 0, err
+}
+
+func exitStatus(options *Options, code int) int {
+	if options.ExitStatus != nil {
+		return options.ExitStatus(code)
+	}
+	return web.StatusInternalServerError
+}
+
+// Serve runs the named command with the given arguments and streams the
+// command's standard output as the response body. Output is flushed to the
+// network as it is read from the command.
+//
+// The response status is 200 unless the command exits with a non-zero exit
+// code before writing to standard output. In that case, the status is
+// determined by options.ExitStatus. Standard error is written to the log.
+func Serve(req *web.Request, options *Options, name string, args ...string) {
+	serve(req, options, nil, name, args)
+}
+
+// serve runs the command and writes prefix followed by the command's
+// standard output to the response.
+func serve(req *web.Request, options *Options, prefix []byte, name string, args []string) {
+	if options == nil {
+		options = &defaultOptions
+	}
+
+	cmd, stdout, stderr, timer, err := start(req, options, name, args)
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+
+	br := bufio.NewReader(stdout)
+	_, err = br.Peek(1)
+	if err != nil {
+		// The command exited or closed stdout without writing output.
+		code, werr := wait(cmd, stderr, timer)
+		switch {
+		case werr != nil:
+			req.Error(web.StatusInternalServerError, werr)
+		case code != 0:
+			req.Error(exitStatus(options, code), os.NewError("twister.command: "+name+" failed"))
+		default:
+			respond(req, options, web.StatusOK).Write(prefix)
+		}
+		return
+	}
+
+	w := respond(req, options, web.StatusOK)
+	w.Write(prefix)
+	if err := copyAndFlush(w, br); err != nil {
+		// Kill the command so that Wait does not block forever on a command
+		// blocked writing to a full stdout pipe.
+		cmd.Process.Kill()
+	}
+	if code, err := wait(cmd, stderr, timer); err != nil || code != 0 {
+		log.Printf("twister.command: %s: exit code %d, %v", name, code, err)
+	}
+}
+
+func respond(req *web.Request, options *Options, status int) io.Writer {
+	header := web.Header{}
+	for k, v := range options.Header {
+		header[k] = v
+	}
+	contentType := options.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set(web.HeaderContentType, contentType)
+	return req.Responder.Respond(status, header)
+}
+
+// copyAndFlush copies src to w, flushing w after each read.
+func copyAndFlush(w io.Writer, src io.Reader) os.Error {
+	f, _ := w.(web.Flusher)
+	p := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(p)
+		if n > 0 {
+			if _, werr := w.Write(p[:n]); werr != nil {
+				return werr
+			}
+			if f != nil {
+				if ferr := f.Flush(); ferr != nil {
+					return ferr
+				}
+			}
+		}
+		if err == os.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler that runs the named command and streams the
+// output as the response body. See Serve for details.
+func Handler(options *Options, name string, args ...string) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		Serve(req, options, name, args...)
+	})
+}
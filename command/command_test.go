@@ -0,0 +1,98 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package command
+
+import (
+	"github.com/garyburd/twister/web"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+var serveTests = []struct {
+	name   string
+	args   []string
+	status int
+	body   string
+}{
+	{"echo", []string{"hello"}, web.StatusOK, "hello\n"},
+	{"true", nil, web.StatusOK, ""},
+	{"false", nil, web.StatusInternalServerError, ""},
+}
+
+func TestServe(t *testing.T) {
+	options := &Options{ContentType: "text/plain"}
+	for _, tt := range serveTests {
+		status, header, body := web.RunHandler("/", "GET", nil, nil, Handler(options, tt.name, tt.args...))
+		if status != tt.status {
+			t.Errorf("%s status=%d, want %d", tt.name, status, tt.status)
+			continue
+		}
+		if status == web.StatusOK {
+			if ct := header.Get(web.HeaderContentType); ct != "text/plain" {
+				t.Errorf("%s content-type=%q, want text/plain", tt.name, ct)
+			}
+			if string(body) != tt.body {
+				t.Errorf("%s body=%q, want %q", tt.name, body, tt.body)
+			}
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	options := &Options{ExitStatus: func(code int) int { return web.StatusNotFound }}
+	status, _, _ := web.RunHandler("/", "GET", nil, nil, Handler(options, "false"))
+	if status != web.StatusNotFound {
+		t.Errorf("status=%d, want %d", status, web.StatusNotFound)
+	}
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, os.Error) { return 0, os.EPIPE }
+
+// disconnectedResponder simulates a client that disconnects after the
+// response headers are sent.
+type disconnectedResponder struct {
+	web.Responder
+}
+
+func (r disconnectedResponder) Respond(status int, header web.Header) io.Writer {
+	r.Responder.Respond(status, header)
+	return errorWriter{}
+}
+
+func TestServeDisconnect(t *testing.T) {
+	done := make(chan bool)
+	go func() {
+		web.RunHandler("/", "GET", nil, nil, web.HandlerFunc(func(req *web.Request) {
+			req.Responder = disconnectedResponder{req.Responder}
+			Serve(req, nil, "yes")
+		}))
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(10e9):
+		t.Fatal("Serve did not return after write error")
+	}
+}
+
+func TestPktLine(t *testing.T) {
+	if s := string(pktLine("# service=git-upload-pack\n")); s != "001e# service=git-upload-pack\n" {
+		t.Errorf("pktLine=%q", s)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package command
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"os"
+	"path"
+	"strings"
+)
+
+// GitOptions configures the Git smart HTTP handler.
+type GitOptions struct {
+	// Directory containing the repositories.
+	Root string
+
+	// URL path prefix stripped before mapping the request path to a
+	// repository directory.
+	Prefix string
+
+	// Enable push with git-receive-pack. Applications that enable push
+	// should wrap the handler with an authentication filter.
+	ReceivePack bool
+
+	// Timeout in nanoseconds for the git process. Zero means no timeout.
+	Timeout int64
+
+	// Path to the git executable. The default is "git".
+	Git string
+}
+
+type gitHandler struct {
+	options GitOptions
+}
+
+// GitHandler returns a handler that serves Git repositories using the
+// smart HTTP protocol. Clone and fetch are handled by git-upload-pack. Push
+// is handled by git-receive-pack if enabled in the options. Request and
+// response bodies are streamed to and from the git process.
+//
+// The handler should be registered for the paths below the prefix:
+//
+//  h := command.GitHandler(&command.GitOptions{Root: "/srv/git", Prefix: "/git"})
+//  r.Register("/git/<:.*>", "GET", h, "POST", h)
+//
+// Access control is the responsibility of the application. A typical
+// application wraps the handler with a filter that authenticates pushes.
+func GitHandler(options *GitOptions) web.Handler {
+	gh := &gitHandler{options: *options}
+	gh.options.Root = path.Clean(gh.options.Root) + "/"
+	if gh.options.Git == "" {
+		gh.options.Git = "git"
+	}
+	return gh
+}
+
+// repoDir returns the repository directory for the URL path p or "" if the
+// path is not valid.
+func (gh *gitHandler) repoDir(p string) string {
+	if !strings.HasPrefix(p, gh.options.Prefix) {
+		return ""
+	}
+	dir := path.Clean(gh.options.Root + p[len(gh.options.Prefix):])
+	if !strings.HasPrefix(dir, gh.options.Root) {
+		return ""
+	}
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDirectory() {
+		return ""
+	}
+	return dir
+}
+
+func (gh *gitHandler) serviceAllowed(service string) bool {
+	switch service {
+	case "git-upload-pack":
+		return true
+	case "git-receive-pack":
+		return gh.options.ReceivePack
+	}
+	return false
+}
+
+// pktLine returns s encoded as a Git protocol packet line.
+func pktLine(s string) []byte {
+	return []byte(fmt.Sprintf("%04x%s", len(s)+4, s))
+}
+
+func (gh *gitHandler) ServeWeb(req *web.Request) {
+	p := req.URL.Path
+	switch {
+	case strings.HasSuffix(p, "/info/refs"):
+		gh.serveRefs(req, p[:len(p)-len("/info/refs")])
+	case strings.HasSuffix(p, "/git-upload-pack"):
+		gh.serveRPC(req, p[:len(p)-len("/git-upload-pack")], "git-upload-pack")
+	case strings.HasSuffix(p, "/git-receive-pack"):
+		gh.serveRPC(req, p[:len(p)-len("/git-receive-pack")], "git-receive-pack")
+	default:
+		req.Error(web.StatusNotFound, nil)
+	}
+}
+
+func (gh *gitHandler) commandOptions(contentType string) *Options {
+	return &Options{
+		ContentType: contentType,
+		Timeout:     gh.options.Timeout,
+		Header:      web.NewHeader(web.HeaderCacheControl, "no-cache"),
+	}
+}
+
+func (gh *gitHandler) serveRefs(req *web.Request, repo string) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		req.Error(web.StatusMethodNotAllowed, nil, web.HeaderAllow, "GET, HEAD")
+		return
+	}
+	service := req.Param.Get("service")
+	if service == "" {
+		req.Error(web.StatusForbidden, os.NewError("twister.command: dumb protocol not supported"))
+		return
+	}
+	if !gh.serviceAllowed(service) {
+		req.Error(web.StatusForbidden, nil)
+		return
+	}
+	dir := gh.repoDir(repo)
+	if dir == "" {
+		req.Error(web.StatusNotFound, nil)
+		return
+	}
+	prefix := append(pktLine("# service="+service+"\n"), "0000"...)
+	serve(req,
+		gh.commandOptions("application/x-"+service+"-advertisement"),
+		prefix,
+		gh.options.Git,
+		[]string{service[len("git-"):], "--stateless-rpc", "--advertise-refs", dir})
+}
+
+func (gh *gitHandler) serveRPC(req *web.Request, repo string, service string) {
+	if req.Method != "POST" {
+		req.Error(web.StatusMethodNotAllowed, nil, web.HeaderAllow, "POST")
+		return
+	}
+	if !gh.serviceAllowed(service) {
+		req.Error(web.StatusForbidden, nil)
+		return
+	}
+	if req.Header.Get(web.HeaderContentType) != "application/x-"+service+"-request" {
+		req.Error(web.StatusUnsupportedMediaType, nil)
+		return
+	}
+	dir := gh.repoDir(repo)
+	if dir == "" {
+		req.Error(web.StatusNotFound, nil)
+		return
+	}
+	switch req.Header.Get(web.HeaderContentEncoding) {
+	case "":
+		// ok
+	case "gzip":
+		r, err := gzip.NewReader(req.Body)
+		if err != nil {
+			req.Error(web.StatusBadRequest, err)
+			return
+		}
+		defer r.Close()
+		req.Body = r
+	default:
+		req.Error(web.StatusUnsupportedMediaType, nil)
+		return
+	}
+	options := gh.commandOptions("application/x-" + service + "-result")
+	options.Stdin = true
+	serve(req, options, nil, gh.options.Git, []string{service[len("git-"):], "--stateless-rpc", dir})
+}
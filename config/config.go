@@ -0,0 +1,266 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package config loads server configuration from files and the environment.
+//
+// A configuration file is either a JSON object or an INI file with one
+// "key = value" pair per line. Lines starting with '#' or ';' and section
+// headers are ignored in INI files. Keys are matched against the Config field
+// names ignoring case, underscores and dashes. List values are written as
+// JSON arrays or as comma separated strings. Example INI file:
+//
+//  addr = :443
+//  read_timeout = 30
+//  allowed_hosts = example.com, *.example.com
+//  tls_cert_file = /etc/ssl/example.com.crt
+//  tls_key_file = /etc/ssl/example.com.key
+//  log_format = json
+//  log_file = /var/log/example/access.log
+//
+// Use the server.New function to create a server from a Config.
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config is the server configuration.
+type Config struct {
+	// TCP address to listen on. The default is ":8080".
+	Addr string
+
+	// Host used for requests without a Host header.
+	DefaultHost string
+
+	// Allowed values of the request Host header. See server.Server.
+	AllowedHosts []string
+
+	// Connection read and write timeouts in seconds. Zero disables the
+	// timeout.
+	ReadTimeout  int
+	WriteTimeout int
+
+	// Maximum number of in-flight connections. Zero is unlimited.
+	MaxConnections int
+
+	// Size of the response body buffer in bytes. The default is 4096.
+	ResponseBufferSize int
+
+	// Certificate and key files. If both are set, the server accepts TLS
+	// connections.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Request log format: "short", "verbose", "apache", "json" or "none".
+	// The default is "short".
+	LogFormat string
+
+	// Request log destination. The value is a file name, "stderr" or
+	// "syslog://host:port" for a remote syslog collector. The default is
+	// "stderr".
+	LogFile string
+}
+
+// Default returns a configuration with the default values.
+func Default() *Config {
+	return &Config{
+		Addr:               ":8080",
+		ResponseBufferSize: 4096,
+		LogFormat:          "short",
+		LogFile:            "stderr",
+	}
+}
+
+// normalizeKey converts a key to the lower case field name.
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.Map(func(r int) int {
+		if r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(key)))
+}
+
+func splitList(value string) []string {
+	var result []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Set sets the field with the given key to value.
+func (c *Config) Set(key, value string) os.Error {
+	value = strings.TrimSpace(value)
+	var ip *int
+	switch normalizeKey(key) {
+	case "addr":
+		c.Addr = value
+	case "defaulthost":
+		c.DefaultHost = value
+	case "allowedhosts":
+		// Host names are compared in lower case.
+		c.AllowedHosts = splitList(strings.ToLower(value))
+	case "readtimeout":
+		ip = &c.ReadTimeout
+	case "writetimeout":
+		ip = &c.WriteTimeout
+	case "maxconnections":
+		ip = &c.MaxConnections
+	case "responsebuffersize":
+		ip = &c.ResponseBufferSize
+	case "tlscertfile":
+		c.TLSCertFile = value
+	case "tlskeyfile":
+		c.TLSKeyFile = value
+	case "logformat":
+		switch value {
+		case "short", "verbose", "apache", "json", "none":
+		default:
+			return os.NewError("twister.config: unknown log format " + value)
+		}
+		c.LogFormat = value
+	case "logfile":
+		c.LogFile = value
+	default:
+		return os.NewError("twister.config: unknown key " + key)
+	}
+	if ip != nil {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return os.NewError("twister.config: bad value for " + key + ": " + value)
+		}
+		*ip = n
+	}
+	return nil
+}
+
+// ParseJSON sets fields from a JSON object.
+func (c *Config) ParseJSON(p []byte) os.Error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	for key, v := range m {
+		var value string
+		switch v := v.(type) {
+		case []interface{}:
+			a := make([]string, len(v))
+			for i := range v {
+				a[i] = fmt.Sprint(v[i])
+			}
+			value = strings.Join(a, ",")
+		case float64:
+			value = strconv.Itoa(int(v))
+		default:
+			value = fmt.Sprint(v)
+		}
+		if err := c.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadINI reads "key = value" pairs from r and calls set for each pair.
+// Blank lines, comments and section headers are skipped.
+func ReadINI(r io.Reader, set func(key, value string) os.Error) os.Error {
+	br := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		line, err := br.ReadString('\n')
+		if err == os.EOF {
+			if line == "" {
+				return nil
+			}
+		} else if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '[' {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return os.NewError(fmt.Sprintf("twister.config: line %d: missing '='", lineNum))
+		}
+		if err := set(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])); err != nil {
+			return os.NewError(fmt.Sprintf("%s (line %d)", err.String(), lineNum))
+		}
+	}
+	panic("unreachable")
+}
+
+// ParseINI sets fields from an INI file.
+func (c *Config) ParseINI(r io.Reader) os.Error {
+	return ReadINI(r, func(key, value string) os.Error { return c.Set(key, value) })
+}
+
+// LoadFile sets fields from the named JSON or INI file. The file is parsed as
+// JSON if the first non-space character is '{'.
+func (c *Config) LoadFile(filename string) os.Error {
+	p, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if q := bytes.TrimSpace(p); len(q) > 0 && q[0] == '{' {
+		return c.ParseJSON(p)
+	}
+	return c.ParseINI(bytes.NewBuffer(p))
+}
+
+// LoadEnv sets fields from environment variables with the given prefix. The
+// variable name is the prefix followed by the upper case field name with
+// words separated by underscores, for example TWISTER_READ_TIMEOUT for the
+// ReadTimeout field with the prefix "TWISTER_".
+func (c *Config) LoadEnv(prefix string) os.Error {
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		if err := c.Set(kv[len(prefix):i], kv[i+1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load returns the default configuration updated from the named file and
+// then from the environment variables with the given prefix. If filename is
+// "", only the environment is used.
+func Load(filename, envPrefix string) (*Config, os.Error) {
+	c := Default()
+	if filename != "" {
+		if err := c.LoadFile(filename); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadEnv(envPrefix); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseINI(t *testing.T) {
+	c := Default()
+	err := c.ParseINI(strings.NewReader(`
+# comment
+[server]
+addr = :443
+read_timeout = 30
+Allowed-Hosts = example.com, *.EXAMPLE.com
+log_format = json
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.Addr = ":443"
+	expected.ReadTimeout = 30
+	expected.AllowedHosts = []string{"example.com", "*.example.com"}
+	expected.LogFormat = "json"
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("config = %+v, want %+v", c, expected)
+	}
+
+	for _, s := range []string{"addr", "unknown = 1", "read_timeout = x", "log_format = xml"} {
+		if err := Default().ParseINI(strings.NewReader(s)); err == nil {
+			t.Errorf("ParseINI(%q) did not return error", s)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	c := Default()
+	err := c.ParseJSON([]byte(`{"addr": ":443", "maxConnections": 100, "allowedHosts": ["a.com", "b.com"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":443" || c.MaxConnections != 100 || !reflect.DeepEqual(c.AllowedHosts, []string{"a.com", "b.com"}) {
+		t.Errorf("config = %+v", c)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	os.Setenv("TWISTERTEST_WRITE_TIMEOUT", "10")
+	os.Setenv("TWISTERTEST_LOG_FILE", "/tmp/access.log")
+	c, err := Load("", "TWISTERTEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.WriteTimeout != 10 || c.LogFile != "/tmp/access.log" || c.Addr != ":8080" {
+		t.Errorf("config = %+v", c)
+	}
+}
@@ -8,33 +8,11 @@ import (
 	"template"
 )
 
-type subscription struct {
-	conn      *websocket.Conn
-	subscribe bool
-}
-
 var (
-	messageChan      = make(chan []byte)
-	chatTempl        *template.Template
-	subscriptionChan = make(chan subscription)
+	hub       = websocket.NewHub()
+	chatTempl *template.Template
 )
 
-func hub() {
-	conns := make(map[*websocket.Conn]int)
-	for {
-		select {
-		case subscription := <-subscriptionChan:
-			conns[subscription.conn] = 0, subscription.subscribe
-		case message := <-messageChan:
-			for conn, _ := range conns {
-				if err := conn.WriteMessage(message); err != nil {
-					conn.Close()
-				}
-			}
-		}
-	}
-}
-
 func chatWsHandler(req *web.Request) {
 	conn, err := websocket.Upgrade(req, 1024, 1024, nil)
 	if err != nil {
@@ -42,12 +20,9 @@ func chatWsHandler(req *web.Request) {
 		return
 	}
 
-	defer func() {
-		subscriptionChan <- subscription{conn, false}
-		conn.Close()
-	}()
-
-	subscriptionChan <- subscription{conn, true}
+	c := hub.Register(conn)
+	defer c.Close()
+	c.Join("chat")
 
 	for {
 		p, hasMore, err := conn.ReadMessage()
@@ -58,7 +33,7 @@ func chatWsHandler(req *web.Request) {
 		// copy because Receive reuses underling byte array.
 		mp := make([]byte, len(p))
 		copy(mp, p)
-		messageChan <- mp
+		hub.Broadcast("chat", mp)
 	}
 }
 
@@ -74,7 +49,6 @@ func main() {
 	if err := chatTempl.Parse(chatStr); err != nil {
 		panic("template error: " + err.String())
 	}
-	go hub()
 	server.Run(":8080",
 		web.NewRouter().
 			Register("/", "GET", chatFrameHandler).
@@ -0,0 +1,106 @@
+// Command loadgen is a simple HTTP load generator for measuring server
+// throughput and latency.
+//
+//  loadgen -addr localhost:8080 -path / -c 10 -n 10000 -k=true
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	addr        = flag.String("addr", "localhost:8080", "server address")
+	path        = flag.String("path", "/", "request path")
+	concurrency = flag.Int("c", 10, "number of concurrent clients")
+	count       = flag.Int("n", 10000, "total number of requests")
+	keepAlive   = flag.Bool("k", true, "use keep-alive connections")
+)
+
+type int64Slice []int64
+
+func (p int64Slice) Len() int           { return len(p) }
+func (p int64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// client sends n requests and sends the latency of each request to results.
+func client(n int, results chan int64) {
+	request := "GET " + *path + " HTTP/1.1\r\nHost: " + *addr + "\r\n"
+	if !*keepAlive {
+		request += "Connection: close\r\n"
+	}
+	request += "\r\n"
+
+	var conn net.Conn
+	var br *bufio.Reader
+	for i := 0; i < n; i++ {
+		start := time.Nanoseconds()
+		if conn == nil {
+			var err os.Error
+			conn, err = net.Dial("tcp", *addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			br = bufio.NewReader(conn)
+		}
+		if _, err := io.WriteString(conn, request); err != nil {
+			log.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, "GET")
+		if err != nil {
+			log.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if !*keepAlive || resp.Close {
+			conn.Close()
+			conn = nil
+		}
+		results <- time.Nanoseconds() - start
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *count <= 0 || *concurrency <= 0 {
+		log.Fatal("loadgen: -n and -c must be positive")
+	}
+	results := make(chan int64, *concurrency)
+	start := time.Nanoseconds()
+	for i := 0; i < *concurrency; i++ {
+		n := *count / *concurrency
+		if i < *count%*concurrency {
+			n += 1
+		}
+		go client(n, results)
+	}
+	latencies := make(int64Slice, *count)
+	for i := range latencies {
+		latencies[i] = <-results
+	}
+	elapsed := time.Nanoseconds() - start
+	sort.Sort(latencies)
+
+	fmt.Printf("requests:    %d\n", *count)
+	fmt.Printf("elapsed:     %.3f s\n", float64(elapsed)/1e9)
+	fmt.Printf("throughput:  %.1f req/s\n", float64(*count)*1e9/float64(elapsed))
+	for _, pct := range []int{50, 90, 99, 100} {
+		i := (len(latencies)*pct)/100 - 1
+		if i < 0 {
+			i = 0
+		}
+		fmt.Printf("latency %3d%%: %.3f ms\n", pct, float64(latencies[i])/1e6)
+	}
+}
@@ -34,7 +34,6 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
-	"time"
 )
 
 var (
@@ -166,13 +165,13 @@ func ServeWeb(req *web.Request) {
 }
 
 func init() {
-	start := time.Seconds()
+	start := web.Seconds()
 	Publish("runtime", map[string]interface{}{
 		"cgocalls":   Func(func() interface{} { return runtime.Cgocalls() }),
 		"goroutines": Func(func() interface{} { return runtime.Goroutines() }),
 		"version":    runtime.Version(),
 		"memstats":   &runtime.MemStats,
 	})
-	Publish("uptimeSeconds", Func(func() interface{} { return time.Seconds() - start }))
+	Publish("uptimeSeconds", Func(func() interface{} { return web.Seconds() - start }))
 	Publish("cmdline", &os.Args)
 }
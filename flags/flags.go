@@ -0,0 +1,263 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package flags implements feature flags with percentage rollouts.
+//
+// Define the flags at startup, override them from a configuration file or at
+// runtime through the admin endpoint and evaluate them per request:
+//
+//  var features = flags.NewSet()
+//
+//  func init() {
+//      features.Define("newCheckout", false, "Use the new checkout flow.")
+//  }
+//
+//  func main() {
+//      ...
+//      h := flags.Handler(features, flags.ClientIP, r)
+//  }
+//
+//  func checkoutHandler(req *web.Request) {
+//      if flags.Enabled(req, "newCheckout") {
+//          ...
+//      }
+//  }
+//
+// A flag's value is "on", "off" or a rollout percentage such as "25%". A
+// request is in the rollout when a hash of the flag name and the request key
+// falls below the percentage. The key is typically the user id or client IP
+// address so that a user sees the same value across requests.
+package flags
+
+import (
+	"github.com/garyburd/twister/config"
+	"github.com/garyburd/twister/web"
+	"hash/crc32"
+	"io"
+	"json"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type flag struct {
+	name        string
+	description string
+	def         bool
+	percent     int // -1 if not overridden
+}
+
+func (f *flag) value() string {
+	switch {
+	case f.percent < 0:
+		return "default"
+	case f.percent == 0:
+		return "off"
+	case f.percent == 100:
+		return "on"
+	}
+	return strconv.Itoa(f.percent) + "%"
+}
+
+// Set is a set of feature flags.
+type Set struct {
+	mu    sync.RWMutex
+	flags map[string]*flag
+}
+
+// NewSet returns a new empty set.
+func NewSet() *Set {
+	return &Set{flags: make(map[string]*flag)}
+}
+
+// Define defines a flag with the given default value. Define panics if the
+// flag is already defined.
+func (s *Set) Define(name string, def bool, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.flags[name]; ok {
+		panic("twister.flags: flag " + name + " already defined")
+	}
+	s.flags[name] = &flag{name: name, description: description, def: def, percent: -1}
+}
+
+func parseValue(value string) (int, os.Error) {
+	switch value {
+	case "default":
+		return -1, nil
+	case "on", "true":
+		return 100, nil
+	case "off", "false":
+		return 0, nil
+	}
+	if strings.HasSuffix(value, "%") {
+		if n, err := strconv.Atoi(value[:len(value)-1]); err == nil && n >= 0 && n <= 100 {
+			return n, nil
+		}
+	}
+	return 0, os.NewError("twister.flags: bad value " + value)
+}
+
+// Set overrides the value of the named flag. The value is "on", "off", a
+// percentage such as "25%" or "default" to remove the override.
+func (s *Set) Set(name, value string) os.Error {
+	percent, err := parseValue(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.flags[name]
+	if !ok {
+		return os.NewError("twister.flags: unknown flag " + name)
+	}
+	f.percent = percent
+	return nil
+}
+
+// Load overrides flags from an INI file with lines of the form
+// "name = value". See Set for the format of the value.
+func (s *Set) Load(r io.Reader) os.Error {
+	return config.ReadINI(r, s.Set)
+}
+
+// Enabled returns whether the named flag is enabled for the given key.
+// Undefined flags are disabled.
+func (s *Set) Enabled(name, key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.flags[name]
+	switch {
+	case !ok:
+		return false
+	case f.percent < 0:
+		return f.def
+	case f.percent == 0:
+		return false
+	case f.percent == 100:
+		return true
+	}
+	return int(crc32.ChecksumIEEE([]byte(name+":"+key))%100) < f.percent
+}
+
+// Values returns the value of all flags for the given key.
+func (s *Set) Values(key string) map[string]bool {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.flags))
+	for name := range s.flags {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	m := make(map[string]bool, len(names))
+	for _, name := range names {
+		m[name] = s.Enabled(name, key)
+	}
+	return m
+}
+
+// ServeWeb serves the admin endpoint. GET responds with the flags as a JSON
+// document of the form:
+//
+//  {"flags": [{"name": "newCheckout", "description": "...", "default": false, "value": "25%"}]}
+//
+// POST with the parameters "name" and "value" overrides a flag.
+func (s *Set) ServeWeb(req *web.Request) {
+	if req.Method == "POST" {
+		if err := s.Set(req.Param.Get("name"), req.Param.Get("value")); err != nil {
+			req.Error(web.StatusBadRequest, err)
+			return
+		}
+	}
+	s.mu.RLock()
+	var names []string
+	for name := range s.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	flags := make([]interface{}, len(names))
+	for i, name := range names {
+		f := s.flags[name]
+		flags[i] = map[string]interface{}{
+			"name":        f.name,
+			"description": f.description,
+			"default":     f.def,
+			"value":       f.value(),
+		}
+	}
+	s.mu.RUnlock()
+	p, err := json.Marshal(map[string]interface{}{"flags": flags})
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	w := req.Respond(web.StatusOK,
+		web.HeaderContentType, "application/json; charset=utf-8",
+		web.HeaderContentLength, strconv.Itoa(len(p)))
+	w.Write(p)
+}
+
+const envKey = "twister.flags"
+
+type requestFlags struct {
+	set *Set
+	key string
+}
+
+// FlagEnabled implements the web.FlagChecker interface.
+func (rf *requestFlags) FlagEnabled(name string) bool {
+	return rf.set.Enabled(name, rf.key)
+}
+
+// ClientIP returns the client IP address of the request. Use ClientIP as
+// the key function for anonymous users.
+func ClientIP(req *web.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// Handler returns a handler that makes the flags in set available to h
+// through the Enabled and Values functions and to web.FlagSwitchHandler. The
+// function key returns the rollout key for a request.
+func Handler(set *Set, key func(req *web.Request) string, h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		rf := &requestFlags{set, key(req)}
+		req.Env[envKey] = rf
+		req.Env[web.FlagCheckerEnvKey] = rf
+		h.ServeWeb(req)
+	})
+}
+
+// Enabled returns whether the named flag is enabled for the request. The
+// request must be handled by a Handler.
+func Enabled(req *web.Request, name string) bool {
+	rf, ok := req.Env[envKey].(*requestFlags)
+	return ok && rf.set.Enabled(name, rf.key)
+}
+
+// Values returns the value of all flags for the request. Pass the map to
+// templates as a section:
+//
+//  {.section flags}{.section newCheckout}...{.end}{.end}
+func Values(req *web.Request) map[string]bool {
+	rf, ok := req.Env[envKey].(*requestFlags)
+	if !ok {
+		return map[string]bool{}
+	}
+	return rf.set.Values(rf.key)
+}
@@ -0,0 +1,98 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package flags
+
+import (
+	"github.com/garyburd/twister/web"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	s := NewSet()
+	s.Define("a", true, "")
+	s.Define("b", false, "")
+	s.Define("c", false, "")
+	if err := s.Load(strings.NewReader("b = on\nc = 50%\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Enabled("a", "x") || !s.Enabled("b", "x") || s.Enabled("undefined", "x") {
+		t.Errorf("unexpected values %v", s.Values("x"))
+	}
+	n := 0
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if s.Enabled("c", key) {
+			n++
+		}
+		if s.Enabled("c", key) != s.Enabled("c", key) {
+			t.Fatalf("value for key %s is not stable", key)
+		}
+	}
+	if n < 400 || n > 600 {
+		t.Errorf("50%% rollout enabled for %d of 1000 keys", n)
+	}
+	for _, v := range []string{"maybe", "101%", "-1%"} {
+		if err := s.Set("a", v); err == nil {
+			t.Errorf("Set(%q) did not return error", v)
+		}
+	}
+	if err := s.Set("z", "on"); err == nil {
+		t.Error("Set of undefined flag did not return error")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := NewSet()
+	s.Define("a", false, "")
+	var enabled bool
+	h := Handler(s, ClientIP, web.HandlerFunc(func(req *web.Request) {
+		enabled = Enabled(req, "a")
+		req.Respond(web.StatusOK)
+	}))
+	web.RunHandler("http://example.com/", "GET", nil, nil, h)
+	if enabled {
+		t.Error("flag enabled before override")
+	}
+	status, _, _ := web.RunHandler("http://example.com/?name=a&value=on", "POST", nil, nil, web.FormHandler(1000, false, s))
+	if status != web.StatusOK {
+		t.Fatalf("admin POST status = %d", status)
+	}
+	web.RunHandler("http://example.com/", "GET", nil, nil, h)
+	if !enabled {
+		t.Error("flag not enabled after override")
+	}
+}
+
+func TestFlagSwitchHandler(t *testing.T) {
+	s := NewSet()
+	s.Define("new", false, "")
+	respond := func(status int) web.Handler {
+		return web.HandlerFunc(func(req *web.Request) { req.Respond(status) })
+	}
+	h := Handler(s, ClientIP, web.FlagSwitchHandler("new", respond(web.StatusOK), nil))
+	if status, _, _ := web.RunHandler("http://example.com/", "GET", nil, nil, h); status != web.StatusNotFound {
+		t.Errorf("status = %d with flag off, want %d", status, web.StatusNotFound)
+	}
+	s.Set("new", "on")
+	if status, _, _ := web.RunHandler("http://example.com/", "GET", nil, nil, h); status != web.StatusOK {
+		t.Errorf("status = %d with flag on, want %d", status, web.StatusOK)
+	}
+	h = web.FlagSwitchHandler("new", respond(web.StatusOK), respond(web.StatusAccepted))
+	if status, _, _ := web.RunHandler("http://example.com/", "GET", nil, nil, h); status != web.StatusAccepted {
+		t.Errorf("status = %d without checker, want %d", status, web.StatusAccepted)
+	}
+}
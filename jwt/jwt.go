@@ -0,0 +1,223 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package jwt implements verification of JSON Web Token bearer tokens.
+//
+// The HS256 and RS256 signature algorithms are supported. Keys are obtained
+// from a KeySet. Applications rotate keys by returning multiple keys from the
+// key set and selecting the key using the token's "kid" header.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/garyburd/twister/web"
+	"json"
+	"os"
+	"strings"
+)
+
+var (
+	ErrMalformed        = os.NewError("twister.jwt: malformed token")
+	ErrUnknownAlgorithm = os.NewError("twister.jwt: unknown signature algorithm")
+	ErrUnknownKey       = os.NewError("twister.jwt: unknown key")
+	ErrBadSignature     = os.NewError("twister.jwt: bad signature")
+	ErrExpired          = os.NewError("twister.jwt: token expired")
+	ErrNotValidYet      = os.NewError("twister.jwt: token not valid yet")
+	ErrBadAudience      = os.NewError("twister.jwt: bad audience")
+)
+
+// KeySet provides the keys used to verify token signatures. Key returns a
+// []byte secret for the HS256 algorithm and a *rsa.PublicKey for the RS256
+// algorithm. The keyID argument is the value of the "kid" token header or ""
+// if the header is not present.
+type KeySet interface {
+	Key(algorithm, keyID string) (key interface{}, err os.Error)
+}
+
+// StaticKeySet is a KeySet backed by a map from key id to key. Use the empty
+// string as the key id for tokens without the "kid" header.
+type StaticKeySet map[string]interface{}
+
+func (ks StaticKeySet) Key(algorithm, keyID string) (interface{}, os.Error) {
+	key, found := ks[keyID]
+	if !found {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Claims is the set of claims in a token. Numeric claims are stored as
+// float64.
+type Claims map[string]interface{}
+
+// String returns the string claim with the given name or "" if the claim is
+// not present or not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject returns the "sub" claim.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func decodeSegment(s string) ([]byte, os.Error) {
+	if m := len(s) % 4; m != 0 {
+		s += strings.Repeat("=", 4-m)
+	}
+	p := make([]byte, base64.URLEncoding.DecodedLen(len(s)))
+	n, err := base64.URLEncoding.Decode(p, []byte(s))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return p[:n], nil
+}
+
+func verifySignature(algorithm string, key interface{}, signed, signature []byte) os.Error {
+	switch algorithm {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrUnknownKey
+		}
+		hm := hmac.NewSHA256(secret)
+		hm.Write(signed)
+		if subtle.ConstantTimeCompare(hm.Sum(), signature) != 1 {
+			return ErrBadSignature
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnknownKey
+		}
+		h := sha256.New()
+		h.Write(signed)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, h.Sum(), signature) != nil {
+			return ErrBadSignature
+		}
+	default:
+		return ErrUnknownAlgorithm
+	}
+	return nil
+}
+
+// Verify verifies the token signature using a key from keys, checks the
+// "exp" and "nbf" claims and, if audience is not "", checks that the "aud"
+// claim contains audience. The claims are returned if the token is valid.
+func Verify(token string, keys KeySet, audience string) (Claims, os.Error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	p, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string
+		Kid string
+	}
+	if err := json.Unmarshal(p, &header); err != nil {
+		return nil, ErrMalformed
+	}
+
+	key, err := keys.Key(header.Alg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := verifySignature(header.Alg, key, signed, signature); err != nil {
+		return nil, err
+	}
+
+	p, err = decodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := json.NewDecoder(bytes.NewBuffer(p)).Decode(&claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	now := float64(web.Seconds())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return nil, ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, ErrNotValidYet
+	}
+	if audience != "" && !claims.hasAudience(audience) {
+		return nil, ErrBadAudience
+	}
+	return claims, nil
+}
+
+func (c Claims) hasAudience(audience string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const claimsKey = "twister.jwt.claims"
+
+// Handler returns a handler that verifies the bearer token in the
+// Authorization header before calling h. Requests without a valid token are
+// rejected with status 401. The claims are attached to the request and can be
+// retrieved with RequestClaims.
+func Handler(keys KeySet, audience string, h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		auth := req.Header.Get(web.HeaderAuthorization)
+		if len(auth) < 7 || strings.ToLower(auth[:7]) != "bearer " {
+			req.Error(web.StatusUnauthorized, os.NewError("twister.jwt: bearer token missing"),
+				web.HeaderWWWAuthenticate, "Bearer")
+			return
+		}
+		claims, err := Verify(strings.TrimSpace(auth[7:]), keys, audience)
+		if err != nil {
+			req.Error(web.StatusUnauthorized, err,
+				web.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return
+		}
+		req.Env[claimsKey] = claims
+		h.ServeWeb(req)
+	})
+}
+
+// RequestClaims returns the claims attached to the request by Handler or nil
+// if there are no claims.
+func RequestClaims(req *web.Request) Claims {
+	claims, _ := req.Env[claimsKey].(Claims)
+	return claims
+}
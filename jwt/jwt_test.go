@@ -0,0 +1,67 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package jwt
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeSegment(s string) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString([]byte(s)), "=")
+}
+
+func testToken(secret, header, claims string) string {
+	signed := encodeSegment(header) + "." + encodeSegment(claims)
+	hm := hmac.NewSHA256([]byte(secret))
+	hm.Write([]byte(signed))
+	return signed + "." + strings.TrimRight(base64.URLEncoding.EncodeToString(hm.Sum()), "=")
+}
+
+func TestVerify(t *testing.T) {
+	keys := StaticKeySet{"1": []byte("secret1"), "2": []byte("secret2")}
+	future := strconv.Itoa64(time.Seconds() + 3600)
+	past := strconv.Itoa64(time.Seconds() - 3600)
+
+	tests := []struct {
+		token string
+		err   bool
+	}{
+		{testToken("secret1", `{"alg":"HS256","kid":"1"}`, `{"sub":"a","aud":"api"}`), false},
+		{testToken("secret2", `{"alg":"HS256","kid":"2"}`, `{"sub":"a","aud":["x","api"]}`), false},
+		{testToken("secret1", `{"alg":"HS256","kid":"2"}`, `{"sub":"a","aud":"api"}`), true},
+		{testToken("secret1", `{"alg":"HS256","kid":"3"}`, `{"sub":"a","aud":"api"}`), true},
+		{testToken("secret1", `{"alg":"none","kid":"1"}`, `{"sub":"a","aud":"api"}`), true},
+		{testToken("secret1", `{"alg":"HS256","kid":"1"}`, `{"sub":"a","aud":"other"}`), true},
+		{testToken("secret1", `{"alg":"HS256","kid":"1"}`, `{"sub":"a","aud":"api","exp":`+past+`}`), true},
+		{testToken("secret1", `{"alg":"HS256","kid":"1"}`, `{"sub":"a","aud":"api","exp":`+future+`}`), false},
+		{testToken("secret1", `{"alg":"HS256","kid":"1"}`, `{"sub":"a","aud":"api","nbf":`+future+`}`), true},
+		{"junk", true},
+	}
+
+	for i, tt := range tests {
+		claims, err := Verify(tt.token, keys, "api")
+		if (err != nil) != tt.err {
+			t.Errorf("test %d, err=%v, want error %v", i, err, tt.err)
+		}
+		if err == nil && claims.Subject() != "a" {
+			t.Errorf("test %d, subject=%q, want %q", i, claims.Subject(), "a")
+		}
+	}
+}
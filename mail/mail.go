@@ -0,0 +1,271 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package mail composes and sends email for application flows such as signup
+// confirmation and password reset.
+//
+//  t := mail.MustParseTemplate("Reset your password",
+//      "Visit {url} to reset your password.\n", "")
+//  msg, err := t.Message([]string{user.Email}, map[string]string{"url": resetURL})
+//  if err != nil {
+//      return err
+//  }
+//  m := &mail.Mailer{Addr: "smtp.example.com:587", From: "noreply@example.com",
+//      Auth: smtp.PlainAuth("", user, password, "smtp.example.com")}
+//  err = m.Send(msg)
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/garyburd/twister/web"
+	"io"
+	"os"
+	"smtp"
+	"strings"
+	"template"
+	"time"
+)
+
+// Message is an email message.
+type Message struct {
+	To      []string
+	Subject string
+
+	// Plain text and HTML bodies. If both are set, the message is sent as
+	// multipart/alternative.
+	Text string
+	HTML string
+
+	// Additional header fields.
+	Header map[string]string
+}
+
+// Mailer sends messages through an SMTP server. The connection is upgraded
+// with STARTTLS when the server supports it.
+type Mailer struct {
+	// Address of the SMTP server in host:port format.
+	Addr string
+
+	// Authentication or nil for no authentication. Use smtp.PlainAuth or
+	// LoginAuth.
+	Auth smtp.Auth
+
+	// Sender address.
+	From string
+}
+
+// Send sends the message.
+func (m *Mailer) Send(msg *Message) os.Error {
+	var b bytes.Buffer
+	if err := msg.write(&b, m.From); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, m.Auth, m.From, msg.To, b.Bytes())
+}
+
+type loginAuth struct {
+	username, password, host string
+}
+
+// LoginAuth returns an smtp.Auth that implements the LOGIN mechanism. The
+// credentials are only sent over TLS connections or to localhost.
+func LoginAuth(username, password, host string) smtp.Auth {
+	return &loginAuth{username, password, host}
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, os.Error) {
+	if !server.TLS && server.Name != "localhost" {
+		return "", nil, os.NewError("twister.mail: unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, os.NewError("twister.mail: wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, os.Error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, os.NewError("twister.mail: unexpected server challenge")
+}
+
+// encodeHeader encodes s as an RFC 2047 encoded word if s contains non-ASCII
+// characters.
+func encodeHeader(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 || s[i] < ' ' {
+			return "=?utf-8?b?" + base64.StdEncoding.EncodeToString([]byte(s)) + "?="
+		}
+	}
+	return s
+}
+
+func newBoundary() string {
+	return web.RandomHex(12)
+}
+
+// writeBase64 writes p base64 encoded in lines of 76 characters.
+func writeBase64(w io.Writer, p []byte) {
+	s := base64.StdEncoding.EncodeToString(p)
+	for len(s) > 76 {
+		io.WriteString(w, s[:76]+"\r\n")
+		s = s[76:]
+	}
+	io.WriteString(w, s+"\r\n")
+}
+
+func writePart(w io.Writer, contentType string, body string) {
+	io.WriteString(w, "Content-Type: "+contentType+"; charset=utf-8\r\n")
+	io.WriteString(w, "Content-Transfer-Encoding: base64\r\n\r\n")
+	writeBase64(w, []byte(body))
+}
+
+var errBadHeader = os.NewError("twister.mail: control character in address or header")
+
+// hasControl returns true if s contains an ASCII control character. Such
+// characters in an address or header can inject headers or SMTP commands.
+func hasControl(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHeaders returns an error if an address, the subject or a header
+// contains a control character.
+func (msg *Message) checkHeaders(from string) os.Error {
+	if hasControl(from) || hasControl(msg.Subject) {
+		return errBadHeader
+	}
+	for _, to := range msg.To {
+		if hasControl(to) {
+			return errBadHeader
+		}
+	}
+	for k, v := range msg.Header {
+		if k == "" || hasControl(k) || strings.IndexAny(k, ": ") >= 0 || hasControl(v) {
+			return errBadHeader
+		}
+	}
+	return nil
+}
+
+// write writes the message in RFC 5322 format.
+func (msg *Message) write(w io.Writer, from string) os.Error {
+	if len(msg.To) == 0 {
+		return os.NewError("twister.mail: no recipients")
+	}
+	if err := msg.checkHeaders(from); err != nil {
+		return err
+	}
+	io.WriteString(w, "From: "+from+"\r\n")
+	io.WriteString(w, "To: "+strings.Join(msg.To, ", ")+"\r\n")
+	io.WriteString(w, "Subject: "+encodeHeader(msg.Subject)+"\r\n")
+	io.WriteString(w, "Date: "+time.SecondsToLocalTime(web.Seconds()).Format(time.RFC1123Z)+"\r\n")
+	io.WriteString(w, "MIME-Version: 1.0\r\n")
+	for k, v := range msg.Header {
+		io.WriteString(w, k+": "+encodeHeader(v)+"\r\n")
+	}
+	switch {
+	case msg.Text != "" && msg.HTML != "":
+		boundary := newBoundary()
+		io.WriteString(w, "Content-Type: multipart/alternative; boundary="+boundary+"\r\n\r\n")
+		io.WriteString(w, "--"+boundary+"\r\n")
+		writePart(w, "text/plain", msg.Text)
+		io.WriteString(w, "--"+boundary+"\r\n")
+		writePart(w, "text/html", msg.HTML)
+		io.WriteString(w, "--"+boundary+"--\r\n")
+	case msg.HTML != "":
+		writePart(w, "text/html", msg.HTML)
+	default:
+		writePart(w, "text/plain", msg.Text)
+	}
+	return nil
+}
+
+// Template is a message template. The subject and text templates are
+// executed without formatting. The HTML template is executed with HTML
+// escaping.
+type Template struct {
+	subject, text, html *template.Template
+}
+
+// ParseTemplate parses the subject, text and HTML templates. Empty text or
+// HTML templates are omitted from messages.
+func ParseTemplate(subject, text, html string) (*Template, os.Error) {
+	t := &Template{}
+	var err os.Error
+	if t.subject, err = template.Parse(subject, nil); err != nil {
+		return nil, err
+	}
+	if text != "" {
+		if t.text, err = template.Parse(text, nil); err != nil {
+			return nil, err
+		}
+	}
+	if html != "" {
+		if t.html, err = template.Parse(html, template.FormatterMap{"": template.HTMLFormatter}); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// MustParseTemplate is like ParseTemplate but panics if a template cannot be
+// parsed.
+func MustParseTemplate(subject, text, html string) *Template {
+	t, err := ParseTemplate(subject, text, html)
+	if err != nil {
+		panic("twister.mail: " + err.String())
+	}
+	return t
+}
+
+func execute(t *template.Template, data interface{}) (string, os.Error) {
+	if t == nil {
+		return "", nil
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Message executes the templates with data and returns a message to the
+// recipients.
+func (t *Template) Message(to []string, data interface{}) (*Message, os.Error) {
+	msg := &Message{To: to}
+	var err os.Error
+	if msg.Subject, err = execute(t.subject, data); err != nil {
+		return nil, err
+	}
+	if msg.Text, err = execute(t.text, data); err != nil {
+		return nil, err
+	}
+	if msg.HTML, err = execute(t.html, data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
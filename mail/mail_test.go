@@ -0,0 +1,104 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"smtp"
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	tmpl := MustParseTemplate("Hello {name}", "Hi {name}\n", "<p>Hi {name}</p>")
+	msg, err := tmpl.Message([]string{"a@example.com"}, map[string]string{"name": "<Bob>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Hello <Bob>" || msg.Text != "Hi <Bob>\n" || msg.HTML != "<p>Hi &lt;Bob&gt;</p>" {
+		t.Errorf("message = %+v", msg)
+	}
+
+	var b bytes.Buffer
+	if err := msg.write(&b, "noreply@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	s := b.String()
+	for _, want := range []string{
+		"From: noreply@example.com\r\n",
+		"To: a@example.com\r\n",
+		"Subject: Hello <Bob>\r\n",
+		"Content-Type: multipart/alternative; boundary=",
+		base64.StdEncoding.EncodeToString([]byte("Hi <Bob>\n")),
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("message does not contain %q\n%s", want, s)
+		}
+	}
+}
+
+func TestEncodeHeader(t *testing.T) {
+	if s := encodeHeader("hello"); s != "hello" {
+		t.Errorf("encodeHeader(hello) = %q", s)
+	}
+	if s := encodeHeader("héllo"); s != "=?utf-8?b?aMOpbGxv?=" {
+		t.Errorf("encodeHeader(héllo) = %q", s)
+	}
+}
+
+var headerInjectionTests = []*Message{
+	{To: []string{"a@example.com\r\nBcc: b@example.com"}, Subject: "Hello"},
+	{To: []string{"a@example.com>\r\nRCPT TO:<b@example.com"}, Subject: "Hello"},
+	{To: []string{"a@example.com"}, Subject: "Hello\nBcc: b@example.com"},
+	{To: []string{"a@example.com"}, Subject: "Hello", Header: map[string]string{"X-Tag": "a\rb"}},
+	{To: []string{"a@example.com"}, Subject: "Hello", Header: map[string]string{"X-Tag\r\nBcc": "b@example.com"}},
+	{To: []string{"a@example.com"}, Subject: "Hello", Header: map[string]string{"Bcc: b@example.com\r\nX-Tag": "a"}},
+}
+
+func TestHeaderInjection(t *testing.T) {
+	for i, msg := range headerInjectionTests {
+		var b bytes.Buffer
+		if err := msg.write(&b, "noreply@example.com"); err == nil {
+			t.Errorf("%d: write succeeded, want error\n%s", i, b.String())
+		}
+	}
+	msg := &Message{To: []string{"a@example.com"}, Subject: "Hello"}
+	var b bytes.Buffer
+	if err := msg.write(&b, "noreply@example.com\r\nBcc: b@example.com"); err == nil {
+		t.Errorf("write with bad from succeeded, want error")
+	}
+	m := &Mailer{Addr: "localhost:0", From: "noreply@example.com"}
+	if err := m.Send(headerInjectionTests[0]); err != errBadHeader {
+		t.Errorf("Send returned %v, want %v", err, errBadHeader)
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	a := LoginAuth("user", "pass", "smtp.example.com")
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); err == nil {
+		t.Error("Start succeeded without TLS")
+	}
+	proto, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true})
+	if err != nil || proto != "LOGIN" {
+		t.Fatalf("Start = %q, %v", proto, err)
+	}
+	for _, tt := range []struct{ challenge, response string }{{"Username:", "user"}, {"Password:", "pass"}} {
+		p, err := a.Next([]byte(tt.challenge), true)
+		if err != nil || string(p) != tt.response {
+			t.Errorf("Next(%q) = %q, %v", tt.challenge, p, err)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package markdown converts Markdown to sanitized HTML.
+//
+// The converter supports the common subset of Markdown: paragraphs, ATX and
+// setext headers, block quotes, ordered and unordered lists, indented and
+// fenced code blocks, horizontal rules, emphasis, code spans, links, images,
+// automatic links and hard line breaks. Raw HTML in the input is passed
+// through the sanitize package default policy with the rest of the output, so
+// the result is safe to include in a page.
+package markdown
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/sanitize"
+	"github.com/garyburd/twister/web"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ToHTML converts Markdown to sanitized HTML.
+func ToHTML(src string) web.SafeHTML {
+	var b bytes.Buffer
+	renderBlocks(&b, splitLines(src))
+	return web.SafeHTML(sanitize.HTML(b.String()))
+}
+
+// Cache caches the result of converting Markdown to HTML. Use a cache for
+// content that is rendered on every request, such as comments and wiki pages.
+type Cache struct {
+	max int
+
+	mu      sync.Mutex
+	entries map[string]web.SafeHTML
+	order   []string
+}
+
+// NewCache returns a cache holding up to max entries. The oldest entry is
+// evicted when the cache is full.
+func NewCache(max int) *Cache {
+	return &Cache{max: max, entries: make(map[string]web.SafeHTML)}
+}
+
+// ToHTML returns the cached conversion of src or converts src and adds the
+// result to the cache.
+func (c *Cache) ToHTML(src string) web.SafeHTML {
+	c.mu.Lock()
+	html, ok := c.entries[src]
+	c.mu.Unlock()
+	if ok {
+		return html
+	}
+	html = ToHTML(src)
+	c.mu.Lock()
+	if _, ok := c.entries[src]; !ok {
+		for len(c.order) >= c.max && len(c.order) > 0 {
+			c.entries[c.order[0]] = "", false
+			c.order = c.order[1:]
+		}
+		c.entries[src] = html
+		c.order = append(c.order, src)
+	}
+	c.mu.Unlock()
+	return html
+}
+
+var defaultCache = NewCache(1000)
+
+// Formatter is a template formatter that converts the value from Markdown to
+// HTML. The conversion is cached. Add the formatter to a formatter map:
+//
+//  fmap := template.FormatterMap{}
+//  for k, v := range web.TemplateFormatters {
+//      fmap[k] = v
+//  }
+//  fmap["markdown"] = markdown.Formatter
+//
+// and use it in a template:
+//
+//  <div class="comment">{body|markdown}</div>
+func Formatter(w io.Writer, format string, values ...interface{}) {
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			io.WriteString(w, string(defaultCache.ToHTML(s)))
+		}
+	}
+}
+
+func splitLines(src string) []string {
+	src = strings.Replace(src, "\r\n", "\n", -1)
+	src = strings.Replace(src, "\t", "    ", -1)
+	return strings.Split(strings.TrimRight(src, "\n"), "\n")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+var (
+	atxRegexp       = regexp.MustCompile(`^(#+)[ ]*(.*[^#])?[ #]*$`)
+	hrRegexp        = regexp.MustCompile(`^[ ]{0,3}(([-][ ]*){3,}|([*][ ]*){3,}|([_][ ]*){3,})$`)
+	ulRegexp        = regexp.MustCompile(`^[ ]{0,3}[-*+][ ]+`)
+	olRegexp        = regexp.MustCompile(`^[ ]{0,3}[0-9]+[.][ ]+`)
+	htmlBlockRegexp = regexp.MustCompile(`^<[/]?[a-zA-Z][a-zA-Z0-9]*[ />]`)
+)
+
+// listMarker returns the length of the list marker at the start of line and
+// whether the list is ordered. The length is zero if there is no marker.
+func listMarker(line string) (int, bool) {
+	if hrRegexp.MatchString(line) {
+		return 0, false
+	}
+	if loc := ulRegexp.FindStringIndex(line); loc != nil {
+		return loc[1], false
+	}
+	if loc := olRegexp.FindStringIndex(line); loc != nil {
+		return loc[1], true
+	}
+	return 0, false
+}
+
+// startsBlock returns true if line interrupts a paragraph.
+func startsBlock(line string) bool {
+	n, _ := listMarker(line)
+	return n > 0 || atxRegexp.MatchString(line) || hrRegexp.MatchString(line) ||
+		strings.HasPrefix(strings.TrimLeft(line, " "), ">") ||
+		strings.HasPrefix(strings.TrimLeft(line, " "), "```")
+}
+
+func renderBlocks(b *bytes.Buffer, lines []string) {
+	for len(lines) > 0 {
+		line := lines[0]
+		trimmed := strings.TrimLeft(line, " ")
+		switch {
+		case isBlank(line):
+			lines = lines[1:]
+		case strings.HasPrefix(trimmed, "```"):
+			i := 1
+			for i < len(lines) && !strings.HasPrefix(strings.TrimLeft(lines[i], " "), "```") {
+				i++
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(sanitize.EscapeHTML(strings.Join(lines[1:i], "\n")))
+			b.WriteString("\n</code></pre>\n")
+			if i < len(lines) {
+				i++
+			}
+			lines = lines[i:]
+		case indent(line) >= 4:
+			i := 0
+			for i < len(lines) && (isBlank(lines[i]) || indent(lines[i]) >= 4) {
+				i++
+			}
+			code := make([]string, i)
+			for j := range code {
+				if len(lines[j]) >= 4 {
+					code[j] = lines[j][4:]
+				}
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(sanitize.EscapeHTML(strings.TrimRight(strings.Join(code, "\n"), "\n")))
+			b.WriteString("\n</code></pre>\n")
+			lines = lines[i:]
+		case hrRegexp.MatchString(line):
+			b.WriteString("<hr>\n")
+			lines = lines[1:]
+		case atxRegexp.MatchString(line):
+			m := atxRegexp.FindStringSubmatch(line)
+			level := len(m[1])
+			if level > 6 {
+				level = 6
+			}
+			h := strconv.Itoa(level)
+			b.WriteString("<h" + h + ">")
+			renderInline(b, strings.TrimSpace(m[2]))
+			b.WriteString("</h" + h + ">\n")
+			lines = lines[1:]
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			i := 0
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				s := strings.TrimLeft(lines[i], " ")
+				if strings.HasPrefix(s, ">") {
+					s = s[1:]
+					if strings.HasPrefix(s, " ") {
+						s = s[1:]
+					}
+				}
+				quote = append(quote, s)
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quote)
+			b.WriteString("</blockquote>\n")
+			lines = lines[i:]
+		case listMarkerLen(line) > 0:
+			lines = renderList(b, lines)
+		case htmlBlockRegexp.MatchString(line):
+			i := 0
+			for i < len(lines) && !isBlank(lines[i]) {
+				b.WriteString(lines[i])
+				b.WriteByte('\n')
+				i++
+			}
+			lines = lines[i:]
+		default:
+			i := 1
+			for i < len(lines) && !isBlank(lines[i]) {
+				if isUnderline(lines[i]) {
+					i++
+					break
+				}
+				if startsBlock(lines[i]) {
+					break
+				}
+				i++
+			}
+			para := lines[:i]
+			lines = lines[i:]
+			// Setext header.
+			if n := len(para); n > 1 {
+				if isUnderline(para[n-1]) {
+					h := "1"
+					if strings.TrimSpace(para[n-1])[0] == '-' {
+						h = "2"
+					}
+					if n > 2 {
+						renderParagraph(b, para[:n-2])
+					}
+					b.WriteString("<h" + h + ">")
+					renderInline(b, strings.TrimSpace(para[n-2]))
+					b.WriteString("</h" + h + ">\n")
+					continue
+				}
+			}
+			renderParagraph(b, para)
+		}
+	}
+}
+
+// isUnderline returns true if line is a setext header underline.
+func isUnderline(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && (strings.Trim(line, "=") == "" || strings.Trim(line, "-") == "")
+}
+
+func listMarkerLen(line string) int {
+	n, _ := listMarker(line)
+	return n
+}
+
+func renderParagraph(b *bytes.Buffer, lines []string) {
+	b.WriteString("<p>")
+	renderInline(b, strings.TrimSpace(strings.Join(lines, "\n")))
+	b.WriteString("</p>\n")
+}
+
+// renderList renders the list at the start of lines and returns the lines
+// following the list.
+func renderList(b *bytes.Buffer, lines []string) []string {
+	_, ordered := listMarker(lines[0])
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	var items [][]string
+	loose := false
+	i := 0
+	for i < len(lines) {
+		n, o := listMarker(lines[i])
+		if n == 0 || o != ordered {
+			break
+		}
+		item := []string{lines[i][n:]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if isBlank(line) {
+				// The item continues if the next nonblank line is indented.
+				j := i + 1
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j < len(lines) && indent(lines[j]) >= 2 {
+					loose = true
+					item = append(item, lines[i:j]...)
+					i = j
+					continue
+				}
+				if j < len(lines) {
+					if n, o := listMarker(lines[j]); n > 0 && o == ordered {
+						loose = true
+					}
+				}
+				i = j
+				break
+			}
+			if listMarkerLen(line) > 0 && indent(line) < 2 {
+				break
+			}
+			item = append(item, line)
+			i++
+		}
+		// Remove the item indentation from continuation lines.
+		for j := 1; j < len(item); j++ {
+			n := indent(item[j])
+			if n > 4 {
+				n = 4
+			}
+			item[j] = item[j][n:]
+		}
+		items = append(items, item)
+	}
+
+	b.WriteString("<" + tag + ">\n")
+	for _, item := range items {
+		b.WriteString("<li>")
+		if loose {
+			renderBlocks(b, item)
+		} else {
+			// The first lines are inline text. The remaining lines are
+			// blocks such as a nested list.
+			j := 1
+			for j < len(item) && !startsBlock(item[j]) {
+				j++
+			}
+			renderInline(b, strings.TrimSpace(strings.Join(item[:j], "\n")))
+			if j < len(item) {
+				b.WriteString("\n")
+				renderBlocks(b, item[j:])
+			}
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	if i > len(lines) {
+		i = len(lines)
+	}
+	return lines[i:]
+}
+
+var (
+	linkRegexp     = regexp.MustCompile(`^!?\[([^\]]*)\]\(([^ )]*)([ ]+"([^"]*)")?\)`)
+	autoLinkRegexp = regexp.MustCompile(`^<((https?|ftp)://[^ >]+|mailto:[^ >]+)>`)
+	tagRegexp      = regexp.MustCompile(`^</?[a-zA-Z][a-zA-Z0-9]*([ ]+[a-zA-Z][-a-zA-Z0-9]*([ ]*=[ ]*("[^"]*"|'[^']*'|[^ "'=<>]+))?)*[ ]*/?>`)
+	entityRegexp   = regexp.MustCompile(`^&([a-zA-Z][a-zA-Z0-9]*|#[0-9]+|#[xX][0-9a-fA-F]+);`)
+)
+
+// renderInline renders the inline elements in s.
+func renderInline(b *bytes.Buffer, s string) {
+	for len(s) > 0 {
+		c := s[0]
+		switch {
+		case c == '\\' && len(s) > 1 && strings.IndexRune("\\`*_{}[]()#+-.!<>", int(s[1])) >= 0:
+			b.WriteString(sanitize.EscapeHTML(s[1:2]))
+			s = s[2:]
+			continue
+		case c == '`':
+			n := len(s) - len(strings.TrimLeft(s, "`"))
+			if end := strings.Index(s[n:], s[:n]); end >= 0 {
+				b.WriteString("<code>")
+				b.WriteString(sanitize.EscapeHTML(strings.TrimSpace(s[n : n+end])))
+				b.WriteString("</code>")
+				s = s[n+end+n:]
+				continue
+			}
+			b.WriteString(s[:n])
+			s = s[n:]
+			continue
+		case c == '[' || (c == '!' && strings.HasPrefix(s, "![")):
+			if m := linkRegexp.FindStringSubmatch(s); m != nil {
+				title := ""
+				if m[4] != "" {
+					title = ` title="` + sanitize.EscapeHTML(m[4]) + `"`
+				}
+				if c == '!' {
+					b.WriteString(`<img src="` + sanitize.EscapeHTML(m[2]) + `" alt="` + sanitize.EscapeHTML(m[1]) + `"` + title + `>`)
+				} else {
+					b.WriteString(`<a href="` + sanitize.EscapeHTML(m[2]) + `"` + title + `>`)
+					renderInline(b, m[1])
+					b.WriteString("</a>")
+				}
+				s = s[len(m[0]):]
+				continue
+			}
+		case c == '<':
+			if m := autoLinkRegexp.FindStringSubmatch(s); m != nil {
+				url := sanitize.EscapeHTML(m[1])
+				text := url
+				if strings.HasPrefix(m[1], "mailto:") {
+					text = sanitize.EscapeHTML(m[1][len("mailto:"):])
+				}
+				b.WriteString(`<a href="` + url + `">` + text + `</a>`)
+				s = s[len(m[0]):]
+				continue
+			}
+			if m := tagRegexp.FindString(s); m != "" {
+				// Raw HTML is checked by the sanitizer.
+				b.WriteString(m)
+				s = s[len(m):]
+				continue
+			}
+			b.WriteString("&lt;")
+			s = s[1:]
+			continue
+		case c == '&':
+			if m := entityRegexp.FindString(s); m != "" {
+				b.WriteString(m)
+				s = s[len(m):]
+				continue
+			}
+			b.WriteString("&amp;")
+			s = s[1:]
+			continue
+		case c == '>':
+			b.WriteString("&gt;")
+			s = s[1:]
+			continue
+		case c == '*' || c == '_':
+			delim := s[:1]
+			tag := "em"
+			if len(s) > 1 && s[1] == c {
+				delim = s[:2]
+				tag = "strong"
+			}
+			rest := s[len(delim):]
+			if c == '_' && endsWithWord(b.Bytes()) {
+				// Underscores inside words are not emphasis.
+				b.WriteString(delim)
+				s = rest
+				continue
+			}
+			if len(rest) > 0 && rest[0] != ' ' && rest[0] != '\n' {
+				if end := closingDelim(rest, delim); end > 0 {
+					b.WriteString("<" + tag + ">")
+					renderInline(b, rest[:end])
+					b.WriteString("</" + tag + ">")
+					s = rest[end+len(delim):]
+					continue
+				}
+			}
+			b.WriteString(delim)
+			s = rest
+			continue
+		case c == '\n':
+			if bytes.HasSuffix(b.Bytes(), []byte("  ")) {
+				b.Truncate(len(bytes.TrimRight(b.Bytes(), " ")))
+				b.WriteString("<br>")
+			}
+			b.WriteByte('\n')
+			s = s[1:]
+			continue
+		}
+		b.WriteByte(c)
+		s = s[1:]
+	}
+}
+
+// closingDelim returns the index of the emphasis delimiter that closes the
+// emphasis started before s or -1 if there is none.
+func closingDelim(s, delim string) int {
+	for i := 1; i+len(delim) <= len(s); i++ {
+		switch {
+		case s[i] == '\\' || s[i] == '`':
+			// Skip escaped characters. Emphasis does not close inside code
+			// spans.
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if j := strings.Index(s[i+1:], "`"); j >= 0 {
+				i += j + 1
+			}
+		case len(delim) == 1 && strings.HasPrefix(s[i:], delim+delim):
+			// Skip strong delimiter inside emphasis.
+			i++
+		case s[i:i+len(delim)] == delim && s[i-1] != ' ' && s[i-1] != '\n':
+			return i
+		}
+	}
+	return -1
+}
+
+// endsWithWord returns true if p ends with a letter or digit.
+func endsWithWord(p []byte) bool {
+	if len(p) == 0 {
+		return false
+	}
+	c := p[len(p)-1]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
@@ -0,0 +1,60 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package markdown
+
+import (
+	"testing"
+)
+
+var toHTMLTests = []struct {
+	src, expect string
+}{
+	{"# Hello *world*", "<h1>Hello <em>world</em></h1>\n"},
+	{"Title\n=====\n\nSub\n---", "<h1>Title</h1>\n<h2>Sub</h2>\n"},
+	{"Some **bold** and _em_ and `a <b>`.\nsnake_case", "<p>Some <strong>bold</strong> and <em>em</em> and <code>a &lt;b&gt;</code>.\nsnake_case</p>\n"},
+	{"a *b **c** d* e", "<p>a <em>b <strong>c</strong> d</em> e</p>\n"},
+	{"line  \nbreak", "<p>line<br>\nbreak</p>\n"},
+	{"- a\n- b\n  - c\n- d", "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n"},
+	{"1. a\n\n2. b", "<ol>\n<li><p>a</p>\n</li>\n<li><p>b</p>\n</li>\n</ol>\n"},
+	{"> quote\n> more", "<blockquote>\n<p>quote\nmore</p>\n</blockquote>\n"},
+	{"    code <b>\n\n```\n& x\n```", "<pre><code>code &lt;b&gt;\n</code></pre>\n<pre><code>&amp; x\n</code></pre>\n"},
+	{"***", "<hr>\n"},
+	{`[a](http://example.com/ "T") ![i](/i.png)`, `<p><a href="http://example.com/" title="T" rel="nofollow">a</a> <img src="/i.png" alt="i"></p>` + "\n"},
+	{"<http://example.com/>", `<p><a href="http://example.com/" rel="nofollow">http://example.com/</a></p>` + "\n"},
+	{"[x](javascript:alert)", `<p><a rel="nofollow">x</a></p>` + "\n"},
+	{"a < b && c", "<p>a &lt; b &amp;&amp; c</p>\n"},
+	{"<script>alert(1)</script>\n\nok <b onclick=\"x\">b</b>", "\n<p>ok <b>b</b></p>\n"},
+}
+
+func TestToHTML(t *testing.T) {
+	for _, tt := range toHTMLTests {
+		html := string(ToHTML(tt.src))
+		if html != tt.expect {
+			t.Errorf("ToHTML(%q) = %q, want %q", tt.src, html, tt.expect)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+	for _, src := range []string{"a", "b", "a", "c"} {
+		if html := c.ToHTML(src); string(html) != "<p>"+src+"</p>\n" {
+			t.Errorf("ToHTML(%q) = %q", src, html)
+		}
+	}
+	if len(c.entries) != 2 || c.entries["a"] != "" {
+		t.Errorf("entries = %v, want b and c", c.entries)
+	}
+}
@@ -19,7 +19,6 @@ package oauth
 import (
 	"bytes"
 	"crypto/hmac"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
@@ -189,7 +188,9 @@ func nonce() string {
 	nonceLock.Lock()
 	defer nonceLock.Unlock()
 	if nonceCounter == 0 {
-		binary.Read(rand.Reader, binary.BigEndian, &nonceCounter)
+		var p [8]byte
+		web.ReadRandom(p[:])
+		nonceCounter = binary.BigEndian.Uint64(p[:])
 	}
 	result := strconv.Uitob64(nonceCounter, 16)
 	nonceCounter += 1
@@ -230,7 +231,10 @@ func (c *Client) SignParam(credentials *Credentials, method, url string, param m
 
 func (c *Client) request(client *http.Client, credentials *Credentials, url string, param web.Values) (*Credentials, web.Values, os.Error) {
 	c.SignParam(credentials, "POST", url, param)
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", bytes.NewBuffer(param.FormEncodedBytes()))
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(url, "application/x-www-form-urlencoded", bytes.NewBuffer(param.FormEncodedBytes()))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,331 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+// Package operation implements the asynchronous request pattern for long
+// running work. A handler starts an operation and responds with status 202
+// and the URL of a status resource. The client polls the status resource for
+// progress and the result of the operation.
+//
+//  ops := operation.NewManager(&operation.Options{
+//      Store: operation.NewMemoryStore(3600),
+//      Path:  "/operations/",
+//  })
+//  router.Register("/operations/<id>", "GET", ops)
+//  router.Register("/reports", "POST", func(req *web.Request) {
+//      ops.Start(req, func(p *operation.Progress) (interface{}, os.Error) {
+//          return buildReport(p)
+//      })
+//  })
+package operation
+
+import (
+	"github.com/garyburd/twister/web"
+	"json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a store when an operation does not exist.
+var ErrNotFound = os.NewError("twister.operation: not found")
+
+// Operation states.
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// Operation is the record of an operation.
+type Operation struct {
+	ID    string
+	State string
+
+	// Percent complete, 0 to 100.
+	Progress int
+
+	// Description of the current step.
+	Message string
+
+	// The value returned by a successful operation. The value must be
+	// encodable as JSON.
+	Result interface{}
+
+	// The error from a failed operation.
+	Error string
+
+	// Creation and last update time in seconds since the epoch.
+	Created int64
+	Updated int64
+}
+
+func (op *Operation) jsonValue() map[string]interface{} {
+	m := map[string]interface{}{
+		"id":       op.ID,
+		"state":    op.State,
+		"progress": op.Progress,
+		"created":  time.SecondsToUTC(op.Created).Format(time.RFC3339),
+		"updated":  time.SecondsToUTC(op.Updated).Format(time.RFC3339),
+	}
+	if op.Message != "" {
+		m["message"] = op.Message
+	}
+	if op.Result != nil {
+		m["result"] = op.Result
+	}
+	if op.Error != "" {
+		m["error"] = op.Error
+	}
+	return m
+}
+
+// Store is the interface implemented by operation stores. Stores shared by
+// several processes allow any process to serve the status resource.
+type Store interface {
+	// Put creates or replaces the operation record.
+	Put(op *Operation) os.Error
+
+	// Get returns the operation with the given ID. Get returns ErrNotFound
+	// if the operation does not exist.
+	Get(id string) (*Operation, os.Error)
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	ops       map[string]Operation
+	ttl       int64
+	nextSweep int64
+}
+
+// NewMemoryStore returns a store that keeps operations in memory. Completed
+// operations are removed ttl seconds after the last update. If ttl is zero,
+// then completed operations are kept for one hour. Running operations are
+// not removed.
+func NewMemoryStore(ttl int64) Store {
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	return &memoryStore{ops: make(map[string]Operation), ttl: ttl}
+}
+
+func (s *memoryStore) expired(op *Operation, now int64) bool {
+	return op.State != StateRunning && now >= op.Updated+s.ttl
+}
+
+func (s *memoryStore) Put(op *Operation) os.Error {
+	now := web.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now >= s.nextSweep {
+		for id, o := range s.ops {
+			if s.expired(&o, now) {
+				s.ops[id] = Operation{}, false
+			}
+		}
+		s.nextSweep = now + 60
+	}
+	s.ops[op.ID] = *op
+	return nil
+}
+
+func (s *memoryStore) Get(id string) (*Operation, os.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, found := s.ops[id]
+	if !found || s.expired(&op, web.Seconds()) {
+		return nil, ErrNotFound
+	}
+	return &op, nil
+}
+
+// Options specifies the options for a Manager.
+type Options struct {
+	// Store for operation records. Required.
+	Store Store
+
+	// URL path prefix of the status resource. The ID of the operation is
+	// appended to the prefix. Required.
+	Path string
+
+	// Suggested polling interval in nanoseconds, sent in the Retry-After
+	// header while an operation is running. Default is one second.
+	PollInterval int64
+
+	// Maximum time in nanoseconds that Start waits for an operation to
+	// complete before responding with status 202. The client can shorten
+	// the time with the Prefer wait preference and skip the wait with the
+	// respond-async preference. Default is zero.
+	MaxWait int64
+}
+
+// Manager starts operations and serves the status resource.
+type Manager struct {
+	options Options
+}
+
+// NewManager returns a new operation manager.
+func NewManager(options *Options) *Manager {
+	m := &Manager{options: *options}
+	if m.options.Store == nil {
+		panic("twister.operation: Manager requires Store option")
+	}
+	if m.options.Path == "" {
+		panic("twister.operation: Manager requires Path option")
+	}
+	if m.options.PollInterval <= 0 {
+		m.options.PollInterval = 1e9
+	}
+	return m
+}
+
+// Progress is passed to the function running an operation.
+type Progress struct {
+	m  *Manager
+	op Operation
+}
+
+// ID returns the ID of the operation.
+func (p *Progress) ID() string {
+	return p.op.ID
+}
+
+// Update records the progress of the operation.
+func (p *Progress) Update(percent int, message string) os.Error {
+	p.op.Progress = percent
+	p.op.Message = message
+	p.op.Updated = web.Seconds()
+	return p.m.options.Store.Put(&p.op)
+}
+
+// Func is the type of the function running an operation. The returned result
+// is stored in the operation record on success.
+type Func func(p *Progress) (result interface{}, err os.Error)
+
+// Start creates an operation record and runs fn in a new goroutine. If the
+// operation completes within the MaxWait option or the shorter time from the
+// request's wait preference, then Start responds with status 200 and the
+// completed operation. Otherwise, Start responds with status 202, the URL of
+// the status resource in the Location header and a JSON representation of
+// the operation in the body. If the request has the respond-async
+// preference, Start does not wait and the preference is reported in the
+// Preference-Applied header.
+func (m *Manager) Start(req *web.Request, fn Func) {
+	now := web.Seconds()
+	p := &Progress{m: m, op: Operation{
+		ID:      web.RandomHex(16),
+		State:   StateRunning,
+		Created: now,
+		Updated: now,
+	}}
+	if err := m.options.Store.Put(&p.op); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	op := p.op
+	done := make(chan bool, 1)
+	go func() {
+		m.run(p, fn)
+		done <- true
+	}()
+
+	_, async := req.Header.GetPreferences()["respond-async"]
+	wait := m.options.MaxWait
+	if async {
+		wait = 0
+	} else if seconds, ok := req.Header.GetPreferWait(); ok && int64(seconds)*1e9 < wait {
+		wait = int64(seconds) * 1e9
+	}
+	if wait > 0 {
+		select {
+		case <-done:
+			op = p.op
+			m.respond(req, web.StatusOK, web.NewHeader(
+				web.HeaderContentLocation, m.options.Path+op.ID,
+				web.HeaderCacheControl, "no-cache"), &op)
+			return
+		case <-time.After(wait):
+		}
+	}
+
+	header := web.NewHeader(
+		web.HeaderLocation, m.options.Path+op.ID,
+		web.HeaderRetryAfter, web.RetryAfter(m.options.PollInterval))
+	if async {
+		header.AddPreferenceApplied("respond-async", "")
+	}
+	m.respond(req, web.StatusAccepted, header, &op)
+}
+
+func (m *Manager) run(p *Progress, fn Func) {
+	var result interface{}
+	var err os.Error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("twister.operation: panic in operation", p.op.ID, r)
+				err = os.NewError("internal error")
+			}
+		}()
+		result, err = fn(p)
+	}()
+	if err != nil {
+		p.op.State = StateFailed
+		p.op.Error = err.String()
+	} else {
+		p.op.State = StateSucceeded
+		p.op.Progress = 100
+		p.op.Result = result
+	}
+	p.op.Updated = web.Seconds()
+	if err := m.options.Store.Put(&p.op); err != nil {
+		log.Println("twister.operation: store failed: ", err)
+	}
+}
+
+// ServeWeb serves the status resource. The operation ID is the request path
+// following the Path option. The response is a JSON representation of the
+// operation. The Retry-After header is set while the operation is running.
+func (m *Manager) ServeWeb(req *web.Request) {
+	path := req.URL.Path
+	if len(path) <= len(m.options.Path) || path[:len(m.options.Path)] != m.options.Path {
+		req.Error(web.StatusNotFound, nil)
+		return
+	}
+	op, err := m.options.Store.Get(path[len(m.options.Path):])
+	if err == ErrNotFound {
+		req.Error(web.StatusNotFound, err)
+		return
+	} else if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	header := web.NewHeader(web.HeaderCacheControl, "no-cache")
+	if op.State == StateRunning {
+		header.Set(web.HeaderRetryAfter, web.RetryAfter(m.options.PollInterval))
+	}
+	m.respond(req, web.StatusOK, header, op)
+}
+
+func (m *Manager) respond(req *web.Request, status int, header web.Header, op *Operation) {
+	p, err := json.Marshal(op.jsonValue())
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	header.Set(web.HeaderContentType, "application/json; charset=utf-8")
+	header.Set(web.HeaderContentLength, strconv.Itoa(len(p)))
+	w := req.Responder.Respond(status, header)
+	w.Write(p)
+}
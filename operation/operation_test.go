@@ -0,0 +1,171 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package operation
+
+import (
+	"github.com/garyburd/twister/web"
+	"json"
+	"os"
+	"testing"
+	"time"
+)
+
+func waitState(t *testing.T, store Store, id string) *Operation {
+	for i := 0; i < 1000; i++ {
+		op, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op.State != StateRunning {
+			return op
+		}
+		time.Sleep(1e6)
+	}
+	t.Fatalf("operation %s did not finish", id)
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	store := NewMemoryStore(0)
+	m := NewManager(&Options{Store: store, Path: "/operations/"})
+
+	proceed := make(chan bool)
+	status, header, body := web.RunHandler("http://example.com/reports", "POST", web.NewHeader(web.HeaderPrefer, "respond-async"), nil,
+		web.HandlerFunc(func(req *web.Request) {
+			m.Start(req, func(p *Progress) (interface{}, os.Error) {
+				p.Update(50, "half way")
+				<-proceed
+				return map[string]string{"report": "done"}, nil
+			})
+		}))
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := doc["id"].(string)
+	if status != web.StatusAccepted || header.Get(web.HeaderLocation) != "/operations/"+id || id == "" {
+		t.Fatalf("status=%d, location=%q, id=%q", status, header.Get(web.HeaderLocation), id)
+	}
+	if header.Get(web.HeaderPreferenceApplied) != "respond-async" || header.Get(web.HeaderRetryAfter) != "1" {
+		t.Errorf("header=%v", header)
+	}
+
+	status, header, _ = web.RunHandler("http://example.com/operations/"+id, "GET", nil, nil, m)
+	if status != web.StatusOK || header.Get(web.HeaderRetryAfter) != "1" {
+		t.Errorf("running status=%d, header=%v", status, header)
+	}
+
+	proceed <- true
+	op := waitState(t, store, id)
+	if op.State != StateSucceeded || op.Progress != 100 {
+		t.Errorf("op=%+v", op)
+	}
+	status, header, body = web.RunHandler("http://example.com/operations/"+id, "GET", nil, nil, m)
+	doc = nil
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := doc["result"].(map[string]interface{})
+	if status != web.StatusOK || header.Get(web.HeaderRetryAfter) != "" || doc["state"] != StateSucceeded || result["report"] != "done" {
+		t.Errorf("succeeded status=%d, header=%v, body=%s", status, header, body)
+	}
+
+	status, _, _ = web.RunHandler("http://example.com/operations/missing", "GET", nil, nil, m)
+	if status != web.StatusNotFound {
+		t.Errorf("missing status=%d, want %d", status, web.StatusNotFound)
+	}
+}
+
+var managerWaitTests = []struct {
+	prefer string
+	status int
+}{
+	{"", web.StatusOK},
+	{"wait=5", web.StatusOK},
+	{"wait=0", web.StatusAccepted},
+	{"respond-async", web.StatusAccepted},
+	{"respond-async, wait=5", web.StatusAccepted},
+}
+
+func TestManagerWait(t *testing.T) {
+	m := NewManager(&Options{Store: NewMemoryStore(0), Path: "/operations/", MaxWait: 10e9})
+	for _, tt := range managerWaitTests {
+		var header web.Header
+		if tt.prefer != "" {
+			header = web.NewHeader(web.HeaderPrefer, tt.prefer)
+		}
+		status, header, body := web.RunHandler("http://example.com/reports", "POST", header, nil,
+			web.HandlerFunc(func(req *web.Request) {
+				m.Start(req, func(p *Progress) (interface{}, os.Error) { return "done", nil })
+			}))
+		if status != tt.status {
+			t.Errorf("Prefer %q: status=%d, want %d", tt.prefer, status, tt.status)
+			continue
+		}
+		if status != web.StatusOK {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc["state"] != StateSucceeded || doc["result"] != "done" || header.Get(web.HeaderContentLocation) != "/operations/"+doc["id"].(string) {
+			t.Errorf("Prefer %q: header=%v, body=%s", tt.prefer, header, body)
+		}
+	}
+}
+
+func TestMemoryStoreExpiration(t *testing.T) {
+	clock := web.NewFakeClock(1000e9)
+	defer web.SetClock(web.SetClock(clock))
+
+	s := NewMemoryStore(10).(*memoryStore)
+	s.Put(&Operation{ID: "running", State: StateRunning, Updated: 1000})
+	s.Put(&Operation{ID: "done", State: StateSucceeded, Updated: 1000})
+
+	clock.Advance(9e9)
+	if _, err := s.Get("done"); err != nil {
+		t.Errorf("Get(done) before expiration returned %v", err)
+	}
+	clock.Advance(1e9)
+	if _, err := s.Get("done"); err != ErrNotFound {
+		t.Errorf("Get(done) after expiration returned %v, want %v", err, ErrNotFound)
+	}
+
+	clock.Advance(60e9)
+	s.Put(&Operation{ID: "new", State: StateRunning, Updated: 1070})
+	if _, found := s.ops["done"]; found {
+		t.Errorf("expired operation not removed by sweep")
+	}
+	if _, err := s.Get("running"); err != nil {
+		t.Errorf("Get(running) returned %v", err)
+	}
+}
+
+func TestManagerFailure(t *testing.T) {
+	store := NewMemoryStore(0)
+	m := NewManager(&Options{Store: store, Path: "/operations/"})
+	for _, fn := range []Func{
+		func(p *Progress) (interface{}, os.Error) { return nil, os.NewError("no disk") },
+		func(p *Progress) (interface{}, os.Error) { panic("boom") },
+	} {
+		_, header, _ := web.RunHandler("http://example.com/reports", "POST", nil, nil, web.HandlerFunc(func(req *web.Request) {
+			m.Start(req, fn)
+		}))
+		id := header.Get(web.HeaderLocation)[len("/operations/"):]
+		if op := waitState(t, store, id); op.State != StateFailed || op.Error == "" {
+			t.Errorf("op=%+v", op)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions configures the shared cache of a ReverseProxy.
+type CacheOptions struct {
+	// Maximum total size in bytes of cached responses. The least recently
+	// used responses are evicted when the limit is exceeded. The default is
+	// 64 MB.
+	MaxSize int
+
+	// Maximum size in bytes of a cached response body. Larger responses and
+	// responses without a Content-Length are not cached. The default is 1 MB.
+	MaxEntrySize int
+}
+
+// Warnings added to stale responses.
+const (
+	warningStale              = `110 - "Response is Stale"`
+	warningRevalidationFailed = `111 - "Revalidation Failed"`
+)
+
+// maxHeuristicLifetime is the maximum freshness lifetime in seconds computed
+// from the Last-Modified header of a response without explicit expiration.
+const maxHeuristicLifetime = 24 * 60 * 60
+
+// cacheableStatus is the set of status codes that are cacheable by default.
+var cacheableStatus = map[int]bool{
+	web.StatusOK:                          true,
+	web.StatusNonAuthoritativeInformation: true,
+	web.StatusMultipleChoices:             true,
+	web.StatusMovedPermanently:            true,
+	web.StatusNotFound:                    true,
+	web.StatusGone:                        true,
+}
+
+type cacheEntry struct {
+	key    string
+	vary   map[string]string
+	status int
+	header web.Header
+	body   []byte
+	elem   *list.Element
+
+	// Times in seconds since the epoch and the Age header value used to
+	// compute the current age of the entry.
+	requestTime  int64
+	responseTime int64
+	date         int64
+	age          int64
+
+	// Freshness lifetime in seconds.
+	lifetime int64
+
+	// True if the entry must not be served stale.
+	mustRevalidate bool
+}
+
+func (e *cacheEntry) size() int {
+	n := len(e.key) + len(e.body)
+	for k, v := range e.header {
+		n += len(k)
+		for _, s := range v {
+			n += len(s)
+		}
+	}
+	return n
+}
+
+// currentAge returns the age in seconds of the entry as described in RFC 7234
+// section 4.2.3.
+func (e *cacheEntry) currentAge(now int64) int64 {
+	apparentAge := e.responseTime - e.date
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	age := e.age + e.responseTime - e.requestTime
+	if age < apparentAge {
+		age = apparentAge
+	}
+	return age + now - e.responseTime
+}
+
+// fresh returns true if the entry can be served without validation given the
+// request's Cache-Control directives.
+func (e *cacheEntry) fresh(cc map[string]string, now int64) bool {
+	if _, found := cc["no-cache"]; found {
+		return false
+	}
+	age := e.currentAge(now)
+	if s, found := cc["max-age"]; found {
+		if maxAge, err := strconv.Atoi64(s); err != nil || age > maxAge {
+			return false
+		}
+	}
+	return age < e.lifetime
+}
+
+func (e *cacheEntry) matchVary(header web.Header) bool {
+	for name, value := range e.vary {
+		if strings.Join(header[name], ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// setValidators sets the conditional headers for revalidating the entry. The
+// client's conditional headers are replaced because the cache answers the
+// client from the revalidated entry.
+func (e *cacheEntry) setValidators(header http.Header) {
+	header.Del(web.HeaderIfNoneMatch)
+	header.Del(web.HeaderIfModifiedSince)
+	if s := e.header.Get(web.HeaderETag); s != "" {
+		header.Set(web.HeaderIfNoneMatch, s)
+	}
+	if s := e.header.Get(web.HeaderLastModified); s != "" {
+		header.Set(web.HeaderIfModifiedSince, s)
+	}
+}
+
+// cache is an in-memory shared HTTP cache as described in RFC 7234.
+type cache struct {
+	options CacheOptions
+
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry
+	lru     *list.List
+	size    int
+}
+
+func newCache(options *CacheOptions) *cache {
+	c := &cache{options: *options, entries: make(map[string][]*cacheEntry), lru: list.New()}
+	if c.options.MaxSize <= 0 {
+		c.options.MaxSize = 64 << 20
+	}
+	if c.options.MaxEntrySize <= 0 {
+		c.options.MaxEntrySize = 1 << 20
+	}
+	return c
+}
+
+func cacheKey(req *web.Request) string {
+	return req.URL.Host + req.URL.Path + "?" + req.URL.RawQuery
+}
+
+// requestCacheControl returns the Cache-Control directives of the request.
+// Pragma: no-cache is treated as Cache-Control: no-cache when the request
+// does not have a Cache-Control header.
+func requestCacheControl(req *web.Request) map[string]string {
+	cc := req.Header.GetCacheControl()
+	if _, found := req.Header[web.HeaderCacheControl]; !found {
+		for _, s := range req.Header.GetList(web.HeaderPragma) {
+			if s == "no-cache" {
+				cc["no-cache"] = ""
+			}
+		}
+	}
+	return cc
+}
+
+// cacheableRequest returns true if the response to req can be served from or
+// stored in the cache.
+func cacheableRequest(req *web.Request) bool {
+	if req.Method != "GET" {
+		return false
+	}
+	_, noStore := requestCacheControl(req)["no-store"]
+	return !noStore
+}
+
+// lookup returns the entry for the request or nil if there is no entry. The
+// hit result is true if the entry is fresh.
+func (c *cache) lookup(req *web.Request) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries[cacheKey(req)] {
+		if e.matchVary(req.Header) {
+			c.lru.MoveToFront(e.elem)
+			return e, e.fresh(requestCacheControl(req), web.Seconds())
+		}
+	}
+	return nil, false
+}
+
+// newEntry returns an entry for the response to req or nil if the response
+// cannot be stored. The body of the entry is not set.
+func (c *cache) newEntry(req *web.Request, status int, header web.Header, requestTime int64) *cacheEntry {
+	if !cacheableStatus[status] {
+		return nil
+	}
+	if _, found := header[web.HeaderSetCookie]; found {
+		return nil
+	}
+	cc := header.GetCacheControl()
+	if _, found := cc["no-store"]; found {
+		return nil
+	}
+	if _, found := cc["private"]; found {
+		return nil
+	}
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	_, mustRevalidate := cc["must-revalidate"]
+	if req.Header.Get(web.HeaderAuthorization) != "" && !public && !sMaxAge && !mustRevalidate {
+		return nil
+	}
+
+	e := &cacheEntry{
+		key:          cacheKey(req),
+		vary:         make(map[string]string),
+		status:       status,
+		header:       make(web.Header),
+		requestTime:  requestTime,
+		responseTime: web.Seconds(),
+	}
+	for _, name := range header.GetList(web.HeaderVary) {
+		if name == "*" {
+			return nil
+		}
+		name = web.HeaderName(name)
+		e.vary[name] = strings.Join(req.Header[name], ", ")
+	}
+	for k, v := range header {
+		e.header[k] = append([]string(nil), v...)
+	}
+	e.header.RemoveHopByHop()
+	e.header[web.HeaderAge] = nil, false
+
+	e.date = e.responseTime
+	if t, err := time.Parse(web.TimeLayout, header.Get(web.HeaderDate)); err == nil {
+		e.date = t.Seconds()
+	}
+	if n, err := strconv.Atoi64(header.Get(web.HeaderAge)); err == nil && n > 0 {
+		e.age = n
+	}
+	var ok bool
+	if e.lifetime, ok = freshnessLifetime(header, cc, e.date); !ok &&
+		header.Get(web.HeaderETag) == "" && header.Get(web.HeaderLastModified) == "" {
+		// The entry can be neither served fresh nor revalidated.
+		return nil
+	}
+	_, proxyRevalidate := cc["proxy-revalidate"]
+	_, noCache := cc["no-cache"]
+	e.mustRevalidate = mustRevalidate || proxyRevalidate || noCache
+	return e
+}
+
+// freshnessLifetime returns the freshness lifetime in seconds of a response
+// as described in RFC 7234 section 4.2.1. The ok result is false if the
+// response has no explicit or heuristic lifetime.
+func freshnessLifetime(header web.Header, cc map[string]string, date int64) (lifetime int64, ok bool) {
+	if _, found := cc["no-cache"]; found {
+		return 0, true
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if s, found := cc[name]; found {
+			lifetime, _ = strconv.Atoi64(s)
+			return lifetime, true
+		}
+	}
+	if s := header.Get(web.HeaderExpires); s != "" {
+		// An invalid date represents a time in the past.
+		if t, err := time.Parse(web.TimeLayout, s); err == nil && t.Seconds() > date {
+			lifetime = t.Seconds() - date
+		}
+		return lifetime, true
+	}
+	if t, err := time.Parse(web.TimeLayout, header.Get(web.HeaderLastModified)); err == nil && t.Seconds() < date {
+		lifetime = (date - t.Seconds()) / 10
+		if lifetime > maxHeuristicLifetime {
+			lifetime = maxHeuristicLifetime
+		}
+		return lifetime, true
+	}
+	return 0, false
+}
+
+// store adds the upstream response to the cache if the response can be
+// stored. The response body is replaced with a copy of the cached body.
+func (c *cache) store(req *web.Request, resp *http.Response, requestTime int64) {
+	if resp.ContentLength < 0 || resp.ContentLength > int64(c.options.MaxEntrySize) {
+		return
+	}
+	e := c.newEntry(req, resp.StatusCode, web.Header(resp.Header), requestTime)
+	if e == nil {
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	if err != nil || int64(len(body)) != resp.ContentLength {
+		return
+	}
+	e.body = body
+	c.insert(e)
+}
+
+// revalidated returns the entry updated with the headers of a 304 response
+// from the upstream.
+func (c *cache) revalidated(req *web.Request, old *cacheEntry, resp *http.Response, requestTime int64) *cacheEntry {
+	header := make(web.Header)
+	for k, v := range old.header {
+		header[k] = v
+	}
+	// Warnings with code 1xx are removed after a successful revalidation.
+	header[web.HeaderWarning] = nil, false
+	for k, v := range resp.Header {
+		if k != web.HeaderContentLength {
+			header[k] = v
+		}
+	}
+	e := c.newEntry(req, old.status, header, requestTime)
+	if e == nil {
+		c.invalidate(req)
+		return old
+	}
+	e.body = old.body
+	c.insert(e)
+	return e
+}
+
+func (c *cache) insert(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, old := range c.entries[e.key] {
+		if sameVary(old.vary, e.vary) {
+			c.removeLocked(old)
+			break
+		}
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = append(c.entries[e.key], e)
+	c.size += e.size()
+	for c.size > c.options.MaxSize {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+func sameVary(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if v, found := b[name]; !found || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidate removes the entries for the request URL. The cache invalidates
+// entries after a successful request with an unsafe method.
+func (c *cache) invalidate(req *web.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(req)
+	for len(c.entries[key]) > 0 {
+		c.removeLocked(c.entries[key][0])
+	}
+}
+
+func (c *cache) removeLocked(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	c.size -= e.size()
+	entries := c.entries[e.key]
+	for i, v := range entries {
+		if v == e {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		c.entries[e.key] = nil, false
+	} else {
+		c.entries[e.key] = entries
+	}
+}
+
+// respond responds to the request from the entry. The Age header is set to
+// the current age of the entry and the warnings are added to the response.
+func (c *cache) respond(req *web.Request, e *cacheEntry, warnings ...string) {
+	header := make(web.Header)
+	for k, v := range e.header {
+		header[k] = v
+	}
+	header.Set(web.HeaderAge, strconv.Itoa64(e.currentAge(web.Seconds())))
+	for _, w := range warnings {
+		header.Add(web.HeaderWarning, w)
+	}
+	if e.status == web.StatusOK && notModified(req, header) {
+		header[web.HeaderContentLength] = nil, false
+		req.Responder.Respond(web.StatusNotModified, header)
+		return
+	}
+	header.Set(web.HeaderContentLength, strconv.Itoa(len(e.body)))
+	w := req.Responder.Respond(e.status, header)
+	if req.Method != "HEAD" {
+		w.Write(e.body)
+	}
+}
+
+// notModified returns true if the client's conditional headers match the
+// cached response header. If-None-Match uses the weak comparison function.
+func notModified(req *web.Request, header web.Header) bool {
+	if inm := req.Header.GetList(web.HeaderIfNoneMatch); len(inm) > 0 {
+		etag := weakETag(header.Get(web.HeaderETag))
+		for _, s := range inm {
+			if s == "*" || (etag != "" && weakETag(s) == etag) {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := time.Parse(web.TimeLayout, req.Header.Get(web.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	lm, err := time.Parse(web.TimeLayout, header.Get(web.HeaderLastModified))
+	return err == nil && lm.Seconds() <= ims.Seconds()
+}
+
+func weakETag(s string) string {
+	if strings.HasPrefix(s, "W/") {
+		s = s[2:]
+	}
+	return s
+}
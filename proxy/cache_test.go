@@ -0,0 +1,188 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package proxy
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type cacheTestUpstream struct {
+	requests int
+	header   http.Header
+	status   int
+	body     string
+	err      os.Error
+	outreq   *http.Request
+}
+
+func (u *cacheTestUpstream) RoundTrip(req *http.Request) (*http.Response, os.Error) {
+	u.requests += 1
+	u.outreq = req
+	if u.err != nil {
+		return nil, u.err
+	}
+	header := make(http.Header)
+	for k, v := range u.header {
+		header[k] = v
+	}
+	return &http.Response{
+		StatusCode:    u.status,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(u.body)),
+		ContentLength: int64(len(u.body)),
+	}, nil
+}
+
+func newCacheTestProxy(t *testing.T, upstream *cacheTestUpstream) *ReverseProxy {
+	p, err := NewReverseProxy(&ReverseOptions{
+		Upstreams: []string{"http://a"},
+		MaxFails:  100,
+		Cache:     &CacheOptions{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.upstreams[0].transport = upstream
+	return p
+}
+
+func TestCacheFreshness(t *testing.T) {
+	clock := web.NewFakeClock(1000e9)
+	defer web.SetClock(web.SetClock(clock))
+
+	upstream := &cacheTestUpstream{
+		status: web.StatusOK,
+		header: http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}},
+		body:   "hello",
+	}
+	p := newCacheTestProxy(t, upstream)
+
+	status, header, body := web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+	if status != web.StatusOK || string(body) != "hello" || upstream.requests != 1 {
+		t.Fatalf("miss status=%d body=%q requests=%d", status, body, upstream.requests)
+	}
+
+	clock.Advance(30e9)
+	status, header, body = web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+	if status != web.StatusOK || string(body) != "hello" || upstream.requests != 1 || header.Get(web.HeaderAge) != "30" {
+		t.Errorf("hit status=%d body=%q requests=%d Age=%q", status, body, upstream.requests, header.Get(web.HeaderAge))
+	}
+
+	status, _, _ = web.RunHandler("http://example.com/a", "GET", web.NewHeader(web.HeaderIfNoneMatch, `"v1"`), nil, p)
+	if status != web.StatusNotModified || upstream.requests != 1 {
+		t.Errorf("conditional hit status=%d requests=%d", status, upstream.requests)
+	}
+
+	web.RunHandler("http://example.com/a", "GET", web.NewHeader(web.HeaderCacheControl, "no-cache"), nil, p)
+	if upstream.requests != 2 || upstream.outreq.Header.Get(web.HeaderIfNoneMatch) != `"v1"` {
+		t.Errorf("no-cache requests=%d If-None-Match=%q", upstream.requests, upstream.outreq.Header.Get(web.HeaderIfNoneMatch))
+	}
+
+	web.RunHandler("http://example.com/a?x=1", "GET", nil, nil, p)
+	if upstream.requests != 3 {
+		t.Errorf("other query requests=%d, want 3", upstream.requests)
+	}
+
+	upstream.status = web.StatusNoContent
+	web.RunHandler("http://example.com/a", "DELETE", nil, nil, p)
+	upstream.status = web.StatusOK
+	web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+	if upstream.requests != 5 {
+		t.Errorf("after DELETE requests=%d, want 5", upstream.requests)
+	}
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	clock := web.NewFakeClock(1000e9)
+	defer web.SetClock(web.SetClock(clock))
+
+	upstream := &cacheTestUpstream{
+		status: web.StatusOK,
+		header: http.Header{"Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}, "X-Version": {"1"}},
+		body:   "hello",
+	}
+	p := newCacheTestProxy(t, upstream)
+	web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+
+	clock.Advance(20e9)
+	upstream.status = web.StatusNotModified
+	upstream.header = http.Header{"Cache-Control": {"max-age=10"}, "X-Version": {"2"}}
+	upstream.body = ""
+	status, header, body := web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+	if status != web.StatusOK || string(body) != "hello" || header.Get("X-Version") != "2" || header.Get(web.HeaderAge) != "0" {
+		t.Errorf("revalidated status=%d body=%q header=%v", status, body, header)
+	}
+	if upstream.requests != 2 || upstream.outreq.Header.Get(web.HeaderIfNoneMatch) != `"v1"` {
+		t.Errorf("revalidation requests=%d If-None-Match=%q", upstream.requests, upstream.outreq.Header.Get(web.HeaderIfNoneMatch))
+	}
+
+	clock.Advance(20e9)
+	upstream.err = os.NewError("connection refused")
+	status, header, body = web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+	if status != web.StatusOK || string(body) != "hello" || len(header[web.HeaderWarning]) != 2 {
+		t.Errorf("stale status=%d body=%q header=%v", status, body, header)
+	}
+}
+
+var cacheStoreTests = []struct {
+	header http.Header
+	stored bool
+}{
+	{http.Header{"Cache-Control": {"max-age=60"}}, true},
+	{http.Header{"Expires": {"Thu, 01 Jan 1970 00:17:40 GMT"}, "Date": {"Thu, 01 Jan 1970 00:16:40 GMT"}}, true},
+	{http.Header{"Last-Modified": {"Thu, 01 Jan 1970 00:00:00 GMT"}}, true},
+	{http.Header{}, false},
+	{http.Header{"Cache-Control": {"max-age=60, private"}}, false},
+	{http.Header{"Cache-Control": {"no-store"}}, false},
+	{http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, false},
+	{http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, false},
+}
+
+func TestCacheStore(t *testing.T) {
+	clock := web.NewFakeClock(1000e9)
+	defer web.SetClock(web.SetClock(clock))
+
+	for _, tt := range cacheStoreTests {
+		upstream := &cacheTestUpstream{status: web.StatusOK, header: tt.header, body: "hello"}
+		p := newCacheTestProxy(t, upstream)
+		web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+		web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+		if stored := upstream.requests == 1; stored != tt.stored {
+			t.Errorf("%v stored=%v, want %v", tt.header, stored, tt.stored)
+		}
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	upstream := &cacheTestUpstream{
+		status: web.StatusOK,
+		header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-language"}},
+	}
+	p := newCacheTestProxy(t, upstream)
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		upstream.body = lang
+		_, _, body := web.RunHandler("http://example.com/a", "GET", web.NewHeader("Accept-Language", lang), nil, p)
+		if string(body) != lang {
+			t.Errorf("Accept-Language %s body=%q", lang, body)
+		}
+	}
+	if upstream.requests != 2 {
+		t.Errorf("requests=%d, want 2", upstream.requests)
+	}
+}
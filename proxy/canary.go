@@ -0,0 +1,124 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package proxy
+
+import (
+	"github.com/garyburd/twister/expvar"
+	"github.com/garyburd/twister/web"
+	"rand"
+)
+
+// Variant names used by CanaryHandler.
+const (
+	Stable = "stable"
+	Canary = "canary"
+)
+
+// CanaryOptions configures CanaryHandler.
+type CanaryOptions struct {
+	// Handler for requests assigned to the canary variant, typically a
+	// ReverseProxy for the canary upstreams.
+	Canary web.Handler
+
+	// Percentage of clients assigned to the canary variant.
+	Percent int
+
+	// If not empty, requests with this header set to "canary" or "stable"
+	// are routed to the named variant regardless of the assignment.
+	Header string
+
+	// Name and maximum age in seconds of the cookie that records a client's
+	// variant. The default name is "twister_canary". If the maximum age is
+	// zero, the cookie is a session cookie. A client can select a variant by
+	// setting the cookie.
+	Cookie       string
+	CookieMaxAge int
+
+	// If not nil, request and server error counts are recorded in Metrics
+	// using the keys "stable.requests", "stable.errors", "canary.requests"
+	// and "canary.errors".
+	Metrics *expvar.Map
+}
+
+// CanaryHandler returns a handler that routes a percentage of clients to a
+// canary handler and the remaining clients to stable. The assignment is
+// sticky: a client's variant is recorded in a cookie and reused on later
+// requests. Use RequestVariant to get the variant for a request.
+func CanaryHandler(options *CanaryOptions, stable web.Handler) web.Handler {
+	h := &canaryHandler{options: *options, stable: stable}
+	if h.options.Canary == nil {
+		panic("twister.proxy: CanaryHandler requires Canary option")
+	}
+	if h.options.Cookie == "" {
+		h.options.Cookie = "twister_canary"
+	}
+	return h
+}
+
+type canaryHandler struct {
+	options CanaryOptions
+	stable  web.Handler
+}
+
+const variantEnvKey = "twister.proxy.Variant"
+
+// RequestVariant returns the variant assigned to the request by
+// CanaryHandler or "" if the request was not handled by CanaryHandler.
+func RequestVariant(req *web.Request) string {
+	v, _ := req.Env[variantEnvKey].(string)
+	return v
+}
+
+func (h *canaryHandler) ServeWeb(req *web.Request) {
+	variant := ""
+	if h.options.Header != "" {
+		variant = req.Header.Get(h.options.Header)
+	}
+	if variant != Stable && variant != Canary {
+		variant = req.Cookie.Get(h.options.Cookie)
+		if variant != Stable && variant != Canary {
+			variant = Stable
+			if rand.Intn(100) < h.options.Percent {
+				variant = Canary
+			}
+			c := web.NewCookie(h.options.Cookie, variant)
+			if h.options.CookieMaxAge != 0 {
+				c.MaxAge(h.options.CookieMaxAge)
+			}
+			cookie := c.String()
+			web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+				header.Add(web.HeaderSetCookie, cookie)
+				return status, header
+			})
+		}
+	}
+	req.Env[variantEnvKey] = variant
+
+	if m := h.options.Metrics; m != nil {
+		m.AddInt(variant+".requests", 1)
+		web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+			if status >= 500 {
+				m.AddInt(variant+".errors", 1)
+			}
+			return status, header
+		})
+	}
+
+	if variant == Canary {
+		h.options.Canary.ServeWeb(req)
+	} else {
+		h.stable.ServeWeb(req)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package proxy implements a forward HTTP proxy.
+//
+// The proxy forwards requests with an absolute URL and tunnels CONNECT
+// requests. Use the handler as both the request handler and CONNECT handler
+// of a server:
+//
+//  p := proxy.NewHandler(&proxy.Options{
+//      Realm: "lab",
+//      Allow: func(req *web.Request) bool {
+//          user, password, ok := proxy.BasicAuth(req)
+//          return ok && checkPassword(user, password)
+//      },
+//  })
+//  s := &server.Server{Listener: l, Handler: p, ConnectHandler: p}
+package proxy
+
+import (
+	"encoding/base64"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Options configures the proxy handler.
+type Options struct {
+	// Allow returns true if the request is permitted. The application is
+	// required to set this field.
+	Allow func(req *web.Request) bool
+
+	// If not empty, requests that are not allowed are rejected with status
+	// 407 and a challenge for basic authentication in this realm. Otherwise,
+	// requests that are not allowed are rejected with status 403.
+	Realm string
+
+	// Transport for forwarded requests. The default is http.DefaultTransport.
+	// Use a client.Transport to add timeouts and retries.
+	Transport http.RoundTripper
+
+	// Ports allowed for CONNECT requests. The default is 443.
+	ConnectPorts []string
+}
+
+type handler struct {
+	options Options
+}
+
+// NewHandler returns a forward proxy handler.
+func NewHandler(options *Options) web.Handler {
+	if options.Allow == nil {
+		panic("twister.proxy: NewHandler requires Allow option")
+	}
+	h := &handler{options: *options}
+	if h.options.Transport == nil {
+		h.options.Transport = http.DefaultTransport
+	}
+	if h.options.ConnectPorts == nil {
+		h.options.ConnectPorts = []string{"443"}
+	}
+	return h
+}
+
+// BasicAuth returns the user name and password in the request's
+// Proxy-Authorization header.
+func BasicAuth(req *web.Request) (user, password string, ok bool) {
+	s := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if !strings.HasPrefix(s, prefix) {
+		return "", "", false
+	}
+	p, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s[len(prefix):]))
+	if err != nil {
+		return "", "", false
+	}
+	s = string(p)
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func (h *handler) ServeWeb(req *web.Request) {
+	if !h.options.Allow(req) {
+		if h.options.Realm != "" {
+			req.Error(web.StatusProxyAuthenticationRequired, os.NewError("twister.proxy: not allowed"),
+				"Proxy-Authenticate", "Basic realm="+web.QuoteHeaderValue(h.options.Realm))
+		} else {
+			req.Error(web.StatusForbidden, os.NewError("twister.proxy: not allowed"))
+		}
+		return
+	}
+	if req.Method == "CONNECT" {
+		h.connect(req)
+	} else {
+		h.forward(req)
+	}
+}
+
+// newOutgoingRequest returns a request to url with the method, headers and
+// body of req. Hop-by-hop headers are removed.
+func newOutgoingRequest(req *web.Request, url *http.URL) *http.Request {
+	header := make(http.Header)
+	for k, v := range req.Header {
+		header[k] = v
+	}
+	web.Header(header).RemoveHopByHop()
+
+	outreq := &http.Request{
+		Method:     req.Method,
+		URL:        url,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Host:       url.Host,
+	}
+	if req.ContentLength != 0 {
+		outreq.Body = ioutil.NopCloser(req.Body)
+		outreq.ContentLength = int64(req.ContentLength)
+	}
+	return outreq
+}
+
+// absoluteForm returns true if the request target is an absolute URL. A
+// request with an origin form target is addressed to the proxy itself.
+func absoluteForm(req *web.Request) bool {
+	raw := strings.ToLower(req.URL.Raw)
+	return strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://")
+}
+
+func (h *handler) forward(req *web.Request) {
+	if !absoluteForm(req) {
+		req.Error(web.StatusBadRequest, os.NewError("twister.proxy: request target is not an absolute URL"))
+		return
+	}
+	outreq := newOutgoingRequest(req, req.URL)
+	outreq.RawURL = req.URL.String()
+	resp, err := h.options.Transport.RoundTrip(outreq)
+	if err != nil {
+		req.Error(web.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyResponse(req, resp)
+}
+
+// copyResponse copies the response from an upstream server to the client.
+func copyResponse(req *web.Request, resp *http.Response) {
+	header := web.Header(resp.Header)
+	header.RemoveHopByHop()
+	if resp.ContentLength >= 0 {
+		header.Set(web.HeaderContentLength, strconv.Itoa64(resp.ContentLength))
+	}
+	w := req.Responder.Respond(resp.StatusCode, header)
+	io.Copy(w, resp.Body)
+}
+
+func (h *handler) connect(req *web.Request) {
+	_, port, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		req.Error(web.StatusBadRequest, err)
+		return
+	}
+	allowed := false
+	for _, p := range h.options.ConnectPorts {
+		if p == port {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		req.Error(web.StatusForbidden, os.NewError("twister.proxy: port not allowed"))
+		return
+	}
+
+	upstream, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		req.Error(web.StatusBadGateway, err)
+		return
+	}
+	defer upstream.Close()
+
+	conn, br, err := req.Responder.Hijack()
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	done := make(chan bool)
+	go func() {
+		// Copy buffered and subsequent client data to the upstream server.
+		io.Copy(upstream, br)
+		upstream.Close()
+		done <- true
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+	<-done
+}
@@ -0,0 +1,77 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package proxy
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type transportFunc func(req *http.Request) (*http.Response, os.Error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, os.Error) { return f(req) }
+
+func TestForward(t *testing.T) {
+	var outreq *http.Request
+	h := NewHandler(&Options{
+		Realm: "test",
+		Allow: func(req *web.Request) bool {
+			user, password, ok := BasicAuth(req)
+			return ok && user == "alice" && password == "secret"
+		},
+		Transport: transportFunc(func(req *http.Request) (*http.Response, os.Error) {
+			outreq = req
+			return &http.Response{
+				StatusCode:    web.StatusOK,
+				Header:        http.Header{"Content-Type": {"text/plain"}, "Connection": {"close"}},
+				Body:          ioutil.NopCloser(bytes.NewBufferString("hello")),
+				ContentLength: 5,
+			}, nil
+		}),
+	})
+
+	status, header, _ := web.RunHandler("http://example.com/a", "GET", nil, nil, h)
+	if status != web.StatusProxyAuthenticationRequired || header.Get("Proxy-Authenticate") != `Basic realm="test"` {
+		t.Errorf("no auth, status=%d Proxy-Authenticate=%q", status, header.Get("Proxy-Authenticate"))
+	}
+
+	reqHeader := web.NewHeader(
+		"Proxy-Authorization", "Basic YWxpY2U6c2VjcmV0",
+		"Proxy-Connection", "keep-alive",
+		"Accept", "text/plain")
+	status, header, body := web.RunHandler("http://example.com/a", "GET", reqHeader, nil, h)
+	if status != web.StatusOK || string(body) != "hello" || header.Get("Connection") != "" || header.Get(web.HeaderContentLength) != "5" {
+		t.Errorf("auth, status=%d body=%q header=%v", status, body, header)
+	}
+	if outreq == nil {
+		t.Fatal("request not forwarded")
+	}
+	if outreq.URL.Host != "example.com" || outreq.Header.Get("Proxy-Authorization") != "" || outreq.Header.Get("Proxy-Connection") != "" || outreq.Header.Get("Accept") != "text/plain" {
+		t.Errorf("forwarded host=%q header=%v", outreq.URL.Host, outreq.Header)
+	}
+
+	outreq = nil
+	reqHeader = web.NewHeader(
+		"Proxy-Authorization", "Basic YWxpY2U6c2VjcmV0",
+		web.HeaderHost, "internal.example.com")
+	status, _, _ = web.RunHandler("/a", "GET", reqHeader, nil, h)
+	if status != web.StatusBadRequest || outreq != nil {
+		t.Errorf("origin form, status=%d forwarded=%v, want status 400 and no request", status, outreq != nil)
+	}
+}
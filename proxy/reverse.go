@@ -0,0 +1,566 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package proxy
+
+import (
+	"github.com/garyburd/twister/client"
+	"github.com/garyburd/twister/trace"
+	"github.com/garyburd/twister/web"
+	"hash/crc32"
+	"http"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Balance specifies how the reverse proxy selects an upstream server.
+type Balance int
+
+const (
+	// RoundRobin selects healthy upstreams in turn.
+	RoundRobin Balance = iota
+
+	// LeastConnections selects the healthy upstream with the fewest active
+	// requests.
+	LeastConnections
+)
+
+// Affinity specifies how the reverse proxy keeps a client on the same
+// upstream server.
+type Affinity int
+
+const (
+	// NoAffinity selects an upstream for each request using the balance
+	// method.
+	NoAffinity Affinity = iota
+
+	// CookieAffinity records the selected upstream in a cookie.
+	CookieAffinity
+
+	// IPHashAffinity selects the upstream from a hash of the client's IP
+	// address.
+	IPHashAffinity
+)
+
+// ReverseOptions configures a ReverseProxy.
+type ReverseOptions struct {
+	// Base URLs of the upstream servers. The request path is appended to the
+	// path of the base URL.
+	Upstreams []string
+
+	// Upstream selection method. The default is RoundRobin.
+	Balance Balance
+
+	// Session affinity mode. If the upstream for a client is not available,
+	// another upstream is selected using the balance method. The default is
+	// NoAffinity.
+	Affinity Affinity
+
+	// Name and maximum age in seconds of the CookieAffinity cookie. The
+	// default name is "twister_upstream". If the maximum age is zero, the
+	// cookie is a session cookie.
+	AffinityCookie       string
+	AffinityCookieMaxAge int
+
+	// Number of consecutive failures after which an upstream is marked down.
+	// The default is 3.
+	MaxFails int
+
+	// Number of seconds an upstream is marked down after MaxFails failures.
+	// The default is 10.
+	FailTimeout int64
+
+	// If not empty, each upstream is probed with a GET request for this path
+	// every HealthCheckInterval seconds. Upstreams that do not respond with a
+	// 2xx status are marked down until a probe succeeds.
+	HealthCheckPath     string
+	HealthCheckInterval int64
+
+	// Maximum number of idle connections kept open to each upstream. The
+	// default is 8.
+	MaxIdleConns int
+
+	// Maximum time in nanoseconds to wait for the response from an upstream.
+	// For upgrade requests, the limit applies to the dial and the handshake.
+	// If the time is exceeded, the attempt is recorded as a failure and the
+	// request fails with status 504. No limit is imposed if Timeout is zero.
+	Timeout int64
+
+	// If not nil, a circuit breaker with these options is created for each
+	// upstream. An upstream is not selected while its breaker is open. When
+	// no upstream is available, requests fail immediately with status 503.
+	Breaker *client.BreakerOptions
+
+	// Path prefix where the proxy is mounted in the application, for example
+	// "/legacy". The prefix is removed from the request path before the
+	// request is forwarded. The prefix is added to paths in the Location
+	// and Content-Location headers and to Set-Cookie path attributes of the
+	// upstream response. Set-Cookie domain attributes are removed.
+	Prefix string
+
+	// If true, root relative href, src and action attributes in HTML
+	// responses are rewritten to include Prefix.
+	RewriteHTML bool
+
+	// If not nil, the proxy acts as a shared cache for upstream responses to
+	// GET requests. The cache honors the Cache-Control, Expires and Vary
+	// headers of upstream responses, revalidates stale responses with
+	// conditional requests and serves stale responses with a Warning header
+	// when no upstream is available. Successful requests with other methods
+	// invalidate the cached responses for the request URL.
+	Cache *CacheOptions
+
+	// If not nil, RewriteRequest is called to modify each request before it
+	// is sent to an upstream.
+	RewriteRequest func(req *web.Request, outreq *http.Request)
+
+	// If not nil, RewriteResponse is called to modify each upstream response
+	// before it is sent to the client. RewriteResponse is called after the
+	// Prefix rewrites are applied.
+	RewriteResponse func(req *web.Request, resp *http.Response)
+}
+
+type upstream struct {
+	id        string
+	url       *http.URL
+	transport http.RoundTripper
+	breaker   *client.Breaker
+
+	// The following fields are protected by ReverseProxy.mu.
+	active    int
+	fails     int
+	downUntil int64
+	unhealthy bool
+}
+
+func (u *upstream) available(now int64) bool {
+	return !u.unhealthy && now >= u.downUntil && (u.breaker == nil || u.breaker.Ready())
+}
+
+// ReverseProxy forwards requests to a set of upstream servers. Upgrade
+// requests, such as the WebSocket handshake, are forwarded to the upstream
+// and, if the upstream switches protocols, the client connection is
+// hijacked and spliced to the upstream connection.
+type ReverseProxy struct {
+	options   ReverseOptions
+	upstreams []*upstream
+	cache     *cache
+	done      chan bool
+
+	mu   sync.Mutex
+	next int
+}
+
+var errNoUpstream = os.NewError("twister.proxy: no upstream available")
+
+// NewReverseProxy returns a reverse proxy for the upstreams in options.
+func NewReverseProxy(options *ReverseOptions) (*ReverseProxy, os.Error) {
+	p := &ReverseProxy{options: *options, done: make(chan bool)}
+	if len(p.options.Upstreams) == 0 {
+		return nil, os.NewError("twister.proxy: no upstreams")
+	}
+	if p.options.MaxFails <= 0 {
+		p.options.MaxFails = 3
+	}
+	if p.options.FailTimeout <= 0 {
+		p.options.FailTimeout = 10
+	}
+	if p.options.HealthCheckInterval <= 0 {
+		p.options.HealthCheckInterval = 10
+	}
+	if p.options.MaxIdleConns <= 0 {
+		p.options.MaxIdleConns = 8
+	}
+	if p.options.AffinityCookie == "" {
+		p.options.AffinityCookie = "twister_upstream"
+	}
+	for _, s := range p.options.Upstreams {
+		url, err := http.ParseURL(s)
+		if err != nil {
+			return nil, err
+		}
+		if url.Scheme != "http" && url.Scheme != "https" || url.Host == "" {
+			return nil, os.NewError("twister.proxy: bad upstream URL " + s)
+		}
+		u := &upstream{
+			id:        strconv.Uitob(uint(crc32.ChecksumIEEE([]byte(s))), 36),
+			url:       url,
+			transport: &http.Transport{MaxIdleConnsPerHost: p.options.MaxIdleConns},
+		}
+		if p.options.Breaker != nil {
+			u.breaker = client.NewBreaker(p.options.Breaker)
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+	if p.options.Cache != nil {
+		p.cache = newCache(p.options.Cache)
+	}
+	if p.options.HealthCheckPath != "" {
+		go p.healthCheck()
+	}
+	return p, nil
+}
+
+// Close stops health checking.
+func (p *ReverseProxy) Close() {
+	close(p.done)
+}
+
+// preferred returns the upstream for the client given the affinity mode or
+// nil if the client does not have an upstream.
+func (p *ReverseProxy) preferred(req *web.Request) *upstream {
+	switch p.options.Affinity {
+	case CookieAffinity:
+		if id := req.Cookie.Get(p.options.AffinityCookie); id != "" {
+			for _, u := range p.upstreams {
+				if u.id == id {
+					return u
+				}
+			}
+		}
+	case IPHashAffinity:
+		h := crc32.ChecksumIEEE([]byte(remoteHost(req)))
+		return p.upstreams[int(h%uint32(len(p.upstreams)))]
+	}
+	return nil
+}
+
+// choose returns an available upstream that is not in tried or nil if there
+// is no such upstream. The preferred upstream is returned if it is available.
+func (p *ReverseProxy) choose(preferred *upstream, tried []*upstream) *upstream {
+	now := web.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if preferred != nil && preferred.available(now) && !containsUpstream(tried, preferred) {
+		preferred.active += 1
+		return preferred
+	}
+	var best *upstream
+	next := p.next
+	for i := 0; i < len(p.upstreams); i++ {
+		j := (p.next + i) % len(p.upstreams)
+		u := p.upstreams[j]
+		if !u.available(now) || containsUpstream(tried, u) {
+			continue
+		}
+		if best == nil || u.active < best.active {
+			best = u
+			next = j + 1
+		}
+		if p.options.Balance == RoundRobin {
+			break
+		}
+	}
+	if best != nil {
+		best.active += 1
+		p.next = next
+	}
+	return best
+}
+
+// retryAfter returns the time in nanoseconds until an upstream is expected
+// to become available.
+func (p *ReverseProxy) retryAfter() int64 {
+	now := web.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	min := int64(-1)
+	for _, u := range p.upstreams {
+		var d int64
+		switch {
+		case u.unhealthy:
+			d = p.options.HealthCheckInterval * 1e9
+		case now < u.downUntil:
+			d = (u.downUntil - now) * 1e9
+		case u.breaker != nil:
+			d = u.breaker.RetryAfter()
+		}
+		if min < 0 || d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+func containsUpstream(upstreams []*upstream, u *upstream) bool {
+	for _, v := range upstreams {
+		if v == u {
+			return true
+		}
+	}
+	return false
+}
+
+// release records the result of a request to u.
+func (p *ReverseProxy) release(u *upstream, failed bool) {
+	if u.breaker != nil {
+		u.breaker.Record(!failed)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u.active -= 1
+	if !failed {
+		u.fails = 0
+		return
+	}
+	u.fails += 1
+	if u.fails >= p.options.MaxFails {
+		u.fails = 0
+		u.downUntil = web.Seconds() + p.options.FailTimeout
+	}
+}
+
+// upstreamURL returns the URL for the request on upstream u.
+func (p *ReverseProxy) upstreamURL(u *upstream, req *web.Request) *http.URL {
+	path := req.URL.Path
+	if p.options.Prefix != "" && strings.HasPrefix(path, p.options.Prefix) {
+		path = path[len(p.options.Prefix):]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if base := u.url.Path; base != "" && base != "/" {
+		path = strings.TrimRight(base, "/") + path
+	}
+	return &http.URL{
+		Scheme:   u.url.Scheme,
+		Host:     u.url.Host,
+		Path:     path,
+		RawQuery: req.URL.RawQuery,
+	}
+}
+
+func remoteHost(req *web.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// respondStale responds to the request from a stale cache entry after a
+// failed revalidation. The function returns false if the entry is nil or
+// must not be served stale.
+func (p *ReverseProxy) respondStale(req *web.Request, e *cacheEntry) bool {
+	if e == nil || e.mustRevalidate {
+		return false
+	}
+	p.cache.respond(req, e, warningStale, warningRevalidationFailed)
+	return true
+}
+
+func (p *ReverseProxy) ServeWeb(req *web.Request) {
+	cacheable := p.cache != nil && cacheableRequest(req)
+	var entry *cacheEntry
+	if cacheable {
+		var hit bool
+		if entry, hit = p.cache.lookup(req); hit {
+			p.cache.respond(req, entry)
+			return
+		}
+	}
+
+	preferred := p.preferred(req)
+	var tried []*upstream
+	for {
+		u := p.choose(preferred, tried)
+		if u == nil {
+			if p.respondStale(req, entry) {
+				return
+			}
+			web.ThrottleError(req, web.StatusServiceUnavailable, errNoUpstream, p.retryAfter())
+			return
+		}
+		tried = append(tried, u)
+
+		if u.breaker != nil && !u.breaker.Allow() {
+			// Another request took the breaker's last probe.
+			p.mu.Lock()
+			u.active -= 1
+			p.mu.Unlock()
+			continue
+		}
+
+		outreq := p.outgoingRequest(req, u)
+		if isUpgrade(req) {
+			if p.upgrade(req, u, outreq) {
+				continue
+			}
+			return
+		}
+		if entry != nil {
+			entry.setValidators(outreq.Header)
+		}
+
+		requestTime := web.Seconds()
+		resp, err := p.roundTrip(req, u, outreq)
+		if err == client.ErrTimeout {
+			p.release(u, true)
+			if p.respondStale(req, entry) {
+				return
+			}
+			req.Error(web.StatusGatewayTimeout, err)
+			return
+		}
+		if err != nil {
+			p.release(u, true)
+			// Retry idempotent requests without a body on another upstream.
+			if req.ContentLength == 0 && client.Idempotent(req.Method) {
+				continue
+			}
+			req.Error(web.StatusBadGateway, err)
+			return
+		}
+		if entry != nil && resp.StatusCode == web.StatusNotModified {
+			resp.Body.Close()
+			p.release(u, false)
+			p.cache.respond(req, p.cache.revalidated(req, entry, resp, requestTime))
+			return
+		}
+		if entry != nil && resp.StatusCode >= 500 && !entry.mustRevalidate {
+			resp.Body.Close()
+			p.release(u, false)
+			p.respondStale(req, entry)
+			return
+		}
+		if p.options.Prefix != "" {
+			p.rewritePrefix(req, u, resp)
+		}
+		if p.options.RewriteResponse != nil {
+			p.options.RewriteResponse(req, resp)
+		}
+		if cacheable {
+			p.cache.store(req, resp, requestTime)
+		} else if p.cache != nil && req.Method != "GET" && req.Method != "HEAD" && resp.StatusCode < 400 {
+			p.cache.invalidate(req)
+		}
+		// The affinity cookie is added after the response is stored
+		// because responses with cookies are not cached.
+		if p.options.Affinity == CookieAffinity && u != preferred {
+			c := web.NewCookie(p.options.AffinityCookie, u.id)
+			if p.options.Prefix != "" {
+				c.Path(p.options.Prefix + "/")
+			}
+			if p.options.AffinityCookieMaxAge != 0 {
+				c.MaxAge(p.options.AffinityCookieMaxAge)
+			}
+			resp.Header.Add(web.HeaderSetCookie, c.String())
+		}
+		copyResponse(req, resp)
+		resp.Body.Close()
+		p.release(u, false)
+		return
+	}
+}
+
+// outgoingRequest returns the request for upstream u with the forwarding
+// headers set and RewriteRequest applied.
+func (p *ReverseProxy) outgoingRequest(req *web.Request, u *upstream) *http.Request {
+	outreq := newOutgoingRequest(req, p.upstreamURL(u, req))
+	forwardedFor := remoteHost(req)
+	if prior := req.Header["X-Forwarded-For"]; len(prior) > 0 {
+		forwardedFor = strings.Join(prior, ", ") + ", " + forwardedFor
+	}
+	outreq.Header.Set("X-Forwarded-For", forwardedFor)
+	outreq.Header.Set("X-Forwarded-Host", req.URL.Host)
+	outreq.Header.Set("X-Forwarded-Proto", req.URL.Scheme)
+	web.Header(outreq.Header).AddForwarded(remoteHost(req), "", req.URL.Host, req.URL.Scheme)
+	if p.options.RewriteRequest != nil {
+		p.options.RewriteRequest(req, outreq)
+	}
+	return outreq
+}
+
+// startSpan returns a child of the request's span for a call to upstream u
+// or nil if the request is not traced.
+func startSpan(req *web.Request, u *upstream, outreq *http.Request) *trace.Span {
+	parent := trace.RequestSpan(req)
+	if parent == nil {
+		return nil
+	}
+	span := parent.Child("proxy " + u.url.Host)
+	span.SetTag("http.url", outreq.URL.String())
+	span.Inject(outreq.Header)
+	return span
+}
+
+// finishSpan records the result of a call to an upstream and finishes the
+// span. The span can be nil.
+func finishSpan(span *trace.Span, resp *http.Response, err os.Error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.SetTag("error", err.String())
+	} else {
+		span.SetTag("http.status", strconv.Itoa(resp.StatusCode))
+	}
+	span.Finish()
+}
+
+// roundTrip sends outreq to upstream u. If the request is traced, the call is
+// recorded in a child span of the request's span.
+func (p *ReverseProxy) roundTrip(req *web.Request, u *upstream, outreq *http.Request) (*http.Response, os.Error) {
+	span := startSpan(req, u, outreq)
+	resp, err := client.RoundTripTimeout(u.transport, outreq, p.options.Timeout)
+	finishSpan(span, resp, err)
+	return resp, err
+}
+
+func (p *ReverseProxy) healthCheck() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(p.options.HealthCheckInterval * 1e9):
+		}
+		for _, u := range p.upstreams {
+			healthy := p.probe(u)
+			p.mu.Lock()
+			u.unhealthy = !healthy
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *ReverseProxy) probe(u *upstream) bool {
+	url := &http.URL{
+		Scheme: u.url.Scheme,
+		Host:   u.url.Host,
+		Path:   strings.TrimRight(u.url.Path, "/") + p.options.HealthCheckPath,
+	}
+	// Limit the probe so that a hung upstream does not stall health checks
+	// of the other upstreams.
+	timeout := p.options.Timeout
+	if timeout <= 0 {
+		timeout = p.options.HealthCheckInterval * 1e9
+	}
+	resp, err := client.RoundTripTimeout(u.transport, &http.Request{
+		Method:     "GET",
+		URL:        url,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       url.Host,
+	}, timeout)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
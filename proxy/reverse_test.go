@@ -0,0 +1,368 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/garyburd/twister/client"
+	"github.com/garyburd/twister/expvar"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReverseChoose(t *testing.T) {
+	p, err := NewReverseProxy(&ReverseOptions{
+		Upstreams: []string{"http://a", "http://b", "http://c"},
+		MaxFails:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hosts string
+	for i := 0; i < 4; i++ {
+		u := p.choose(nil, nil)
+		hosts += u.url.Host
+		p.release(u, false)
+	}
+	if hosts != "abca" {
+		t.Errorf("round robin = %q, want %q", hosts, "abca")
+	}
+
+	b := p.upstreams[1]
+	for i := 0; i < 2; i++ {
+		b.active += 1
+		p.release(b, true)
+	}
+	hosts = ""
+	for i := 0; i < 4; i++ {
+		u := p.choose(nil, nil)
+		hosts += u.url.Host
+		p.release(u, false)
+	}
+	if hosts != "caca" {
+		t.Errorf("after failures = %q, want %q", hosts, "caca")
+	}
+
+	if u := p.choose(nil, p.upstreams); u != nil {
+		t.Errorf("all tried, choose = %v, want nil", u.url.Host)
+	}
+}
+
+func TestReverseLeastConnections(t *testing.T) {
+	p, err := NewReverseProxy(&ReverseOptions{
+		Upstreams: []string{"http://a", "http://b"},
+		Balance:   LeastConnections,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := p.choose(nil, nil)
+	b := p.choose(nil, nil)
+	if a == b {
+		t.Fatalf("choose returned %s twice", a.url.Host)
+	}
+	p.release(b, false)
+	if u := p.choose(nil, nil); u != b {
+		t.Errorf("choose = %s, want %s", u.url.Host, b.url.Host)
+	}
+}
+
+func TestReverseBreaker(t *testing.T) {
+	p, err := NewReverseProxy(&ReverseOptions{
+		Upstreams: []string{"http://a", "http://b"},
+		MaxFails:  100,
+		Breaker:   &client.BreakerOptions{MinRequests: 1, OpenTimeout: 60e9},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := p.choose(nil, nil)
+	p.release(a, true)
+	b := p.choose(nil, nil)
+	if b == a {
+		t.Fatalf("choose returned upstream with open breaker")
+	}
+	p.release(b, true)
+	if u := p.choose(nil, nil); u != nil {
+		t.Errorf("all breakers open, choose = %v, want nil", u.url.Host)
+	}
+	status, header, _ := web.RunHandler("http://example.com/", "GET", nil, nil, p)
+	if status != web.StatusServiceUnavailable {
+		t.Errorf("all breakers open, status=%d, want %d", status, web.StatusServiceUnavailable)
+	}
+	if v := header.Get(web.HeaderRetryAfter); v != "60" {
+		t.Errorf("Retry-After=%q, want 60", v)
+	}
+}
+
+func TestReverseAffinity(t *testing.T) {
+	p, err := NewReverseProxy(&ReverseOptions{
+		Upstreams: []string{"http://a", "http://b", "http://c"},
+		Affinity:  CookieAffinity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := p.upstreams[2]
+	req, _ := web.NewRequest("1.2.3.4:1000", "GET", &http.URL{Path: "/"}, web.ProtocolVersion11,
+		web.NewHeader(web.HeaderCookie, "twister_upstream="+c.id))
+	if u := p.preferred(req); u != c {
+		t.Errorf("cookie preferred = %v, want %s", u, c.url.Host)
+	}
+	for i := 0; i < 3; i++ {
+		if u := p.choose(c, nil); u != c {
+			t.Errorf("choose = %s, want %s", u.url.Host, c.url.Host)
+		}
+	}
+	c.downUntil = 1 << 62
+	if u := p.choose(c, nil); u == c {
+		t.Errorf("choose returned unavailable preferred upstream")
+	}
+
+	p.options.Affinity = IPHashAffinity
+	if p.preferred(req) != p.preferred(req) {
+		t.Errorf("ip hash not stable")
+	}
+}
+
+func TestReverseForward(t *testing.T) {
+	p, err := NewReverseProxy(&ReverseOptions{
+		Upstreams: []string{"http://a", "http://b"},
+		MaxFails:  100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &cacheTestUpstream{status: web.StatusOK}
+	b := &cacheTestUpstream{status: web.StatusOK}
+	p.upstreams[0].transport = a
+	p.upstreams[1].transport = b
+
+	web.RunHandler("http://example.com/a", "GET", web.NewHeader("X-Forwarded-For", "10.0.0.1"), nil, p)
+	outreq := a.outreq
+	if outreq == nil {
+		outreq = b.outreq
+	}
+	if v := outreq.Header.Get("X-Forwarded-For"); v != "10.0.0.1, 1.2.3.4" {
+		t.Errorf("X-Forwarded-For=%q, want %q", v, "10.0.0.1, 1.2.3.4")
+	}
+
+	a.err = os.NewError("refused")
+	b.err = os.NewError("refused")
+	a.requests, b.requests = 0, 0
+	status, _, _ := web.RunHandler("http://example.com/a", "POST", nil, nil, p)
+	if status != web.StatusBadGateway || a.requests+b.requests != 1 {
+		t.Errorf("POST status=%d attempts=%d, want 502 and 1 attempt", status, a.requests+b.requests)
+	}
+
+	a.requests, b.requests = 0, 0
+	status, _, _ = web.RunHandler("http://example.com/a", "GET", nil, nil, p)
+	if status != web.StatusServiceUnavailable || a.requests != 1 || b.requests != 1 {
+		t.Errorf("GET status=%d attempts=%d,%d, want 503 and 1 attempt on each upstream", status, a.requests, b.requests)
+	}
+}
+
+var rewriteLocationTests = []struct {
+	in, out string
+}{
+	{"/a?b=c", "/legacy/a?b=c"},
+	{"/app/a?b=c", "/legacy/a?b=c"},
+	{"/app", "/legacy/"},
+	{"/application", "/legacy/application"},
+	{"http://upstream:8080/app/a", "https://example.com/legacy/a"},
+	{"HTTP://UPSTREAM:8080/app/a#f", "https://example.com/legacy/a#f"},
+	{"http://user@upstream:8080/app/a?b=c", "https://example.com/legacy/a?b=c"},
+	{"http://other.com/app/a", "http://other.com/app/a"},
+	{"//cdn.com/a", "//cdn.com/a"},
+}
+
+var rewriteSetCookieTests = []struct {
+	in, out string
+}{
+	{"a=b", "a=b; path=/legacy/"},
+	{"a=b; Path=/x; Domain=upstream; HttpOnly", "a=b; path=/legacy/x; HttpOnly"},
+}
+
+func TestRewrite(t *testing.T) {
+	upstreamURL := &http.URL{Scheme: "http", Host: "upstream:8080", Path: "/app"}
+	reqURL := &http.URL{Scheme: "https", Host: "example.com"}
+	for _, tt := range rewriteLocationTests {
+		if out := rewriteLocation(tt.in, "/legacy", upstreamURL, reqURL); out != tt.out {
+			t.Errorf("rewriteLocation(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+	for _, tt := range rewriteSetCookieTests {
+		if out := rewriteSetCookie(tt.in, "/legacy"); out != tt.out {
+			t.Errorf("rewriteSetCookie(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	for _, tt := range []struct {
+		header web.Header
+		want   bool
+	}{
+		{web.NewHeader("Upgrade", "websocket", "Connection", "Upgrade"), true},
+		{web.NewHeader("Upgrade", "websocket", "Connection", "keep-alive, Upgrade"), true},
+		{web.NewHeader("Upgrade", "websocket"), false},
+		{web.NewHeader("Connection", "Upgrade"), false},
+	} {
+		req, _ := web.NewRequest("1.2.3.4:1000", "GET", &http.URL{Path: "/"}, web.ProtocolVersion11, tt.header)
+		if got := isUpgrade(req); got != tt.want {
+			t.Errorf("isUpgrade(%v) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+var canaryTests = []struct {
+	percent    int
+	header     web.Header
+	variant    string
+	setsCookie bool
+}{
+	{0, nil, Stable, true},
+	{100, nil, Canary, true},
+	{0, web.NewHeader("X-Canary", "canary"), Canary, false},
+	{100, web.NewHeader("X-Canary", "stable"), Stable, false},
+	{0, web.NewHeader(web.HeaderCookie, "twister_canary=canary"), Canary, false},
+	{100, web.NewHeader(web.HeaderCookie, "twister_canary=stable"), Stable, false},
+}
+
+func TestCanaryHandler(t *testing.T) {
+	variant := func(name string) web.Handler {
+		return web.HandlerFunc(func(req *web.Request) {
+			status := web.StatusOK
+			if name == Canary {
+				status = web.StatusInternalServerError
+			}
+			req.Respond(status, "X-Variant", name+" "+RequestVariant(req))
+		})
+	}
+	metrics := new(expvar.Map).Init()
+	for _, tt := range canaryTests {
+		h := CanaryHandler(&CanaryOptions{
+			Canary:  variant(Canary),
+			Percent: tt.percent,
+			Header:  "X-Canary",
+			Metrics: metrics,
+		}, variant(Stable))
+		_, header, _ := web.RunHandler("http://example.com/", "GET", tt.header, nil, h)
+		if v := header.Get("X-Variant"); v != tt.variant+" "+tt.variant {
+			t.Errorf("percent=%d header=%v variant=%q, want %q", tt.percent, tt.header, v, tt.variant)
+		}
+		cookie := header.Get(web.HeaderSetCookie)
+		if tt.setsCookie != (cookie != "") {
+			t.Errorf("percent=%d header=%v Set-Cookie=%q", tt.percent, tt.header, cookie)
+		}
+	}
+	for _, key := range []string{"stable.requests", "canary.requests", "canary.errors"} {
+		if v, _ := metrics.Get(key).(*expvar.Int); v == nil {
+			t.Errorf("metric %s not recorded", key)
+		}
+	}
+	if metrics.Get("stable.errors") != nil {
+		t.Errorf("metric stable.errors recorded")
+	}
+}
+
+func TestOutgoingRequest(t *testing.T) {
+	p := &ReverseProxy{}
+	u := &upstream{url: &http.URL{Scheme: "http", Host: "upstream"}}
+	url, _ := http.ParseURL("http://example.com/ws")
+	req, err := web.NewRequest("10.0.0.1:1234", "GET", url, web.ProtocolVersion(1, 1),
+		web.NewHeader("X-Forwarded-For", "1.2.3.4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outreq := p.outgoingRequest(req, u)
+	if s := outreq.Header.Get("X-Forwarded-For"); s != "1.2.3.4, 10.0.0.1" {
+		t.Errorf("X-Forwarded-For=%q, want %q", s, "1.2.3.4, 10.0.0.1")
+	}
+}
+
+// serveUpgradeUpstream accepts connections on l, sends the request header of
+// each connection to requests and switches protocols unless the request path
+// is /stuck.
+func serveUpgradeUpstream(l net.Listener, requests chan string) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			br := bufio.NewReader(c)
+			var b bytes.Buffer
+			for {
+				line, err := br.ReadString('\n')
+				if err != nil {
+					c.Close()
+					return
+				}
+				b.WriteString(line)
+				if line == "\r\n" {
+					break
+				}
+			}
+			requests <- b.String()
+			if strings.HasPrefix(b.String(), "GET /stuck ") {
+				return
+			}
+			io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		}(c)
+	}
+}
+
+func TestUpgradeHandshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	requests := make(chan string, 2)
+	go serveUpgradeUpstream(l, requests)
+
+	p := &ReverseProxy{options: ReverseOptions{Timeout: 2e8}}
+	outreq := &http.Request{
+		Method: "GET",
+		URL:    &http.URL{Scheme: "http", Host: l.Addr().String(), Path: "/ws"},
+		Header: http.Header{"X-Forwarded-For": {"1.2.3.4, 10.0.0.1"}},
+	}
+	resp, conn, _, err := p.handshake(outreq, "websocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if resp.StatusCode != web.StatusSwitchingProtocols {
+		t.Errorf("status=%d, want %d", resp.StatusCode, web.StatusSwitchingProtocols)
+	}
+	s := <-requests
+	for _, expect := range []string{"GET /ws HTTP/1.1\r\n", "X-Forwarded-For: 1.2.3.4, 10.0.0.1\r\n", "Upgrade: websocket\r\n"} {
+		if !strings.Contains(s, expect) {
+			t.Errorf("request %q does not contain %q", s, expect)
+		}
+	}
+
+	outreq.URL.Path = "/stuck"
+	if _, _, _, err := p.handshake(outreq, "websocket"); err != client.ErrTimeout {
+		t.Errorf("stuck upstream err=%v, want %v", err, client.ErrTimeout)
+	}
+}
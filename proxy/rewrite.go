@@ -0,0 +1,114 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package proxy
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// maxRewriteHTML is the maximum size of an HTML response body rewritten by
+// the reverse proxy. Larger bodies are passed through unchanged.
+const maxRewriteHTML = 4 << 20
+
+var htmlLinkRegexp = regexp.MustCompile(`(href|src|action)=["']/[^/]`)
+
+// rewritePrefix adds the proxy prefix to paths in the upstream response.
+func (p *ReverseProxy) rewritePrefix(req *web.Request, u *upstream, resp *http.Response) {
+	prefix := p.options.Prefix
+	for _, name := range []string{web.HeaderLocation, web.HeaderContentLocation} {
+		if s := resp.Header.Get(name); s != "" {
+			resp.Header.Set(name, rewriteLocation(s, prefix, u.url, req.URL))
+		}
+	}
+
+	if cookies := resp.Header[web.HeaderSetCookie]; len(cookies) > 0 {
+		rewritten := make([]string, len(cookies))
+		for i, c := range cookies {
+			rewritten[i] = rewriteSetCookie(c, prefix)
+		}
+		resp.Header[web.HeaderSetCookie] = rewritten
+	}
+
+	if p.options.RewriteHTML &&
+		strings.HasPrefix(resp.Header.Get(web.HeaderContentType), "text/html") &&
+		resp.Header.Get(web.HeaderContentEncoding) == "" &&
+		resp.ContentLength >= 0 && resp.ContentLength <= maxRewriteHTML {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			body = nil
+		}
+		body = htmlLinkRegexp.ReplaceAllFunc(body, func(m []byte) []byte {
+			i := bytes.IndexByte(m, '/')
+			return []byte(string(m[:i]) + prefix + string(m[i:]))
+		})
+		resp.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		resp.ContentLength = int64(len(body))
+	}
+}
+
+// rewriteLocation rewrites a Location header value from upstream to a
+// location on the proxy. The upstream base path is replaced with the prefix.
+func rewriteLocation(s string, prefix string, upstreamURL *http.URL, reqURL *http.URL) string {
+	u, err := http.ParseURLReference(s)
+	if err != nil {
+		return s
+	}
+	relative := u.Scheme == "" && u.Host == "" && strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//")
+	if !relative && (u.Host == "" || strings.ToLower(u.Host) != strings.ToLower(upstreamURL.Host)) {
+		return s
+	}
+	path := u.Path
+	if base := strings.TrimRight(upstreamURL.Path, "/"); base != "" && (path == base || strings.HasPrefix(path, base+"/")) {
+		path = path[len(base):]
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	location := (&http.URL{Path: prefix + path, RawQuery: u.RawQuery, Fragment: u.Fragment}).String()
+	if relative {
+		return location
+	}
+	return reqURL.Scheme + "://" + reqURL.Host + location
+}
+
+// rewriteSetCookie adds the prefix to the path attribute of a Set-Cookie
+// header value and removes the domain attribute.
+func rewriteSetCookie(s string, prefix string) string {
+	parts := strings.Split(s, ";")
+	result := []string{parts[0]}
+	hasPath := false
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		lower := strings.ToLower(attr)
+		switch {
+		case strings.HasPrefix(lower, "domain="):
+			continue
+		case strings.HasPrefix(lower, "path="):
+			hasPath = true
+			part = " path=" + prefix + attr[len("path="):]
+		}
+		result = append(result, part)
+	}
+	if !hasPath {
+		result = append(result, " path="+prefix+"/")
+	}
+	return strings.Join(result, ";")
+}
@@ -0,0 +1,171 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"github.com/garyburd/twister/client"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// isUpgrade returns true if the request asks to switch protocols, as is done
+// by the WebSocket handshake.
+func isUpgrade(req *web.Request) bool {
+	return req.Header.Get(web.HeaderUpgrade) != "" && req.Header.HasConnectionToken("upgrade")
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  os.Error
+}
+
+// dialUpstream connects to the host in url. If timeout is greater than zero,
+// dialUpstream returns client.ErrTimeout when the connection is not
+// established within timeout nanoseconds.
+func dialUpstream(url *http.URL, timeout int64) (net.Conn, os.Error) {
+	addr := url.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if url.Scheme == "https" {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+	ch := make(chan dialResult, 1)
+	go func() {
+		var r dialResult
+		if url.Scheme == "https" {
+			r.conn, r.err = tls.Dial("tcp", addr, nil)
+		} else {
+			r.conn, r.err = net.Dial("tcp", addr)
+		}
+		ch <- r
+	}()
+	if timeout <= 0 {
+		r := <-ch
+		return r.conn, r.err
+	}
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		// Close the connection if the dial completes later.
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+	}
+	return nil, client.ErrTimeout
+}
+
+// handshake sends the upgrade request outreq to the upstream and reads the
+// response. The Timeout option limits the time for the dial and the
+// handshake.
+func (p *ReverseProxy) handshake(outreq *http.Request, upgrade string) (resp *http.Response, conn net.Conn, br *bufio.Reader, err os.Error) {
+	timeout := p.options.Timeout
+	conn, err = dialUpstream(outreq.URL, timeout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if timeout > 0 {
+		conn.SetTimeout(timeout)
+	}
+
+	header := web.Header(outreq.Header)
+	header.Set(web.HeaderHost, outreq.URL.Host)
+	header.Set(web.HeaderConnection, "Upgrade")
+	header.Set(web.HeaderUpgrade, upgrade)
+
+	uri := outreq.URL.Path
+	if outreq.URL.RawQuery != "" {
+		uri += "?" + outreq.URL.RawQuery
+	}
+	var b bytes.Buffer
+	b.WriteString(outreq.Method + " " + uri + " HTTP/1.1\r\n")
+	header.WriteHttpHeader(&b)
+	if _, err = conn.Write(b.Bytes()); err == nil {
+		br = bufio.NewReader(conn)
+		resp, err = http.ReadResponse(br, outreq.Method)
+	}
+	if err != nil {
+		conn.Close()
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			err = client.ErrTimeout
+		}
+		return nil, nil, nil, err
+	}
+	conn.SetTimeout(0)
+	return resp, conn, br, nil
+}
+
+// upgrade forwards an upgrade request to upstream u. If the upstream
+// switches protocols, the client and upstream connections are spliced
+// together until either side closes. The return value is true if the request
+// should be retried on another upstream.
+func (p *ReverseProxy) upgrade(req *web.Request, u *upstream, outreq *http.Request) (retry bool) {
+	span := startSpan(req, u, outreq)
+	resp, upstreamConn, upstreamReader, err := p.handshake(outreq, req.Header.Get(web.HeaderUpgrade))
+	finishSpan(span, resp, err)
+	if err == client.ErrTimeout {
+		p.release(u, true)
+		req.Error(web.StatusGatewayTimeout, err)
+		return false
+	}
+	if err != nil {
+		p.release(u, true)
+		return true
+	}
+	defer upstreamConn.Close()
+	p.release(u, false)
+
+	if resp.StatusCode != web.StatusSwitchingProtocols {
+		copyResponse(req, resp)
+		resp.Body.Close()
+		return false
+	}
+
+	conn, clientReader, err := req.Responder.Hijack()
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return false
+	}
+	defer conn.Close()
+
+	var b bytes.Buffer
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	web.Header(resp.Header).WriteHttpHeader(&b)
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return false
+	}
+
+	done := make(chan bool)
+	go func() {
+		io.Copy(upstreamConn, clientReader)
+		upstreamConn.Close()
+		done <- true
+	}()
+	io.Copy(conn, upstreamReader)
+	conn.Close()
+	<-done
+	return false
+}
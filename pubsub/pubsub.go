@@ -0,0 +1,325 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package pubsub implements an in-memory message bus with handlers for long
+// polling and server-sent events.
+//
+// Messages published to a topic are assigned increasing ids. Clients track
+// the id of the last message they received and pass the id back to the server
+// to resume from that point:
+//
+//  bus := pubsub.NewBus(100)
+//  r.Register("/poll/<topic>", "GET", pubsub.LongPollHandler(bus, 30e9))
+//  r.Register("/events/<topic>", "GET", pubsub.EventStreamHandler(bus, 15e9))
+//
+//  // Elsewhere in the application.
+//  bus.Publish("news", "hello")
+package pubsub
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"io"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a message published to a topic.
+type Message struct {
+	ID    int64
+	Topic string
+	Data  string
+}
+
+// Bus is a collection of topics.
+type Bus struct {
+	// Topics without waiting subscribers are removed IdleTimeout seconds
+	// after the last message is published. NewBus sets IdleTimeout to one
+	// hour. Change the value before using the bus.
+	IdleTimeout int64
+
+	mu        sync.Mutex
+	history   int
+	topics    map[string]*topic
+	nextSweep int64
+}
+
+type topic struct {
+	lastID   int64
+	updated  int64
+	messages []*Message
+	waiters  map[chan bool]bool
+}
+
+// NewBus returns a new bus. The bus retains up to history messages per topic
+// for replay to clients that resume with an older message id.
+func NewBus(history int) *Bus {
+	if history < 1 {
+		history = 1
+	}
+	return &Bus{history: history, topics: make(map[string]*topic), IdleTimeout: 3600}
+}
+
+// topic returns the named topic, creating the topic if it does not exist.
+func (b *Bus) topic(name string) *topic {
+	t := b.topics[name]
+	if t == nil {
+		t = &topic{waiters: make(map[chan bool]bool), updated: web.Seconds()}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// sweep removes idle topics. The caller must hold b.mu.
+func (b *Bus) sweep(now int64) {
+	if now < b.nextSweep {
+		return
+	}
+	for name, t := range b.topics {
+		if len(t.waiters) == 0 && now >= t.updated+b.IdleTimeout {
+			b.topics[name] = nil, false
+		}
+	}
+	b.nextSweep = now + 60
+}
+
+// Publish adds a message to the topic and wakes waiting subscribers. The id
+// of the new message is returned.
+func (b *Bus) Publish(topicName string, data string) int64 {
+	now := web.Seconds()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(now)
+	t := b.topic(topicName)
+	t.updated = now
+	t.lastID += 1
+	t.messages = append(t.messages, &Message{ID: t.lastID, Topic: topicName, Data: data})
+	if n := len(t.messages) - b.history; n > 0 {
+		copy(t.messages, t.messages[n:])
+		t.messages = t.messages[:b.history]
+	}
+	for c := range t.waiters {
+		select {
+		case c <- true:
+		default:
+		}
+	}
+	return t.lastID
+}
+
+// LastID returns the id of the last message published to the topic.
+func (b *Bus) LastID(topicName string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t := b.topics[topicName]; t != nil {
+		return t.lastID
+	}
+	return 0
+}
+
+// since returns the retained messages with ids greater than lastID.
+func (t *topic) since(lastID int64) []*Message {
+	i := len(t.messages)
+	for i > 0 && t.messages[i-1].ID > lastID {
+		i -= 1
+	}
+	if i == len(t.messages) {
+		return nil
+	}
+	result := make([]*Message, len(t.messages)-i)
+	copy(result, t.messages[i:])
+	return result
+}
+
+// Wait returns the messages in the topic with ids greater than lastID. If
+// there are no such messages, then Wait blocks until a message is published
+// or timeout nanoseconds elapse. A nil slice is returned on timeout.
+func (b *Bus) Wait(topicName string, lastID int64, timeout int64) []*Message {
+	b.mu.Lock()
+	t := b.topics[topicName]
+	if t != nil {
+		if lastID > t.lastID {
+			// The client is from a previous incarnation of the bus or
+			// the topic.
+			lastID = 0
+		}
+		if m := t.since(lastID); m != nil {
+			b.mu.Unlock()
+			return m
+		}
+	} else {
+		lastID = 0
+	}
+	// The topic is created to register the waiter and removed below if no
+	// message is published.
+	t = b.topic(topicName)
+	c := make(chan bool, 1)
+	t.waiters[c] = true
+	b.mu.Unlock()
+
+	select {
+	case <-c:
+	case <-time.After(timeout):
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t.waiters[c] = false, false
+	if len(t.waiters) == 0 && len(t.messages) == 0 && b.topics[topicName] == t {
+		b.topics[topicName] = nil, false
+	}
+	return t.since(lastID)
+}
+
+// Subscription tracks the position of a subscriber in a topic.
+type Subscription struct {
+	bus    *Bus
+	topic  string
+	lastID int64
+}
+
+// Subscribe returns a subscription to the topic starting after the message
+// with id lastID. Use lastID 0 to receive all retained messages and
+// b.LastID(topic) to receive new messages only.
+func (b *Bus) Subscribe(topicName string, lastID int64) *Subscription {
+	return &Subscription{bus: b, topic: topicName, lastID: lastID}
+}
+
+// Next returns the messages published since the previous call to Next. Next
+// blocks for up to timeout nanoseconds waiting for a message.
+func (s *Subscription) Next(timeout int64) []*Message {
+	m := s.bus.Wait(s.topic, s.lastID, timeout)
+	if len(m) > 0 {
+		s.lastID = m[len(m)-1].ID
+	}
+	return m
+}
+
+// LastID returns the id of the last message returned from Next.
+func (s *Subscription) LastID() int64 {
+	return s.lastID
+}
+
+const headerLastEventID = "Last-Event-Id"
+
+// requestTopicAndLastID returns the topic from the "topic" URL parameter and
+// the last id from the Last-Event-ID header or "lastId" request parameter.
+func requestTopicAndLastID(req *web.Request) (string, int64) {
+	s := req.Header.Get(headerLastEventID)
+	if s == "" {
+		s = req.Param.Get("lastId")
+	}
+	lastID, _ := strconv.Atoi64(s)
+	return req.URLParam["topic"], lastID
+}
+
+// LongPollHandler returns a handler that responds with a JSON array of
+// messages published to a topic. If no messages are available, the handler
+// parks the request until a message arrives or timeout nanoseconds elapse.
+//
+// The topic is specified by the "topic" URL parameter. The last message id
+// seen by the client is specified by the "lastId" request parameter or the
+// Last-Event-ID header.
+//
+// Each element in the returned array is an object with the fields "id" and
+// "data".
+func LongPollHandler(bus *Bus, timeout int64) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		topic, lastID := requestTopicAndLastID(req)
+		if topic == "" {
+			req.Error(web.StatusNotFound, nil)
+			return
+		}
+		messages := bus.Wait(topic, lastID, timeout)
+		result := make([]map[string]interface{}, len(messages))
+		for i, m := range messages {
+			result[i] = map[string]interface{}{"id": m.ID, "data": m.Data}
+		}
+		p, err := json.Marshal(result)
+		if err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		w := req.Respond(web.StatusOK,
+			web.HeaderContentType, "application/json; charset=utf-8",
+			web.HeaderContentLength, strconv.Itoa(len(p)),
+			web.HeaderCacheControl, "no-cache")
+		w.Write(p)
+	})
+}
+
+// EventStreamHandler returns a handler that streams messages published to a
+// topic using the server-sent events (text/event-stream) format. A comment
+// line is sent every heartbeat nanoseconds while the topic is idle. The
+// handler returns when a write to the client fails.
+//
+// The topic and last message id are specified as described for
+// LongPollHandler. Browsers send the Last-Event-ID header automatically on
+// reconnect.
+func EventStreamHandler(bus *Bus, heartbeat int64) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		topic, lastID := requestTopicAndLastID(req)
+		if topic == "" {
+			req.Error(web.StatusNotFound, nil)
+			return
+		}
+		w := req.Respond(web.StatusOK,
+			web.HeaderContentType, "text/event-stream; charset=utf-8",
+			web.HeaderCacheControl, "no-cache")
+		s := bus.Subscribe(topic, lastID)
+		for {
+			if err := flush(w); err != nil {
+				return
+			}
+			messages := s.Next(heartbeat)
+			if len(messages) == 0 {
+				if _, err := io.WriteString(w, ":\n\n"); err != nil {
+					return
+				}
+				continue
+			}
+			var b bytes.Buffer
+			for _, m := range messages {
+				writeEvent(&b, m)
+			}
+			if _, err := w.Write(b.Bytes()); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func flush(w io.Writer) os.Error {
+	if f, ok := w.(web.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// writeEvent writes a message in the text/event-stream format.
+func writeEvent(b *bytes.Buffer, m *Message) {
+	b.WriteString("id: ")
+	b.WriteString(strconv.Itoa64(m.ID))
+	b.WriteByte('\n')
+	for _, line := range strings.Split(m.Data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+}
@@ -0,0 +1,82 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package pubsub
+
+import (
+	"github.com/garyburd/twister/web"
+	"testing"
+)
+
+func TestWait(t *testing.T) {
+	bus := NewBus(2)
+	if m := bus.Wait("t", 0, 1e6); m != nil {
+		t.Errorf("empty topic returned %v", m)
+	}
+	bus.Publish("t", "a")
+	bus.Publish("t", "b")
+	bus.Publish("t", "c")
+
+	m := bus.Wait("t", 0, 1e6)
+	if len(m) != 2 || m[0].Data != "b" || m[1].Data != "c" {
+		t.Errorf("history not trimmed, got %v", m)
+	}
+	m = bus.Wait("t", 2, 1e6)
+	if len(m) != 1 || m[0].ID != 3 {
+		t.Errorf("Wait after 2 returned %v", m)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	bus := NewBus(10)
+	s := bus.Subscribe("t", bus.LastID("t"))
+	go func() {
+		bus.Publish("t", "hello")
+	}()
+	m := s.Next(5e9)
+	if len(m) != 1 || m[0].Data != "hello" {
+		t.Fatalf("Next returned %v", m)
+	}
+	if s.LastID() != 1 {
+		t.Errorf("LastID() = %d, want 1", s.LastID())
+	}
+	if m := s.Next(1e6); m != nil {
+		t.Errorf("Next returned %v, want nil", m)
+	}
+}
+
+func TestTopicExpiry(t *testing.T) {
+	clock := web.NewFakeClock(1000e9)
+	defer web.SetClock(web.SetClock(clock))
+
+	bus := NewBus(10)
+	if id := bus.LastID("a"); id != 0 || len(bus.topics) != 0 {
+		t.Errorf("LastID(a) = %d, topics = %d, want 0, 0", id, len(bus.topics))
+	}
+	if m := bus.Wait("a", 5, 1e6); m != nil || len(bus.topics) != 0 {
+		t.Errorf("Wait(a) = %v, topics = %d, want nil, 0", m, len(bus.topics))
+	}
+
+	bus.Publish("a", "hello")
+	clock.Advance(3000e9)
+	bus.Publish("b", "hello")
+	if len(bus.topics) != 2 {
+		t.Errorf("topics = %d, want 2", len(bus.topics))
+	}
+	clock.Advance(600e9)
+	bus.Publish("b", "hello")
+	if _, found := bus.topics["a"]; found || len(bus.topics) != 1 {
+		t.Errorf("idle topic not removed, topics = %d", len(bus.topics))
+	}
+}
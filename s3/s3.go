@@ -0,0 +1,351 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package s3 implements a minimal client for Amazon S3 and compatible object
+// stores and a handler that serves objects from a bucket.
+//
+// Requests are signed with the S3 REST authentication scheme (signature
+// version 2) and use path style URLs, so the client works with S3 and with
+// compatible servers that do not support virtual host style buckets.
+//
+//  c := &s3.Client{Endpoint: "https://s3.amazonaws.com", AccessKey: id, SecretKey: secret}
+//  r.Register("/uploads/<path:.*>", "GET", c.Handler("uploads"))
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is an S3 client.
+type Client struct {
+	// Endpoint URL, for example "https://s3.amazonaws.com".
+	Endpoint string
+
+	// Access key ID and secret access key.
+	AccessKey string
+	SecretKey string
+
+	// Transport used for requests. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	Code       string // S3 error code such as "NoSuchKey"
+	Message    string
+}
+
+func (e *Error) String() string {
+	return "twister.s3: " + strconv.Itoa(e.StatusCode) + " " + e.Code + " " + e.Message
+}
+
+// objectURL returns the URL for key in bucket. The query is appended to the
+// URL if not empty.
+func (c *Client) objectURL(bucket, key, query string) string {
+	u := strings.TrimRight(c.Endpoint, "/") + "/" + bucket + "/" + escapeKey(key)
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// escapeKey escapes an object key for use in a URL path.
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = http.URLEscape(p)
+	}
+	return strings.Replace(strings.Join(parts, "/"), "+", "%20", -1)
+}
+
+// subresources are the query parameters included in the signature.
+var subresources = map[string]bool{"partNumber": true, "uploadId": true, "uploads": true}
+
+// sign adds the Date, if not already set, and Authorization headers to req.
+func (c *Client) sign(req *http.Request, bucket, key string) {
+	if req.Header.Get(web.HeaderDate) == "" {
+		req.Header.Set(web.HeaderDate, time.SecondsToUTC(web.Seconds()).Format(web.TimeLayout))
+	}
+
+	var amz []string
+	for k, v := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			amz = append(amz, k+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(amz)
+
+	resource := "/" + bucket + "/" + escapeKey(key)
+	if q := req.URL.RawQuery; q != "" {
+		var sub []string
+		for _, p := range strings.Split(q, "&") {
+			name := p
+			if i := strings.Index(p, "="); i >= 0 {
+				name = p[:i]
+			}
+			if subresources[name] {
+				sub = append(sub, p)
+			}
+		}
+		if len(sub) > 0 {
+			sort.Strings(sub)
+			resource += "?" + strings.Join(sub, "&")
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.Header.Get("Content-Md5") + "\n")
+	b.WriteString(req.Header.Get(web.HeaderContentType) + "\n")
+	b.WriteString(req.Header.Get(web.HeaderDate) + "\n")
+	for _, s := range amz {
+		b.WriteString(s + "\n")
+	}
+	b.WriteString(resource)
+
+	h := hmac.NewSHA1([]byte(c.SecretKey))
+	h.Write(b.Bytes())
+	req.Header.Set(web.HeaderAuthorization, "AWS "+c.AccessKey+":"+base64.StdEncoding.EncodeToString(h.Sum()))
+}
+
+// do sends a signed request and returns the response. An *Error is returned
+// if the response status is not 2xx or 3xx.
+func (c *Client) do(method, bucket, key, query string, header http.Header, body io.Reader, length int64) (*http.Response, os.Error) {
+	req, err := http.NewRequest(method, c.objectURL(bucket, key, query), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	c.sign(req, bucket, key)
+	client := &http.Client{Transport: c.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		p, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		return nil, &Error{StatusCode: resp.StatusCode, Code: elementText(p, "Code"), Message: elementText(p, "Message")}
+	}
+	return resp, nil
+}
+
+// elementText returns the text of the first element with the given name in
+// the XML document p.
+func elementText(p []byte, name string) string {
+	s := string(p)
+	i := strings.Index(s, "<"+name+">")
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(name)+2:]
+	j := strings.Index(s, "</"+name+">")
+	if j < 0 {
+		return ""
+	}
+	return strings.TrimSpace(s[:j])
+}
+
+// Get returns the response for a GET request for the object. The caller
+// must close the response body. The header argument specifies additional
+// request headers such as Range and If-None-Match. A response with status
+// 206 or 304 is returned without error.
+func (c *Client) Get(bucket, key string, header http.Header) (*http.Response, os.Error) {
+	return c.do("GET", bucket, key, "", header, nil, 0)
+}
+
+// Put stores the object read from body. The length of the body must be
+// known.
+func (c *Client) Put(bucket, key, contentType string, body io.Reader, length int64) os.Error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set(web.HeaderContentType, contentType)
+	}
+	resp, err := c.do("PUT", bucket, key, "", header, body, length)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Delete deletes the object.
+func (c *Client) Delete(bucket, key string) os.Error {
+	resp, err := c.do("DELETE", bucket, key, "", nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// InitiateMultipart starts a multipart upload of the object and returns the
+// upload ID. Use multipart uploads for large objects and for uploads that
+// arrive in pieces, such as resumable uploads from clients.
+func (c *Client) InitiateMultipart(bucket, key, contentType string) (string, os.Error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set(web.HeaderContentType, contentType)
+	}
+	resp, err := c.do("POST", bucket, key, "uploads", header, bytes.NewBuffer(nil), 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	id := elementText(p, "UploadId")
+	if id == "" {
+		return "", os.NewError("twister.s3: upload ID missing from response")
+	}
+	return id, nil
+}
+
+// UploadPart uploads part number n, starting from 1, of a multipart upload
+// and returns the ETag of the part. All parts except the last must be at
+// least 5 MB.
+func (c *Client) UploadPart(bucket, key, uploadID string, n int, body io.Reader, length int64) (string, os.Error) {
+	query := "partNumber=" + strconv.Itoa(n) + "&uploadId=" + http.URLEscape(uploadID)
+	resp, err := c.do("PUT", bucket, key, query, nil, body, length)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get(web.HeaderEtag), nil
+}
+
+// CompleteMultipart completes a multipart upload. The etags argument is the
+// list of part ETags in part number order.
+func (c *Client) CompleteMultipart(bucket, key, uploadID string, etags []string) os.Error {
+	var b bytes.Buffer
+	b.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range etags {
+		b.WriteString("<Part><PartNumber>" + strconv.Itoa(i+1) + "</PartNumber><ETag>" +
+			web.HTMLEscapeString(etag) + "</ETag></Part>")
+	}
+	b.WriteString("</CompleteMultipartUpload>")
+	resp, err := c.do("POST", bucket, key, "uploadId="+http.URLEscape(uploadID), nil, &b, int64(b.Len()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The server can report an error in a 200 response.
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if code := elementText(p, "Code"); code != "" {
+		return &Error{StatusCode: resp.StatusCode, Code: code, Message: elementText(p, "Message")}
+	}
+	return nil
+}
+
+// AbortMultipart aborts a multipart upload and deletes the uploaded parts.
+func (c *Client) AbortMultipart(bucket, key, uploadID string) os.Error {
+	resp, err := c.do("DELETE", bucket, key, "uploadId="+http.URLEscape(uploadID), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// forwardedRequestHeaders are the request headers passed to the server by
+// Handler.
+var forwardedRequestHeaders = []string{
+	web.HeaderRange,
+	web.HeaderIfMatch,
+	web.HeaderIfNoneMatch,
+	web.HeaderIfModifiedSince,
+	web.HeaderIfUnmodifiedSince,
+}
+
+// forwardedResponseHeaders are the response headers copied to the client by
+// Handler.
+var forwardedResponseHeaders = []string{
+	web.HeaderAcceptRanges,
+	web.HeaderCacheControl,
+	web.HeaderContentDisposition,
+	web.HeaderContentEncoding,
+	web.HeaderContentLength,
+	web.HeaderContentRange,
+	web.HeaderContentType,
+	web.HeaderEtag,
+	web.HeaderLastModified,
+}
+
+// Handler returns a handler that serves objects from bucket using the URL
+// parameter "path" as the object key. Range and conditional request headers
+// are forwarded to the server and the response body is streamed to the
+// client without buffering.
+//
+//  r.Register("/uploads/<path:.*>", "GET", c.Handler("uploads"))
+func (c *Client) Handler(bucket string) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		key := req.URLParam["path"]
+		if key == "" {
+			panic("twister.s3: Handler expects path URLParam")
+		}
+		header := http.Header{}
+		for _, name := range forwardedRequestHeaders {
+			if v := req.Header.Get(name); v != "" {
+				header.Set(name, v)
+			}
+		}
+		method := "GET"
+		if req.Method == "HEAD" {
+			method = "HEAD"
+		}
+		resp, err := c.do(method, bucket, key, "", header, nil, 0)
+		if err != nil {
+			status := web.StatusBadGateway
+			if e, ok := err.(*Error); ok && (e.StatusCode == web.StatusNotFound ||
+				e.StatusCode == web.StatusForbidden ||
+				e.StatusCode == web.StatusPreconditionFailed ||
+				e.StatusCode == web.StatusRequestedRangeNotSatisfiable) {
+				status = e.StatusCode
+			}
+			req.Error(status, err)
+			return
+		}
+		defer resp.Body.Close()
+		respHeader := web.Header{}
+		for _, name := range forwardedResponseHeaders {
+			if v := resp.Header.Get(name); v != "" {
+				respHeader.Set(name, v)
+			}
+		}
+		w := req.Responder.Respond(resp.StatusCode, respHeader)
+		io.Copy(w, resp.Body)
+	})
+}
@@ -0,0 +1,415 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package sanitize removes unsafe markup from HTML.
+//
+// The sanitizer is intended for rich text submitted by users. Elements and
+// attributes not in the policy allowlist are removed, URLs with schemes such
+// as javascript: are removed, comments are removed and the remaining
+// elements are balanced. Style attributes, style elements and event handler
+// attributes are always removed.
+package sanitize
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"regexp"
+	"strconv"
+	"strings"
+	"utf8"
+)
+
+// Policy specifies the markup allowed by the sanitizer.
+type Policy struct {
+	// Elements maps the allowed elements to a space separated list of the
+	// allowed attributes for the element.
+	Elements map[string]string
+
+	// DropElements is the set of elements that are removed along with
+	// their content. The content of other disallowed elements is kept.
+	DropElements map[string]bool
+
+	// Schemes is the set of URL schemes allowed in href, src and cite
+	// attributes. Relative URLs are always allowed.
+	Schemes map[string]bool
+
+	// Add rel="nofollow" to links.
+	NoFollow bool
+}
+
+// DefaultPolicy allows basic text formatting, links, images, lists and
+// tables. Links are marked nofollow.
+var DefaultPolicy = &Policy{
+	Elements: map[string]string{
+		"a":          "href title",
+		"b":          "",
+		"blockquote": "cite",
+		"br":         "",
+		"code":       "",
+		"del":        "",
+		"em":         "",
+		"h1":         "",
+		"h2":         "",
+		"h3":         "",
+		"h4":         "",
+		"h5":         "",
+		"h6":         "",
+		"hr":         "",
+		"i":          "",
+		"img":        "src alt title width height",
+		"li":         "",
+		"ol":         "",
+		"p":          "",
+		"pre":        "",
+		"strong":     "",
+		"sub":        "",
+		"sup":        "",
+		"table":      "",
+		"tbody":      "",
+		"td":         "colspan rowspan",
+		"th":         "colspan rowspan",
+		"thead":      "",
+		"tr":         "",
+		"ul":         "",
+	},
+	DropElements: map[string]bool{
+		"script":   true,
+		"iframe":   true,
+		"object":   true,
+		"embed":    true,
+		"noscript": true,
+		"textarea": true,
+		"title":    true,
+	},
+	Schemes:  map[string]bool{"http": true, "https": true, "ftp": true, "mailto": true},
+	NoFollow: true,
+}
+
+// voidElements is the set of elements without an end tag.
+var voidElements = map[string]bool{"area": true, "br": true, "col": true, "hr": true, "img": true, "wbr": true}
+
+type attribute struct {
+	name, value string
+}
+
+// HTML sanitizes s using the default policy.
+func HTML(s string) string {
+	return DefaultPolicy.Sanitize(s)
+}
+
+// Param returns the named request parameter sanitized with the default
+// policy. Use Param in handlers that accept rich text input.
+func Param(req *web.Request, name string) string {
+	return DefaultPolicy.Sanitize(req.Param.Get(name))
+}
+
+// Sanitize returns s with the markup not allowed by the policy removed. Text
+// is escaped as needed and the allowed elements are balanced.
+func (p *Policy) Sanitize(s string) string {
+	var b bytes.Buffer
+	var stack []string
+	for len(s) > 0 {
+		i := strings.Index(s, "<")
+		if i < 0 {
+			i = len(s)
+		}
+		writeText(&b, s[:i])
+		s = s[i:]
+		if len(s) == 0 {
+			break
+		}
+		if strings.HasPrefix(s, "<!--") {
+			if i := strings.Index(s[4:], "-->"); i >= 0 {
+				s = s[4+i+3:]
+			} else {
+				s = ""
+			}
+			continue
+		}
+		name, attrs, closing, n := parseTag(s)
+		if n == 0 {
+			b.WriteString("&lt;")
+			s = s[1:]
+			continue
+		}
+		s = s[n:]
+		if name == "style" || p.DropElements[name] {
+			if !closing {
+				// Skip to the end tag.
+				i := strings.Index(strings.ToLower(s), "</"+name)
+				if i < 0 {
+					s = ""
+				} else if j := strings.Index(s[i:], ">"); j >= 0 {
+					s = s[i+j+1:]
+				} else {
+					s = ""
+				}
+			}
+			continue
+		}
+		allowedAttrs, ok := p.Elements[name]
+		if !ok {
+			continue
+		}
+		if closing {
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == name {
+					for j := len(stack) - 1; j >= i; j-- {
+						b.WriteString("</" + stack[j] + ">")
+					}
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+		b.WriteString("<" + name)
+		for _, a := range attrs {
+			if a.name == "style" || strings.HasPrefix(a.name, "on") || !hasWord(allowedAttrs, a.name) {
+				continue
+			}
+			if urlAttributes[a.name] && !p.safeURL(a.value) {
+				continue
+			}
+			b.WriteString(" " + a.name + `="` + EscapeHTML(a.value) + `"`)
+		}
+		if name == "a" && p.NoFollow {
+			b.WriteString(` rel="nofollow"`)
+		}
+		b.WriteString(">")
+		if !voidElements[name] {
+			stack = append(stack, name)
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		b.WriteString("</" + stack[i] + ">")
+	}
+	return b.String()
+}
+
+func hasWord(list, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// writeText writes text to b, escaping '<', '>' and '&' characters that do
+// not start an entity.
+func writeText(b *bytes.Buffer, text string) {
+	for len(text) > 0 {
+		i := strings.IndexAny(text, "<>&")
+		if i < 0 {
+			b.WriteString(text)
+			return
+		}
+		b.WriteString(text[:i])
+		text = text[i:]
+		switch text[0] {
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '&':
+			if m := entityRegexp.FindString(text); m != "" {
+				b.WriteString(m)
+				text = text[len(m):]
+				continue
+			}
+			b.WriteString("&amp;")
+		}
+		text = text[1:]
+	}
+}
+
+// EscapeHTML escapes the characters '&', '<', '>' and '"' in s.
+func EscapeHTML(s string) string {
+	s = strings.Replace(s, "&", "&amp;", -1)
+	s = strings.Replace(s, "<", "&lt;", -1)
+	s = strings.Replace(s, ">", "&gt;", -1)
+	return strings.Replace(s, `"`, "&quot;", -1)
+}
+
+// parseTag parses the start or end tag at the beginning of s. The tag name
+// is converted to lower case. The returned length n is zero if s does not
+// start with a tag.
+func parseTag(s string) (name string, attrs []attribute, closing bool, n int) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && isAlnum(s[i]) {
+		i++
+	}
+	if i == start || !isAlpha(s[start]) {
+		return "", nil, false, 0
+	}
+	name = strings.ToLower(s[start:i])
+	for {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			return "", nil, false, 0
+		}
+		switch {
+		case s[i] == '>':
+			return name, attrs, closing, i + 1
+		case strings.HasPrefix(s[i:], "/>"):
+			return name, attrs, closing, i + 2
+		}
+		start := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		a := attribute{name: strings.ToLower(s[start:i])}
+		if i == start {
+			// Skip stray slash.
+			i++
+		}
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i >= len(s) {
+				return "", nil, false, 0
+			}
+			if q := s[i]; q == '"' || q == '\'' {
+				j := strings.IndexRune(s[i+1:], int(q))
+				if j < 0 {
+					return "", nil, false, 0
+				}
+				a.value = s[i+1 : i+1+j]
+				i += j + 2
+			} else {
+				start := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				a.value = s[start:i]
+			}
+			a.value = unescapeEntities(a.value)
+		}
+		if a.name != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	panic("unreachable")
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isAlnum(c byte) bool {
+	return isAlpha(c) || '0' <= c && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+var namedEntities = map[string]int{
+	"amp":   '&',
+	"lt":    '<',
+	"gt":    '>',
+	"quot":  '"',
+	"apos":  '\'',
+	"colon": ':',
+	"tab":   '\t',
+	"nbsp":  0xa0,
+}
+
+// unescapeEntities replaces character references in s with the referenced
+// characters. Unknown references are left unchanged.
+func unescapeEntities(s string) string {
+	if strings.Index(s, "&") < 0 {
+		return s
+	}
+	var b bytes.Buffer
+	for len(s) > 0 {
+		i := strings.Index(s, "&")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		m := entityRegexp.FindStringSubmatch(s)
+		if m == nil {
+			// Numeric references without the trailing ';' are common in
+			// attacks.
+			m = numericRegexp.FindStringSubmatch(s)
+		}
+		c := -1
+		if m != nil {
+			ref := m[1]
+			switch {
+			case strings.HasPrefix(ref, "#x") || strings.HasPrefix(ref, "#X"):
+				if n, err := strconv.Btoui64(ref[2:], 16); err == nil && n <= utf8.MaxRune {
+					c = int(n)
+				}
+			case strings.HasPrefix(ref, "#"):
+				if n, err := strconv.Atoui64(ref[1:]); err == nil && n <= utf8.MaxRune {
+					c = int(n)
+				}
+			default:
+				if r, ok := namedEntities[ref]; ok {
+					c = r
+				}
+			}
+		}
+		if c < 0 {
+			b.WriteByte('&')
+			s = s[1:]
+			continue
+		}
+		b.WriteRune(c)
+		s = s[len(m[0]):]
+	}
+	return b.String()
+}
+
+var (
+	entityRegexp  = regexp.MustCompile(`^&([a-zA-Z][a-zA-Z0-9]*|#[0-9]+|#[xX][0-9a-fA-F]+);`)
+	numericRegexp = regexp.MustCompile(`^&(#[0-9]+|#[xX][0-9a-fA-F]+)`)
+)
+
+// urlAttributes is the set of attributes with URL values.
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true}
+
+// safeURL returns true if the URL is relative or uses an allowed scheme.
+func (p *Policy) safeURL(u string) bool {
+	// Browsers ignore control characters and white space in the scheme.
+	var b bytes.Buffer
+	for i := 0; i < len(u); i++ {
+		if u[i] > ' ' && u[i] != 0x7f {
+			b.WriteByte(u[i])
+		}
+	}
+	u = b.String()
+	i := strings.IndexAny(u, ":/?#")
+	if i < 0 || u[i] != ':' {
+		return true
+	}
+	return p.Schemes[strings.ToLower(u[:i])]
+}
@@ -0,0 +1,57 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package sanitize
+
+import (
+	"testing"
+)
+
+var htmlTests = []struct {
+	html, expect string
+}{
+	{"<b>bold <i>it</b> x", "<b>bold <i>it</i></b> x"},
+	{"<img src=x onerror=alert(1)>", `<img src="x">`},
+	{`<a href="JaVaScRiPt:x">z</a>`, `<a rel="nofollow">z</a>`},
+	{`<a href="java&#x09;script:x">z</a>`, `<a rel="nofollow">z</a>`},
+	{`<a href="&#106;avascript:x">z</a>`, `<a rel="nofollow">z</a>`},
+	{`<a href='/p?a=1&amp;b="2"'>z</a>`, `<a href="/p?a=1&amp;b=&quot;2&quot;" rel="nofollow">z</a>`},
+	{`<p style="color: red" class="x">a</p>`, "<p>a</p>"},
+	{"<style>b {}</style><div>a</div><!-- c -->", "a"},
+	{"<SCRIPT>alert(1)</script >b", "b"},
+	{"</p>x<p", "x&lt;p"},
+	{"<ul><li>a", "<ul><li>a</li></ul>"},
+	{"a < b && c &amp; d", "a &lt; b &amp;&amp; c &amp; d"},
+}
+
+func TestHTML(t *testing.T) {
+	for _, tt := range htmlTests {
+		html := HTML(tt.html)
+		if html != tt.expect {
+			t.Errorf("HTML(%q) = %q, want %q", tt.html, html, tt.expect)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := &Policy{
+		Elements: map[string]string{"span": "class style onclick", "a": "href"},
+		Schemes:  map[string]bool{"https": true},
+	}
+	html := p.Sanitize(`<span class="x" style="y" onclick="z"><a href="http://a/">a</a><a href="https://b/">b</a></span>`)
+	expect := `<span class="x"><a>a</a><a href="https://b/">b</a></span>`
+	if html != expect {
+		t.Errorf("Sanitize() = %q, want %q", html, expect)
+	}
+}
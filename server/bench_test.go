@@ -0,0 +1,112 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+const benchRequest = "GET /hello/world?a=b HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/534.30\r\n" +
+	"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+	"Accept-Language: en-US,en;q=0.8\r\n" +
+	"Accept-Encoding: gzip,deflate,sdch\r\n" +
+	"Accept-Charset: ISO-8859-1,utf-8;q=0.7,*;q=0.3\r\n" +
+	"Cookie: a=b; c=d\r\n" +
+	"Connection: keep-alive\r\n" +
+	"\r\n"
+
+func BenchmarkReadRequest(b *testing.B) {
+	p := []byte(benchRequest)
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		br := bufio.NewReader(bytes.NewBuffer(p))
+		if _, _, _, err := readRequestLine(br); err != nil {
+			b.Fatal(err)
+		}
+		header := web.Header{}
+		if err := header.ParseHttpHeader(br); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchHandler(req *web.Request) {
+	w := req.Respond(web.StatusOK, web.HeaderContentType, "text/plain", web.HeaderContentLength, "13")
+	io.WriteString(w, "Hello, World!")
+}
+
+// benchmarkLoopback runs b.N requests through a server over the loopback
+// interface.
+func benchmarkLoopback(b *testing.B, keepAlive bool) {
+	b.StopTimer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	go (&Server{Listener: listener, Handler: web.HandlerFunc(benchHandler)}).Serve()
+	addr := listener.Addr().String()
+
+	request := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	if !keepAlive {
+		request = "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"
+	}
+
+	var conn net.Conn
+	var br *bufio.Reader
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if conn == nil {
+			conn, err = net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			br = bufio.NewReader(conn)
+		}
+		if _, err := io.WriteString(conn, request); err != nil {
+			b.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, "GET")
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if !keepAlive {
+			conn.Close()
+			conn = nil
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func BenchmarkLoopbackKeepAlive(b *testing.B) {
+	benchmarkLoopback(b, true)
+}
+
+func BenchmarkLoopbackNoKeepAlive(b *testing.B) {
+	benchmarkLoopback(b, false)
+}
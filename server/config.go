@@ -0,0 +1,104 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"github.com/garyburd/twister/config"
+	"github.com/garyburd/twister/web"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// New creates a server for handler from the configuration. New listens on the
+// configured address and opens the request log. Call the server's Serve
+// method to handle requests:
+//
+//  c, err := config.Load("/etc/example.conf", "EXAMPLE_")
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  s, err := server.New(c, h)
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  log.Fatal(s.Serve())
+//
+// The short and verbose log formats write to the standard logger and ignore
+// the configured log file.
+func New(c *config.Config, handler web.Handler) (*Server, os.Error) {
+	s := &Server{
+		Handler:            handler,
+		DefaultHost:        c.DefaultHost,
+		AllowedHosts:       c.AllowedHosts,
+		ReadTimeout:        int64(c.ReadTimeout) * 1e9,
+		WriteTimeout:       int64(c.WriteTimeout) * 1e9,
+		MaxConnections:     c.MaxConnections,
+		ResponseBufferSize: c.ResponseBufferSize,
+	}
+
+	var w io.Writer
+	switch {
+	case c.LogFormat != "apache" && c.LogFormat != "json":
+		// Log file is not used.
+	case c.LogFile == "" || c.LogFile == "stderr":
+		w = os.Stderr
+	case strings.HasPrefix(c.LogFile, "syslog://"):
+		sw, err := DialSyslog(&SyslogOptions{Network: "udp", Addr: c.LogFile[len("syslog://"):]})
+		if err != nil {
+			return nil, err
+		}
+		w = sw
+	default:
+		f, err := os.OpenFile(c.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	switch c.LogFormat {
+	case "", "short":
+		s.Logger = LoggerFunc(ShortLogger)
+	case "verbose":
+		s.Logger = LoggerFunc(VerboseLogger)
+	case "apache":
+		s.Logger = NewApacheCombinedLogger(w)
+	case "json":
+		s.Logger = NewJSONLogger(w)
+	}
+
+	addr := c.Addr
+	if addr == "" {
+		addr = ":8080"
+	}
+	var err os.Error
+	if c.TLSCertFile != "" && c.TLSKeyFile != "" {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.Secure = true
+		s.Listener, err = tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+	} else {
+		s.Listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
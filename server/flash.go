@@ -0,0 +1,77 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bufio"
+	"github.com/garyburd/twister/web"
+	"io"
+	"log"
+	"net"
+	"os"
+)
+
+// ServeFlashPolicy accepts connections on listener and responds to Adobe
+// Flash socket policy file requests with policy. Use web.FlashPolicy to
+// create the policy document.
+func ServeFlashPolicy(listener net.Listener, policy string) os.Error {
+	p := []byte(policy + "\x00")
+	for {
+		conn, e := listener.Accept()
+		if e != nil {
+			if e, ok := e.(net.Error); ok && e.Temporary() {
+				log.Printf("twister.server: flash policy accept error %v", e)
+				continue
+			}
+			return e
+		}
+		go serveFlashPolicyConnection(conn, p)
+	}
+	return nil
+}
+
+func serveFlashPolicyConnection(conn net.Conn, policy []byte) {
+	defer conn.Close()
+	conn.SetTimeout(10e9)
+	// The request is "<policy-file-request/>" terminated by a NUL byte.
+	br, err := bufio.NewReaderSize(io.LimitReader(conn, 64), 64)
+	if err != nil {
+		return
+	}
+	if _, err := br.ReadSlice(0); err != nil {
+		return
+	}
+	conn.Write(policy)
+}
+
+// RunFlashPolicy is a convenience function for running a Flash socket policy
+// server on the TCP address addr. The policy allows connections from the
+// given domains to all ports. Flash clients request the policy from port
+// 843. RunFlashPolicy logs a fatal error if it encounters an error.
+//
+//  go server.RunFlashPolicy(":843", "*.example.com")
+//  server.Run(":8080", h)
+func RunFlashPolicy(addr string, domains ...string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("Listen", err)
+		return
+	}
+	defer listener.Close()
+	err = ServeFlashPolicy(listener, web.FlashPolicy("*", domains...))
+	if err != nil {
+		log.Fatal("Server", err)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"github.com/garyburd/twister/web"
 	"io"
+	"json"
 	"log"
 	"net"
 	"os"
@@ -46,6 +47,15 @@ type LogRecord struct {
 	// Size of the header in bytes.
 	HeaderSize int
 
+	// Number of request body bytes read by the handler. The count is the
+	// decoded size of a chunked body.
+	BodyRead int
+
+	// True if the handler read the entire request body. A false value with a
+	// request body indicates a client that sent a body the application did
+	// not use.
+	BodyConsumed bool
+
 	// True if connection hijacked.
 	Hijacked bool
 }
@@ -64,11 +74,17 @@ func writeStringMap(w io.Writer, title string, m map[string][]string) {
 }
 
 // ShortLogger logs a short summary of the request.
+// The request ID is included if the request was assigned an ID by
+// web.RequestID.
 func ShortLogger(lr *LogRecord) {
+	id := ""
+	if s, ok := lr.Request.Env[web.RequestIDEnvKey].(string); ok {
+		id = " " + s
+	}
 	if lr.Error != nil {
-		log.Printf("%d %s %s %s\n", lr.Status, lr.Request.Method, lr.Request.URL, lr.Error)
+		log.Printf("%d %s %s%s %s\n", lr.Status, lr.Request.Method, lr.Request.URL, id, lr.Error)
 	} else {
-		log.Printf("%d %s %s\n", lr.Status, lr.Request.Method, lr.Request.URL)
+		log.Printf("%d %s %s%s\n", lr.Status, lr.Request.Method, lr.Request.URL, id)
 	}
 }
 
@@ -91,6 +107,8 @@ func VerboseLogger(lr *LogRecord) {
 		fmt.Fprintf(b, "  Status: %d\n", lr.Status)
 		fmt.Fprintf(b, "  Written: %d\n", lr.Written)
 		fmt.Fprintf(b, "  HeaderSize: %d\n", lr.HeaderSize)
+		fmt.Fprintf(b, "  BodyRead: %d\n", lr.BodyRead)
+		fmt.Fprintf(b, "  BodyConsumed: %v\n", lr.BodyConsumed)
 		writeStringMap(b, "Header", lr.Header)
 	}
 	log.Print(b.String())
@@ -152,3 +170,66 @@ func (acl *ApacheCombinedLogger) Log(lr *LogRecord) {
 
 	acl.w.Write(b.Bytes())
 }
+
+// JSONLogger writes access logs as JSON objects, one per line. The format is
+// intended for consumption by log shippers and aggregators.
+type JSONLogger struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewJSONLogger creates a new JSON logger.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// SwitchFiles switches the output of the logger to the new writer.
+func (jl *JSONLogger) SwitchFiles(w io.Writer) {
+	jl.mutex.Lock()
+	defer jl.mutex.Unlock()
+
+	jl.w = w
+}
+
+func (jl *JSONLogger) Log(lr *LogRecord) {
+	m := map[string]interface{}{
+		"time":       time.UTC().Format(time.RFC3339),
+		"remoteAddr": lr.Request.RemoteAddr,
+		"method":     lr.Request.Method,
+		"url":        lr.Request.URL.String(),
+		"protocol":   fmt.Sprintf("HTTP/%d.%d", lr.Request.ProtocolVersion/1000, lr.Request.ProtocolVersion%1000),
+		"status":     lr.Status,
+		"written":    lr.Written,
+		"headerSize": lr.HeaderSize,
+		"bodyRead":   lr.BodyRead,
+		"referer":    lr.Request.Header.Get(web.HeaderReferer),
+		"userAgent":  lr.Request.Header.Get(web.HeaderUserAgent),
+	}
+	if s, ok := lr.Request.Env[web.RequestIDEnvKey].(string); ok {
+		m["requestID"] = s
+	}
+	if s := web.RequestRoute(lr.Request); s != "" {
+		m["route"] = s
+	}
+	if lr.Error != nil {
+		m["error"] = lr.Error.String()
+	}
+	if lr.Hijacked {
+		m["hijacked"] = true
+	} else if !lr.BodyConsumed {
+		m["bodyUnread"] = true
+	}
+	p, err := json.Marshal(m)
+	if err != nil {
+		log.Print("twister: json log encode failed: ", err)
+		return
+	}
+	p = append(p, '\n')
+
+	jl.mutex.Lock()
+	defer jl.mutex.Unlock()
+
+	if jl.w != nil {
+		jl.w.Write(p)
+	}
+}
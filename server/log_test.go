@@ -0,0 +1,78 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package server
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"json"
+	"os"
+	"testing"
+)
+
+func TestJSONLogger(t *testing.T) {
+	url, _ := http.ParseURL("http://example.com/a?q=%22x%22")
+	req, err := web.NewRequest("10.0.0.1:1234", "GET", url, web.ProtocolVersion(1, 1),
+		web.NewHeader(web.HeaderUserAgent, "agent \"quoted\"\n<script>", web.HeaderReferer, "http://example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Env[web.RequestIDEnvKey] = "id1"
+
+	var b bytes.Buffer
+	l := NewJSONLogger(&b)
+	l.Log(&LogRecord{
+		Request:      req,
+		Error:        os.NewError("bad \"thing\""),
+		Status:       web.StatusNotFound,
+		Written:      120,
+		HeaderSize:   100,
+		BodyConsumed: true,
+	})
+
+	line := b.Bytes()
+	if bytes.Count(line, []byte{'\n'}) != 1 || line[len(line)-1] != '\n' {
+		t.Fatalf("log output %q is not one line", line)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(line, &m); err != nil {
+		t.Fatalf("decode %q: %v", line, err)
+	}
+	expected := map[string]interface{}{
+		"remoteAddr": "10.0.0.1:1234",
+		"method":     "GET",
+		"url":        "http://example.com/a?q=%22x%22",
+		"protocol":   "HTTP/1.1",
+		"status":     float64(web.StatusNotFound),
+		"written":    float64(120),
+		"headerSize": float64(100),
+		"bodyRead":   float64(0),
+		"referer":    "http://example.com/",
+		"userAgent":  "agent \"quoted\"\n<script>",
+		"requestID":  "id1",
+		"error":      "bad \"thing\"",
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("%s=%#v, want %#v", k, m[k], v)
+		}
+	}
+	if _, ok := m["time"].(string); !ok {
+		t.Errorf("time=%#v, want string", m["time"])
+	}
+	if _, ok := m["bodyUnread"]; ok {
+		t.Errorf("bodyUnread set for consumed body")
+	}
+}
@@ -19,11 +19,13 @@ import (
 	"github.com/garyburd/twister/web"
 	"io"
 	"os"
+	"strconv"
 )
 
 type responseBody interface {
 	io.Writer
 	web.Flusher
+	web.BufferController
 
 	// finish the response body and return an error if the connection should be
 	// closed due to a write error.
@@ -60,6 +62,14 @@ func (w *nullResponseBody) Flush() os.Error {
 	return w.err
 }
 
+func (w *nullResponseBody) SetBufferSize(n int) os.Error {
+	return w.err
+}
+
+func (w *nullResponseBody) DisableBuffering() os.Error {
+	return w.err
+}
+
 func (w *nullResponseBody) finish() (int, os.Error) {
 	err := w.err
 	if w.err == nil {
@@ -82,6 +92,9 @@ type identityResponseBody struct {
 
 	// Number of header bytes written.
 	headerWritten int
+
+	// Flush after every write.
+	autoFlush bool
 }
 
 func newIdentityResponseBody(wr io.Writer, header []byte, bufferSize, contentLength int) (*identityResponseBody, os.Error) {
@@ -123,6 +136,9 @@ func (w *identityResponseBody) Write(p []byte) (int, os.Error) {
 	if w.err == nil && w.contentLength >= 0 && w.written > w.contentLength {
 		w.err = os.NewError("twister: long write by handler")
 	}
+	if w.autoFlush {
+		w.Flush()
+	}
 	return n, w.err
 }
 
@@ -136,6 +152,9 @@ func (w *identityResponseBody) WriteString(p string) (int, os.Error) {
 	if w.err == nil && w.contentLength >= 0 && w.written > w.contentLength {
 		w.err = os.NewError("twister: long write by handler")
 	}
+	if w.autoFlush {
+		w.Flush()
+	}
 	return n, w.err
 }
 
@@ -147,6 +166,20 @@ func (w *identityResponseBody) Flush() os.Error {
 	return w.err
 }
 
+func (w *identityResponseBody) SetBufferSize(n int) os.Error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.autoFlush = false
+	w.bw, w.err = bufio.NewWriterSize(w.wr, n)
+	return w.err
+}
+
+func (w *identityResponseBody) DisableBuffering() os.Error {
+	w.autoFlush = true
+	return w.Flush()
+}
+
 func (w *identityResponseBody) finish() (int, os.Error) {
 	w.Flush()
 	if w.err != nil {
@@ -170,14 +203,13 @@ type chunkedResponseBody struct {
 	n       int       // current write position in buf
 	ndigit  int       // number of hex digits in chunk size
 	written int
+	// flush after every write
+	autoFlush bool
 }
 
 func newChunkedResponseBody(wr io.Writer, header []byte, bufferSize int) (*chunkedResponseBody, os.Error) {
-	w := &chunkedResponseBody{wr: wr, buf: make([]byte, bufferSize)}
-
-	for n := int32(bufferSize); n != 0; n >>= 4 {
-		w.ndigit += 1
-	}
+	w := &chunkedResponseBody{wr: wr}
+	w.setBuffer(bufferSize)
 
 	if len(header) < len(w.buf) {
 		w.n = copy(w.buf, header)
@@ -190,6 +222,35 @@ func newChunkedResponseBody(wr io.Writer, header []byte, bufferSize int) (*chunk
 	return w, w.err
 }
 
+// setBuffer allocates a buffer with the given size. The buffer must be empty.
+func (w *chunkedResponseBody) setBuffer(bufferSize int) {
+	w.buf = make([]byte, bufferSize)
+	w.ndigit = 0
+	for n := int32(bufferSize); n != 0; n >>= 4 {
+		w.ndigit += 1
+	}
+}
+
+func (w *chunkedResponseBody) SetBufferSize(n int) os.Error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	const minBufferSize = 64
+	if n < minBufferSize {
+		n = minBufferSize
+	}
+	w.autoFlush = false
+	w.setBuffer(n)
+	w.s = 0
+	w.n = w.ndigit + 2 // length CRLF
+	return nil
+}
+
+func (w *chunkedResponseBody) DisableBuffering() os.Error {
+	w.autoFlush = true
+	return w.Flush()
+}
+
 func (w *chunkedResponseBody) writeBuf() {
 	var n int
 	n, w.err = w.wr.Write(w.buf[:w.n])
@@ -276,10 +337,52 @@ func (w *chunkedResponseBody) ncopy(max int) int {
 	return n
 }
 
+// writeLarge writes p as a single chunk. The buffered data and the framing
+// for p are written with one call to the underlying writer and p is written
+// with a second call. Without writeLarge, p is copied through the buffer and
+// written with one call per buffer size.
+func (w *chunkedResponseBody) writeLarge(p []byte) (int, os.Error) {
+	w.finalizeChunk()
+	size := strconv.Itob(len(p), 16)
+	if w.n+len(size)+2 > len(w.buf) {
+		w.writeBuf()
+		if w.err != nil {
+			return 0, w.err
+		}
+		w.n = 0
+	}
+	w.n += copy(w.buf[w.n:], size)
+	w.buf[w.n] = '\r'
+	w.buf[w.n+1] = '\n'
+	w.n += 2
+	w.writeBuf()
+	if w.err != nil {
+		return 0, w.err
+	}
+	var n int
+	n, w.err = w.wr.Write(p)
+	w.written += n
+	if w.err != nil {
+		return n, w.err
+	}
+	// Start the buffer with the CRLF after the data.
+	w.buf[0] = '\r'
+	w.buf[1] = '\n'
+	w.s = 2
+	w.n = w.s + w.ndigit + 2
+	if w.autoFlush {
+		w.Flush()
+	}
+	return n, w.err
+}
+
 func (w *chunkedResponseBody) Write(p []byte) (int, os.Error) {
 	if w.err != nil {
 		return 0, w.err
 	}
+	if len(p) >= len(w.buf) {
+		return w.writeLarge(p)
+	}
 	nn := 0
 	for len(p) > 0 {
 		n := w.ncopy(len(p))
@@ -291,6 +394,9 @@ func (w *chunkedResponseBody) Write(p []byte) (int, os.Error) {
 		nn += n
 		p = p[n:]
 	}
+	if w.autoFlush && w.err == nil {
+		w.Flush()
+	}
 	return nn, w.err
 }
 
@@ -298,6 +404,9 @@ func (w *chunkedResponseBody) WriteString(p string) (int, os.Error) {
 	if w.err != nil {
 		return 0, w.err
 	}
+	if len(p) >= len(w.buf) {
+		return w.writeLarge([]byte(p))
+	}
 	nn := 0
 	for len(p) > 0 {
 		n := w.ncopy(len(p))
@@ -309,5 +418,8 @@ func (w *chunkedResponseBody) WriteString(p string) (int, os.Error) {
 		nn += n
 		p = p[n:]
 	}
+	if w.autoFlush && w.err == nil {
+		w.Flush()
+	}
 	return nn, w.err
 }
@@ -45,8 +45,9 @@ var chunkedResponseTests = []struct {
 	{[]int{0, 27}, "1a\r\n" + dots[:26] + "\r\n01\r\n" + "." + "\r\n0\r\n\r\n"},
 	// Flush before and after chunk
 	{[]int{10, -1, 10, -1}, dots[:10] + "0a\r\n" + dots[:10] + "\r\n0\r\n\r\n"},
-	// Write spanning multiple chunks
-	{[]int{0, 53}, "1a\r\n" + dots[:26] + "\r\n1a\r\n" + dots[:26] + "\r\n01\r\n.\r\n0\r\n\r\n"},
+	// Write larger than buffer
+	{[]int{0, 53}, "35\r\n" + dots[:53] + "\r\n0\r\n\r\n"},
+	{[]int{10, 5, 53, 5}, dots[:10] + "05\r\n" + dots[:5] + "\r\n35\r\n" + dots[:53] + "\r\n05\r\n" + dots[:5] + "\r\n0\r\n\r\n"},
 	// Chunk in multipe writes
 	{[]int{10, -1, 5, 5, -1}, dots[:10] + "0a\r\n" + dots[:10] + "\r\n0\r\n\r\n"},
 	{[]int{10, -1, 5, -1, 5, -1}, dots[:10] + "05\r\n" + dots[:5] + "\r\n05\r\n" + dots[:5] + "\r\n0\r\n\r\n"},
@@ -109,3 +110,60 @@ func TestIdentityResponseReadFrom(t *testing.T) {
 		}
 	}
 }
+
+func TestChunkedResponseDisableBuffering(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := newChunkedResponseBody(&buf, nil, 1024)
+	w.DisableBuffering()
+	io.WriteString(w, "hello")
+	if out, want := buf.String(), "005\r\nhello\r\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	w.SetBufferSize(1024)
+	io.WriteString(w, "world")
+	if out, want := buf.String(), "005\r\nhello\r\n"; out != want {
+		t.Errorf("after SetBufferSize got %q, want %q", out, want)
+	}
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	count int
+}
+
+func (w *countingWriter) Write(p []byte) (int, os.Error) {
+	w.count += 1
+	return w.Buffer.Write(p)
+}
+
+func TestChunkedResponseWriteCount(t *testing.T) {
+	var cw countingWriter
+	w, _ := newChunkedResponseBody(&cw, []byte(dots[:10]), chunkTestBufferSize)
+	io.WriteString(w, dots[:10])
+	w.Write([]byte(dots[:1000]))
+	w.finish()
+	// Header, first chunk and framing; large chunk; CRLF and last chunk.
+	if cw.count != 3 {
+		t.Errorf("count = %d, want 3", cw.count)
+	}
+}
+
+func TestResponseHeaderCoalescing(t *testing.T) {
+	header := []byte("HTTP/1.1 200 OK\r\n\r\n")
+
+	var cw countingWriter
+	cb, _ := newChunkedResponseBody(&cw, header, 1024)
+	io.WriteString(cb, "hello")
+	cb.Flush()
+	if cw.count != 1 {
+		t.Errorf("chunked count = %d, want 1", cw.count)
+	}
+
+	cw = countingWriter{}
+	ib, _ := newIdentityResponseBody(&cw, header, 1024, 5)
+	io.WriteString(ib, "hello")
+	ib.Flush()
+	if cw.count != 1 {
+		t.Errorf("identity count = %d, want 1", cw.count)
+	}
+}
@@ -18,9 +18,11 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"github.com/garyburd/twister/config"
 	"github.com/garyburd/twister/web"
 	"http"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
@@ -28,6 +30,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 var (
@@ -37,9 +40,17 @@ var (
 // Server defines parameters for running an HTTP server.
 type Server struct {
 	// The server accepts incoming connections on this listener. The
-	// application is required to set this field.
+	// application is required to set this field. Applications can wrap a
+	// net.Listener to filter or throttle accepted connections.
 	Listener net.Listener
 
+	// If not nil, WrapConn is called on each accepted connection before the
+	// server reads from the connection. The function is called on the
+	// connection's goroutine. Use WrapConn to add TLS, PROXY protocol
+	// handling or throttling. If WrapConn returns an error, the connection is
+	// closed.
+	WrapConn func(conn net.Conn) (net.Conn, os.Error)
+
 	// The server dispatches requests to this handler. The application is
 	// required to set this field.
 	Handler web.Handler
@@ -62,6 +73,37 @@ type Server struct {
 
 	// If true, do not recover from handler panics.
 	NoRecoverHandlers bool
+
+	// Handler for CONNECT requests. The handler typically hijacks the
+	// connection to tunnel traffic for a forward proxy. If nil, CONNECT
+	// requests are rejected with status 405.
+	ConnectHandler web.Handler
+
+	// Allowed values of the request host in lower case. A value with the
+	// prefix "*." matches subdomains of the remainder. The port is ignored in
+	// the comparison. If AllowedHosts is not empty, requests without a Host
+	// header are rejected with status 400 and requests for other hosts are
+	// rejected with status 421. Use this field to protect internal services
+	// from DNS rebinding and cache poisoning attacks.
+	AllowedHosts []string
+
+	// If true, the server resets and reuses the web.Request of the previous
+	// request on a keep-alive connection instead of allocating a new request.
+	// Reuse reduces allocations, but is only safe when no handler or
+	// middleware retains the request or its Header, Param, Cookie or Env
+	// maps after the handler returns. See the lifetime rules for
+	// web.Request.
+	ReuseRequests bool
+
+	// If greater than zero, new connections are answered with a minimal 503
+	// response and closed when the number of in-flight connections is at
+	// or above this value. Hijacked connections are not counted.
+	MaxConnections int
+
+	// Size of the response body buffer. The default is 4096 bytes.
+	ResponseBufferSize int
+
+	active int32
 }
 
 // Logger defines an interface for logging a request.
@@ -87,6 +129,7 @@ type transaction struct {
 	hijacked           bool
 	req                *web.Request
 	requestAvail       int
+	requestRead        int
 	requestErr         os.Error
 	requestConsumed    bool
 	respondCalled      bool
@@ -137,7 +180,9 @@ func readRequestLine(b *bufio.Reader) (method string, url string, version int, e
 	return
 }
 
-func (t *transaction) prepare() (err os.Error) {
+// prepare reads the request. If reuse is not nil, then reuse is reset to
+// hold the request.
+func (t *transaction) prepare(reuse *web.Request) (err os.Error) {
 	method, rawURL, version, err := readRequestLine(t.br)
 	if err != nil {
 		return err
@@ -149,11 +194,18 @@ func (t *transaction) prepare() (err os.Error) {
 		return err
 	}
 
-	url, err := http.ParseURL(rawURL)
-	if err != nil {
-		return err
+	var url *http.URL
+	if method == "CONNECT" {
+		// The request target is the authority form host:port.
+		url = &http.URL{Raw: rawURL, RawAuthority: rawURL, Host: rawURL}
+	} else {
+		url, err = http.ParseURL(rawURL)
+		if err != nil {
+			return err
+		}
 	}
 
+	// The host in an absolute form request target overrides the Host header.
 	if url.Host == "" {
 		url.Host = header.Get(web.HeaderHost)
 		if url.Host == "" {
@@ -161,13 +213,20 @@ func (t *transaction) prepare() (err os.Error) {
 		}
 	}
 
+	// The scheme is determined by the connection, not the request target.
+	// Use web.ProxyHeaderHandler to set the scheme from a trusted proxy.
 	if t.server.Secure {
 		url.Scheme = "https"
 	} else {
 		url.Scheme = "http"
 	}
 
-	req, err := web.NewRequest(t.conn.RemoteAddr().String(), method, url, version, header)
+	req := reuse
+	if req != nil {
+		err = req.Reset(t.conn.RemoteAddr().String(), method, url, version, header)
+	} else {
+		req, err = web.NewRequest(t.conn.RemoteAddr().String(), method, url, version, header)
+	}
 	if err != nil {
 		return
 	}
@@ -177,11 +236,10 @@ func (t *transaction) prepare() (err os.Error) {
 		t.write100Continue = strings.ToLower(s) == "100-continue"
 	}
 
-	connection := strings.ToLower(req.Header.Get(web.HeaderConnection))
 	if version >= web.ProtocolVersion(1, 1) {
-		t.closeAfterResponse = connection == "close"
+		t.closeAfterResponse = req.Header.HasConnectionToken("close")
 	} else if version == web.ProtocolVersion(1, 0) && req.ContentLength >= 0 {
-		t.closeAfterResponse = connection != "keep-alive"
+		t.closeAfterResponse = !req.Header.HasConnectionToken("keep-alive")
 	} else {
 		t.closeAfterResponse = true
 	}
@@ -239,6 +297,7 @@ func (t identityReader) Read(p []byte) (int, os.Error) {
 	var n int
 	n, t.requestErr = t.br.Read(p)
 	t.requestAvail -= n
+	t.requestRead += n
 	if t.requestAvail == 0 {
 		t.requestConsumed = true
 	}
@@ -268,6 +327,7 @@ func (t chunkedReader) Read(p []byte) (n int, err os.Error) {
 	n, err = t.br.Read(p)
 	t.requestErr = err
 	t.requestAvail -= n
+	t.requestRead += n
 	if err == nil && t.requestAvail == 0 {
 		// We read the next chunk length here to ensure that the entire request
 		// body encoding is consumed in case where the application reads
@@ -343,7 +403,7 @@ func (t *transaction) Respond(status int, header web.Header) (body io.Writer) {
 		t.closeAfterResponse = true
 	}
 
-	if header.Get(web.HeaderConnection) == "close" {
+	if header.HasConnectionToken("close") {
 		t.closeAfterResponse = true
 	}
 
@@ -391,7 +451,12 @@ func (t *transaction) Respond(status int, header web.Header) (body io.Writer) {
 	header.WriteHttpHeader(&b)
 	t.headerSize = b.Len()
 
-	const bufferSize = 4096
+	// The header is copied to the response body buffer so that the header and
+	// the first body bytes are sent to the connection with a single write.
+	bufferSize := t.server.ResponseBufferSize
+	if bufferSize <= 0 {
+		bufferSize = 4096
+	}
 	switch {
 	case t.req.Method == "HEAD":
 		t.responseBody, _ = newNullResponseBody(t.conn, b.Bytes())
@@ -415,6 +480,7 @@ func (t *transaction) Hijack() (conn net.Conn, br *bufio.Reader, err os.Error) {
 		t.server.Logger.Log(&LogRecord{
 			Request:  t.req,
 			Header:   t.header,
+			BodyRead: t.requestRead,
 			Hijacked: true,
 		})
 	}
@@ -443,6 +509,14 @@ func (t *transaction) invokeHandler() {
 			}
 		}()
 	}
+	if t.req.Method == "CONNECT" {
+		if t.server.ConnectHandler == nil {
+			t.req.Error(web.StatusMethodNotAllowed, os.NewError("twister: CONNECT not supported"))
+			return
+		}
+		t.server.ConnectHandler.ServeWeb(t.req)
+		return
+	}
 	t.server.Handler.ServeWeb(t.req)
 }
 
@@ -473,12 +547,14 @@ func (t *transaction) finish() os.Error {
 			}
 		}
 		t.server.Logger.Log(&LogRecord{
-			Written:    written,
-			Request:    t.req,
-			Header:     t.header,
-			HeaderSize: t.headerSize,
-			Status:     t.status,
-			Error:      err})
+			Written:      written,
+			Request:      t.req,
+			Header:       t.header,
+			HeaderSize:   t.headerSize,
+			Status:       t.status,
+			BodyRead:     t.requestRead,
+			BodyConsumed: t.requestConsumed,
+			Error:        err})
 	}
 	t.conn = nil
 	t.br = nil
@@ -486,6 +562,31 @@ func (t *transaction) finish() os.Error {
 	return nil
 }
 
+// checkHost returns the status for a request with a missing or foreign host
+// or zero if the host is allowed.
+func (s *Server) checkHost(req *web.Request) int {
+	if len(s.AllowedHosts) == 0 {
+		return 0
+	}
+	if req.Header.Get(web.HeaderHost) == "" {
+		return web.StatusBadRequest
+	}
+	host := strings.ToLower(req.URL.Host)
+	if i := strings.LastIndex(host, ":"); i >= 0 && strings.Index(host[i:], "]") < 0 {
+		host = host[:i]
+	}
+	for _, allowed := range s.AllowedHosts {
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(host, allowed[1:]) {
+				return 0
+			}
+		} else if host == allowed {
+			return 0
+		}
+	}
+	return web.StatusMisdirectedRequest
+}
+
 func (s *Server) serveConnection(conn net.Conn) {
 	defer conn.Close()
 	if s.ReadTimeout != 0 {
@@ -495,19 +596,30 @@ func (s *Server) serveConnection(conn net.Conn) {
 		conn.SetWriteTimeout(s.WriteTimeout)
 	}
 	br := bufio.NewReader(conn)
+	var t *transaction
 	for {
-		t := &transaction{
+		var reuse *web.Request
+		if t == nil || !s.ReuseRequests {
+			t = &transaction{}
+		} else {
+			reuse = t.req
+		}
+		*t = transaction{
 			server: s,
 			conn:   conn,
 			br:     br}
-		if err := t.prepare(); err != nil {
+		if err := t.prepare(reuse); err != nil {
 			if err != os.EOF {
 				log.Println("twister: prepare failed", err)
 			}
 			break
 		}
 
-		t.invokeHandler()
+		if status := s.checkHost(t.req); status != 0 {
+			t.req.Error(status, os.NewError("twister: host not allowed"))
+		} else {
+			t.invokeHandler()
+		}
 		if t.hijacked {
 			return
 		}
@@ -556,19 +668,80 @@ func (s *Server) serveConnection(conn net.Conn) {
 //  }
 func (s *Server) Serve() os.Error {
 	for {
-		conn, e := s.Listener.Accept()
-		if e != nil {
-			if e, ok := e.(net.Error); ok && e.Temporary() {
-				log.Printf("twister.server: accept error %v", e)
-				continue
-			}
-			return e
+		conn, err := s.accept()
+		if err != nil {
+			return err
+		}
+		if atomic.AddInt32(&s.active, 1) > int32(s.MaxConnections) && s.MaxConnections > 0 {
+			atomic.AddInt32(&s.active, -1)
+			go shedConnection(conn)
+			continue
 		}
-		go s.serveConnection(conn)
+		go func() {
+			defer atomic.AddInt32(&s.active, -1)
+			s.ServeConn(conn)
+		}()
 	}
 	return nil
 }
 
+// accept accepts a connection from s.Listener, retrying on temporary errors.
+func (s *Server) accept() (net.Conn, os.Error) {
+	for {
+		conn, err := s.Listener.Accept()
+		if err == nil {
+			return conn, nil
+		}
+		if e, ok := err.(net.Error); ok && e.Temporary() {
+			log.Printf("twister.server: accept error %v", e)
+			continue
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ServeConn applies s.WrapConn to conn and then reads requests from the
+// connection and calls s.Handler to respond to the requests. The connection
+// is closed when ServeConn returns. Applications with their own accept loop
+// should call ServeConn in a new goroutine for each connection. The Listener
+// and MaxConnections fields are not used by ServeConn.
+func (s *Server) ServeConn(conn net.Conn) {
+	if s.WrapConn != nil {
+		c, err := s.WrapConn(conn)
+		if err != nil {
+			log.Printf("twister.server: wrap connection error %v", err)
+			conn.Close()
+			return
+		}
+		conn = c
+	}
+	s.serveConnection(conn)
+}
+
+const shedResponse = "HTTP/1.1 503 Service Unavailable\r\n" +
+	"Connection: close\r\n" +
+	"Content-Length: 0\r\n" +
+	"Retry-After: 1\r\n\r\n"
+
+// maxShedDrain is the maximum number of request bytes discarded by
+// shedConnection.
+const maxShedDrain = 64 * 1024
+
+// shedConnection answers a connection rejected by the MaxConnections check.
+// The request is discarded until the client closes the connection so that
+// closing the connection with unread data does not reset the connection
+// before the client reads the response.
+func shedConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteTimeout(1e9)
+	if _, err := io.WriteString(conn, shedResponse); err != nil {
+		return
+	}
+	conn.SetReadTimeout(1e9)
+	io.Copy(ioutil.Discard, io.LimitReader(conn, maxShedDrain))
+}
+
 // Run is a convenience function for running an HTTP server. Run listens on the
 // TCP address addr, initializes a server object and calls the server's Serve()
 // method to handle HTTP requests. Run logs a fatal error if it encounters an
@@ -599,13 +772,15 @@ func (s *Server) Serve() os.Error {
 //  }
 //
 func Run(addr string, handler web.Handler) {
-	listener, err := net.Listen("tcp", addr)
+	c := config.Default()
+	c.Addr = addr
+	s, err := New(c, handler)
 	if err != nil {
 		log.Fatal("Listen", err)
 		return
 	}
-	defer listener.Close()
-	err = (&Server{Logger: LoggerFunc(ShortLogger), Listener: listener, Handler: handler}).Serve()
+	defer s.Listener.Close()
+	err = s.Serve()
 	if err != nil {
 		log.Fatal("Server", err)
 	}
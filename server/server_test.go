@@ -19,6 +19,7 @@ import (
 	"github.com/garyburd/twister/web"
 	"net"
 	"os"
+	"strings"
 	"syscall"
 	"testing"
 	"log"
@@ -281,3 +282,207 @@ func TestServer(t *testing.T) {
 		}
 	}
 }
+
+var bodyReadTests = []struct {
+	in       string
+	read     int
+	consumed bool
+}{
+	{"GET / HTTP/1.1\r\n\r\n", 0, true},
+	{"POST /?cl=0 HTTP/1.1\r\nContent-Length: 7\r\nContent-Type: application/x-www-form-urlencoded\r\n\r\nw=Hello", 7, true},
+	{"POST /?cl=0 HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: application/x-www-form-urlencoded\r\n\r\n1\r\nw\r\n6\r\n=Hello\r\n0\r\n\r\n", 7, true},
+	{"POST /?cl=0 HTTP/1.1\r\nContent-Length: 7\r\n\r\nw=Hello", 0, false},
+}
+
+func TestBodyRead(t *testing.T) {
+	log.SetOutput(silentLogger{t})
+	defer log.SetOutput(os.Stdout)
+	for _, tt := range bodyReadTests {
+		var lr *LogRecord
+		l := &testListener{done: make(chan bool), errs: defaultErrs}
+		l.in.WriteString(tt.in)
+		(&Server{Listener: l, Handler: web.HandlerFunc(testHandler), Logger: LoggerFunc(func(r *LogRecord) { lr = r })}).Serve()
+		<-l.done
+		if lr == nil {
+			t.Errorf("in=%q, no log record", tt.in)
+			continue
+		}
+		if lr.BodyRead != tt.read || lr.BodyConsumed != tt.consumed {
+			t.Errorf("in=%q BodyRead=%d BodyConsumed=%v, want %d %v", tt.in, lr.BodyRead, lr.BodyConsumed, tt.read, tt.consumed)
+		}
+	}
+}
+
+func TestReuseRequest(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		var reqs []*web.Request
+		handler := web.HandlerFunc(func(req *web.Request) {
+			if len(req.Env) != 0 || len(req.Param) != 1 {
+				t.Errorf("reuse=%v, Env=%v Param=%v, want empty Env and one Param", reuse, req.Env, req.Param)
+			}
+			req.Env["x"] = true
+			reqs = append(reqs, req)
+			req.Respond(web.StatusOK, web.HeaderContentLength, "0")
+		})
+		l := &testListener{done: make(chan bool), errs: defaultErrs}
+		l.in.WriteString("GET /?a=1 HTTP/1.1\r\n\r\nGET /?b=2 HTTP/1.1\r\n\r\n")
+		(&Server{Listener: l, Handler: handler, ReuseRequests: reuse}).Serve()
+		<-l.done
+		if len(reqs) != 2 {
+			t.Errorf("reuse=%v, %d requests, want 2", reuse, len(reqs))
+			continue
+		}
+		if (reqs[0] == reqs[1]) != reuse {
+			t.Errorf("reuse=%v, request reused = %v", reuse, reqs[0] == reqs[1])
+		}
+		if reqs[1].Param.Get("b") != "2" || reqs[1].Param.Get("a") != "" {
+			t.Errorf("reuse=%v, Param=%v", reuse, reqs[1].Param)
+		}
+	}
+}
+
+var allowedHostTests = []struct {
+	in     string
+	status string
+}{
+	{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "200"},
+	{"GET / HTTP/1.1\r\nHost: EXAMPLE.com:8080\r\n\r\n", "200"},
+	{"GET / HTTP/1.1\r\nHost: api.example.org\r\n\r\n", "200"},
+	{"GET / HTTP/1.1\r\nHost: example.org\r\n\r\n", "421"},
+	{"GET / HTTP/1.1\r\nHost: evil.com\r\n\r\n", "421"},
+	{"GET http://evil.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n", "421"},
+	{"GET / HTTP/1.1\r\n\r\n", "400"},
+}
+
+func TestAllowedHosts(t *testing.T) {
+	for _, tt := range allowedHostTests {
+		l := &testListener{done: make(chan bool), errs: defaultErrs}
+		l.in.WriteString(tt.in)
+		(&Server{Listener: l, Handler: web.HandlerFunc(testHandler), AllowedHosts: []string{"example.com", "*.example.org"}}).Serve()
+		<-l.done
+		out := l.out.String()
+		if !strings.HasPrefix(out, "HTTP/1.1 "+tt.status+" ") {
+			t.Errorf("in=%q, out=%q, want status %s", tt.in, out, tt.status)
+		}
+	}
+}
+
+var requestTargetTests = []struct {
+	in  string
+	url string
+}{
+	{"GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n", "http://example.com/a"},
+	{"GET http://example.org/a?b=c HTTP/1.1\r\nHost: example.com\r\n\r\n", "http://example.org/a?b=c"},
+	{"GET https://example.org/a HTTP/1.1\r\n\r\n", "http://example.org/a"},
+}
+
+func TestRequestTarget(t *testing.T) {
+	for _, tt := range requestTargetTests {
+		var url string
+		l := &testListener{done: make(chan bool), errs: defaultErrs}
+		l.in.WriteString(tt.in)
+		(&Server{Listener: l, Handler: web.HandlerFunc(func(req *web.Request) {
+			url = req.URL.String()
+			req.Respond(web.StatusOK, web.HeaderContentLength, "0")
+		})}).Serve()
+		<-l.done
+		if url != tt.url {
+			t.Errorf("in=%q, url=%q, want %q", tt.in, url, tt.url)
+		}
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	called := false
+	l := &testListener{done: make(chan bool), errs: defaultErrs}
+	l.in.WriteString("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	s := &Server{Listener: l, MaxConnections: 1, Handler: web.HandlerFunc(func(req *web.Request) {
+		called = true
+		req.Respond(web.StatusOK, web.HeaderContentLength, "0")
+	})}
+	s.active = 1
+	s.Serve()
+	<-l.done
+	if out := l.out.String(); out != shedResponse {
+		t.Errorf("out=%q, want %q", out, shedResponse)
+	}
+	if !l.readAll {
+		t.Error("request not drained")
+	}
+	if called {
+		t.Error("handler called for shed connection")
+	}
+
+	// Connections below the limit are served.
+	l = &testListener{done: make(chan bool), errs: defaultErrs}
+	l.in.WriteString("GET / HTTP/1.1\r\n\r\n")
+	s = &Server{Listener: l, MaxConnections: 1, Handler: web.HandlerFunc(testHandler)}
+	s.Serve()
+	<-l.done
+	if out := l.out.String(); !strings.HasPrefix(out, "HTTP/1.1 200 ") {
+		t.Errorf("out=%q, want status 200", out)
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	log.SetOutput(silentLogger{t})
+	defer log.SetOutput(os.Stdout)
+
+	l := &testListener{done: make(chan bool)}
+	l.in.WriteString("GET / HTTP/1.0\r\n\r\n")
+	go (&Server{Handler: web.HandlerFunc(testHandler)}).ServeConn(testConn{l})
+	<-l.done
+	if out := l.out.String(); out != "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\n" {
+		t.Errorf("out=%q, want status 200", out)
+	}
+
+	var wrapped net.Conn
+	l = &testListener{done: make(chan bool)}
+	l.in.WriteString("GET / HTTP/1.0\r\n\r\n")
+	go (&Server{Handler: web.HandlerFunc(testHandler), WrapConn: func(conn net.Conn) (net.Conn, os.Error) {
+		wrapped = conn
+		return conn, nil
+	}}).ServeConn(testConn{l})
+	<-l.done
+	if wrapped == nil {
+		t.Error("WrapConn not called")
+	}
+	if out := l.out.String(); !strings.HasPrefix(out, "HTTP/1.0 200 ") {
+		t.Errorf("wrapped out=%q, want status 200", out)
+	}
+
+	// The connection is closed without a response if WrapConn fails.
+	l = &testListener{done: make(chan bool)}
+	l.in.WriteString("GET / HTTP/1.0\r\n\r\n")
+	go (&Server{Handler: web.HandlerFunc(testHandler), WrapConn: func(conn net.Conn) (net.Conn, os.Error) {
+		return nil, os.NewError("wrap failed")
+	}}).ServeConn(testConn{l})
+	<-l.done
+	if out := l.out.String(); out != "" {
+		t.Errorf("wrap error out=%q, want no response", out)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	in := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"
+
+	l := &testListener{done: make(chan bool), errs: defaultErrs}
+	l.in.WriteString(in)
+	(&Server{Listener: l, Handler: web.HandlerFunc(testHandler)}).Serve()
+	<-l.done
+	if out := l.out.String(); !strings.HasPrefix(out, "HTTP/1.1 405 ") {
+		t.Errorf("no connect handler, out=%q, want status 405", out)
+	}
+
+	var host string
+	l = &testListener{done: make(chan bool), errs: defaultErrs}
+	l.in.WriteString(in)
+	(&Server{Listener: l, Handler: web.HandlerFunc(testHandler), ConnectHandler: web.HandlerFunc(func(req *web.Request) {
+		host = req.URL.Host
+		req.Respond(web.StatusOK, web.HeaderContentLength, "0")
+	})}).Serve()
+	<-l.done
+	if host != "example.com:443" {
+		t.Errorf("host=%q, want %q", host, "example.com:443")
+	}
+}
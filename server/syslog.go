@@ -0,0 +1,195 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyslogFormat specifies the syslog message format.
+type SyslogFormat int
+
+const (
+	// SyslogRFC3164 is the traditional BSD syslog format.
+	SyslogRFC3164 SyslogFormat = iota
+
+	// SyslogRFC5424 is the IETF syslog format.
+	SyslogRFC5424
+)
+
+// Syslog severities.
+const (
+	SyslogEmerg = iota
+	SyslogAlert
+	SyslogCrit
+	SyslogErr
+	SyslogWarning
+	SyslogNotice
+	SyslogInfo
+	SyslogDebug
+)
+
+// SyslogKern selects the kern facility, code 0, in SyslogOptions. The zero
+// value of the Facility field selects the default facility.
+const SyslogKern = -1
+
+// SyslogOptions configures a SyslogWriter.
+type SyslogOptions struct {
+	// Network and address of the syslog daemon. Network is "udp" or
+	// "unixgram". The default is the local daemon at "/dev/log".
+	Network string
+	Addr    string
+
+	// Message format. The default is SyslogRFC3164.
+	Format SyslogFormat
+
+	// Facility code. The default is 16 (local0). Use SyslogKern for
+	// facility 0.
+	Facility int
+
+	// Severity for messages written with Write. The default is SyslogInfo.
+	Severity int
+
+	// Application name included in each message. The default is the base
+	// name of the executable.
+	Tag string
+}
+
+// SyslogWriter sends messages to a syslog daemon. Each call to Write sends
+// one message. Use SyslogWriter as the output of the access loggers and the
+// standard logger:
+//
+//  w, err := server.DialSyslog(&server.SyslogOptions{Network: "udp", Addr: "logs:514"})
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  log.SetOutput(w)
+//  s := &server.Server{Logger: server.NewApacheCombinedLogger(w), ...}
+type SyslogWriter struct {
+	options  SyslogOptions
+	hostname string
+	pid      string
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// DialSyslog connects to the syslog daemon.
+func DialSyslog(options *SyslogOptions) (*SyslogWriter, os.Error) {
+	w := &SyslogWriter{options: *options}
+	if w.options.Network == "" {
+		w.options.Network = "unixgram"
+		if w.options.Addr == "" {
+			w.options.Addr = "/dev/log"
+		}
+	}
+	switch w.options.Facility {
+	case 0:
+		w.options.Facility = 16
+	case SyslogKern:
+		w.options.Facility = 0
+	}
+	if w.options.Severity == 0 {
+		w.options.Severity = SyslogInfo
+	}
+	if w.options.Tag == "" {
+		w.options.Tag = path.Base(os.Args[0])
+	}
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "-"
+	}
+	w.pid = strconv.Itoa(os.Getpid())
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *SyslogWriter) connect() os.Error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	conn, err := net.Dial(w.options.Network, w.options.Addr)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+// Write sends p as a message with the default severity.
+func (w *SyslogWriter) Write(p []byte) (int, os.Error) {
+	if err := w.WriteSeverity(w.options.Severity, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteSeverity sends a message with the given severity.
+func (w *SyslogWriter) WriteSeverity(severity int, message string) os.Error {
+	for len(message) > 0 && message[len(message)-1] == '\n' {
+		message = message[:len(message)-1]
+	}
+	pri := w.options.Facility*8 + severity
+	var b bytes.Buffer
+	switch w.options.Format {
+	case SyslogRFC5424:
+		fmt.Fprintf(&b, "<%d>1 %s %s %s %s - - %s",
+			pri, time.UTC().Format(time.RFC3339), w.hostname, w.options.Tag, w.pid, message)
+	default:
+		fmt.Fprintf(&b, "<%d>%s %s %s[%s]: %s",
+			pri, time.LocalTime().Format("Jan _2 15:04:05"), w.hostname, w.options.Tag, w.pid, message)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.conn.Write(b.Bytes()); err != nil {
+		// Reconnect once in case the daemon restarted.
+		if err := w.connect(); err != nil {
+			return err
+		}
+		if _, err := w.conn.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection to the syslog daemon.
+func (w *SyslogWriter) Close() os.Error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var syslogTests = []struct {
+	options SyslogOptions
+	prefix  string
+	suffix  string
+}{
+	{SyslogOptions{Tag: "test"}, "<134>", " test[" + strconv.Itoa(os.Getpid()) + "]: hello"},
+	{SyslogOptions{Tag: "test", Facility: SyslogKern}, "<6>", " test[" + strconv.Itoa(os.Getpid()) + "]: hello"},
+	{SyslogOptions{Tag: "test", Facility: 3, Severity: SyslogErr}, "<27>", " test[" + strconv.Itoa(os.Getpid()) + "]: hello"},
+	{SyslogOptions{Tag: "test", Format: SyslogRFC5424}, "<134>1 ", " test " + strconv.Itoa(os.Getpid()) + " - - hello"},
+}
+
+func TestSyslogWriter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadTimeout(1e9)
+
+	p := make([]byte, 1024)
+	for _, tt := range syslogTests {
+		options := tt.options
+		options.Network = "udp"
+		options.Addr = conn.LocalAddr().String()
+		w, err := DialSyslog(&options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte("hello\n"))
+		w.Close()
+		if err != nil {
+			t.Errorf("%+v: write returned %v", tt.options, err)
+			continue
+		}
+		n, _, err := conn.ReadFrom(p)
+		if err != nil {
+			t.Fatalf("%+v: read returned %v", tt.options, err)
+		}
+		s := string(p[:n])
+		if !strings.HasPrefix(s, tt.prefix) || !strings.HasSuffix(s, tt.suffix) {
+			t.Errorf("%+v: message %q, want prefix %q and suffix %q", tt.options, s, tt.prefix, tt.suffix)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package thumbnail implements a Twister handler that resizes and crops
+// images on the fly.
+//
+// The handler serves images from a directory or a blob store using the URL
+// parameter "path".
+// The request parameters "w" and "h" specify the size of the result and the
+// parameter "fit" specifies how the image is fit to the size:
+//
+//  contain - scale the image to fit within w x h (default)
+//  cover   - scale and crop the image to fill w x h
+//  fill    - scale the image to w x h, ignoring the aspect ratio
+//
+// Example:
+//
+//  r.Register("/thumb/<path:.*>", "GET", thumbnail.NewHandler("avatars", 1000))
+//
+// Results are kept in an in-memory LRU cache and served with web.ServeBytes.
+// Because the URL does not change when the source image changes, clients may
+// cache a result for cacheMaxAge seconds and then revalidate it using the
+// entity tag.
+package thumbnail
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/garyburd/twister/blob"
+	"github.com/garyburd/twister/web"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// MaxSize is the maximum width or height of a result.
+const MaxSize = 2048
+
+// MaxSourcePixels is the maximum number of pixels in a source image. Larger
+// images are rejected before they are decoded.
+const MaxSourcePixels = 25000000
+
+// cacheMaxAge is the max-age in seconds of the Cache-Control header.
+const cacheMaxAge = 300
+
+// Handler serves resized images.
+type Handler struct {
+	root  string
+	store blob.Store
+
+	mu       sync.Mutex
+	maxItems int
+	lru      *list.List
+	items    map[string]*list.Element
+}
+
+type cacheItem struct {
+	key   string
+	name  string // name with the extension of the result's format
+	data  []byte
+	mtime int64
+}
+
+// NewHandler returns a handler that serves images from the directory root.
+// Up to cacheSize results are cached in memory.
+func NewHandler(root string, cacheSize int) *Handler {
+	root = path.Clean(root)
+	if root != "/" {
+		root += "/"
+	}
+	return &Handler{
+		root:     root,
+		maxItems: cacheSize,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// NewStoreHandler returns a handler that serves images from a blob store.
+// The "path" URL parameter is the blob key. Up to cacheSize results are
+// cached in memory.
+func NewStoreHandler(store blob.Store, cacheSize int) *Handler {
+	return &Handler{
+		store:    store,
+		maxItems: cacheSize,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (h *Handler) get(key string, mtime int64) *cacheItem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := h.items[key]
+	if e == nil {
+		return nil
+	}
+	item := e.Value.(*cacheItem)
+	if item.mtime != mtime {
+		h.lru.Remove(e)
+		h.items[key] = nil, false
+		return nil
+	}
+	h.lru.MoveToFront(e)
+	return item
+}
+
+func (h *Handler) put(item *cacheItem) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxItems <= 0 {
+		return
+	}
+	if e := h.items[item.key]; e != nil {
+		h.lru.Remove(e)
+	}
+	h.items[item.key] = h.lru.PushFront(item)
+	for h.lru.Len() > h.maxItems {
+		e := h.lru.Back()
+		h.lru.Remove(e)
+		h.items[e.Value.(*cacheItem).key] = nil, false
+	}
+}
+
+func (h *Handler) ServeWeb(req *web.Request) {
+	fname := req.URLParam["path"]
+	if fname == "" {
+		panic("twister: thumbnail.Handler expects path URLParam")
+	}
+	if h.store == nil {
+		// Cleaning the rooted path removes ".." elements that would escape
+		// the root.
+		fname = h.root + path.Clean("/" + fname)[1:]
+	}
+
+	w, _ := strconv.Atoi(req.Param.Get("w"))
+	ht, _ := strconv.Atoi(req.Param.Get("h"))
+	fit := req.Param.Get("fit")
+	if fit == "" {
+		fit = "contain"
+	}
+	if w < 0 || ht < 0 || w > MaxSize || ht > MaxSize || (w == 0 && ht == 0) ||
+		(fit != "contain" && fit != "cover" && fit != "fill") {
+		req.Error(web.StatusBadRequest, os.NewError("twister: bad thumbnail parameters"))
+		return
+	}
+
+	mtime, err := h.mtime(fname)
+	if err != nil {
+		req.Error(web.StatusNotFound, err)
+		return
+	}
+
+	key := fname + "\x00" + strconv.Itoa(w) + "x" + strconv.Itoa(ht) + "\x00" + fit
+	item := h.get(key, mtime)
+	if item == nil {
+		item, err = h.render(fname, w, ht, fit)
+		if err != nil {
+			req.Error(web.StatusNotFound, err)
+			return
+		}
+		item.key = key
+		item.mtime = mtime
+		h.put(item)
+	}
+
+	web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+		if status == web.StatusOK || status == web.StatusNotModified {
+			header.Set(web.HeaderCacheControl, "public, max-age="+strconv.Itoa(cacheMaxAge))
+		}
+		return status, header
+	})
+	web.ServeBytes(req, item.name, item.mtime/1e9, item.data)
+}
+
+// mtime returns the modification time in nanoseconds of the named image.
+func (h *Handler) mtime(fname string) (int64, os.Error) {
+	if h.store != nil {
+		info, err := h.store.Stat(fname)
+		if err != nil {
+			return 0, err
+		}
+		return info.ModTime * 1e9, nil
+	}
+	info, err := os.Stat(fname)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsRegular() {
+		return 0, os.NewError("twister: thumbnail source not a regular file")
+	}
+	return info.Mtime_ns, nil
+}
+
+// open opens the named image.
+func (h *Handler) open(fname string) (io.ReadCloser, os.Error) {
+	if h.store != nil {
+		r, _, err := h.store.Get(fname)
+		return r, err
+	}
+	return os.Open(fname)
+}
+
+var errTooLarge = os.NewError("twister: thumbnail source image too large")
+
+// render decodes the named image and returns the transformed image.
+func (h *Handler) render(fname string, w, ht int, fit string) (*cacheItem, os.Error) {
+	f, err := h.open(fname)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > MaxSourcePixels/config.Height {
+		return nil, errTooLarge
+	}
+
+	f, err = h.open(fname)
+	if err != nil {
+		return nil, err
+	}
+	src, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	dst := Transform(src, w, ht, fit)
+
+	var b bytes.Buffer
+	item := &cacheItem{}
+	if format == "jpeg" {
+		item.name = "thumbnail.jpg"
+		err = jpeg.Encode(&b, dst, &jpeg.Options{Quality: 85})
+	} else {
+		item.name = "thumbnail.png"
+		err = png.Encode(&b, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	item.data = b.Bytes()
+	return item, nil
+}
+
+// Transform scales src to w x h using the fit mode "contain", "cover" or
+// "fill". If one of w or h is zero, then the dimension is computed from the
+// aspect ratio of src.
+func Transform(src image.Image, w, h int, fit string) image.Image {
+	sr := src.Bounds()
+	sw, sh := sr.Dx(), sr.Dy()
+	if sw == 0 || sh == 0 {
+		return src
+	}
+	if w == 0 {
+		w = sw * h / sh
+	} else if h == 0 {
+		h = sh * w / sw
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	switch fit {
+	case "contain":
+		// Shrink one dimension to preserve the aspect ratio.
+		if sw*h > sh*w {
+			h = sh * w / sw
+		} else {
+			w = sw * h / sh
+		}
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+	case "cover":
+		// Crop the source to the aspect ratio of the result.
+		if sw*h > sh*w {
+			cw := sh * w / h
+			sr.Min.X += (sw - cw) / 2
+			sr.Max.X = sr.Min.X + cw
+		} else {
+			ch := sw * h / w
+			sr.Min.Y += (sh - ch) / 2
+			sr.Max.Y = sr.Min.Y + ch
+		}
+	}
+	return resize(src, sr, w, h)
+}
+
+// resize scales the rectangle r in src to w x h by averaging the source
+// pixels covered by each destination pixel.
+func resize(src image.Image, r image.Rectangle, w, h int) image.Image {
+	dst := image.NewRGBA(w, h)
+	sw, sh := r.Dx(), r.Dy()
+	for y := 0; y < h; y++ {
+		y0 := r.Min.Y + y*sh/h
+		y1 := r.Min.Y + (y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := r.Min.X + x*sw/w
+			x1 := r.Min.X + (x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sr, sg, sb, sa, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := src.At(sx, sy).RGBA()
+					sr += uint64(r)
+					sg += uint64(g)
+					sb += uint64(b)
+					sa += uint64(a)
+					n += 1
+				}
+			}
+			dst.Set(x, y, image.RGBAColor{
+				uint8(sr / n >> 8),
+				uint8(sg / n >> 8),
+				uint8(sb / n >> 8),
+				uint8(sa / n >> 8)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,136 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/garyburd/twister/web"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+var transformTests = []struct {
+	w, h int
+	fit  string
+	dx   int
+	dy   int
+}{
+	{20, 20, "contain", 20, 10},
+	{20, 20, "cover", 20, 20},
+	{20, 20, "fill", 20, 20},
+	{0, 10, "contain", 20, 10},
+	{50, 0, "contain", 50, 25},
+}
+
+func TestTransform(t *testing.T) {
+	src := image.NewRGBA(100, 50)
+	for _, tt := range transformTests {
+		r := Transform(src, tt.w, tt.h, tt.fit).Bounds()
+		if r.Dx() != tt.dx || r.Dy() != tt.dy {
+			t.Errorf("Transform(%d, %d, %s) = %dx%d, want %dx%d", tt.w, tt.h, tt.fit, r.Dx(), r.Dy(), tt.dx, tt.dy)
+		}
+	}
+}
+
+// pngHeader returns the start of a PNG file with the given dimensions. The
+// image data is missing.
+func pngHeader(w, h uint32) []byte {
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	chunk := make([]byte, 17)
+	copy(chunk, "IHDR")
+	binary.BigEndian.PutUint32(chunk[4:], w)
+	binary.BigEndian.PutUint32(chunk[8:], h)
+	chunk[12] = 8 // bit depth
+	chunk[13] = 6 // color type RGBA
+	p := make([]byte, 4)
+	binary.BigEndian.PutUint32(p, 13)
+	b.Write(p)
+	b.Write(chunk)
+	binary.BigEndian.PutUint32(p, crc32.ChecksumIEEE(chunk))
+	b.Write(p)
+	return b.Bytes()
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thumbnail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(path.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(100, 50)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "sub/b.png"} {
+		if err := ioutil.WriteFile(path.Join(dir, name), b.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "sub/large.png"), pngHeader(100000, 100000), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(h *Handler, fname string, header web.Header) (int, web.Header) {
+		status, header, _ := web.RunHandler("/?w=20&h=20", "GET", header, nil, web.HandlerFunc(func(req *web.Request) {
+			req.URLParam = map[string]string{"path": fname}
+			h.ServeWeb(req)
+		}))
+		return status, header
+	}
+
+	h := NewHandler(path.Join(dir, "sub"), 10)
+	status, header := run(h, "b.png", nil)
+	if status != web.StatusOK || header.Get(web.HeaderContentType) != "image/png" ||
+		header.Get(web.HeaderCacheControl) != "public, max-age=300" || header.Get(web.HeaderETag) == "" {
+		t.Errorf("status=%d header=%v", status, header)
+	}
+	status, _ = run(h, "b.png", web.NewHeader(web.HeaderIfNoneMatch, header.Get(web.HeaderETag)))
+	if status != web.StatusNotModified {
+		t.Errorf("If-None-Match status=%d, want %d", status, web.StatusNotModified)
+	}
+	if status, _ = run(h, "../a.png", nil); status != web.StatusNotFound {
+		t.Errorf("outside root status=%d, want %d", status, web.StatusNotFound)
+	}
+	if status, _ = run(h, "large.png", nil); status != web.StatusNotFound {
+		t.Errorf("large image status=%d, want %d", status, web.StatusNotFound)
+	}
+
+	// The root directory.
+	if status, _ = run(NewHandler("/", 10), dir[1:]+"/a.png", nil); status != web.StatusOK {
+		t.Errorf("root / status=%d, want %d", status, web.StatusOK)
+	}
+
+	// The current directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if status, _ = run(NewHandler(".", 10), "a.png", nil); status != web.StatusOK {
+		t.Errorf("root . status=%d, want %d", status, web.StatusOK)
+	}
+}
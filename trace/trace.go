@@ -0,0 +1,233 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package trace propagates trace context between services and records spans
+// for distributed tracing.
+//
+// Handler starts a span for each request. The trace identifier and the
+// caller's span identifier are extracted from the request headers. Spans are
+// passed to a collector when finished:
+//
+//  h = trace.Handler(&trace.Options{Collector: trace.WriterCollector(f)}, h)
+//
+// Handlers start child spans for calls to other services and inject the
+// span's context into the outgoing request headers:
+//
+//  span := trace.RequestSpan(req).Child("users.lookup")
+//  span.Inject(outreq.Header)
+//  resp, err := transport.RoundTrip(outreq)
+//  span.Finish()
+//
+// The reverse proxy in package proxy creates child spans for upstream calls.
+package trace
+
+import (
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"json"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// Span is a timed operation in a trace.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string // "" for the root span of a trace
+	Name     string
+
+	// Start and end time in nanoseconds since the epoch.
+	Start int64
+	End   int64
+
+	Tags map[string]string
+
+	options *Options
+	mu      sync.Mutex
+}
+
+// Collector receives finished spans.
+type Collector interface {
+	Collect(span *Span)
+}
+
+// CollectorFunc is a type adapter to allow the use of ordinary functions as
+// Collector.
+type CollectorFunc func(span *Span)
+
+// Collect calls f(span).
+func (f CollectorFunc) Collect(span *Span) { f(span) }
+
+// WriterCollector returns a collector that writes spans to w as JSON objects,
+// one per line.
+func WriterCollector(w io.Writer) Collector {
+	var mu sync.Mutex
+	return CollectorFunc(func(span *Span) {
+		m := map[string]interface{}{
+			"traceID":  span.TraceID,
+			"spanID":   span.SpanID,
+			"name":     span.Name,
+			"start":    span.Start,
+			"duration": span.End - span.Start,
+		}
+		if span.ParentID != "" {
+			m["parentID"] = span.ParentID
+		}
+		if len(span.Tags) > 0 {
+			m["tags"] = span.Tags
+		}
+		p, err := json.Marshal(m)
+		if err != nil {
+			log.Print("twister.trace: json encode failed: ", err)
+			return
+		}
+		p = append(p, '\n')
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(p)
+	})
+}
+
+// Options configures tracing.
+type Options struct {
+	// Collector for finished spans. The application is required to set this
+	// field.
+	Collector Collector
+
+	// Names of the headers for the trace identifier and the caller's span
+	// identifier. The header names must be in canonical header name format.
+	// The defaults are "X-Trace-Id" and "X-Span-Id".
+	TraceHeader string
+	SpanHeader  string
+}
+
+func validID(s string) bool {
+	if len(s) == 0 || len(s) > 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Child starts a span for an operation performed as part of s.
+func (s *Span) Child(name string) *Span {
+	return &Span{
+		TraceID:  s.TraceID,
+		SpanID:   web.RandomHex(8),
+		ParentID: s.SpanID,
+		Name:     name,
+		Start:    web.Nanoseconds(),
+		options:  s.options,
+	}
+}
+
+// SetTag sets a tag on the span.
+func (s *Span) SetTag(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Tags == nil {
+		s.Tags = make(map[string]string)
+	}
+	s.Tags[key] = value
+}
+
+// Inject sets the trace context headers in header for a call made as part
+// of the span.
+func (s *Span) Inject(header http.Header) {
+	header.Set(s.options.TraceHeader, s.TraceID)
+	header.Set(s.options.SpanHeader, s.SpanID)
+}
+
+// Finish records the end time of the span and passes the span to the
+// collector. Calls to Finish after the first are ignored.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.End != 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.End = web.Nanoseconds()
+	s.mu.Unlock()
+	s.options.Collector.Collect(s)
+}
+
+const spanEnvKey = "twister.trace.Span"
+
+// RequestSpan returns the request's span or nil if the request is not
+// traced by Handler.
+func RequestSpan(req *web.Request) *Span {
+	s, _ := req.Env[spanEnvKey].(*Span)
+	return s
+}
+
+// Handler returns a handler that records a span for each request to h. The
+// span is named with the request method and the matched Router route or the
+// request path if no route matched. The span is tagged with the response
+// status.
+func Handler(options *Options, h web.Handler) web.Handler {
+	if options.Collector == nil {
+		panic("twister.trace: Handler requires Collector option")
+	}
+	th := &handler{options: *options, h: h}
+	if th.options.TraceHeader == "" {
+		th.options.TraceHeader = "X-Trace-Id"
+	}
+	if th.options.SpanHeader == "" {
+		th.options.SpanHeader = "X-Span-Id"
+	}
+	return th
+}
+
+type handler struct {
+	options Options
+	h       web.Handler
+}
+
+func (th *handler) ServeWeb(req *web.Request) {
+	s := &Span{
+		TraceID:  req.Header.Get(th.options.TraceHeader),
+		SpanID:   web.RandomHex(8),
+		ParentID: req.Header.Get(th.options.SpanHeader),
+		Start:    web.Nanoseconds(),
+		options:  &th.options,
+	}
+	if !validID(s.TraceID) {
+		s.TraceID = web.RandomHex(16)
+		s.ParentID = ""
+	} else if !validID(s.ParentID) {
+		s.ParentID = ""
+	}
+	s.SetTag("http.method", req.Method)
+	s.SetTag("http.url", req.URL.String())
+	req.Env[spanEnvKey] = s
+	web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+		s.SetTag("http.status", strconv.Itoa(status))
+		return status, header
+	})
+	defer func() {
+		name := web.RequestRoute(req)
+		if name == "" {
+			name = req.URL.Path
+		}
+		s.Name = req.Method + " " + name
+		s.Finish()
+	}()
+	th.h.ServeWeb(req)
+}
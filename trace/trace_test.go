@@ -0,0 +1,73 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package trace
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"json"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var spans []*Span
+	options := &Options{Collector: CollectorFunc(func(s *Span) { spans = append(spans, s) })}
+	h := Handler(options, web.HandlerFunc(func(req *web.Request) {
+		child := RequestSpan(req).Child("child")
+		header := make(http.Header)
+		child.Inject(header)
+		if header.Get("X-Trace-Id") != child.TraceID || header.Get("X-Span-Id") != child.SpanID {
+			t.Errorf("Inject() set header %v", header)
+		}
+		child.Finish()
+		child.Finish()
+		req.Respond(web.StatusNotFound)
+	}))
+
+	web.RunHandler("/a", "GET", web.NewHeader("X-Trace-Id", "0123456789abcdef", "X-Span-Id", "aaaa"), nil, h)
+	if len(spans) != 2 {
+		t.Fatalf("collected %d spans, want 2", len(spans))
+	}
+	child, root := spans[0], spans[1]
+	if root.TraceID != "0123456789abcdef" || root.ParentID != "aaaa" || root.Name != "GET /a" {
+		t.Errorf("root span = %+v", root)
+	}
+	if root.Tags["http.status"] != "404" {
+		t.Errorf("root status tag = %q, want 404", root.Tags["http.status"])
+	}
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID || child.End < child.Start {
+		t.Errorf("child span = %+v", child)
+	}
+
+	spans = nil
+	web.RunHandler("/a", "GET", web.NewHeader("X-Trace-Id", "not hex", "X-Span-Id", "aaaa"), nil, h)
+	if root := spans[1]; len(root.TraceID) != 32 || root.ParentID != "" {
+		t.Errorf("span for invalid context = %+v", root)
+	}
+}
+
+func TestWriterCollector(t *testing.T) {
+	var b bytes.Buffer
+	c := WriterCollector(&b)
+	c.Collect(&Span{TraceID: "t", SpanID: "s", Name: "n", Start: 10, End: 25, Tags: map[string]string{"k": "v"}})
+	var m map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["traceID"] != "t" || m["duration"] != float64(15) || m["parentID"] != nil {
+		t.Errorf("collected %s", b.String())
+	}
+}
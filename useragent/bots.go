@@ -0,0 +1,275 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package useragent
+
+import (
+	"container/list"
+	"github.com/garyburd/twister/web"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action is the action taken by BotHandler for a class of clients.
+type Action int
+
+const (
+	// Allow passes the request to the wrapped handler.
+	Allow Action = iota
+
+	// Throttle limits the number of requests from each client IP address.
+	Throttle
+
+	// Block rejects the request with status 403.
+	Block
+
+	// CachedOnly passes the request to the Cached handler.
+	CachedOnly
+)
+
+// Policy is the policy for a class of clients.
+type Policy struct {
+	Action Action
+
+	// Maximum number of requests per bot and client IP address in Period
+	// seconds for the Throttle action. The default period is 60 seconds.
+	Limit  int
+	Period int
+}
+
+// BotOptions configures BotHandler.
+type BotOptions struct {
+	// Policies by bot name. See BotRules for the names. Clients that are
+	// not bots are allowed.
+	Policies map[string]Policy
+
+	// Policy for bots without an entry in Policies. The default is Allow.
+	DefaultPolicy Policy
+
+	// VerifiedDomains maps bot names to the domains of the bot's hosts. If
+	// a bot has an entry in VerifiedDomains, then the client IP address is
+	// verified with a reverse DNS lookup followed by a forward lookup of the
+	// host name. Clients that fail verification are handled as bot "Bot".
+	// The default is DefaultVerifiedDomains. Set to an empty map to disable
+	// verification.
+	VerifiedDomains map[string][]string
+
+	// Handler for the CachedOnly action, typically a handler serving a
+	// cached or static copy of the site. If Cached is nil, then CachedOnly
+	// requests are rejected with status 503.
+	Cached web.Handler
+
+	// Limiter for the Throttle action. Share a limiter with other handlers
+	// to enforce limits across the handlers. The default is a new limiter.
+	Limiter *web.RateLimiter
+
+	// Maximum number of verification results cached. The least recently
+	// used results are discarded first. The default is 10000.
+	MaxVerified int
+
+	// Maximum time in nanoseconds for each DNS lookup. A client is not
+	// verified if a lookup times out. The default is 2 seconds.
+	LookupTimeout int64
+}
+
+// DefaultVerifiedDomains is the default value of BotOptions.VerifiedDomains.
+var DefaultVerifiedDomains = map[string][]string{
+	"Googlebot":   []string{"googlebot.com", "google.com"},
+	"Bingbot":     []string{"search.msn.com"},
+	"Yahoo":       []string{"crawl.yahoo.net"},
+	"Baiduspider": []string{"baidu.com", "baidu.jp"},
+	"YandexBot":   []string{"yandex.ru", "yandex.net", "yandex.com"},
+}
+
+// Functions for DNS lookups. Tests replace these functions.
+var (
+	lookupAddr = net.LookupAddr
+	lookupHost = net.LookupHost
+)
+
+// BotHandler returns a handler that applies the policy for the client's bot
+// class before calling h. The bot name after verification is available to h
+// through the Bot function.
+func BotHandler(options *BotOptions, h web.Handler) web.Handler {
+	bh := &botHandler{
+		options:  *options,
+		h:        h,
+		verified: make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	if bh.options.VerifiedDomains == nil {
+		bh.options.VerifiedDomains = DefaultVerifiedDomains
+	}
+	if bh.options.Limiter == nil {
+		bh.options.Limiter = web.NewRateLimiter()
+	}
+	if bh.options.MaxVerified <= 0 {
+		bh.options.MaxVerified = 10000
+	}
+	if bh.options.LookupTimeout <= 0 {
+		bh.options.LookupTimeout = 2e9
+	}
+	return bh
+}
+
+type verification struct {
+	key     string
+	ok      bool
+	expires int64
+}
+
+type botHandler struct {
+	options BotOptions
+	h       web.Handler
+
+	// Verification results with the most recently used result at the front
+	// of lru.
+	mu       sync.Mutex
+	verified map[string]*list.Element
+	lru      *list.List
+}
+
+const botEnvKey = "twister.useragent.Bot"
+
+func clientIP(req *web.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+var errLookupTimeout = os.NewError("twister.useragent: DNS lookup timeout")
+
+// lookup calls f(name) and returns an error if the call does not complete
+// within timeout nanoseconds.
+func lookup(f func(string) ([]string, os.Error), name string, timeout int64) ([]string, os.Error) {
+	type result struct {
+		names []string
+		err   os.Error
+	}
+	c := make(chan result, 1)
+	go func() {
+		names, err := f(name)
+		c <- result{names, err}
+	}()
+	select {
+	case r := <-c:
+		return r.names, r.err
+	case <-time.After(timeout):
+	}
+	return nil, errLookupTimeout
+}
+
+// verify returns true if ip belongs to one of the domains.
+func (bh *botHandler) verify(bot, ip string, domains []string) bool {
+	key := bot + " " + ip
+	now := web.Seconds()
+	bh.mu.Lock()
+	if e, ok := bh.verified[key]; ok {
+		v := e.Value.(*verification)
+		if now < v.expires {
+			bh.lru.MoveToFront(e)
+			bh.mu.Unlock()
+			return v.ok
+		}
+	}
+	bh.mu.Unlock()
+
+	v := &verification{key: key, expires: now + 3600}
+	names, err := lookup(lookupAddr, ip, bh.options.LookupTimeout)
+	if err == nil {
+	loop:
+		for _, name := range names {
+			name = strings.TrimRight(name, ".")
+			for _, domain := range domains {
+				if !strings.HasSuffix(name, "."+domain) {
+					continue
+				}
+				addrs, err := lookup(lookupHost, name, bh.options.LookupTimeout)
+				if err != nil {
+					break loop
+				}
+				for _, addr := range addrs {
+					if addr == ip {
+						v.ok = true
+						break loop
+					}
+				}
+			}
+		}
+	}
+
+	bh.mu.Lock()
+	defer bh.mu.Unlock()
+	if e, ok := bh.verified[key]; ok {
+		bh.lru.Remove(e)
+	}
+	bh.verified[key] = bh.lru.PushFront(v)
+	for bh.lru.Len() > bh.options.MaxVerified {
+		e := bh.lru.Back()
+		bh.lru.Remove(e)
+		bh.verified[e.Value.(*verification).key] = nil, false
+	}
+	return v.ok
+}
+
+func (bh *botHandler) ServeWeb(req *web.Request) {
+	bot := Get(req).Bot
+	if bot == "" {
+		bh.h.ServeWeb(req)
+		return
+	}
+	ip := clientIP(req)
+	if domains, ok := bh.options.VerifiedDomains[bot]; ok && !bh.verify(bot, ip, domains) {
+		bot = "Bot"
+	}
+	req.Env[botEnvKey] = bot
+
+	p, ok := bh.options.Policies[bot]
+	if !ok {
+		p = bh.options.DefaultPolicy
+	}
+	switch p.Action {
+	case Throttle:
+		period := int64(p.Period)
+		if period <= 0 {
+			period = 60
+		}
+		if remaining, reset := bh.options.Limiter.Take("bot:"+bot+" "+ip, p.Limit, period); remaining < 0 {
+			web.ThrottleError(req, web.StatusTooManyRequests, os.NewError("twister.useragent: bot request limit exceeded"), reset*1e9)
+			return
+		}
+	case Block:
+		req.Error(web.StatusForbidden, os.NewError("twister.useragent: bot blocked"))
+		return
+	case CachedOnly:
+		if bh.options.Cached == nil {
+			req.Error(web.StatusServiceUnavailable, os.NewError("twister.useragent: no cached handler"))
+			return
+		}
+		bh.options.Cached.ServeWeb(req)
+		return
+	}
+	bh.h.ServeWeb(req)
+}
+
+// Bot returns the bot name set by BotHandler. Bot returns "" for clients
+// that are not bots and "Bot" for clients that failed verification.
+func Bot(req *web.Request) string {
+	s, _ := req.Env[botEnvKey].(string)
+	return s
+}
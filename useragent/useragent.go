@@ -0,0 +1,156 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package useragent classifies User-Agent header values.
+//
+// The classification is driven by an ordered rule table. The first browser
+// rule, the first OS rule and the first bot rule that match the header value
+// determine the result. Applications can extend the table by appending rules
+// to BrowserRules, OSRules and BotRules before handling requests.
+//
+// BotHandler applies per crawler policies using the classification.
+package useragent
+
+import (
+	"github.com/garyburd/twister/web"
+	"regexp"
+	"strings"
+)
+
+// Info is the classification of a User-Agent header value.
+type Info struct {
+	// Browser name and version, for example "Firefox" and "5.0". Browser is
+	// "Other" if no rule matched.
+	Browser        string
+	BrowserVersion string
+
+	// Operating system, for example "Windows" or "iOS". OS is "Other" if no
+	// rule matched.
+	OS string
+
+	// Bot is the crawler name for crawlers, scripts and HTTP libraries and ""
+	// for interactive clients.
+	Bot string
+
+	// True for phones and tablets.
+	Mobile bool
+}
+
+// IsBot returns true if the client is a crawler or script.
+func (info *Info) IsBot() bool { return info.Bot != "" }
+
+// Rule maps a regular expression to a name. If the expression has a
+// subexpression, then the first subexpression is the version.
+type Rule struct {
+	Regexp *regexp.Regexp
+	Name   string
+}
+
+func rule(pattern, name string) Rule {
+	return Rule{regexp.MustCompile(pattern), name}
+}
+
+// BrowserRules is the ordered rule table for browsers. Rules for browsers
+// that include the tokens of other browsers must come first.
+var BrowserRules = []Rule{
+	rule(`Edge?/([0-9.]+)`, "Edge"),
+	rule(`OPR/([0-9.]+)`, "Opera"),
+	rule(`Opera.*Version/([0-9.]+)`, "Opera"),
+	rule(`Opera[/ ]([0-9.]+)`, "Opera"),
+	rule(`Chrome/([0-9.]+)`, "Chrome"),
+	rule(`CriOS/([0-9.]+)`, "Chrome"),
+	rule(`Firefox/([0-9.]+)`, "Firefox"),
+	rule(`FxiOS/([0-9.]+)`, "Firefox"),
+	rule(`MSIE ([0-9.]+)`, "IE"),
+	rule(`Trident/.*rv:([0-9.]+)`, "IE"),
+	rule(`Version/([0-9.]+).*Safari/`, "Safari"),
+	rule(`Safari/`, "Safari"),
+}
+
+// OSRules is the ordered rule table for operating systems.
+var OSRules = []Rule{
+	rule(`Windows Phone`, "Windows Phone"),
+	rule(`Windows`, "Windows"),
+	rule(`iPhone|iPad|iPod`, "iOS"),
+	rule(`Android`, "Android"),
+	rule(`Mac OS X`, "Mac OS X"),
+	rule(`CrOS`, "Chrome OS"),
+	rule(`Linux`, "Linux"),
+	rule(`BSD`, "BSD"),
+}
+
+// BotRules is the ordered rule table for crawlers, scripts and HTTP
+// libraries.
+var BotRules = []Rule{
+	rule(`Googlebot`, "Googlebot"),
+	rule(`bingbot|msnbot`, "Bingbot"),
+	rule(`Slurp`, "Yahoo"),
+	rule(`DuckDuckBot`, "DuckDuckBot"),
+	rule(`Baiduspider`, "Baiduspider"),
+	rule(`YandexBot`, "YandexBot"),
+	rule(`facebookexternalhit`, "Facebook"),
+	rule(`Twitterbot`, "Twitterbot"),
+	rule(`curl/|Wget/|Go http package|Go-http-client|python-requests|Python-urllib|libwww-perl|Java/`, "Script"),
+	rule(`[Bb]ot|[Cc]rawler|[Ss]pider`, "Bot"),
+}
+
+var mobileRegexp = regexp.MustCompile(`Mobile|Android|iPhone|iPad|iPod|Windows Phone|Opera Mini`)
+
+func match(rules []Rule, s string) (name, version string) {
+	for _, r := range rules {
+		m := r.Regexp.FindStringSubmatch(s)
+		if m == nil {
+			continue
+		}
+		if len(m) > 1 {
+			version = m[1]
+		}
+		return r.Name, version
+	}
+	return "", ""
+}
+
+// Parse classifies a User-Agent header value.
+func Parse(s string) *Info {
+	info := &Info{}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		info.Browser, info.OS = "Other", "Other"
+		return info
+	}
+	info.Bot, _ = match(BotRules, s)
+	info.Browser, info.BrowserVersion = match(BrowserRules, s)
+	if info.Browser == "" {
+		info.Browser = "Other"
+	}
+	info.OS, _ = match(OSRules, s)
+	if info.OS == "" {
+		info.OS = "Other"
+	}
+	info.Mobile = info.Bot == "" && mobileRegexp.MatchString(s)
+	return info
+}
+
+const envKey = "twister.useragent"
+
+// Get returns the classification of the request's User-Agent header. The
+// result is cached in the request environment.
+func Get(req *web.Request) *Info {
+	if info, ok := req.Env[envKey].(*Info); ok {
+		return info
+	}
+	info := Parse(req.Header.Get(web.HeaderUserAgent))
+	req.Env[envKey] = info
+	return info
+}
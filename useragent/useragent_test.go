@@ -0,0 +1,135 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package useragent
+
+import (
+	"github.com/garyburd/twister/web"
+	"net"
+	"os"
+	"testing"
+)
+
+var parseTests = []struct {
+	s      string
+	expect Info
+}{
+	{"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/534.30 (KHTML, like Gecko) Chrome/12.0.742.112 Safari/534.30",
+		Info{Browser: "Chrome", BrowserVersion: "12.0.742.112", OS: "Windows"}},
+	{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.6; rv:5.0) Gecko/20100101 Firefox/5.0",
+		Info{Browser: "Firefox", BrowserVersion: "5.0", OS: "Mac OS X"}},
+	{"Mozilla/5.0 (iPhone; U; CPU iPhone OS 4_3_3 like Mac OS X; en-us) AppleWebKit/533.17.9 (KHTML, like Gecko) Version/5.0.2 Mobile/8J2 Safari/6533.18.5",
+		Info{Browser: "Safari", BrowserVersion: "5.0.2", OS: "iOS", Mobile: true}},
+	{"Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.0; Trident/4.0)",
+		Info{Browser: "IE", BrowserVersion: "8.0", OS: "Windows"}},
+	{"Opera/9.80 (X11; Linux i686; U; en) Presto/2.8.131 Version/11.11",
+		Info{Browser: "Opera", BrowserVersion: "11.11", OS: "Linux"}},
+	{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		Info{Browser: "Other", OS: "Other", Bot: "Googlebot"}},
+	{"curl/7.21.6 (x86_64-pc-linux-gnu)",
+		Info{Browser: "Other", OS: "Other", Bot: "Script"}},
+	{"",
+		Info{Browser: "Other", OS: "Other"}},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		info := Parse(tt.s)
+		if *info != tt.expect {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.s, *info, tt.expect)
+		}
+	}
+}
+
+func TestBotHandler(t *testing.T) {
+	lookupAddr = func(addr string) ([]string, os.Error) {
+		if addr == "66.249.66.1" {
+			return []string{"crawl-66-249-66-1.googlebot.com."}, nil
+		}
+		return nil, os.NewError("not found")
+	}
+	lookupHost = func(host string) ([]string, os.Error) {
+		return []string{"66.249.66.1"}, nil
+	}
+	defer func() { lookupAddr, lookupHost = net.LookupAddr, net.LookupHost }()
+
+	h := BotHandler(&BotOptions{
+		Policies: map[string]Policy{
+			"Googlebot": Policy{Action: Throttle, Limit: 1},
+			"Bot":       Policy{Action: Block},
+			"Script":    Policy{Action: CachedOnly},
+		},
+		Cached: web.HandlerFunc(func(req *web.Request) { req.Respond(web.StatusNonAuthoritativeInformation) }),
+	}, web.HandlerFunc(func(req *web.Request) { req.Respond(web.StatusOK) }))
+
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	tests := []struct {
+		remoteAddr, userAgent string
+		status                int
+	}{
+		{"10.0.0.1:1234", "Mozilla/5.0 (X11; Linux x86_64) Firefox/5.0", web.StatusOK},
+		{"66.249.66.1:1234", googlebot, web.StatusOK},
+		{"66.249.66.1:1234", googlebot, web.StatusTooManyRequests},
+		{"10.0.0.1:1234", googlebot, web.StatusForbidden},
+		{"10.0.0.1:1234", "curl/7.21.6", web.StatusNonAuthoritativeInformation},
+	}
+	for _, tt := range tests {
+		status, _, _ := web.RunHandler("http://example.com/", "GET", web.NewHeader(web.HeaderUserAgent, tt.userAgent), nil,
+			web.HandlerFunc(func(req *web.Request) {
+				req.RemoteAddr = tt.remoteAddr
+				h.ServeWeb(req)
+			}))
+		if status != tt.status {
+			t.Errorf("%s %q: status = %d, want %d", tt.remoteAddr, tt.userAgent, status, tt.status)
+		}
+	}
+}
+
+func TestBotVerify(t *testing.T) {
+	lookups := 0
+	block := make(chan bool)
+	lookupAddr = func(addr string) ([]string, os.Error) {
+		lookups += 1
+		if addr == "10.0.0.9" {
+			<-block
+		}
+		return []string{"crawl.googlebot.com."}, nil
+	}
+	lookupHost = func(host string) ([]string, os.Error) {
+		return []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil
+	}
+	defer func() { lookupAddr, lookupHost = net.LookupAddr, net.LookupHost }()
+	defer close(block)
+
+	bh := BotHandler(&BotOptions{MaxVerified: 2, LookupTimeout: 1e6}, nil).(*botHandler)
+	domains := []string{"googlebot.com"}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3"} {
+		if !bh.verify("Googlebot", ip, domains) {
+			t.Errorf("verify(%s) = false, want true", ip)
+		}
+	}
+	if lookups != 3 {
+		t.Errorf("lookups = %d, want 3", lookups)
+	}
+	if n := len(bh.verified); n != 2 {
+		t.Errorf("cached results = %d, want 2", n)
+	}
+	if _, ok := bh.verified["Googlebot 10.0.0.2"]; ok {
+		t.Errorf("least recently used result not discarded")
+	}
+
+	if bh.verify("Googlebot", "10.0.0.9", domains) {
+		t.Errorf("verify with timed out lookup = true, want false")
+	}
+}
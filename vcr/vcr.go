@@ -0,0 +1,229 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package vcr records and replays HTTP client interactions so that tests of
+// code that calls external APIs can run without network access.
+//
+// A Transport is used as the transport for an http.Client:
+//
+//  t, err := vcr.NewTransport("testdata/twitter.json", vcr.Replay)
+//  if err != nil {
+//      t.Fatal(err)
+//  }
+//  client := &http.Client{Transport: t}
+//
+// To create or update a fixture file, run the test once with the Record mode
+// and call Save when done.
+//
+// Requests are matched by method, URL and body. The oauth_nonce,
+// oauth_timestamp and oauth_signature parameters in the URL query and in form
+// encoded bodies are ignored when matching so that requests signed with the
+// oauth package replay. Other per-request values, such as an OAuth signature
+// in the Authorization header of a request with a JSON body, are not
+// normalized; requests with such values are replayed only if the value is the
+// same as when recorded.
+package vcr
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Mode specifies whether a transport records or replays interactions.
+type Mode int
+
+const (
+	// Replay responds to requests from the interactions in the fixture
+	// file. An error is returned for requests not found in the file.
+	Replay Mode = iota
+
+	// Record sends requests to the network and records the interactions.
+	Record
+)
+
+// Interaction is a recorded request and response.
+type Interaction struct {
+	Method      string
+	URL         string
+	RequestBody string
+	StatusCode  int
+	Header      map[string][]string
+	Body        string
+}
+
+// Transport is an http.RoundTripper that records or replays interactions.
+type Transport struct {
+	// Transport used to send requests in Record mode. If nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	path string
+	mode Mode
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// NewTransport returns a transport for the fixture file at path. In Replay
+// mode, the interactions are loaded from the file.
+func NewTransport(path string, mode Mode) (*Transport, os.Error) {
+	t := &Transport{path: path, mode: mode}
+	if mode == Replay {
+		p, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(p, &t.interactions); err != nil {
+			return nil, err
+		}
+		t.used = make([]bool, len(t.interactions))
+	}
+	return t, nil
+}
+
+// Save writes the recorded interactions to the fixture file.
+func (t *Transport) Save() os.Error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.path, p, 0666)
+}
+
+func readBody(r io.ReadCloser) ([]byte, os.Error) {
+	if r == nil {
+		return nil, nil
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, os.Error) {
+	body, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if t.mode == Record {
+		return t.record(req, body)
+	}
+	return t.replay(req, body)
+}
+
+func (t *Transport) record(req *http.Request, body []byte) (*http.Response, os.Error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if req.Body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(respBody))
+	t.mu.Lock()
+	t.interactions = append(t.interactions, &Interaction{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: string(body),
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header,
+		Body:        string(respBody),
+	})
+	t.mu.Unlock()
+	return resp, nil
+}
+
+// volatileParams are the request parameters that change with each signed
+// request.
+var volatileParams = []string{"oauth_nonce=", "oauth_timestamp=", "oauth_signature="}
+
+// stripVolatile removes volatile parameters from the URL encoded string s.
+func stripVolatile(s string) string {
+	parts := strings.Split(s, "&")
+	kept := parts[:0]
+loop:
+	for _, part := range parts {
+		for _, prefix := range volatileParams {
+			if strings.HasPrefix(part, prefix) {
+				continue loop
+			}
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, "&")
+}
+
+// normalizeURL removes volatile parameters from the query of url.
+func normalizeURL(url string) string {
+	i := strings.Index(url, "?")
+	if i < 0 {
+		return url
+	}
+	return url[:i+1] + stripVolatile(url[i+1:])
+}
+
+func (t *Transport) replay(req *http.Request, body []byte) (*http.Response, os.Error) {
+	url := req.URL.String()
+	normURL := normalizeURL(url)
+	normBody := string(body)
+	form := strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+	if form {
+		normBody = stripVolatile(normBody)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, in := range t.interactions {
+		if t.used[i] || in.Method != req.Method || normalizeURL(in.URL) != normURL {
+			continue
+		}
+		inBody := in.RequestBody
+		if form {
+			inBody = stripVolatile(inBody)
+		}
+		if inBody != normBody {
+			continue
+		}
+		t.used[i] = true
+		return &http.Response{
+			Status:        strconv.Itoa(in.StatusCode) + " " + http.StatusText(in.StatusCode),
+			StatusCode:    in.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header(in.Header),
+			Body:          ioutil.NopCloser(bytes.NewBufferString(in.Body)),
+			ContentLength: int64(len(in.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, os.NewError(fmt.Sprintf("twister.vcr: no recorded interaction for %s %s", req.Method, url))
+}
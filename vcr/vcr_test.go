@@ -0,0 +1,98 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package vcr
+
+import (
+	"bytes"
+	"http"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeTransport struct{}
+
+func (fakeTransport) RoundTrip(req *http.Request) (*http.Response, os.Error) {
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString("hello " + req.URL.Path)),
+	}, nil
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	return string(p)
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	rec, _ := NewTransport(path, Record)
+	rec.Transport = fakeTransport{}
+	if s := get(t, &http.Client{Transport: rec}, "http://example.com/a"); s != "hello /a" {
+		t.Errorf("record got %q", s)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	play, err := NewTransport(path, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: play}
+	if s := get(t, client, "http://example.com/a"); s != "hello /a" {
+		t.Errorf("replay got %q", s)
+	}
+	if _, err := client.Get("http://example.com/a"); err == nil {
+		t.Error("expected error for second replay of interaction")
+	}
+}
+
+func TestReplaySigned(t *testing.T) {
+	play := &Transport{
+		mode: Replay,
+		interactions: []*Interaction{
+			{Method: "GET", URL: "http://example.com/a?oauth_nonce=1&oauth_timestamp=100&oauth_signature=x&q=go", StatusCode: 200, Body: "get"},
+			{Method: "POST", URL: "http://example.com/b", RequestBody: "oauth_nonce=1&status=hi&oauth_signature=x", StatusCode: 200, Body: "post"},
+		},
+		used: make([]bool, 2),
+	}
+	client := &http.Client{Transport: play}
+	if s := get(t, client, "http://example.com/a?oauth_nonce=2&oauth_timestamp=200&oauth_signature=y&q=go"); s != "get" {
+		t.Errorf("replay signed GET got %q", s)
+	}
+	resp, err := client.Post("http://example.com/b", "application/x-www-form-urlencoded", bytes.NewBufferString("oauth_nonce=2&status=hi&oauth_signature=y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(p) != "post" {
+		t.Errorf("replay signed POST got %q", p)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"os"
+	"strconv"
+)
+
+// APIKey describes an API key.
+type APIKey struct {
+	// Identity of the key. This is typically not the secret key value.
+	ID string
+
+	// Application defined owner of the key.
+	Owner string
+
+	// If true, requests using the key are rejected.
+	Disabled bool
+
+	// Maximum number of requests allowed in QuotaPeriod seconds. No quota is
+	// enforced if Quota is zero. The default period is 60 seconds.
+	Quota       int
+	QuotaPeriod int
+}
+
+// APIKeyLookup finds API keys.
+type APIKeyLookup interface {
+	// LookupAPIKey returns the key with the given value or nil if the key is
+	// not found.
+	LookupAPIKey(value string) (*APIKey, os.Error)
+}
+
+// APIKeyLookupFunc is a type adapter to allow the use of ordinary functions
+// as APIKeyLookup.
+type APIKeyLookupFunc func(value string) (*APIKey, os.Error)
+
+// LookupAPIKey calls f(value).
+func (f APIKeyLookupFunc) LookupAPIKey(value string) (*APIKey, os.Error) { return f(value) }
+
+// APIKeyOptions configures APIKeyHandler.
+type APIKeyOptions struct {
+	// Lookup finds keys. The application is required to set this field.
+	Lookup APIKeyLookup
+
+	// Name of the request header containing the key. The default is
+	// "X-Api-Key".
+	HeaderName string
+
+	// Name of the request parameter containing the key. The parameter is
+	// checked if the header is not present. The default is "api_key".
+	ParamName string
+
+	// Limiter for key quotas. Share a limiter between handlers to enforce a
+	// single quota across the handlers. The default is a new limiter.
+	Limiter *RateLimiter
+}
+
+// APIKeyHandler returns a handler that resolves the API key in the request
+// before calling h. Requests with a missing, unknown or disabled key are
+// rejected with status 401 or 403. Requests exceeding the key's quota are
+// rejected with status 429.
+//
+// The key is attached to the request and can be retrieved with RequestAPIKey.
+func APIKeyHandler(options *APIKeyOptions, h Handler) Handler {
+	if options.Lookup == nil {
+		panic("twister: APIKeyHandler requires Lookup option")
+	}
+	ah := &apiKeyHandler{options: *options, h: h}
+	if ah.options.HeaderName == "" {
+		ah.options.HeaderName = "X-Api-Key"
+	}
+	if ah.options.ParamName == "" {
+		ah.options.ParamName = "api_key"
+	}
+	if ah.options.Limiter == nil {
+		ah.options.Limiter = NewRateLimiter()
+	}
+	return ah
+}
+
+type apiKeyHandler struct {
+	options APIKeyOptions
+	h       Handler
+}
+
+const apiKeyEnvKey = "twister.web.APIKey"
+
+func (ah *apiKeyHandler) ServeWeb(req *Request) {
+	value := req.Header.Get(ah.options.HeaderName)
+	if value == "" {
+		value = req.Param.Get(ah.options.ParamName)
+	}
+	if value == "" {
+		req.Error(StatusUnauthorized, os.NewError("twister: API key missing"))
+		return
+	}
+	key, err := ah.options.Lookup.LookupAPIKey(value)
+	if err != nil {
+		req.Error(StatusInternalServerError, err)
+		return
+	}
+	if key == nil {
+		req.Error(StatusUnauthorized, os.NewError("twister: API key unknown"))
+		return
+	}
+	if key.Disabled {
+		req.Error(StatusForbidden, os.NewError("twister: API key disabled"))
+		return
+	}
+	if key.Quota > 0 {
+		period := int64(key.QuotaPeriod)
+		if period <= 0 {
+			period = 60
+		}
+		remaining, reset := ah.options.Limiter.Take("apikey:"+key.ID, key.Quota, period)
+		if remaining < 0 {
+			ThrottleError(req, StatusTooManyRequests, os.NewError("twister: API key quota exceeded"), reset*1e9)
+			return
+		}
+		FilterRespond(req, func(status int, header Header) (int, Header) {
+			header.Set("X-Ratelimit-Limit", strconv.Itoa(key.Quota))
+			header.Set("X-Ratelimit-Remaining", strconv.Itoa(remaining))
+			return status, header
+		})
+	}
+	req.Env[apiKeyEnvKey] = key
+	ah.h.ServeWeb(req)
+}
+
+// RequestAPIKey returns the API key attached to the request by APIKeyHandler
+// or nil if there is no key.
+func RequestAPIKey(req *Request) *APIKey {
+	key, _ := req.Env[apiKeyEnvKey].(*APIKey)
+	return key
+}
@@ -0,0 +1,77 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"os"
+	"testing"
+)
+
+var apiKeyTests = []struct {
+	url       string
+	header    Header
+	status    int
+	remaining string
+}{
+	{"/", nil, StatusUnauthorized, ""},
+	{"/", NewHeader("X-Api-Key", "unknown"), StatusUnauthorized, ""},
+	{"/", NewHeader("X-Api-Key", "disabled"), StatusForbidden, ""},
+	{"/", NewHeader("X-Api-Key", "unlimited"), StatusOK, ""},
+	{"/", NewHeader("X-Api-Key", "limited"), StatusOK, "1"},
+	{"/?api_key=limited", nil, StatusOK, "0"},
+	{"/", NewHeader("X-Api-Key", "limited"), StatusTooManyRequests, ""},
+	{"/", NewHeader("X-Api-Key", "error"), StatusInternalServerError, ""},
+}
+
+func TestAPIKeyHandler(t *testing.T) {
+	clock := NewFakeClock(1000e9)
+	defer SetClock(SetClock(clock))
+
+	keys := map[string]*APIKey{
+		"disabled":  &APIKey{ID: "1", Disabled: true},
+		"unlimited": &APIKey{ID: "2"},
+		"limited":   &APIKey{ID: "3", Quota: 2, QuotaPeriod: 10},
+	}
+	h := APIKeyHandler(&APIKeyOptions{
+		Lookup: APIKeyLookupFunc(func(value string) (*APIKey, os.Error) {
+			if value == "error" {
+				return nil, os.NewError("lookup failed")
+			}
+			return keys[value], nil
+		}),
+	}, HandlerFunc(func(req *Request) {
+		if RequestAPIKey(req) == nil {
+			t.Errorf("%s: no API key attached to request", req.URL)
+		}
+		req.Respond(StatusOK)
+	}))
+
+	for i, tt := range apiKeyTests {
+		status, header, _ := RunHandler(tt.url, "GET", tt.header, nil, h)
+		if status != tt.status {
+			t.Errorf("%d: status = %d, want %d", i, status, tt.status)
+		}
+		if remaining := header.Get("X-Ratelimit-Remaining"); remaining != tt.remaining {
+			t.Errorf("%d: X-Ratelimit-Remaining = %q, want %q", i, remaining, tt.remaining)
+		}
+		if status == StatusTooManyRequests && header.Get(HeaderRetryAfter) != "10" {
+			t.Errorf("%d: Retry-After = %q, want 10", i, header.Get(HeaderRetryAfter))
+		}
+	}
+
+	clock.Advance(10e9)
+	if status, _, _ := RunHandler("/", "GET", NewHeader("X-Api-Key", "limited"), nil, h); status != StatusOK {
+		t.Errorf("after period, status = %d, want %d", status, StatusOK)
+	}
+}
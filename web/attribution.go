@@ -0,0 +1,136 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"http"
+)
+
+// Attribution is the traffic source of a visitor.
+type Attribution struct {
+	// Referrer of the first request, if the referrer is another site.
+	Referrer string
+
+	// Values of the utm_source, utm_medium, utm_campaign, utm_term and
+	// utm_content request parameters.
+	Source   string
+	Medium   string
+	Campaign string
+	Term     string
+	Content  string
+
+	// Path of the first request.
+	LandingPage string
+}
+
+// AttributionOptions configures AttributionHandler.
+type AttributionOptions struct {
+	// Secret used to sign the cookie. The application is required to set
+	// this field.
+	Secret string
+
+	// Name of the cookie. The default is "attr".
+	CookieName string
+
+	// Maximum age of the cookie in seconds. The default is 30 days.
+	MaxAge int
+}
+
+// AttributionHandler returns a handler that captures the referrer and UTM
+// parameters of a visitor's first request in a signed cookie. A first
+// request is captured if it has UTM parameters or a referrer from another
+// site. The attribution is available to h through RequestAttribution on the
+// first and following requests.
+func AttributionHandler(options *AttributionOptions, h Handler) Handler {
+	if options.Secret == "" {
+		panic("twister: AttributionHandler requires Secret option")
+	}
+	ah := &attributionHandler{options: *options, h: h}
+	if ah.options.CookieName == "" {
+		ah.options.CookieName = "attr"
+	}
+	if ah.options.MaxAge <= 0 {
+		ah.options.MaxAge = 30 * 24 * 60 * 60
+	}
+	return ah
+}
+
+type attributionHandler struct {
+	options AttributionOptions
+	h       Handler
+}
+
+const attributionEnvKey = "twister.web.Attribution"
+
+func (ah *attributionHandler) ServeWeb(req *Request) {
+	if s, err := VerifyValue(ah.options.Secret, ah.options.CookieName, req.Cookie.Get(ah.options.CookieName)); err == nil {
+		m := make(Values)
+		if m.ParseFormEncodedBytes([]byte(s)) == nil {
+			req.Env[attributionEnvKey] = &Attribution{
+				Referrer:    m.Get("r"),
+				Source:      m.Get("s"),
+				Medium:      m.Get("m"),
+				Campaign:    m.Get("c"),
+				Term:        m.Get("t"),
+				Content:     m.Get("o"),
+				LandingPage: m.Get("l"),
+			}
+		}
+	} else if a := ah.capture(req); a != nil {
+		req.Env[attributionEnvKey] = a
+		m := NewValues(
+			"r", a.Referrer,
+			"s", a.Source,
+			"m", a.Medium,
+			"c", a.Campaign,
+			"t", a.Term,
+			"o", a.Content,
+			"l", a.LandingPage)
+		value := SignValue(ah.options.Secret, ah.options.CookieName, ah.options.MaxAge, m.FormEncodedString())
+		c := NewCookie(ah.options.CookieName, value).MaxAge(ah.options.MaxAge).String()
+		FilterRespond(req, func(status int, header Header) (int, Header) {
+			header.Add(HeaderSetCookie, c)
+			return status, header
+		})
+	}
+	ah.h.ServeWeb(req)
+}
+
+func (ah *attributionHandler) capture(req *Request) *Attribution {
+	a := &Attribution{
+		Source:      req.Param.Get("utm_source"),
+		Medium:      req.Param.Get("utm_medium"),
+		Campaign:    req.Param.Get("utm_campaign"),
+		Term:        req.Param.Get("utm_term"),
+		Content:     req.Param.Get("utm_content"),
+		LandingPage: req.URL.Path,
+	}
+	if referrer := req.Header.Get(HeaderReferer); referrer != "" {
+		if u, err := http.ParseURL(referrer); err == nil && u.Host != "" && u.Host != req.URL.Host {
+			a.Referrer = referrer
+		}
+	}
+	if a.Referrer == "" && a.Source == "" && a.Medium == "" && a.Campaign == "" && a.Term == "" && a.Content == "" {
+		return nil
+	}
+	return a
+}
+
+// RequestAttribution returns the attribution captured by AttributionHandler
+// or nil if there is no attribution for the visitor.
+func RequestAttribution(req *Request) *Attribution {
+	a, _ := req.Env[attributionEnvKey].(*Attribution)
+	return a
+}
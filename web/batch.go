@@ -0,0 +1,147 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+var batchRequestLineRegexp = regexp.MustCompile("^([_A-Za-z0-9]+) ([^ ]+)( HTTP/[0-9]+\\.[0-9]+)?[ ]*$")
+
+// BatchHandler returns a handler for batch requests. The body of a batch
+// request is a multipart/mixed entity where each part has the content type
+// application/http and contains an HTTP request:
+//
+//  --batch
+//  Content-Type: application/http
+//  Content-Id: 1
+//
+//  GET /items/1 HTTP/1.1
+//
+//  --batch--
+//
+// The handler dispatches each embedded request to h without network I/O and
+// responds with a multipart/mixed entity containing the responses in the
+// same order. The Content-Id header of each request part is copied to the
+// corresponding response part.
+//
+// The maxRequestBodyLen argument limits the size of the batch request body
+// and maxRequests limits the number of embedded requests.
+func BatchHandler(maxRequestBodyLen int, maxRequests int, h Handler) Handler {
+	return &batchHandler{maxRequestBodyLen: maxRequestBodyLen, maxRequests: maxRequests, h: h}
+}
+
+type batchHandler struct {
+	maxRequestBodyLen int
+	maxRequests       int
+	h                 Handler
+}
+
+type batchResult struct {
+	contentID string
+	resp      *RecordedResponse
+}
+
+func (bh *batchHandler) ServeWeb(req *Request) {
+	if req.ContentType != "multipart/mixed" {
+		req.Error(StatusUnsupportedMediaType, os.NewError("twister: batch request not multipart/mixed"))
+		return
+	}
+	m, err := newMultipartReader(req, bh.maxRequestBodyLen)
+	if err != nil {
+		req.Error(StatusBadRequest, err)
+		return
+	}
+	var results []batchResult
+	for {
+		partHeader, r, err := m.Next()
+		if err == os.EOF {
+			break
+		} else if err != nil {
+			req.Error(StatusBadRequest, err)
+			return
+		}
+		if len(results) >= bh.maxRequests {
+			req.Error(StatusRequestEntityTooLarge, os.NewError("twister: too many requests in batch"))
+			return
+		}
+		if ct, _ := partHeader.GetValueParam(HeaderContentType); ct != "application/http" {
+			req.Error(StatusBadRequest, os.NewError("twister: batch part not application/http"))
+			return
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			req.Error(StatusBadRequest, err)
+			return
+		}
+		resp, err := bh.dispatchPart(req, p)
+		if err != nil {
+			req.Error(StatusBadRequest, err)
+			return
+		}
+		results = append(results, batchResult{partHeader.Get("Content-Id"), resp})
+	}
+
+	boundary := "batch_" + RandomHex(12)
+
+	w := req.Respond(StatusOK, HeaderContentType, "multipart/mixed; boundary="+boundary)
+	for _, result := range results {
+		var b bytes.Buffer
+		b.WriteString("--" + boundary + "\r\n")
+		b.WriteString(HeaderContentType + ": application/http\r\n")
+		if result.contentID != "" {
+			b.WriteString("Content-Id: " + result.contentID + "\r\n")
+		}
+		b.WriteString("\r\n")
+		b.WriteString("HTTP/1.1 " + strconv.Itoa(result.resp.Status) + " " + StatusText(result.resp.Status) + "\r\n")
+		result.resp.Header.WriteHttpHeader(&b)
+		b.Write(result.resp.Body)
+		b.WriteString("\r\n")
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return
+		}
+	}
+	io.WriteString(w, "--"+boundary+"--\r\n")
+}
+
+// dispatchPart parses the HTTP request in p and dispatches the request to the
+// handler.
+func (bh *batchHandler) dispatchPart(parent *Request, p []byte) (*RecordedResponse, os.Error) {
+	br := bufio.NewReader(bytes.NewBuffer(p))
+	line, isPrefix, err := br.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if isPrefix {
+		return nil, ErrLineTooLong
+	}
+	m := batchRequestLineRegexp.FindSubmatch(line)
+	if m == nil {
+		return nil, os.NewError("twister: bad batch request line")
+	}
+	header := Header{}
+	// A missing blank line after the headers is allowed at the end of the
+	// part.
+	if err := header.ParseHttpHeader(br); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return dispatch(bh.h, parent, string(m[1]), string(m[2]), header, br)
+}
@@ -0,0 +1,69 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBatchHandler(t *testing.T) {
+	r := NewRouter()
+	r.Register("/a", "GET", routeTestHandler("a-get"))
+	r.Register("/b", "POST", HandlerFunc(func(req *Request) {
+		p, _ := req.BodyBytes(-1)
+		req.Respond(StatusCreated).Write(p)
+	}))
+
+	body := "--xyz\r\n" +
+		"Content-Type: application/http\r\n" +
+		"Content-Id: 1\r\n" +
+		"\r\n" +
+		"GET /a HTTP/1.1\r\n" +
+		"\r\n--xyz\r\n" +
+		"Content-Type: application/http\r\n" +
+		"Content-Id: 2\r\n" +
+		"\r\n" +
+		"POST /b HTTP/1.1\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello" +
+		"\r\n--xyz\r\n" +
+		"Content-Type: application/http\r\n" +
+		"\r\n" +
+		"GET /c HTTP/1.1\r\n" +
+		"\r\n--xyz--\r\n"
+
+	status, header, respBody := RunHandler("http://example.com/batch", "POST",
+		NewHeader(HeaderContentType, "multipart/mixed; boundary=xyz"), []byte(body), BatchHandler(-1, 10, r))
+	if status != StatusOK {
+		t.Fatalf("status=%d, want %d", status, StatusOK)
+	}
+	if !strings.HasPrefix(header.Get(HeaderContentType), "multipart/mixed; boundary=") {
+		t.Errorf("content type=%q", header.Get(HeaderContentType))
+	}
+	s := string(respBody)
+	for _, want := range []string{
+		"Content-Id: 1\r\n\r\nHTTP/1.1 200 OK\r\n",
+		"a-get",
+		"Content-Id: 2\r\n\r\nHTTP/1.1 201 Created\r\n",
+		"hello",
+		"HTTP/1.1 404 Not Found\r\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("response does not contain %q\n%s", want, s)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CacheControl represents the directives of a Cache-Control response header.
+//
+//  web.CacheControl{Public: true, MaxAge: 3600}.Set(header)
+type CacheControl struct {
+	// MaxAge and SMaxAge are included in the header if greater than zero.
+	MaxAge  int
+	SMaxAge int
+
+	Public          bool
+	Private         bool
+	NoCache         bool
+	NoStore         bool
+	NoTransform     bool
+	MustRevalidate  bool
+	ProxyRevalidate bool
+}
+
+// String returns the Cache-Control header value.
+func (cc CacheControl) String() string {
+	var directives []string
+	add := func(b bool, s string) {
+		if b {
+			directives = append(directives, s)
+		}
+	}
+	add(cc.Public, "public")
+	add(cc.Private, "private")
+	add(cc.NoCache, "no-cache")
+	add(cc.NoStore, "no-store")
+	add(cc.NoTransform, "no-transform")
+	add(cc.MustRevalidate, "must-revalidate")
+	add(cc.ProxyRevalidate, "proxy-revalidate")
+	if cc.MaxAge > 0 {
+		directives = append(directives, "max-age="+strconv.Itoa(cc.MaxAge))
+	}
+	if cc.SMaxAge > 0 {
+		directives = append(directives, "s-maxage="+strconv.Itoa(cc.SMaxAge))
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Set sets the Cache-Control header in header.
+func (cc CacheControl) Set(header Header) {
+	header.Set(HeaderCacheControl, cc.String())
+}
+
+// CacheControlHandler returns a handler that sets the Cache-Control header to
+// cc on responses from h that do not have a Cache-Control header.
+func CacheControlHandler(cc CacheControl, h Handler) Handler {
+	value := cc.String()
+	return HandlerFunc(func(req *Request) {
+		FilterRespond(req, func(status int, header Header) (int, Header) {
+			if _, found := header[HeaderCacheControl]; !found {
+				header.Set(HeaderCacheControl, value)
+			}
+			return status, header
+		})
+		h.ServeWeb(req)
+	})
+}
+
+// Cache sets the default Cache-Control header for responses from the most
+// recently registered route. The default is used when the handler does not
+// set the Cache-Control header.
+//
+//  r.Register("/static/<path:.*>", "GET", serveStatic).Cache(&web.CacheControl{Public: true, MaxAge: 86400})
+func (router *Router) Cache(cc *CacheControl) *Router {
+	if router.last == nil {
+		panic("twister: Cache called before Register")
+	}
+	router.last.cache = cc
+	return router
+}
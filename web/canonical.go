@@ -0,0 +1,107 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strings"
+)
+
+// CanonicalHostOptions configures CanonicalHostHandler.
+type CanonicalHostOptions struct {
+	// Canonical host, for example "www.example.com". If empty, the host is
+	// not checked.
+	Host string
+
+	// Canonical scheme, "http" or "https". If empty, the scheme is not
+	// checked.
+	Scheme string
+
+	// Names of headers set by a trusted proxy with the original request
+	// host and scheme, for example "X-Forwarded-Host" and
+	// "X-Forwarded-Proto". The headers are ignored if the names are empty.
+	// Only set these fields when the server is behind a proxy that sets or
+	// strips the headers. If the request has a Forwarded header, the host
+	// and proto parameters of the header's last element are preferred over
+	// the named headers.
+	HostHeader   string
+	SchemeHeader string
+}
+
+// CanonicalHostHandler returns a handler that redirects requests to the
+// canonical host and scheme. GET and HEAD requests are redirected with status
+// 301. Other requests are redirected with status 307 so that clients repeat
+// the request method and body.
+//
+//  h = web.CanonicalHostHandler(&web.CanonicalHostOptions{
+//      Host:         "www.example.com",
+//      Scheme:       "https",
+//      SchemeHeader: "X-Forwarded-Proto",
+//  }, h)
+func CanonicalHostHandler(options *CanonicalHostOptions, h Handler) Handler {
+	ch := &canonicalHostHandler{options: *options, h: h}
+	ch.options.Host = strings.ToLower(ch.options.Host)
+	ch.options.HostHeader = HeaderName(ch.options.HostHeader)
+	ch.options.SchemeHeader = HeaderName(ch.options.SchemeHeader)
+	return ch
+}
+
+type canonicalHostHandler struct {
+	options CanonicalHostOptions
+	h       Handler
+}
+
+func (ch *canonicalHostHandler) ServeWeb(req *Request) {
+	f := lastForwarded(req.Header)
+	host := req.URL.Host
+	if ch.options.HostHeader != "" {
+		if s := f["host"]; s != "" {
+			host = s
+		} else if s := req.Header.Get(ch.options.HostHeader); s != "" {
+			host = s
+		}
+	}
+	scheme := req.URL.Scheme
+	if ch.options.SchemeHeader != "" {
+		if s := f["proto"]; s != "" {
+			scheme = strings.ToLower(s)
+		} else if s := req.Header.Get(ch.options.SchemeHeader); s != "" {
+			scheme = strings.ToLower(s)
+		}
+	}
+
+	redirect := false
+	if ch.options.Host != "" && strings.ToLower(host) != ch.options.Host {
+		host = ch.options.Host
+		redirect = true
+	}
+	if ch.options.Scheme != "" && scheme != ch.options.Scheme {
+		scheme = ch.options.Scheme
+		redirect = true
+	}
+	if !redirect {
+		ch.h.ServeWeb(req)
+		return
+	}
+
+	url := scheme + "://" + host + escapePath(req.URL.Path)
+	if req.URL.RawQuery != "" {
+		url += "?" + req.URL.RawQuery
+	}
+	status := StatusMovedPermanently
+	if req.Method != "GET" && req.Method != "HEAD" {
+		status = StatusTemporaryRedirect
+	}
+	req.Respond(status, HeaderLocation, url)
+}
@@ -0,0 +1,70 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of the current time. Twister reads the time from the
+// package clock when computing expirations and limits, for example signed
+// value and cookie expiry, sessions, rate limiter windows and cache ages.
+// Tests replace the package clock with a FakeClock to advance time without
+// sleeping.
+type Clock interface {
+	// Nanoseconds returns the current time in nanoseconds since the epoch.
+	Nanoseconds() int64
+}
+
+type systemClock struct{}
+
+func (systemClock) Nanoseconds() int64 { return time.Nanoseconds() }
+
+var (
+	clockMu sync.RWMutex
+	clock   Clock = systemClock{}
+)
+
+// SetClock sets the package clock and returns the previous clock. If c is
+// nil, the system clock is used. SetClock is intended for tests:
+//
+//  c := web.NewFakeClock(1e18)
+//  defer web.SetClock(web.SetClock(c))
+func SetClock(c Clock) Clock {
+	if c == nil {
+		c = systemClock{}
+	}
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	prev := clock
+	clock = c
+	return prev
+}
+
+// Nanoseconds returns the current time from the package clock in
+// nanoseconds since the epoch.
+func Nanoseconds() int64 {
+	clockMu.RLock()
+	c := clock
+	clockMu.RUnlock()
+	return c.Nanoseconds()
+}
+
+// Seconds returns the current time from the package clock in seconds since
+// the epoch.
+func Seconds() int64 {
+	return Nanoseconds() / 1e9
+}
@@ -0,0 +1,278 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressOptions configures CompressHandler.
+type CompressOptions struct {
+	// Responses smaller than MinSize bytes are not compressed. Responses
+	// without a Content-Length header are buffered until MinSize bytes are
+	// written. The default is 1024.
+	MinSize int
+
+	// Content types eligible for compression. A value ending with "/"
+	// matches all subtypes. The default is DefaultCompressContentTypes.
+	ContentTypes []string
+
+	// Compression level from 1 (best speed) to 9 (best compression). The
+	// default is flate.DefaultCompression. Encoders registered with
+	// RegisterEncoder interpret the level as appropriate for the coding.
+	Level int
+
+	// Content codings in order of preference. When the client accepts more
+	// than one coding with the same quality, the coding listed first is
+	// used. Codings without a registered encoder are skipped. The default
+	// is DefaultCompressPreference.
+	Preference []string
+}
+
+// DefaultCompressPreference is the default value of
+// CompressOptions.Preference.
+var DefaultCompressPreference = []string{"br", "gzip", "deflate"}
+
+// DefaultCompressContentTypes is the default value of
+// CompressOptions.ContentTypes.
+var DefaultCompressContentTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/atom+xml",
+	"application/rss+xml",
+	"image/svg+xml",
+}
+
+// Encoder creates a compressor for a content coding. The compressor writes
+// to w. Close is called on the compressor after the last write.
+type Encoder func(w io.Writer, level int) (io.WriteCloser, os.Error)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		"gzip":    func(w io.Writer, level int) (io.WriteCloser, os.Error) { return gzip.NewWriterLevel(w, level) },
+		"deflate": func(w io.Writer, level int) (io.WriteCloser, os.Error) { return zlib.NewWriterLevel(w, level) },
+	}
+)
+
+// RegisterEncoder registers the encoder for a content coding. Use
+// RegisterEncoder to add codings such as brotli that are not implemented in
+// this package:
+//
+//  web.RegisterEncoder("br", func(w io.Writer, level int) (io.WriteCloser, os.Error) {
+//      return brotli.NewWriter(w, level)
+//  })
+//
+// Registering an encoder for a coding replaces the previous encoder.
+func RegisterEncoder(coding string, e Encoder) {
+	encodersMu.Lock()
+	encoders[coding] = e
+	encodersMu.Unlock()
+}
+
+func lookupEncoder(coding string) Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	return encoders[coding]
+}
+
+// CompressHandler returns a handler that compresses responses from h using
+// the gzip or deflate content coding or a coding registered with
+// RegisterEncoder. The coding is negotiated with the
+// Accept-Encoding request header, honoring quality values. Responses are not
+// compressed when the content type is not eligible, the response is smaller
+// than the minimum size, the response already has a Content-Encoding, the
+// response has Cache-Control: no-transform or the status is not a 2xx status
+// with a body.
+func CompressHandler(options *CompressOptions, h Handler) Handler {
+	ch := &compressHandler{options: *options, h: h}
+	if ch.options.MinSize <= 0 {
+		ch.options.MinSize = 1024
+	}
+	if ch.options.ContentTypes == nil {
+		ch.options.ContentTypes = DefaultCompressContentTypes
+	}
+	if ch.options.Level == 0 {
+		ch.options.Level = flate.DefaultCompression
+	}
+	if ch.options.Preference == nil {
+		ch.options.Preference = DefaultCompressPreference
+	}
+	return ch
+}
+
+type compressHandler struct {
+	options CompressOptions
+	h       Handler
+}
+
+// negotiate returns the coding and encoder for the request or nil if the
+// response should not be compressed.
+func (ch *compressHandler) negotiate(header Header) (string, Encoder) {
+	var best Encoder
+	var bestCoding string
+	bestQ := float64(0)
+	for _, coding := range ch.options.Preference {
+		e := lookupEncoder(coding)
+		if e == nil {
+			continue
+		}
+		if q := header.AcceptEncodingQuality(coding); q > bestQ {
+			best, bestCoding, bestQ = e, coding, q
+		}
+	}
+	if best == nil || bestQ < header.AcceptEncodingQuality("identity") {
+		return "", nil
+	}
+	return bestCoding, best
+}
+
+func (ch *compressHandler) eligibleType(header Header) bool {
+	contentType := header.Get(HeaderContentType)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, t := range ch.options.ContentTypes {
+		if contentType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *compressHandler) ServeWeb(req *Request) {
+	cr := &compressResponder{Responder: req.Responder, ch: ch, head: req.Method == "HEAD"}
+	cr.coding, cr.enc = ch.negotiate(req.Header)
+	req.Responder = cr
+	ch.h.ServeWeb(req)
+	cr.finish()
+}
+
+type compressResponder struct {
+	Responder
+	ch     *compressHandler
+	coding string
+	enc    Encoder
+	head   bool
+
+	status int
+	header Header
+	buf    bytes.Buffer
+	body   io.Writer      // response body from the wrapped responder
+	cw     io.WriteCloser // compressor writing to body or nil
+}
+
+func (cr *compressResponder) Respond(status int, header Header) io.Writer {
+	if !cr.ch.eligibleType(header) {
+		return cr.Responder.Respond(status, header)
+	}
+	header.AddVary(HeaderAcceptEncoding)
+	_, noTransform := header.GetCacheControl()["no-transform"]
+	if cr.enc == nil ||
+		cr.head ||
+		status < 200 || status >= 300 || status == StatusNoContent || status == StatusPartialContent ||
+		header.Get(HeaderContentEncoding) != "" ||
+		noTransform {
+		return cr.Responder.Respond(status, header)
+	}
+	if s := header.Get(HeaderContentLength); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n < cr.ch.options.MinSize {
+			return cr.Responder.Respond(status, header)
+		}
+	}
+	cr.status = status
+	cr.header = header
+	return cr
+}
+
+// start starts the compressed response and writes the buffered data.
+func (cr *compressResponder) start() os.Error {
+	header := cr.header
+	header.Set(HeaderContentEncoding, cr.coding)
+	header[HeaderContentLength] = nil, false
+	if etag := header.Get(HeaderEtag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set(HeaderEtag, "W/"+etag)
+	}
+	cr.body = cr.Responder.Respond(cr.status, header)
+	cw, err := cr.enc(cr.body, cr.ch.options.Level)
+	if err != nil {
+		return err
+	}
+	cr.cw = cw
+	_, err = cr.cw.Write(cr.buf.Bytes())
+	cr.buf.Reset()
+	return err
+}
+
+// Write buffers the response body until the minimum size is reached.
+func (cr *compressResponder) Write(p []byte) (int, os.Error) {
+	switch {
+	case cr.cw != nil:
+		return cr.cw.Write(p)
+	case cr.body != nil:
+		return cr.body.Write(p)
+	}
+	cr.buf.Write(p)
+	if cr.buf.Len() >= cr.ch.options.MinSize {
+		if err := cr.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush flushes the compressor and the underlying response body if they
+// support flushing.
+func (cr *compressResponder) Flush() os.Error {
+	if cr.body == nil {
+		if err := cr.start(); err != nil {
+			return err
+		}
+	}
+	for _, w := range []interface{}{cr.cw, cr.body} {
+		if f, ok := w.(interface {
+			Flush() os.Error
+		}); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finish completes the response. A response smaller than the minimum size
+// is sent uncompressed.
+func (cr *compressResponder) finish() {
+	switch {
+	case cr.cw != nil:
+		cr.cw.Close()
+	case cr.header != nil && cr.body == nil:
+		cr.header.Set(HeaderContentLength, strconv.Itoa(cr.buf.Len()))
+		cr.body = cr.Responder.Respond(cr.status, cr.header)
+		cr.body.Write(cr.buf.Bytes())
+	}
+}
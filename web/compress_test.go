@@ -0,0 +1,134 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var compressTests = []struct {
+	acceptEncoding string
+	contentType    string
+	size           int
+	contentLength  bool
+	coding         string
+}{
+	{"gzip, deflate", "text/html; charset=utf-8", 2000, false, "gzip"},
+	{"gzip, deflate", "text/html", 2000, true, "gzip"},
+	{"gzip;q=0.5, deflate", "application/json", 2000, false, "deflate"},
+	{"gzip, deflate", "text/html", 100, false, ""},
+	{"gzip, deflate", "text/html", 100, true, ""},
+	{"gzip", "image/png", 2000, false, ""},
+	{"", "text/html", 2000, false, ""},
+	{"gzip;q=0", "text/html", 2000, false, ""},
+	{"gzip;q=0.5, identity", "text/html", 2000, false, ""},
+}
+
+func TestCompressHandler(t *testing.T) {
+	for _, tt := range compressTests {
+		content := strings.Repeat("a", tt.size)
+		h := CompressHandler(&CompressOptions{}, HandlerFunc(func(req *Request) {
+			header := NewHeader(HeaderContentType, tt.contentType)
+			if tt.contentLength {
+				header.Set(HeaderContentLength, strconv.Itoa(len(content)))
+			}
+			w := req.Responder.Respond(StatusOK, header)
+			// Write in pieces to exercise buffering.
+			io.WriteString(w, content[:len(content)/2])
+			io.WriteString(w, content[len(content)/2:])
+		}))
+		reqHeader := Header{}
+		if tt.acceptEncoding != "" {
+			reqHeader.Set(HeaderAcceptEncoding, tt.acceptEncoding)
+		}
+		_, header, body := RunHandler("http://example.com/", "GET", reqHeader, nil, h)
+
+		coding := header.Get(HeaderContentEncoding)
+		if coding != tt.coding {
+			t.Errorf("%q %q %d: coding = %q, want %q", tt.acceptEncoding, tt.contentType, tt.size, coding, tt.coding)
+			continue
+		}
+		var r io.Reader = bytes.NewBuffer(body)
+		var err os.Error
+		switch coding {
+		case "gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = zlib.NewReader(r)
+		default:
+			// Small responses are buffered and sent with a Content-Length.
+			if tt.size < 1024 && header.Get(HeaderContentLength) != strconv.Itoa(tt.size) {
+				t.Errorf("%q %q %d: Content-Length = %q", tt.acceptEncoding, tt.contentType, tt.size, header.Get(HeaderContentLength))
+			}
+		}
+		if err != nil {
+			t.Errorf("%q %q %d: %v", tt.acceptEncoding, tt.contentType, tt.size, err)
+			continue
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil || string(p) != content {
+			t.Errorf("%q %q %d: body = %q, %v", tt.acceptEncoding, tt.contentType, tt.size, p, err)
+		}
+		if tt.contentType != "image/png" && header.Get(HeaderVary) != HeaderAcceptEncoding {
+			t.Errorf("%q %q %d: Vary = %q", tt.acceptEncoding, tt.contentType, tt.size, header.Get(HeaderVary))
+		}
+	}
+}
+
+type upperEncoder struct{ w io.Writer }
+
+func (e upperEncoder) Write(p []byte) (int, os.Error) { return e.w.Write(bytes.ToUpper(p)) }
+func (e upperEncoder) Close() os.Error                { return nil }
+
+func TestCompressPreference(t *testing.T) {
+	RegisterEncoder("br", func(w io.Writer, level int) (io.WriteCloser, os.Error) { return upperEncoder{w}, nil })
+	defer func() {
+		encodersMu.Lock()
+		encoders["br"] = nil, false
+		encodersMu.Unlock()
+	}()
+
+	tests := []struct {
+		preference     []string
+		acceptEncoding string
+		coding         string
+	}{
+		{nil, "gzip, deflate, br", "br"},
+		{nil, "gzip, deflate, br;q=0.5", "gzip"},
+		{[]string{"deflate", "gzip"}, "gzip, deflate, br", "deflate"},
+		{[]string{"zstd", "gzip"}, "zstd, gzip", "gzip"},
+	}
+	content := strings.Repeat("a", 2000)
+	for _, tt := range tests {
+		h := CompressHandler(&CompressOptions{Preference: tt.preference}, HandlerFunc(func(req *Request) {
+			io.WriteString(req.Respond(StatusOK, HeaderContentType, "text/plain"), content)
+		}))
+		_, header, body := RunHandler("http://example.com/", "GET", NewHeader(HeaderAcceptEncoding, tt.acceptEncoding), nil, h)
+		if coding := header.Get(HeaderContentEncoding); coding != tt.coding {
+			t.Errorf("%v %q: coding = %q, want %q", tt.preference, tt.acceptEncoding, coding, tt.coding)
+		}
+		if tt.coding == "br" && string(body) != strings.ToUpper(content) {
+			t.Errorf("%v %q: body not encoded with registered encoder", tt.preference, tt.acceptEncoding)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// CSVOptions specifies the format of CSV data.
+type CSVOptions struct {
+	// Field delimiter. The default is ','.
+	Comma int
+
+	// Write a UTF-8 byte order mark at the start of the response. Excel uses
+	// the mark to detect the encoding of the file.
+	BOM bool
+
+	// If not empty, the Content-Disposition header is set to attachment with
+	// this filename.
+	Filename string
+}
+
+var defaultCSVOptions CSVOptions
+
+func (options *CSVOptions) comma() int {
+	if options.Comma == 0 {
+		return ','
+	}
+	return options.Comma
+}
+
+// CSVWriter writes CSV rows to a response.
+type CSVWriter struct {
+	w     *bufio.Writer
+	comma int
+	buf   bytes.Buffer
+}
+
+// NewCSVResponse responds to the request with status 200 and content type
+// text/csv. If header is not empty, the header is written as the first row.
+// The caller must call Flush after writing the last row.
+func NewCSVResponse(req *Request, options *CSVOptions, header ...string) *CSVWriter {
+	if options == nil {
+		options = &defaultCSVOptions
+	}
+	h := NewHeader(HeaderContentType, "text/csv; charset=utf-8")
+	if options.Filename != "" {
+		SetAttachment(h, options.Filename)
+	}
+	cw := &CSVWriter{w: bufio.NewWriter(req.Responder.Respond(StatusOK, h)), comma: options.comma()}
+	if options.BOM {
+		cw.w.WriteString("\ufeff")
+	}
+	if len(header) > 0 {
+		cw.WriteRow(header...)
+	}
+	return cw
+}
+
+// WriteRow writes a row. Fields containing the delimiter, quotes or line
+// breaks are quoted.
+func (cw *CSVWriter) WriteRow(fields ...string) os.Error {
+	for i, field := range fields {
+		if i > 0 {
+			cw.w.WriteRune(cw.comma)
+		}
+		if !strings.ContainsAny(field, "\"\r\n"+string(cw.comma)) {
+			cw.w.WriteString(field)
+			continue
+		}
+		cw.w.WriteByte('"')
+		cw.w.WriteString(strings.Replace(field, `"`, `""`, -1))
+		cw.w.WriteByte('"')
+	}
+	_, err := cw.w.WriteString("\r\n")
+	return err
+}
+
+// Flush writes buffered data to the response.
+func (cw *CSVWriter) Flush() os.Error {
+	return cw.w.Flush()
+}
+
+// ErrCSVSyntax is returned by ParseCSV for malformed CSV data.
+var ErrCSVSyntax = os.NewError("twister: CSV syntax error")
+
+// ParseCSV reads CSV data from the request body and calls fn for each row. A
+// leading byte order mark is ignored. If the body is longer than maxLen
+// bytes, then ErrRequestEntityTooLarge is returned. If fn returns an error,
+// then parsing stops and the error is returned.
+func ParseCSV(req *Request, options *CSVOptions, maxLen int, fn func(row []string) os.Error) os.Error {
+	if options == nil {
+		options = &defaultCSVOptions
+	}
+	if req.ContentLength > maxLen {
+		return ErrRequestEntityTooLarge
+	}
+	lr := &io.LimitedReader{R: req.Body, N: int64(maxLen) + 1}
+	br := bufio.NewReader(lr)
+	if r, _, err := br.ReadRune(); err == nil && r != '\ufeff' {
+		br.UnreadRune()
+	}
+	comma := options.comma()
+	for {
+		row, err := readCSVRow(br, comma)
+		if lr.N <= 0 {
+			return ErrRequestEntityTooLarge
+		}
+		if err == os.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCSVRow reads a single row from br.
+func readCSVRow(br *bufio.Reader, comma int) ([]string, os.Error) {
+	var (
+		row    []string
+		field  bytes.Buffer
+		quoted bool
+		start  = true
+	)
+	for {
+		r, _, err := br.ReadRune()
+		if err == os.EOF {
+			if quoted {
+				return nil, ErrCSVSyntax
+			}
+			if start && len(row) == 0 {
+				return nil, os.EOF
+			}
+			return append(row, field.String()), nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case quoted:
+			if r != '"' {
+				field.WriteRune(r)
+				break
+			}
+			r, _, err = br.ReadRune()
+			if err == nil && r == '"' {
+				field.WriteRune('"')
+			} else {
+				quoted = false
+				if err == nil {
+					br.UnreadRune()
+				}
+			}
+		case start && r == '"':
+			quoted = true
+			start = false
+		case r == comma:
+			row = append(row, field.String())
+			field.Reset()
+			start = true
+		case r == '\r':
+			// Ignore carriage returns outside of quotes.
+		case r == '\n':
+			if start && len(row) == 0 {
+				// Skip blank line.
+				continue
+			}
+			return append(row, field.String()), nil
+		default:
+			field.WriteRune(r)
+			start = false
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+var csvRows = [][]string{
+	{"name", "comment"},
+	{"a", "hello, world"},
+	{"b", `say "hi"`},
+	{"c", "line1\nline2"},
+	{"", ""},
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	_, header, p := RunHandler("/", "GET", nil, nil, HandlerFunc(func(req *Request) {
+		cw := NewCSVResponse(req, &CSVOptions{BOM: true, Filename: "x.csv"}, csvRows[0]...)
+		for _, row := range csvRows[1:] {
+			cw.WriteRow(row...)
+		}
+		cw.Flush()
+	}))
+	if ct := header.Get(HeaderContentType); ct != "text/csv; charset=utf-8" {
+		t.Errorf("content-type=%q", ct)
+	}
+
+	var rows [][]string
+	_, _, _ = RunHandler("/", "POST", NewHeader(HeaderContentType, "text/csv"), p, HandlerFunc(func(req *Request) {
+		err := ParseCSV(req, nil, 1000, func(row []string) os.Error {
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		req.Respond(StatusOK)
+	}))
+	if !reflect.DeepEqual(rows, csvRows) {
+		t.Errorf("rows=%q, want %q", rows, csvRows)
+	}
+}
+
+func TestParseCSVTooLarge(t *testing.T) {
+	RunHandler("/", "POST", nil, []byte("a,b\nc,d\n"), HandlerFunc(func(req *Request) {
+		err := ParseCSV(req, nil, 4, func(row []string) os.Error { return nil })
+		if err != ErrRequestEntityTooLarge {
+			t.Errorf("err=%v, want ErrRequestEntityTooLarge", err)
+		}
+		req.Respond(StatusOK)
+	}))
+}
@@ -0,0 +1,114 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"json"
+	"os"
+)
+
+// Cursor is a position in a sorted list. List endpoints return cursors to
+// clients in place of database identifiers or offsets. A cursor is stable
+// when items are inserted before the position.
+type Cursor struct {
+	// Sort key values of the last item before the position.
+	Keys map[string]string
+
+	// Number of items before the position.
+	Position int
+}
+
+// CursorCodec converts cursors to and from opaque tokens. Tokens are signed
+// and optionally encrypted.
+type CursorCodec struct {
+	// Secret used to sign tokens.
+	Secret string
+
+	// If not nil, the AES key used to encrypt tokens. The key must be 16, 24
+	// or 32 bytes long.
+	EncryptionKey []byte
+
+	// Lifetime of a token in seconds. The default is 24 hours.
+	MaxAge int
+}
+
+const cursorContext = "twister.web.Cursor"
+
+var errInvalidCursor = os.NewError("twister: invalid cursor")
+
+type cursorJSON struct {
+	K map[string]string
+	P int
+}
+
+// Encode returns an opaque token for the cursor.
+func (c *CursorCodec) Encode(cursor *Cursor) (string, os.Error) {
+	p, err := json.Marshal(&cursorJSON{cursor.Keys, cursor.Position})
+	if err != nil {
+		return "", err
+	}
+	if c.EncryptionKey != nil {
+		block, err := aes.NewCipher(c.EncryptionKey)
+		if err != nil {
+			return "", err
+		}
+		ciphertext := make([]byte, aes.BlockSize+len(p))
+		iv := ciphertext[:aes.BlockSize]
+		if err := ReadRandom(iv); err != nil {
+			return "", err
+		}
+		cipher.NewCTR(block, iv).XORKeyStream(ciphertext[aes.BlockSize:], p)
+		p = ciphertext
+	}
+	maxAge := c.MaxAge
+	if maxAge <= 0 {
+		maxAge = 24 * 60 * 60
+	}
+	return base64.URLEncoding.EncodeToString([]byte(SignValue(c.Secret, cursorContext, maxAge, string(p)))), nil
+}
+
+// Decode returns the cursor for a token created by Encode. An error is
+// returned if the token was modified or has expired.
+func (c *CursorCodec) Decode(token string) (*Cursor, os.Error) {
+	signed, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	s, err := VerifyValue(c.Secret, cursorContext, string(signed))
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	p := []byte(s)
+	if c.EncryptionKey != nil {
+		block, err := aes.NewCipher(c.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(p) < aes.BlockSize {
+			return nil, errInvalidCursor
+		}
+		iv := p[:aes.BlockSize]
+		p = p[aes.BlockSize:]
+		cipher.NewCTR(block, iv).XORKeyStream(p, p)
+	}
+	var v cursorJSON
+	if err := json.Unmarshal(p, &v); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &Cursor{Keys: v.K, Position: v.P}, nil
+}
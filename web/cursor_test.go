@@ -0,0 +1,70 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCursorLinks(t *testing.T) {
+	var rels []string
+	RunHandler("/items?cursor=x&q=a", "GET", nil, nil, HandlerFunc(func(req *Request) {
+		for _, l := range CursorLinks(req, 10, "", "a=") {
+			rels = append(rels, l.Rel+" "+l.URL)
+		}
+		req.Respond(StatusOK)
+	}))
+	want := []string{
+		"first /items?q=a&limit=10",
+		"next /items?q=a&cursor=a%3D&limit=10",
+	}
+	if !reflect.DeepEqual(rels, want) {
+		t.Errorf("CursorLinks() = %q, want %q", rels, want)
+	}
+}
+
+func TestCursorCodec(t *testing.T) {
+	cursor := &Cursor{Keys: map[string]string{"created": "2011-07-01", "id": "42"}, Position: 20}
+	for _, codec := range []*CursorCodec{
+		&CursorCodec{Secret: "secret"},
+		&CursorCodec{Secret: "secret", EncryptionKey: []byte("0123456789abcdef")},
+	} {
+		token, err := codec.Encode(cursor)
+		if err != nil {
+			t.Errorf("Encode() returned error %v", err)
+			continue
+		}
+		if codec.EncryptionKey != nil && strings.Contains(token, "42") {
+			t.Errorf("token %q contains plaintext", token)
+		}
+		decoded, err := codec.Decode(token)
+		if err != nil {
+			t.Errorf("Decode(%q) returned error %v", token, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, cursor) {
+			t.Errorf("Decode(%q) = %+v, want %+v", token, decoded, cursor)
+		}
+		other := &CursorCodec{Secret: "other", EncryptionKey: codec.EncryptionKey}
+		if _, err := other.Decode(token); err == nil {
+			t.Errorf("Decode(%q) with wrong secret did not return error", token)
+		}
+		if _, err := codec.Decode("x" + token); err == nil {
+			t.Errorf("Decode of modified token did not return error")
+		}
+	}
+}
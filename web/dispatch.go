@@ -0,0 +1,120 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"http"
+	"io"
+	"net"
+	"os"
+)
+
+var errHijackNotSupported = os.NewError("twister: hijack not supported for internal requests")
+
+// recordingResponder records the response to an internal request.
+type recordingResponder struct {
+	status    int
+	header    Header
+	body      bytes.Buffer
+	responded bool
+}
+
+func (r *recordingResponder) Respond(status int, header Header) io.Writer {
+	if r.responded {
+		return nullWriter{ErrInvalidState}
+	}
+	r.responded = true
+	r.status = status
+	r.header = header
+	return &r.body
+}
+
+func (r *recordingResponder) Hijack() (net.Conn, *bufio.Reader, os.Error) {
+	return nil, nil, errHijackNotSupported
+}
+
+type nullWriter struct {
+	err os.Error
+}
+
+func (w nullWriter) Write(p []byte) (int, os.Error) {
+	return 0, w.err
+}
+
+// RecordedResponse is the response to a request executed by Dispatch.
+type RecordedResponse struct {
+	Status int
+	Header Header
+	Body   []byte
+}
+
+// Dispatch executes a request in-process using handler h and returns the
+// response. No network I/O is performed. If the URL does not specify a host,
+// then the request host is "localhost". Handlers that hijack the connection
+// are not supported.
+//
+// Dispatch is useful for composing responses from other handlers and for
+// tests:
+//
+//  resp, err := web.Dispatch(router, "GET", "/items/1", nil, nil)
+func Dispatch(h Handler, method string, url string, header Header, body io.Reader) (*RecordedResponse, os.Error) {
+	return dispatch(h, nil, method, url, header, body)
+}
+
+// dispatch runs the handler h with an internal request and returns the
+// recorded response. If parent is not nil, then the remote address and
+// missing URL components are copied from parent.
+func dispatch(h Handler, parent *Request, method string, rawURL string, header Header, body io.Reader) (*RecordedResponse, os.Error) {
+	url, err := http.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if url.Host == "" && parent != nil {
+		url.Host = parent.URL.Host
+	}
+	if url.Scheme == "" && parent != nil {
+		url.Scheme = parent.URL.Scheme
+	}
+	if url.Host == "" {
+		url.Host = "localhost"
+	}
+	if url.Scheme == "" {
+		url.Scheme = "http"
+	}
+	if header == nil {
+		header = Header{}
+	}
+	remoteAddr := "127.0.0.1"
+	if parent != nil {
+		remoteAddr = parent.RemoteAddr
+	}
+	req, err := NewRequest(remoteAddr, method, url, ProtocolVersion11, header)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		body = bytes.NewBuffer(nil)
+	}
+	req.Body = body
+	r := &recordingResponder{}
+	req.Responder = r
+	h.ServeWeb(req)
+	if !r.responded {
+		return nil, os.NewError("twister: handler did not respond to internal request for " + rawURL)
+	}
+	return &RecordedResponse{Status: r.status, Header: r.header, Body: r.body.Bytes()}, nil
+}
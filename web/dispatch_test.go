@@ -0,0 +1,97 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDispatch(t *testing.T) {
+	r := NewRouter()
+	r.Register("/e/<x>", "GET", routeTestHandler("e"))
+	r.Register("/echo", "PUT", HandlerFunc(func(req *Request) {
+		p, _ := req.BodyBytes(-1)
+		req.Respond(StatusOK, HeaderContentType, req.Header.Get(HeaderContentType)).Write(p)
+	}))
+
+	resp, err := Dispatch(r, "GET", "/e/foo", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != StatusOK || string(resp.Body) != "e x:foo" {
+		t.Errorf("GET /e/foo = %d %q", resp.Status, resp.Body)
+	}
+
+	resp, err = Dispatch(r, "PUT", "http://example.com/echo",
+		NewHeader(HeaderContentType, "text/plain", HeaderContentLength, "5"),
+		bytes.NewBufferString("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != StatusOK || string(resp.Body) != "hello" || resp.Header.Get(HeaderContentType) != "text/plain" {
+		t.Errorf("PUT /echo = %d %v %q", resp.Status, resp.Header, resp.Body)
+	}
+}
+
+func TestMirrorHandler(t *testing.T) {
+	mirrored := make(chan string, 1)
+	h := MirrorHandler(&MirrorOptions{Handler: HandlerFunc(func(req *Request) {
+		p, _ := req.BodyBytes(-1)
+		mirrored <- req.Method + " " + req.URL.Path + " " + req.Header.Get("X-Test") + " " + string(p)
+		req.Respond(StatusOK)
+	})}, HandlerFunc(func(req *Request) {
+		p, _ := req.BodyBytes(-1)
+		req.Respond(StatusOK).Write(p)
+	}))
+
+	status, _, body := RunHandler("http://example.com/items", "POST",
+		NewHeader(HeaderContentLength, "5", "X-Test", "x"), []byte("hello"), h)
+	if status != StatusOK || string(body) != "hello" {
+		t.Errorf("status=%d body=%q, want %d %q", status, body, StatusOK, "hello")
+	}
+	select {
+	case s := <-mirrored:
+		if expect := "POST /items x hello"; s != expect {
+			t.Errorf("mirrored request %q, want %q", s, expect)
+		}
+	case <-time.After(1e9):
+		t.Error("request not mirrored")
+	}
+}
+
+type mirrorErrorReader struct{}
+
+func (mirrorErrorReader) Read(p []byte) (int, os.Error) { return 0, os.EIO }
+
+func TestMirrorHandlerBodyError(t *testing.T) {
+	called := false
+	m := MirrorHandler(&MirrorOptions{Handler: HandlerFunc(func(req *Request) { req.Respond(StatusOK) })},
+		HandlerFunc(func(req *Request) {
+			called = true
+			req.Respond(StatusOK)
+		}))
+	h := HandlerFunc(func(req *Request) {
+		req.Body = mirrorErrorReader{}
+		m.ServeWeb(req)
+	})
+	status, _, _ := RunHandler("http://example.com/items", "POST",
+		NewHeader(HeaderContentLength, "5"), []byte("hello"), h)
+	if status != StatusBadRequest || called {
+		t.Errorf("status=%d called=%v, want %d false", status, called, StatusBadRequest)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io"
+	"json"
+	"os"
+	"strings"
+	"template"
+)
+
+// SafeHTML is a string of trusted HTML. The template formatters in
+// TemplateFormatters write SafeHTML values without escaping. Do not convert
+// user input to SafeHTML.
+type SafeHTML string
+
+// TemplateFormatters is a template formatter map with context-aware escaping.
+// The default formatter escapes HTML. Select the formatter for other contexts
+// with the template's formatter syntax:
+//
+//  <a href="{link|url}" title="{title|attr}">{text}</a>
+//  <a href="/search?q={query|query}">
+//  <script>var name = '{name|js}'; var data = {data|json};</script>
+//
+// The formatters are:
+//
+//  html   HTML text. SafeHTML values are not escaped. This is the default.
+//  attr   Quoted or unquoted attribute value.
+//  url    URL in an attribute. URLs with schemes other than http, https and
+//         mailto are replaced with "#unsafe".
+//  query  URL query component.
+//  js     Contents of a JavaScript string literal.
+//  json   JavaScript value. The value is encoded as JSON and escaped for
+//         embedding in a script element.
+var TemplateFormatters = template.FormatterMap{
+	"":      htmlFormatter,
+	"html":  htmlFormatter,
+	"attr":  attrFormatter,
+	"url":   urlFormatter,
+	"query": queryFormatter,
+	"js":    jsFormatter,
+	"json":  jsonFormatter,
+}
+
+// ParseTemplate parses a template with TemplateFormatters.
+func ParseTemplate(s string) (*template.Template, os.Error) {
+	return template.Parse(s, TemplateFormatters)
+}
+
+// MustParseTemplate is like ParseTemplate but panics if the template cannot
+// be parsed.
+func MustParseTemplate(s string) *template.Template {
+	return template.MustParse(s, TemplateFormatters)
+}
+
+func formatterString(values []interface{}) string {
+	if len(values) == 1 {
+		if s, ok := values[0].(string); ok {
+			return s
+		}
+	}
+	return fmt.Sprint(values...)
+}
+
+func htmlFormatter(w io.Writer, format string, values ...interface{}) {
+	if len(values) == 1 {
+		if s, ok := values[0].(SafeHTML); ok {
+			io.WriteString(w, string(s))
+			return
+		}
+	}
+	io.WriteString(w, HTMLEscapeString(formatterString(values)))
+}
+
+func attrFormatter(w io.Writer, format string, values ...interface{}) {
+	s := HTMLEscapeString(formatterString(values))
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		// Escape characters that end an unquoted attribute value.
+		switch c := s[i]; c {
+		case ' ', '\t', '\n', '\r', '\f', '=', '`':
+			fmt.Fprintf(&b, "&#x%X;", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	w.Write(b.Bytes())
+}
+
+// isSafeURL returns true if the URL is relative or has a safe scheme.
+func isSafeURL(s string) bool {
+	i := strings.IndexAny(s, ":/?#")
+	if i < 0 || s[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(s[:i]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+func urlFormatter(w io.Writer, format string, values ...interface{}) {
+	s := strings.TrimSpace(formatterString(values))
+	if !isSafeURL(s) {
+		s = "#unsafe"
+	}
+	attrFormatter(w, format, s)
+}
+
+func queryFormatter(w io.Writer, format string, values ...interface{}) {
+	io.WriteString(w, http.URLEscape(formatterString(values)))
+}
+
+// escapeJS writes s to b escaped for a JavaScript string literal in an HTML
+// document.
+func escapeJS(b *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '\'' || r == '"' || r == '<' || r == '>' || r == '&' || r == '=' || r == '/' ||
+			r < ' ' || r == 0x2028 || r == 0x2029:
+			fmt.Fprintf(b, "\\u%04X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+func jsFormatter(w io.Writer, format string, values ...interface{}) {
+	var b bytes.Buffer
+	escapeJS(&b, formatterString(values))
+	w.Write(b.Bytes())
+}
+
+// ScriptJSON returns the JSON encoding of v escaped for embedding in an HTML
+// script element. The characters <, > and & and the line terminators U+2028
+// and U+2029 are replaced with \u escapes.
+func ScriptJSON(v interface{}) ([]byte, os.Error) {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, r := range string(p) {
+		switch r {
+		case '<', '>', '&', 0x2028, 0x2029:
+			fmt.Fprintf(&b, "\\u%04X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func jsonFormatter(w io.Writer, format string, values ...interface{}) {
+	var v interface{} = values
+	if len(values) == 1 {
+		v = values[0]
+	}
+	p, err := ScriptJSON(v)
+	if err != nil {
+		io.WriteString(w, "null")
+		return
+	}
+	w.Write(p)
+}
@@ -0,0 +1,57 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"testing"
+)
+
+var escapeTemplateTests = []struct {
+	template string
+	data     map[string]interface{}
+	out      string
+}{
+	{"<p>{x}</p>", map[string]interface{}{"x": "<b>&"}, "<p>&lt;b&gt;&amp;</p>"},
+	{"<p>{x}</p>", map[string]interface{}{"x": SafeHTML("<b>ok</b>")}, "<p><b>ok</b></p>"},
+	{"<a title={x|attr}>", map[string]interface{}{"x": "a b=\"c\""}, "<a title=a&#x20;b&#x3D;&quot;c&quot;>"},
+	{`<a href="{x|url}">`, map[string]interface{}{"x": "javascript:alert(1)"}, `<a href="#unsafe">`},
+	{`<a href="{x|url}">`, map[string]interface{}{"x": "/a?b=c&d"}, `<a href="&#x2F;a?b&#x3D;c&amp;d">`},
+	{`<a href="/s?q={x|query}">`, map[string]interface{}{"x": "a&b c"}, `<a href="/s?q=a%26b+c">`},
+	{`<script>var s = '{x|js}';</script>`, map[string]interface{}{"x": "'</script>\n"}, `<script>var s = '\u0027\u003C\u002Fscript\u003E\u000A';</script>`},
+}
+
+func TestEscapeTemplates(t *testing.T) {
+	for _, tt := range escapeTemplateTests {
+		var b bytes.Buffer
+		if err := MustParseTemplate(tt.template).Execute(&b, tt.data); err != nil {
+			t.Errorf("%q: %v", tt.template, err)
+			continue
+		}
+		if b.String() != tt.out {
+			t.Errorf("%q = %q, want %q", tt.template, b.String(), tt.out)
+		}
+	}
+}
+
+func TestScriptJSON(t *testing.T) {
+	p, err := ScriptJSON([]string{"</script>", "a&b", "\u2028"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.IndexAny(p, "<>&\u2028") >= 0 {
+		t.Errorf("ScriptJSON returned %q", p)
+	}
+}
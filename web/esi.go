@@ -0,0 +1,181 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var esiIncludeRegexp = regexp.MustCompile(`<esi:include\s+src="([^"]*)"\s*/>`)
+
+// ESIHandler returns a handler that processes Edge Side Include tags in HTML
+// responses from h. Each tag of the form
+//
+//  <esi:include src="/fragment/path"/>
+//
+// is replaced with the body of the response to an in-process GET request for
+// the src URL. The fragment request is dispatched to h with the Cookie and
+// Accept-Language headers from the original request. Fragments that respond
+// with a status other than 200 are replaced with the empty string.
+//
+// Fragments are cached for the max-age specified in the fragment's
+// Cache-Control header unless the header includes private or no-cache.
+// Fragments requested with a Cookie header are not cached so that a fragment
+// personalized for one user is not served to another. The cache key includes
+// the Accept-Language header. At most maxESIFragments fragments are cached.
+//
+// Tags are not processed for HEAD requests. The Content-Length header is
+// removed from HEAD responses because the length of the processed body is
+// not known.
+func ESIHandler(h Handler) Handler {
+	return &esiHandler{h: h, cache: make(map[string]*esiFragment)}
+}
+
+// maxESIFragments is the maximum number of fragments in the cache.
+const maxESIFragments = 1000
+
+type esiHandler struct {
+	h Handler
+
+	mu    sync.Mutex
+	cache map[string]*esiFragment
+}
+
+type esiFragment struct {
+	body       []byte
+	expiration int64
+}
+
+type esiResponder struct {
+	Responder
+	head      bool
+	status    int
+	header    Header
+	buf       bytes.Buffer
+	buffering bool
+}
+
+func (r *esiResponder) Respond(status int, header Header) io.Writer {
+	contentType, _ := header.GetValueParam(HeaderContentType)
+	if status != StatusOK || contentType != "text/html" || header.Get(HeaderContentEncoding) != "" {
+		return r.Responder.Respond(status, header)
+	}
+	if r.head {
+		header[HeaderContentLength] = nil, false
+		return r.Responder.Respond(status, header)
+	}
+	r.buffering = true
+	r.status = status
+	r.header = header
+	return &r.buf
+}
+
+func (eh *esiHandler) ServeWeb(req *Request) {
+	r := &esiResponder{Responder: req.Responder, head: req.Method == "HEAD"}
+	req.Responder = r
+	eh.h.ServeWeb(req)
+	req.Responder = r.Responder
+	if !r.buffering {
+		return
+	}
+
+	p := r.buf.Bytes()
+	var b bytes.Buffer
+	included := false
+	for {
+		m := esiIncludeRegexp.FindSubmatchIndex(p)
+		if m == nil {
+			b.Write(p)
+			break
+		}
+		b.Write(p[:m[0]])
+		b.Write(eh.fragment(req, string(p[m[2]:m[3]])))
+		p = p[m[1]:]
+		included = true
+	}
+
+	if included {
+		// Fragments are requested with these headers from the original
+		// request.
+		r.header.AddVary(HeaderCookie, HeaderAcceptLanguage)
+	}
+
+	r.header.Set(HeaderContentLength, strconv.Itoa(b.Len()))
+	w := req.Responder.Respond(r.status, r.header)
+	w.Write(b.Bytes())
+}
+
+// fragment returns the body of the fragment at src.
+func (eh *esiHandler) fragment(req *Request, src string) []byte {
+	header := Header{}
+	for _, key := range []string{HeaderCookie, HeaderAcceptLanguage} {
+		if v, found := req.Header[key]; found {
+			header[key] = v
+		}
+	}
+	_, cookie := header[HeaderCookie]
+	cacheKey := src + "\n" + strings.Join(header[HeaderAcceptLanguage], ", ")
+
+	now := Seconds()
+	if !cookie {
+		eh.mu.Lock()
+		f := eh.cache[cacheKey]
+		eh.mu.Unlock()
+		if f != nil && f.expiration > now {
+			return f.body
+		}
+	}
+
+	resp, err := dispatch(eh.h, req, "GET", src, header, nil)
+	if err != nil || resp.Status != StatusOK {
+		return nil
+	}
+
+	if maxAge := esiMaxAge(resp.Header); maxAge > 0 && !cookie {
+		eh.mu.Lock()
+		if len(eh.cache) >= maxESIFragments {
+			for k, f := range eh.cache {
+				if f.expiration <= now {
+					eh.cache[k] = nil, false
+				}
+			}
+		}
+		if len(eh.cache) < maxESIFragments {
+			eh.cache[cacheKey] = &esiFragment{body: resp.Body, expiration: now + int64(maxAge)}
+		}
+		eh.mu.Unlock()
+	}
+	return resp.Body
+}
+
+// esiMaxAge returns the number of seconds a fragment can be cached.
+func esiMaxAge(header Header) int {
+	if header.Get(HeaderSetCookie) != "" {
+		return 0
+	}
+	cc := header.GetCacheControl()
+	for _, name := range []string{"private", "no-cache", "no-store"} {
+		if _, found := cc[name]; found {
+			return 0
+		}
+	}
+	maxAge, _ := strconv.Atoi(cc["max-age"])
+	return maxAge
+}
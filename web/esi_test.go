@@ -0,0 +1,73 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"io"
+	"strconv"
+	"testing"
+)
+
+func newESITestHandler(calls map[string]int) Handler {
+	return ESIHandler(HandlerFunc(func(req *Request) {
+		calls[req.URL.Path] += 1
+		switch req.URL.Path {
+		case "/page":
+			w := req.Respond(StatusOK, HeaderContentType, "text/html; charset=utf-8")
+			io.WriteString(w, `<p><esi:include src="/user"/>|<esi:include src="/broken"/></p>`)
+		case "/user":
+			w := req.Respond(StatusOK, HeaderCacheControl, "max-age=60")
+			io.WriteString(w, "hello "+req.Cookie.Get("user"))
+		default:
+			req.Error(StatusInternalServerError, nil)
+		}
+	}))
+}
+
+func TestESIHandler(t *testing.T) {
+	calls := make(map[string]int)
+	h := newESITestHandler(calls)
+
+	for i, user := range []string{"alice", "alice", "bob"} {
+		status, header, body := RunHandler("http://example.com/page", "GET", NewHeader(HeaderCookie, "user="+user), nil, h)
+		expect := "<p>hello " + user + "|</p>"
+		if status != StatusOK || string(body) != expect {
+			t.Errorf("request %d status=%d body=%q, want %q", i, status, body, expect)
+		}
+		if header.Get(HeaderContentLength) != strconv.Itoa(len(expect)) {
+			t.Errorf("request %d Content-Length=%q", i, header.Get(HeaderContentLength))
+		}
+	}
+	if calls["/user"] != 3 {
+		t.Errorf("fragment calls=%d, want 3 (no caching with cookies)", calls["/user"])
+	}
+	if calls["/broken"] != 3 {
+		t.Errorf("broken fragment calls=%d, want 3", calls["/broken"])
+	}
+
+	for i := 0; i < 2; i++ {
+		status, _, body := RunHandler("http://example.com/page", "GET", nil, nil, h)
+		if status != StatusOK || string(body) != "<p>hello |</p>" {
+			t.Errorf("anonymous request %d status=%d body=%q", i, status, body)
+		}
+	}
+	if calls["/user"] != 4 {
+		t.Errorf("fragment calls=%d, want 4 (one cache hit)", calls["/user"])
+	}
+
+	status, header, _ := RunHandler("http://example.com/page", "HEAD", nil, nil, h)
+	if status != StatusOK || header.Get(HeaderContentLength) != "" {
+		t.Errorf("HEAD status=%d Content-Length=%q", status, header.Get(HeaderContentLength))
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+// FlagChecker reports whether a feature flag is enabled for a request.
+type FlagChecker interface {
+	FlagEnabled(name string) bool
+}
+
+// FlagCheckerEnvKey is the request environment key for the FlagChecker used
+// by FlagSwitchHandler. The flags package Handler sets this key.
+const FlagCheckerEnvKey = "twister.web.FlagChecker"
+
+// FlagSwitchHandler returns a handler that calls onHandler if the named
+// feature flag is enabled for the request and offHandler otherwise. The flag
+// is disabled if the request does not have a FlagChecker. If offHandler is
+// nil, the handler responds with status 404 when the flag is disabled.
+//
+// Use FlagSwitchHandler to dark launch a new implementation of an endpoint:
+//
+//  r.Register("/checkout", "GET", web.FlagSwitchHandler("newCheckout",
+//      web.HandlerFunc(newCheckout), web.HandlerFunc(checkout)))
+//
+// The flag is evaluated on every request, so the new implementation can be
+// rolled back by changing the flag without a redeploy.
+func FlagSwitchHandler(flagName string, onHandler, offHandler Handler) Handler {
+	if offHandler == nil {
+		offHandler = notFoundHandler
+	}
+	return HandlerFunc(func(req *Request) {
+		if fc, ok := req.Env[FlagCheckerEnvKey].(FlagChecker); ok && fc.FlagEnabled(flagName) {
+			onHandler.ServeWeb(req)
+		} else {
+			offHandler.ServeWeb(req)
+		}
+	})
+}
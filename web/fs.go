@@ -15,7 +15,6 @@
 package web
 
 import (
-	"io"
 	"mime"
 	"os"
 	"path"
@@ -29,14 +28,58 @@ type ServeFileOptions struct {
 
 	// Response headers. 
 	Header Header
+
+	// If true, serve a pre-compressed sidecar file when the client accepts
+	// the sidecar's content coding. The sidecar for "foo.js" compressed with
+	// brotli is "foo.js.br" and compressed with gzip is "foo.js.gz".
+	Precompressed bool
+}
+
+// precompressedEncodings lists sidecar codings in order of preference.
+var precompressedEncodings = []struct {
+	coding, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// openPrecompressed opens the sidecar file with the highest quality coding
+// accepted by the client. If no sidecar is found, nil is returned.
+func openPrecompressed(req *Request, fname string) (f *os.File, info *os.FileInfo, coding string) {
+	best := float64(0)
+	for _, pe := range precompressedEncodings {
+		q := req.Header.AcceptEncodingQuality(pe.coding)
+		if q <= best {
+			continue
+		}
+		pf, err := os.Open(fname + pe.ext)
+		if err != nil {
+			continue
+		}
+		pinfo, err := pf.Stat()
+		if err != nil || !pinfo.IsRegular() {
+			pf.Close()
+			continue
+		}
+		if f != nil {
+			f.Close()
+		}
+		f, info, coding, best = pf, pinfo, pe.coding, q
+	}
+	return
 }
 
 var defaultServeFileOptions ServeFileOptions
 
 // ServeFile responds to the request with the contents of the named file.
+// ServeFile handles conditional GET and range requests. See ServeContent.
 //
 // If the "v" request parameter is set, then ServeFile sets the expires header
 // and the cache control maximum age parameter to ten years in the future.
+//
+// If the Precompressed option is set, ServeFile serves a sidecar file with
+// the Content-Encoding header set when the client accepts the sidecar's
+// coding. The Content-Type is determined from the name of the original file.
 func ServeFile(req *Request, fname string, options *ServeFileOptions) {
 	if options == nil {
 		options = &defaultServeFileOptions
@@ -55,8 +98,6 @@ func ServeFile(req *Request, fname string, options *ServeFileOptions) {
 		return
 	}
 
-	status := StatusOK
-
 	header := Header{}
 	if options.Header != nil {
 		for k, v := range options.Header {
@@ -64,38 +105,17 @@ func ServeFile(req *Request, fname string, options *ServeFileOptions) {
 		}
 	}
 
-	etag := strconv.Itob64(info.Mtime_ns, 36)
-	header.Set(HeaderETag, QuoteHeaderValue(etag))
-
-	for _, qetag := range req.Header.GetList(HeaderIfNoneMatch) {
-		if etag == UnquoteHeaderValue(qetag) {
-			status = StatusNotModified
-			break
+	if _, found := header[HeaderContentType]; !found {
+		ext := path.Ext(fname)
+		contentType := ""
+		if options.MimeType != nil {
+			contentType = options.MimeType[ext]
 		}
-	}
-
-	if status == StatusNotModified {
-		// Clear entity headers.
-		for k, _ := range header {
-			if strings.HasPrefix(k, "Content-") {
-				header[k] = nil, false
-			}
+		if contentType == "" {
+			contentType = mime.TypeByExtension(ext)
 		}
-	} else {
-		// Set entity headers
-		header.Set(HeaderContentLength, strconv.Itoa64(info.Size))
-		if _, found := header[HeaderContentType]; !found {
-			ext := path.Ext(fname)
-			contentType := ""
-			if options.MimeType != nil {
-				contentType = options.MimeType[ext]
-			}
-			if contentType == "" {
-				contentType = mime.TypeByExtension(ext)
-			}
-			if contentType != "" {
-				header.Set(HeaderContentType, contentType)
-			}
+		if contentType != "" {
+			header.Set(HeaderContentType, contentType)
 		}
 	}
 
@@ -119,10 +139,19 @@ func ServeFile(req *Request, fname string, options *ServeFileOptions) {
 		header.Set(HeaderCacheControl, strings.Join(append(parts, "max-age="+strconv.Itoa(maxAge)), ", "))
 	}
 
-	w := req.Responder.Respond(status, header)
-	if req.Method != "HEAD" && status != StatusNotModified {
-		io.Copy(w, f)
+	etag := strconv.Itob64(info.Mtime_ns, 36)
+
+	if options.Precompressed {
+		header.AddVary(HeaderAcceptEncoding)
+		if pf, pinfo, coding := openPrecompressed(req, fname); pf != nil {
+			defer pf.Close()
+			f, info = pf, pinfo
+			header.Set(HeaderContentEncoding, coding)
+			etag = strconv.Itob64(info.Mtime_ns, 36) + "-" + coding
+		}
 	}
+
+	ServeContent(req, header, etag, info.Mtime_ns/1e9, info.Size, f)
 }
 
 // DirectoryHandler returns a request handler that serves static files from
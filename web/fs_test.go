@@ -15,20 +15,29 @@
 package web
 
 import (
+	"io/ioutil"
 	"os"
+	"path"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 )
 
 var testEtag = computeTestEtag()
 var testContentLength = computeTestContentLength()
+var testLastModified = computeTestLastModified()
 
 func computeTestEtag() string {
 	info, _ := os.Stat("fs_test.go")
 	return QuoteHeaderValue(strconv.Itob64(info.Mtime_ns, 36))
 }
 
+func computeTestLastModified() string {
+	info, _ := os.Stat("fs_test.go")
+	return time.SecondsToUTC(info.Mtime_ns / 1e9).Format(TimeLayout)
+}
+
 func computeTestContentLength() string {
 	info, _ := os.Stat("fs_test.go")
 	return strconv.Itoa64(info.Size)
@@ -49,6 +58,8 @@ var fileHandlerTests = []struct {
 		status: StatusOK,
 		responseHeader: NewHeader(
 			HeaderEtag, testEtag,
+			HeaderAcceptRanges, "bytes",
+			HeaderLastModified, testLastModified,
 			HeaderContentLength, testContentLength),
 	},
 	{
@@ -57,6 +68,8 @@ var fileHandlerTests = []struct {
 		status: StatusOK,
 		responseHeader: NewHeader(
 			HeaderEtag, testEtag,
+			HeaderAcceptRanges, "bytes",
+			HeaderLastModified, testLastModified,
 			HeaderCacheControl, "max-age=315360000",
 			HeaderContentLength, testContentLength),
 		url: "http://example.com/?v=10",
@@ -68,6 +81,8 @@ var fileHandlerTests = []struct {
 		options: &ServeFileOptions{Header: NewHeader(HeaderCacheControl, "foo, max-age=2, bar")},
 		responseHeader: NewHeader(
 			HeaderEtag, testEtag,
+			HeaderAcceptRanges, "bytes",
+			HeaderLastModified, testLastModified,
 			HeaderCacheControl, "foo, bar, max-age=315360000",
 			HeaderContentLength, testContentLength),
 		url: "http://example.com/?v=10",
@@ -78,6 +93,8 @@ var fileHandlerTests = []struct {
 		status: StatusOK,
 		responseHeader: NewHeader(
 			HeaderEtag, testEtag,
+			HeaderAcceptRanges, "bytes",
+			HeaderLastModified, testLastModified,
 			HeaderContentLength, testContentLength),
 		noBody: true,
 	},
@@ -140,3 +157,44 @@ func TestFileHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestPrecompressed(t *testing.T) {
+	dir := path.Join(os.TempDir(), "twister-precompressed-test")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := path.Join(dir, "a.js")
+	if err := ioutil.WriteFile(fname, []byte("var a = 1;\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname+".gz", []byte("gz"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	fh := FileHandler(fname, &ServeFileOptions{Precompressed: true})
+	tests := []struct {
+		acceptEncoding  string
+		contentEncoding string
+		body            string
+	}{
+		{"", "", "var a = 1;\n"},
+		{"gzip, deflate", "gzip", "gz"},
+		{"br, gzip;q=0", "", "var a = 1;\n"},
+		{"*", "gzip", "gz"},
+	}
+	for _, tt := range tests {
+		header := Header{}
+		if tt.acceptEncoding != "" {
+			header.Set(HeaderAcceptEncoding, tt.acceptEncoding)
+		}
+		status, header, body := RunHandler("http://example.com/", "GET", header, nil, fh)
+		if status != StatusOK ||
+			header.Get(HeaderContentEncoding) != tt.contentEncoding ||
+			header.Get(HeaderContentLength) != strconv.Itoa(len(tt.body)) ||
+			header.Get(HeaderVary) != HeaderAcceptEncoding ||
+			string(body) != tt.body {
+			t.Errorf("Accept-Encoding %q: status=%d header=%v body=%q", tt.acceptEncoding, status, header, body)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GeoLocation is the location of a client IP address.
+type GeoLocation struct {
+	// ISO 3166-1 alpha-2 country code, for example "US".
+	Country string
+
+	// Region or subdivision code within the country, for example "CA".
+	Region string
+}
+
+// GeoReader looks up the location of IP addresses.
+type GeoReader interface {
+	// LookupGeo returns the location of ip or nil if the location is not
+	// known.
+	LookupGeo(ip net.IP) (*GeoLocation, os.Error)
+}
+
+// GeoReaderFunc is a type adapter to allow the use of ordinary functions as
+// GeoReader.
+type GeoReaderFunc func(ip net.IP) (*GeoLocation, os.Error)
+
+// LookupGeo calls f(ip).
+func (f GeoReaderFunc) LookupGeo(ip net.IP) (*GeoLocation, os.Error) { return f(ip) }
+
+type geoRange struct {
+	start, end net.IP
+	loc        *GeoLocation
+}
+
+type geoRanges []geoRange
+
+func (r geoRanges) Len() int           { return len(r) }
+func (r geoRanges) Less(i, j int) bool { return bytes.Compare(r[i].start, r[j].start) < 0 }
+func (r geoRanges) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// LookupGeo implements the GeoReader interface with a binary search of the
+// ranges.
+func (r geoRanges) LookupGeo(ip net.IP) (*GeoLocation, os.Error) {
+	ip = ip.To16()
+	if ip == nil {
+		return nil, nil
+	}
+	i := sort.Search(len(r), func(i int) bool { return bytes.Compare(r[i].end, ip) >= 0 })
+	if i < len(r) && bytes.Compare(r[i].start, ip) <= 0 {
+		return r[i].loc, nil
+	}
+	return nil, nil
+}
+
+// ReadGeoCSV reads a GeoIP database in CSV format. Each line of the file has
+// the form:
+//
+//  first-ip,last-ip,country[,region]
+//
+// The ranges must not overlap. Lines starting with '#' are ignored.
+func ReadGeoCSV(r io.Reader) (GeoReader, os.Error) {
+	var ranges geoRanges
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err == os.EOF {
+			if line == "" {
+				break
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		f := strings.Split(line, ",")
+		if len(f) < 3 {
+			return nil, os.NewError("twister: bad GeoIP line " + line)
+		}
+		start := net.ParseIP(strings.TrimSpace(f[0])).To16()
+		end := net.ParseIP(strings.TrimSpace(f[1])).To16()
+		if start == nil || end == nil || bytes.Compare(start, end) > 0 {
+			return nil, os.NewError("twister: bad GeoIP range " + line)
+		}
+		loc := &GeoLocation{Country: strings.TrimSpace(f[2])}
+		if len(f) > 3 {
+			loc.Region = strings.TrimSpace(f[3])
+		}
+		ranges = append(ranges, geoRange{start, end, loc})
+	}
+	sort.Sort(ranges)
+	return ranges, nil
+}
+
+// GeoOptions configures GeoHandler.
+type GeoOptions struct {
+	// Reader looks up client locations. The application is required to set
+	// this field.
+	Reader GeoReader
+
+	// Requests from these countries are rejected with status 451.
+	BlockCountries []string
+}
+
+const geoEnvKey = "twister.web.Geo"
+
+// GeoHandler returns a handler that looks up the location of the client IP
+// address before calling h. The location is attached to the request and can
+// be retrieved with RequestGeo. The country is included in messages logged
+// with Request.Logger.
+func GeoHandler(options *GeoOptions, h Handler) Handler {
+	if options.Reader == nil {
+		panic("twister: GeoHandler requires Reader option")
+	}
+	gh := &geoHandler{reader: options.Reader, h: h, block: make(map[string]bool)}
+	for _, c := range options.BlockCountries {
+		gh.block[strings.ToUpper(c)] = true
+	}
+	return gh
+}
+
+type geoHandler struct {
+	reader GeoReader
+	block  map[string]bool
+	h      Handler
+}
+
+func (gh *geoHandler) ServeWeb(req *Request) {
+	host := req.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		loc, err := gh.reader.LookupGeo(ip)
+		if err != nil {
+			req.Error(StatusInternalServerError, err)
+			return
+		}
+		if loc != nil {
+			if gh.block[loc.Country] {
+				req.Error(StatusUnavailableForLegalReasons, os.NewError("twister: request blocked for country "+loc.Country))
+				return
+			}
+			req.Env[geoEnvKey] = loc
+		}
+	}
+	gh.h.ServeWeb(req)
+}
+
+// RequestGeo returns the client location found by GeoHandler or nil if the
+// location is not known.
+func RequestGeo(req *Request) *GeoLocation {
+	loc, _ := req.Env[geoEnvKey].(*GeoLocation)
+	return loc
+}
@@ -0,0 +1,71 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+const testGeoCSV = `# test database
+10.0.0.0,10.0.0.255,US,CA
+10.0.2.0,10.0.2.255,FR
+2001:db8::,2001:db8::ffff,DE,BE
+`
+
+var geoTests = []struct {
+	remoteAddr string
+	status     int
+	country    string
+	region     string
+}{
+	{"10.0.0.7:1234", StatusOK, "US", "CA"},
+	{"10.0.1.7:1234", StatusOK, "", ""},
+	{"10.0.2.0:1234", StatusUnavailableForLegalReasons, "", ""},
+	{"[2001:db8::1]:1234", StatusOK, "DE", "BE"},
+	{"unix", StatusOK, "", ""},
+}
+
+func TestGeoHandler(t *testing.T) {
+	reader, err := ReadGeoCSV(strings.NewReader(testGeoCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loc *GeoLocation
+	h := GeoHandler(&GeoOptions{Reader: reader, BlockCountries: []string{"fr"}},
+		HandlerFunc(func(req *Request) {
+			loc = RequestGeo(req)
+			req.Respond(StatusOK)
+		}))
+	for _, tt := range geoTests {
+		loc = nil
+		status, _, _ := RunHandler("http://example.com/", "GET", nil, nil,
+			HandlerFunc(func(req *Request) {
+				req.RemoteAddr = tt.remoteAddr
+				h.ServeWeb(req)
+			}))
+		if status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.remoteAddr, status, tt.status)
+			continue
+		}
+		var country, region string
+		if loc != nil {
+			country, region = loc.Country, loc.Region
+		}
+		if country != tt.country || region != tt.region {
+			t.Errorf("%s: location = %q %q, want %q %q", tt.remoteAddr, country, region, tt.country, tt.region)
+		}
+	}
+}
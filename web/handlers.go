@@ -14,7 +14,10 @@
 
 package web
 
-import ()
+import (
+	"bytes"
+	"strconv"
+)
 
 type redirectHandler struct {
 	url       string
@@ -36,3 +39,48 @@ var notFoundHandler = HandlerFunc(func(req *Request) { req.Error(StatusNotFound,
 func NotFoundHandler() Handler {
 	return notFoundHandler
 }
+
+// FlashPolicy returns an Adobe Flash cross-domain policy document that allows
+// access from the given domains. A domain can include the wildcard "*". If
+// toPorts is not "", then the policy is a socket policy that allows
+// connections to the specified ports.
+func FlashPolicy(toPorts string, domains ...string) string {
+	var b bytes.Buffer
+	b.WriteString("<?xml version=\"1.0\"?>\n")
+	b.WriteString("<!DOCTYPE cross-domain-policy SYSTEM \"http://www.adobe.com/xml/dtds/cross-domain-policy.dtd\">\n")
+	b.WriteString("<cross-domain-policy>\n")
+	if toPorts != "" {
+		b.WriteString("<site-control permitted-cross-domain-policies=\"master-only\"/>\n")
+	}
+	for _, domain := range domains {
+		b.WriteString("<allow-access-from domain=\"")
+		b.WriteString(HTMLEscapeString(domain))
+		b.WriteString("\"")
+		if toPorts != "" {
+			b.WriteString(" to-ports=\"")
+			b.WriteString(HTMLEscapeString(toPorts))
+			b.WriteString("\"")
+		}
+		b.WriteString("/>\n")
+	}
+	b.WriteString("</cross-domain-policy>\n")
+	return b.String()
+}
+
+type crossDomainHandler []byte
+
+func (h crossDomainHandler) ServeWeb(req *Request) {
+	w := req.Respond(StatusOK,
+		HeaderContentType, "text/x-cross-domain-policy",
+		HeaderContentLength, strconv.Itoa(len(h)))
+	w.Write([]byte(h))
+}
+
+// CrossDomainHandler returns a request handler that serves a Flash
+// cross-domain policy file allowing access from the given domains. Register
+// the handler for the path "/crossdomain.xml":
+//
+//  r.Register("/crossdomain.xml", "GET", web.CrossDomainHandler("*.example.com"))
+func CrossDomainHandler(domains ...string) Handler {
+	return crossDomainHandler(FlashPolicy("", domains...))
+}
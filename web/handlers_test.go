@@ -0,0 +1,56 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"testing"
+)
+
+const flashPolicyHead = "<?xml version=\"1.0\"?>\n" +
+	"<!DOCTYPE cross-domain-policy SYSTEM \"http://www.adobe.com/xml/dtds/cross-domain-policy.dtd\">\n" +
+	"<cross-domain-policy>\n"
+
+var flashPolicyTests = []struct {
+	toPorts string
+	domains []string
+	expect  string
+}{
+	{"", []string{"*.example.com"},
+		"<allow-access-from domain=\"*.example.com\"/>\n"},
+	{"", []string{`a"b<c>&d`, "é.example.com"},
+		"<allow-access-from domain=\"a&quot;b&lt;c&gt;&amp;d\"/>\n" +
+			"<allow-access-from domain=\"é.example.com\"/>\n"},
+	{"80,443", []string{"*"},
+		"<site-control permitted-cross-domain-policies=\"master-only\"/>\n" +
+			"<allow-access-from domain=\"*\" to-ports=\"80,443\"/>\n"},
+}
+
+func TestFlashPolicy(t *testing.T) {
+	for _, tt := range flashPolicyTests {
+		expect := flashPolicyHead + tt.expect + "</cross-domain-policy>\n"
+		if s := FlashPolicy(tt.toPorts, tt.domains...); s != expect {
+			t.Errorf("FlashPolicy(%q, %q) = %q, want %q", tt.toPorts, tt.domains, s, expect)
+		}
+	}
+}
+
+func TestCrossDomainHandler(t *testing.T) {
+	status, header, body := RunHandler("http://example.com/crossdomain.xml", "GET", nil, nil, CrossDomainHandler("*.example.com"))
+	if status != StatusOK || header.Get(HeaderContentType) != "text/x-cross-domain-policy" {
+		t.Errorf("status=%d content-type=%q", status, header.Get(HeaderContentType))
+	}
+	if s := string(body); s != FlashPolicy("", "*.example.com") {
+		t.Errorf("body=%q", s)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"net"
 	"os"
 	"sort"
 	"strconv"
@@ -161,6 +162,88 @@ func (m Header) GetList(key string) []string {
 	return result
 }
 
+// AddVary adds the header names to the Vary header. Names already in the Vary
+// header are not added again. The names are joined in a single
+// comma-separated value. If the Vary header is "*", then the header is not
+// changed.
+func (m Header) AddVary(names ...string) {
+	values := m.GetList(HeaderVary)
+	for _, name := range names {
+		name = HeaderName(name)
+		found := false
+		for _, v := range values {
+			if v == "*" || HeaderName(v) == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, name)
+		}
+	}
+	if len(values) > 0 {
+		m.Set(HeaderVary, strings.Join(values, ", "))
+	}
+}
+
+// HopByHopHeaders lists the headers that apply to a single connection and
+// are not forwarded by proxies. See RFC 2616 section 13.5.1. Proxy-Connection
+// is a non-standard header sent by some clients.
+var HopByHopHeaders = []string{
+	HeaderConnection,
+	"Keep-Alive",
+	HeaderProxyAuthenticate,
+	HeaderProxyAuthorization,
+	"Proxy-Connection",
+	HeaderTE,
+	HeaderTrailer,
+	HeaderTransferEncoding,
+	HeaderUpgrade,
+}
+
+// HasConnectionToken returns true if the Connection header contains token.
+// Tokens are compared without regard to case.
+func (m Header) HasConnectionToken(token string) bool {
+	token = strings.ToLower(token)
+	for _, s := range m.GetList(HeaderConnection) {
+		if strings.ToLower(s) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHopByHop returns true if the header with the canonical name is a
+// hop-by-hop header for a message with header m. A header is hop-by-hop if
+// the header is in HopByHopHeaders or if the header is named in the
+// Connection header.
+func (m Header) IsHopByHop(name string) bool {
+	for _, h := range HopByHopHeaders {
+		if h == name {
+			return true
+		}
+	}
+	for _, s := range m.GetList(HeaderConnection) {
+		if HeaderName(s) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveHopByHop removes the hop-by-hop headers from m. Proxies call this
+// method on the request header before forwarding a request and on the
+// response header before forwarding a response. Headers named in the
+// Connection header are removed along with the headers in HopByHopHeaders.
+func (m Header) RemoveHopByHop() {
+	for _, s := range m.GetList(HeaderConnection) {
+		m[HeaderName(s)] = nil, false
+	}
+	for _, name := range HopByHopHeaders {
+		m[name] = nil, false
+	}
+}
+
 // ValueParams represents a value with parameters.
 type ValueParams struct {
 	Value string
@@ -196,6 +279,218 @@ func (m Header) GetAccept(key string) []ValueParams {
 	return result
 }
 
+// Directive is an element of a list-valued header such as Cache-Control or
+// Prefer. The directive name and parameter names are lowercase. Quoted values
+// are unquoted.
+type Directive struct {
+	Name  string
+	Value string
+	Param map[string]string
+}
+
+// GetDirectives parses a header containing a comma separated list of
+// directives of the form name[=value][;param=value]...
+func (m Header) GetDirectives(key string) []Directive {
+	var result []Directive
+	for _, part := range m.GetList(key) {
+		name, rest := splitToken(part)
+		if name == "" {
+			continue
+		}
+		d := Directive{Name: toLowerToken(name)}
+		rest = skipSpace(rest)
+		if len(rest) > 0 && rest[0] == '=' {
+			d.Value, rest = splitTokenOrQuoted(skipSpace(rest[1:]))
+			rest = skipSpace(rest)
+		}
+		if len(rest) > 0 && rest[0] == ';' {
+			d.Param, _ = splitParam(rest[1:])
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// GetCacheControl returns the Cache-Control directives as a map from
+// lowercase directive name to value. Directives without a value map to "".
+func (m Header) GetCacheControl() map[string]string {
+	result := make(map[string]string)
+	for _, d := range m.GetDirectives(HeaderCacheControl) {
+		result[d.Name] = d.Value
+	}
+	return result
+}
+
+// GetPreferences returns the preferences in the Prefer header as described
+// in RFC 7240, keyed by lowercase preference name. If a preference is listed
+// more than once, the first occurrence is used.
+//
+// Common preferences are "return" with the value "minimal" or
+// "representation", "respond-async" and "wait". A handler that honors a
+// preference should report it with AddPreferenceApplied.
+func (m Header) GetPreferences() map[string]Directive {
+	result := make(map[string]Directive)
+	for _, d := range m.GetDirectives(HeaderPrefer) {
+		if _, found := result[d.Name]; !found {
+			result[d.Name] = d
+		}
+	}
+	return result
+}
+
+// GetPreferWait returns the number of seconds from the wait preference in
+// the Prefer header.
+func (m Header) GetPreferWait() (seconds int, ok bool) {
+	d, found := m.GetPreferences()["wait"]
+	if !found {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(d.Value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// AddPreferenceApplied adds a preference to the Preference-Applied response
+// header. The value is omitted if it is "".
+func (m Header) AddPreferenceApplied(name, value string) {
+	if value != "" {
+		name += "=" + QuoteHeaderValueOrToken(value)
+	}
+	m.Add(HeaderPreferenceApplied, name)
+}
+
+// GetForwarded returns the elements of the Forwarded header as described in
+// RFC 7239. Each element maps lowercase parameter names such as "for",
+// "proto" and "host" to values. The first element was added by the proxy
+// closest to the client.
+func (m Header) GetForwarded() []map[string]string {
+	var result []map[string]string
+	for _, part := range m.GetList(HeaderForwarded) {
+		if param, _ := splitParam(part); len(param) > 0 {
+			result = append(result, param)
+		}
+	}
+	return result
+}
+
+// AddForwarded adds an element with the given parameters to the Forwarded
+// header. Empty parameters are omitted. IPv6 addresses in the for and by
+// parameters are enclosed in brackets as required by RFC 7239.
+func (m Header) AddForwarded(forNode, by, host, proto string) {
+	var parts []string
+	for _, p := range []struct{ name, value string }{
+		{"for", forwardedNode(forNode)},
+		{"by", forwardedNode(by)},
+		{"host", host},
+		{"proto", proto},
+	} {
+		if p.value != "" {
+			parts = append(parts, p.name+"="+QuoteHeaderValueOrToken(p.value))
+		}
+	}
+	if len(parts) > 0 {
+		m.Add(HeaderForwarded, strings.Join(parts, ";"))
+	}
+}
+
+// forwardedNode encloses an IPv6 address in brackets.
+func forwardedNode(s string) string {
+	if strings.Index(s, ":") >= 0 && net.ParseIP(s) != nil {
+		return "[" + s + "]"
+	}
+	return s
+}
+
+// forwardedAddr returns the address in a Forwarded for or by parameter
+// without brackets and port. The empty string is returned for unknown and
+// obfuscated identifiers.
+func forwardedAddr(node string) string {
+	if node == "" || node == "unknown" || node[0] == '_' {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimRight(strings.TrimLeft(node, "["), "]")
+}
+
+// lastForwarded returns the last element of the Forwarded header or nil if
+// the header is not present. The last element is added by the proxy closest
+// to the server.
+func lastForwarded(m Header) map[string]string {
+	elements := m.GetForwarded()
+	if len(elements) == 0 {
+		return nil
+	}
+	return elements[len(elements)-1]
+}
+
+// Warning is a value of the Warning header.
+type Warning struct {
+	Code  int
+	Agent string
+	Text  string
+	Date  string // optional
+}
+
+// GetWarnings parses the Warning header. Values with a syntax error are
+// skipped.
+func (m Header) GetWarnings() []Warning {
+	var result []Warning
+	for _, part := range m.GetList(HeaderWarning) {
+		var w Warning
+		code, rest := splitToken(part)
+		var err os.Error
+		if w.Code, err = strconv.Atoi(code); err != nil || len(code) != 3 {
+			continue
+		}
+		rest = skipSpace(rest)
+		i := indexFunc(rest, func(b byte) bool { return isSpace[b] })
+		if i <= 0 {
+			continue
+		}
+		w.Agent = rest[:i]
+		rest = skipSpace(rest[i:])
+		if len(rest) == 0 || rest[0] != '"' {
+			continue
+		}
+		w.Text, rest = splitQuoted(rest)
+		w.Date, _ = splitQuoted(skipSpace(rest))
+		result = append(result, w)
+	}
+	return result
+}
+
+// AcceptEncodingQuality returns the quality value of the content coding in
+// the Accept-Encoding header. A coding not listed in the header gets the
+// quality of the "*" entry, if any. The identity coding is acceptable with
+// quality 1 unless it is excluded with "identity;q=0" or "*;q=0". Other
+// codings are not acceptable if the Accept-Encoding header is missing.
+func (m Header) AcceptEncodingQuality(coding string) float64 {
+	star := float64(-1)
+	for _, vp := range m.GetAccept(HeaderAcceptEncoding) {
+		q := float64(1)
+		if s, ok := vp.Param["q"]; ok {
+			q, _ = strconv.Atof64(s)
+		}
+		switch {
+		case strings.ToLower(vp.Value) == coding:
+			return q
+		case vp.Value == "*":
+			star = q
+		}
+	}
+	switch {
+	case star >= 0:
+		return star
+	case coding == "identity":
+		return 1
+	}
+	return 0
+}
+
 // WriteHttpHeader writes the map in HTTP header format.
 func (m Header) WriteHttpHeader(w io.Writer) os.Error {
 	for key, values := range m {
@@ -345,6 +640,7 @@ const (
 	HeaderAcceptCharset        = "Accept-Charset"
 	HeaderAcceptEncoding       = "Accept-Encoding"
 	HeaderAcceptLanguage       = "Accept-Language"
+	HeaderAcceptPatch          = "Accept-Patch"
 	HeaderAcceptRanges         = "Accept-Ranges"
 	HeaderAge                  = "Age"
 	HeaderAllow                = "Allow"
@@ -365,6 +661,7 @@ const (
 	HeaderEtag                 = "Etag"
 	HeaderExpect               = "Expect"
 	HeaderExpires              = "Expires"
+	HeaderForwarded            = "Forwarded"
 	HeaderFrom                 = "From"
 	HeaderHost                 = "Host"
 	HeaderIfMatch              = "If-Match"
@@ -373,10 +670,13 @@ const (
 	HeaderIfRange              = "If-Range"
 	HeaderIfUnmodifiedSince    = "If-Unmodified-Since"
 	HeaderLastModified         = "Last-Modified"
+	HeaderLink                 = "Link"
 	HeaderLocation             = "Location"
 	HeaderMaxForwards          = "Max-Forwards"
 	HeaderOrigin               = "Origin"
 	HeaderPragma               = "Pragma"
+	HeaderPrefer               = "Prefer"
+	HeaderPreferenceApplied    = "Preference-Applied"
 	HeaderProxyAuthenticate    = "Proxy-Authenticate"
 	HeaderProxyAuthorization   = "Proxy-Authorization"
 	HeaderRange                = "Range"
@@ -401,6 +701,37 @@ func HeaderName(name string) string {
 	return HeaderNameBytes([]byte(name))
 }
 
+// commonHeaderNames is indexed by length and holds the canonical names of
+// common headers. HeaderNameBytes returns a string from this table to avoid
+// allocating a new string for common headers.
+var commonHeaderNames [32][]string
+
+func init() {
+	for _, name := range []string{
+		HeaderAccept, HeaderAcceptCharset, HeaderAcceptEncoding,
+		HeaderAcceptLanguage, HeaderAcceptPatch, HeaderAcceptRanges, HeaderAge, HeaderAllow,
+		HeaderAuthorization, HeaderCacheControl, HeaderConnection,
+		HeaderContentDisposition, HeaderContentEncoding,
+		HeaderContentLanguage, HeaderContentLength, HeaderContentLocation,
+		HeaderContentMD5, HeaderContentRange, HeaderContentType,
+		HeaderCookie, HeaderDate, HeaderEtag, HeaderExpect, HeaderExpires,
+		HeaderForwarded, HeaderFrom, HeaderHost, HeaderIfMatch, HeaderIfModifiedSince,
+		HeaderIfNoneMatch, HeaderIfRange, HeaderIfUnmodifiedSince,
+		HeaderLastModified, HeaderLink, HeaderLocation, HeaderMaxForwards,
+		HeaderOrigin,
+		HeaderPragma, HeaderPrefer, HeaderPreferenceApplied, HeaderProxyAuthenticate, HeaderProxyAuthorization,
+		HeaderRange, HeaderReferer, HeaderRetryAfter, HeaderServer,
+		HeaderSetCookie, HeaderTE, HeaderTrailer, HeaderTransferEncoding,
+		HeaderUpgrade, HeaderUserAgent, HeaderVary, HeaderVia,
+		HeaderWWWAuthenticate, HeaderWarning, HeaderXXSRFToken,
+		"Keep-Alive", "X-Forwarded-For", "X-Forwarded-Proto", "X-Requested-With",
+	} {
+		if len(name) < len(commonHeaderNames) && HeaderName(name) == name {
+			commonHeaderNames[len(name)] = append(commonHeaderNames[len(name)], name)
+		}
+	}
+}
+
 // HeaderNameBytes returns the canonical format for the header name specified
 // by the bytes in p. This function modifies the contents p.
 func HeaderNameBytes(p []byte) string {
@@ -417,6 +748,17 @@ func HeaderNameBytes(p []byte) string {
 		}
 		upper = c == '-'
 	}
+	if len(p) < len(commonHeaderNames) {
+	names:
+		for _, name := range commonHeaderNames[len(p)] {
+			for i, c := range p {
+				if name[i] != c {
+					continue names
+				}
+			}
+			return name
+		}
+	}
 	return string(p)
 }
 
@@ -566,7 +908,7 @@ func splitQuoted(s string) (value, rest string) {
 			p := make([]byte, len(s)-1)
 			j := copy(p, s[:i])
 			escape := true
-			for i = i + i; i < len(s); i++ {
+			for i = i + 1; i < len(s); i++ {
 				b := s[i]
 				switch {
 				case escape:
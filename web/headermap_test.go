@@ -184,3 +184,238 @@ func TestGetAccept(t *testing.T) {
 		}
 	}
 }
+
+var headerNameTests = []struct {
+	s, name string
+}{
+	{"content-type", "Content-Type"},
+	{"CONTENT-LENGTH", "Content-Length"},
+	{"x-forwarded-for", "X-Forwarded-For"},
+	{"x-custom-header", "X-Custom-Header"},
+	{"etag", "Etag"},
+	{"a", "A"},
+	{"", ""},
+}
+
+func TestHeaderName(t *testing.T) {
+	for _, tt := range headerNameTests {
+		if name := HeaderNameBytes([]byte(tt.s)); name != tt.name {
+			t.Errorf("HeaderNameBytes(%q) = %q, want %q", tt.s, name, tt.name)
+		}
+	}
+}
+
+func BenchmarkWriteHttpHeader(b *testing.B) {
+	b.StopTimer()
+	header := NewHeader(
+		HeaderContentType, "text/html; charset=utf-8",
+		HeaderContentLength, "1234",
+		HeaderCacheControl, "private, max-age=0",
+		HeaderSetCookie, "session=abcdefghijklmnopqrstuvwxyz; Path=/; HttpOnly",
+		HeaderLastModified, "Mon, 02 Jan 2006 15:04:05 GMT")
+	var buf bytes.Buffer
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		header.WriteHttpHeader(&buf)
+	}
+}
+
+func BenchmarkHeaderNameBytes(b *testing.B) {
+	p := []byte("content-type")
+	for i := 0; i < b.N; i++ {
+		HeaderNameBytes(p)
+	}
+}
+
+var addVaryTests = []struct {
+	initial []string
+	names   []string
+	vary    string
+}{
+	{nil, []string{"Accept-Encoding"}, "Accept-Encoding"},
+	{[]string{"accept-encoding"}, []string{"Accept-Encoding", "Cookie"}, "accept-encoding, Cookie"},
+	{[]string{"Cookie, Accept", "Origin"}, []string{"origin", "Accept-Language"}, "Cookie, Accept, Origin, Accept-Language"},
+	{[]string{"*"}, []string{"Cookie"}, "*"},
+}
+
+func TestAddVary(t *testing.T) {
+	for _, tt := range addVaryTests {
+		header := Header{}
+		if tt.initial != nil {
+			header[HeaderVary] = tt.initial
+		}
+		header.AddVary(tt.names...)
+		if vary := header[HeaderVary]; len(vary) != 1 || vary[0] != tt.vary {
+			t.Errorf("AddVary(%q, %q) = %q, want %q", tt.initial, tt.names, vary, tt.vary)
+		}
+	}
+}
+
+var acceptEncodingQualityTests = []struct {
+	s      string
+	coding string
+	q      float64
+}{
+	{"", "identity", 1},
+	{"", "gzip", 0},
+	{"gzip;q=0.5, deflate", "gzip", 0.5},
+	{"GZIP", "gzip", 1},
+	{"gzip", "deflate", 0},
+	{"gzip, *;q=0.2", "br", 0.2},
+	{"identity;q=0", "identity", 0},
+	{"*;q=0", "identity", 0},
+}
+
+func TestAcceptEncodingQuality(t *testing.T) {
+	for _, tt := range acceptEncodingQualityTests {
+		header := Header{}
+		if tt.s != "" {
+			header.Set(HeaderAcceptEncoding, tt.s)
+		}
+		if q := header.AcceptEncodingQuality(tt.coding); q != tt.q {
+			t.Errorf("AcceptEncodingQuality(%q) with %q = %v, want %v", tt.coding, tt.s, q, tt.q)
+		}
+	}
+}
+
+var removeHopByHopTests = []struct {
+	header Header
+	expect Header
+}{
+	{
+		NewHeader("Connection", "close", "Keep-Alive", "300", "Accept", "*/*"),
+		NewHeader("Accept", "*/*"),
+	},
+	{
+		NewHeader("Connection", "X-Foo", "Connection", "x-bar, close", "X-Foo", "a", "X-Bar", "b", "X-Baz", "c"),
+		NewHeader("X-Baz", "c"),
+	},
+	{
+		NewHeader("Te", "trailers", "Proxy-Authorization", "Basic x", "Upgrade", "websocket", "Host", "example.com"),
+		NewHeader("Host", "example.com"),
+	},
+}
+
+func TestRemoveHopByHop(t *testing.T) {
+	for _, tt := range removeHopByHopTests {
+		header := Header{}
+		for k, v := range tt.header {
+			header[k] = v
+		}
+		if !header.IsHopByHop(HeaderConnection) {
+			t.Errorf("IsHopByHop(Connection) = false for %v", tt.header)
+		}
+		header.RemoveHopByHop()
+		if !reflect.DeepEqual(header, tt.expect) {
+			t.Errorf("RemoveHopByHop(%v) = %v, want %v", tt.header, header, tt.expect)
+		}
+	}
+}
+
+func TestHasConnectionToken(t *testing.T) {
+	header := NewHeader("Connection", "keep-alive, Upgrade")
+	if !header.HasConnectionToken("upgrade") || !header.HasConnectionToken("Keep-Alive") {
+		t.Errorf("HasConnectionToken() = false for %v", header)
+	}
+	if header.HasConnectionToken("close") {
+		t.Errorf("HasConnectionToken(close) = true for %v", header)
+	}
+	if header.IsHopByHop("X-Foo") {
+		t.Errorf("IsHopByHop(X-Foo) = true for %v", header)
+	}
+}
+
+var getDirectivesTests = []struct {
+	header string
+	expect []Directive
+}{
+	{"no-cache", []Directive{{Name: "no-cache"}}},
+	{"Max-Age=60, private", []Directive{{Name: "max-age", Value: "60"}, {Name: "private"}}},
+	{`no-cache="Set-Cookie, X-Foo", public`, []Directive{{Name: "no-cache", Value: "Set-Cookie, X-Foo"}, {Name: "public"}}},
+	{"respond-async, wait=100, handling=lenient; foo=bar", []Directive{
+		{Name: "respond-async"},
+		{Name: "wait", Value: "100"},
+		{Name: "handling", Value: "lenient", Param: map[string]string{"foo": "bar"}}}},
+	{`a="x\"y"`, []Directive{{Name: "a", Value: `x"y`}}},
+}
+
+func TestGetDirectives(t *testing.T) {
+	for _, tt := range getDirectivesTests {
+		header := NewHeader("Prefer", tt.header)
+		actual := header.GetDirectives("Prefer")
+		if !reflect.DeepEqual(actual, tt.expect) {
+			t.Errorf("GetDirectives(%q) = %+v, want %+v", tt.header, actual, tt.expect)
+		}
+	}
+	cc := NewHeader(HeaderCacheControl, "public, max-age=300").GetCacheControl()
+	if _, ok := cc["public"]; !ok || cc["max-age"] != "300" {
+		t.Errorf("GetCacheControl() = %v", cc)
+	}
+}
+
+func TestGetForwarded(t *testing.T) {
+	header := NewHeader(HeaderForwarded, `for=192.0.2.60;Proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`)
+	expect := []map[string]string{
+		{"for": "192.0.2.60", "proto": "http", "by": "203.0.113.43"},
+		{"for": "[2001:db8:cafe::17]:4711"},
+	}
+	if actual := header.GetForwarded(); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("GetForwarded() = %v, want %v", actual, expect)
+	}
+}
+
+func TestAddForwarded(t *testing.T) {
+	header := Header{}
+	header.AddForwarded("192.0.2.60", "", "example.com", "https")
+	header.AddForwarded("2001:db8:cafe::17", "unknown", "", "")
+	expect := []string{
+		`for=192.0.2.60;host=example.com;proto=https`,
+		`for="[2001:db8:cafe::17]";by=unknown`,
+	}
+	if actual := header[HeaderForwarded]; !reflect.DeepEqual(actual, expect) {
+		t.Errorf("AddForwarded() = %q, want %q", actual, expect)
+	}
+	var addrs []string
+	for _, f := range header.GetForwarded() {
+		addrs = append(addrs, forwardedAddr(f["for"]))
+	}
+	if expect := []string{"192.0.2.60", "2001:db8:cafe::17"}; !reflect.DeepEqual(addrs, expect) {
+		t.Errorf("forwardedAddr() = %q, want %q", addrs, expect)
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	header := NewHeader(HeaderPrefer, `return=minimal; foo="bar", respond-async`, HeaderPrefer, "wait=10, return=representation")
+	prefs := header.GetPreferences()
+	if d := prefs["return"]; d.Value != "minimal" || d.Param["foo"] != "bar" {
+		t.Errorf("return = %+v, want minimal with foo=bar", d)
+	}
+	if _, ok := prefs["respond-async"]; !ok {
+		t.Errorf("respond-async not found")
+	}
+	if seconds, ok := header.GetPreferWait(); seconds != 10 || !ok {
+		t.Errorf("GetPreferWait() = %d, %v, want 10, true", seconds, ok)
+	}
+	if _, ok := NewHeader(HeaderPrefer, "wait=soon").GetPreferWait(); ok {
+		t.Errorf("GetPreferWait() ok for invalid value")
+	}
+
+	header = Header{}
+	header.AddPreferenceApplied("return", "minimal")
+	header.AddPreferenceApplied("respond-async", "")
+	if actual, expect := header[HeaderPreferenceApplied], []string{"return=minimal", "respond-async"}; !reflect.DeepEqual(actual, expect) {
+		t.Errorf("AddPreferenceApplied() = %q, want %q", actual, expect)
+	}
+}
+
+func TestGetWarnings(t *testing.T) {
+	header := NewHeader(HeaderWarning, `110 cache.example.com:8080 "Response is Stale", 199 - "Misc, warning" "Wed, 21 Oct 2015 07:28:00 GMT", bad`)
+	expect := []Warning{
+		{Code: 110, Agent: "cache.example.com:8080", Text: "Response is Stale"},
+		{Code: 199, Agent: "-", Text: "Misc, warning", Date: "Wed, 21 Oct 2015 07:28:00 GMT"},
+	}
+	if actual := header.GetWarnings(); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("GetWarnings() = %+v, want %+v", actual, expect)
+	}
+}
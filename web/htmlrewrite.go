@@ -0,0 +1,432 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// HTMLTokenType is the type of an HTMLToken.
+type HTMLTokenType int
+
+const (
+	// HTMLText is text, including the content of script and style elements.
+	HTMLText HTMLTokenType = iota
+
+	// HTMLStartTag is a start tag such as <a href="/">.
+	HTMLStartTag
+
+	// HTMLEndTag is an end tag such as </a>.
+	HTMLEndTag
+
+	// HTMLComment is a comment, doctype or processing instruction.
+	HTMLComment
+)
+
+// HTMLAttribute is an attribute of a start tag. The value is stored as it
+// appears in the markup; character references are not decoded.
+type HTMLAttribute struct {
+	Name  string
+	Value string
+}
+
+// HTMLToken is a token in an HTML response processed by HTMLRewriteHandler.
+// Filters modify the token in place. Unmodified tags are written exactly as
+// they appear in the original response.
+type HTMLToken struct {
+	Type HTMLTokenType
+
+	// Lower case tag name of start and end tags.
+	Name string
+
+	// Attributes of a start tag. Modify the attributes with SetAttr and
+	// RemoveAttr.
+	Attr []HTMLAttribute
+
+	// True if the start tag ends with "/>".
+	SelfClosing bool
+
+	// Markup of text and comment tokens.
+	Text string
+
+	// HTML inserted before and after the token.
+	Before, After string
+
+	// If true, the token is not written. Before and After are written.
+	Remove bool
+
+	raw      string
+	modified bool
+}
+
+// GetAttr returns the value of the named attribute.
+func (t *HTMLToken) GetAttr(name string) (string, bool) {
+	for _, a := range t.Attr {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetAttr sets the value of the named attribute. The value is written as is
+// between double quotes. Escape values from untrusted sources with
+// HTMLEscapeString.
+func (t *HTMLToken) SetAttr(name, value string) {
+	t.modified = true
+	for i := range t.Attr {
+		if t.Attr[i].Name == name {
+			t.Attr[i].Value = value
+			return
+		}
+	}
+	t.Attr = append(t.Attr, HTMLAttribute{name, value})
+}
+
+// RemoveAttr removes the named attribute.
+func (t *HTMLToken) RemoveAttr(name string) {
+	attr := t.Attr[:0]
+	for _, a := range t.Attr {
+		if a.Name != name {
+			attr = append(attr, a)
+		}
+	}
+	if len(attr) != len(t.Attr) {
+		t.modified = true
+		t.Attr = attr
+	}
+}
+
+func (t *HTMLToken) writeTo(b *bytes.Buffer) {
+	b.WriteString(t.Before)
+	if !t.Remove {
+		switch {
+		case t.Type == HTMLText || t.Type == HTMLComment:
+			b.WriteString(t.Text)
+		case !t.modified && t.raw != "":
+			b.WriteString(t.raw)
+		case t.Type == HTMLEndTag:
+			b.WriteString("</" + t.Name + ">")
+		default:
+			b.WriteString("<" + t.Name)
+			for _, a := range t.Attr {
+				b.WriteString(" " + a.Name + `="` + strings.Replace(a.Value, `"`, "&quot;", -1) + `"`)
+			}
+			if t.SelfClosing {
+				b.WriteString(" /")
+			}
+			b.WriteString(">")
+		}
+	}
+	b.WriteString(t.After)
+}
+
+// HTMLFilter modifies a token in an HTML response.
+type HTMLFilter func(req *Request, t *HTMLToken)
+
+// HTMLRewriteHandler returns a handler that passes the tokens of text/html
+// responses from h through the filters. The response is processed as it is
+// written, so the entire response is not buffered. Text may be split into
+// more than one token. Responses with a Content-Encoding are not modified.
+//
+// Wrap the handler with CompressHandler, not the other way around, so that
+// the rewriter sees the uncompressed response:
+//
+//  h = web.CompressHandler(&web.CompressOptions{},
+//      web.HTMLRewriteHandler(h, web.InsertBeforeEndTag("body", snippet)))
+func HTMLRewriteHandler(h Handler, filters ...HTMLFilter) Handler {
+	return HandlerFunc(func(req *Request) {
+		hr := &htmlRewriteResponder{Responder: req.Responder, req: req, filters: filters}
+		req.Responder = hr
+		h.ServeWeb(req)
+		if hr.rw != nil {
+			hr.rw.process(true)
+		}
+	})
+}
+
+type htmlRewriteResponder struct {
+	Responder
+	req     *Request
+	filters []HTMLFilter
+	rw      *htmlRewriter
+}
+
+func (hr *htmlRewriteResponder) Respond(status int, header Header) io.Writer {
+	if ct, _ := header.GetValueParam(HeaderContentType); ct != "text/html" ||
+		header.Get(HeaderContentEncoding) != "" ||
+		hr.req.Method == "HEAD" {
+		return hr.Responder.Respond(status, header)
+	}
+	header[HeaderContentLength] = nil, false
+	if etag := header.Get(HeaderEtag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set(HeaderEtag, "W/"+etag)
+	}
+	hr.rw = &htmlRewriter{req: hr.req, filters: hr.filters, w: hr.Responder.Respond(status, header)}
+	return hr.rw
+}
+
+// maxHTMLToken is the maximum size of a buffered partial token. Longer
+// partial tokens are written as text.
+const maxHTMLToken = 64 * 1024
+
+type htmlRewriter struct {
+	req     *Request
+	filters []HTMLFilter
+	w       io.Writer
+	buf     []byte
+	rawTag  string // name of the element with raw text content or ""
+}
+
+func (r *htmlRewriter) Write(p []byte) (int, os.Error) {
+	r.buf = append(r.buf, p...)
+	if err := r.process(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the complete tokens and flushes the underlying response body
+// if it supports flushing.
+func (r *htmlRewriter) Flush() os.Error {
+	if err := r.process(false); err != nil {
+		return err
+	}
+	if f, ok := r.w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// process filters and writes the complete tokens in the buffer. If eof is
+// true, the remaining data is written.
+func (r *htmlRewriter) process(eof bool) os.Error {
+	var out bytes.Buffer
+	for len(r.buf) > 0 {
+		t, n := nextHTMLToken(r.buf, r.rawTag, eof || len(r.buf) > maxHTMLToken)
+		if t == nil {
+			break
+		}
+		r.buf = r.buf[n:]
+		switch {
+		case t.Type == HTMLStartTag && rawTextElements[t.Name] && !t.SelfClosing:
+			r.rawTag = t.Name
+		case t.Type == HTMLEndTag && t.Name == r.rawTag:
+			r.rawTag = ""
+		}
+		for _, f := range r.filters {
+			f(r.req, t)
+		}
+		t.writeTo(&out)
+	}
+	// Copy the partial token to avoid retaining the processed data.
+	r.buf = append([]byte(nil), r.buf...)
+	if out.Len() == 0 {
+		return nil
+	}
+	_, err := r.w.Write(out.Bytes())
+	return err
+}
+
+// rawTextElements is the set of elements with content that is not parsed
+// for tags.
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// nextHTMLToken returns the token at the start of p and the length of the
+// token. If more data is needed to complete the token, nil is returned. If
+// eof is true, a token is always returned. The rawTag argument is the name
+// of the enclosing raw text element or "".
+func nextHTMLToken(p []byte, rawTag string, eof bool) (*HTMLToken, int) {
+	text := func(n int) (*HTMLToken, int) {
+		return &HTMLToken{Type: HTMLText, Text: string(p[:n])}, n
+	}
+
+	if rawTag != "" {
+		end := "</" + rawTag
+		i := bytes.Index(bytes.ToLower(p), []byte(end))
+		switch {
+		case i > 0:
+			return text(i)
+		case i < 0 && eof:
+			return text(len(p))
+		case i < 0 && len(p) > len(end):
+			// Keep a possible partial end tag.
+			return text(len(p) - len(end))
+		case i < 0:
+			return nil, 0
+		}
+	}
+
+	if p[0] != '<' {
+		if i := bytes.IndexByte(p, '<'); i >= 0 {
+			return text(i)
+		}
+		return text(len(p))
+	}
+
+	if len(p) < 4 && !eof && bytes.HasPrefix([]byte("<!--"), p) {
+		return nil, 0
+	}
+
+	switch {
+	case bytes.HasPrefix(p, []byte("<!--")):
+		if i := bytes.Index(p[4:], []byte("-->")); i >= 0 {
+			return &HTMLToken{Type: HTMLComment, Text: string(p[:4+i+3])}, 4 + i + 3
+		}
+	case len(p) > 1 && (p[1] == '!' || p[1] == '?'):
+		if i := bytes.IndexByte(p, '>'); i >= 0 {
+			return &HTMLToken{Type: HTMLComment, Text: string(p[:i+1])}, i + 1
+		}
+	case len(p) > 1 && isHTMLLetter(p[1]) || len(p) > 2 && p[1] == '/' && isHTMLLetter(p[2]):
+		if t, n := parseHTMLTag(p); t != nil {
+			return t, n
+		}
+	case len(p) > 2 || eof:
+		return text(1)
+	}
+	if eof {
+		return text(len(p))
+	}
+	return nil, 0
+}
+
+func isHTMLLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// parseHTMLTag parses the start or end tag at the beginning of p. If the tag
+// is not complete, nil is returned.
+func parseHTMLTag(p []byte) (*HTMLToken, int) {
+	t := &HTMLToken{Type: HTMLStartTag}
+	i := 1
+	if p[i] == '/' {
+		t.Type = HTMLEndTag
+		i++
+	}
+	start := i
+	for i < len(p) && !isHTMLSpace(p[i]) && p[i] != '/' && p[i] != '>' {
+		i++
+	}
+	t.Name = strings.ToLower(string(p[start:i]))
+	for {
+		for i < len(p) && (isHTMLSpace(p[i]) || p[i] == '/') {
+			if p[i] == '/' {
+				t.SelfClosing = true
+			}
+			i++
+		}
+		if i >= len(p) {
+			return nil, 0
+		}
+		if p[i] == '>' {
+			t.raw = string(p[:i+1])
+			if t.Type == HTMLEndTag {
+				t.Attr = nil
+				t.SelfClosing = false
+			}
+			return t, i + 1
+		}
+		t.SelfClosing = false
+		start := i
+		for i < len(p) && !isHTMLSpace(p[i]) && p[i] != '=' && p[i] != '>' && p[i] != '/' {
+			i++
+		}
+		a := HTMLAttribute{Name: strings.ToLower(string(p[start:i]))}
+		for i < len(p) && isHTMLSpace(p[i]) {
+			i++
+		}
+		if i < len(p) && p[i] == '=' {
+			i++
+			for i < len(p) && isHTMLSpace(p[i]) {
+				i++
+			}
+			if i >= len(p) {
+				return nil, 0
+			}
+			if q := p[i]; q == '"' || q == '\'' {
+				j := bytes.IndexByte(p[i+1:], q)
+				if j < 0 {
+					return nil, 0
+				}
+				a.Value = string(p[i+1 : i+1+j])
+				i += j + 2
+			} else {
+				start := i
+				for i < len(p) && !isHTMLSpace(p[i]) && p[i] != '>' {
+					i++
+				}
+				a.Value = string(p[start:i])
+			}
+		}
+		t.Attr = append(t.Attr, a)
+	}
+	panic("unreachable")
+}
+
+// InsertBeforeEndTag returns a filter that inserts html before end tags with
+// the given name. Use the filter to add a snippet such as an analytics script
+// before </body>.
+func InsertBeforeEndTag(name, html string) HTMLFilter {
+	name = strings.ToLower(name)
+	return func(req *Request, t *HTMLToken) {
+		if t.Type == HTMLEndTag && t.Name == name {
+			t.Before += html
+		}
+	}
+}
+
+// ScriptNonceFilter returns a filter that sets the nonce attribute of script
+// and style elements to the value returned by the nonce function. The
+// function is called for each element and should return the same value for
+// all calls with the same request, typically the nonce from the request's
+// Content-Security-Policy header.
+func ScriptNonceFilter(nonce func(req *Request) string) HTMLFilter {
+	return func(req *Request, t *HTMLToken) {
+		if t.Type == HTMLStartTag && (t.Name == "script" || t.Name == "style") {
+			t.SetAttr("nonce", HTMLEscapeString(nonce(req)))
+		}
+	}
+}
+
+// AssetHostFilter returns a filter that prefixes root relative URLs in the
+// src attribute of img and script elements and the href attribute of link
+// elements with base, for example "https://cdn.example.com".
+func AssetHostFilter(base string) HTMLFilter {
+	base = strings.TrimRight(base, "/")
+	return func(req *Request, t *HTMLToken) {
+		if t.Type != HTMLStartTag {
+			return
+		}
+		var name string
+		switch t.Name {
+		case "img", "script":
+			name = "src"
+		case "link":
+			name = "href"
+		default:
+			return
+		}
+		if v, ok := t.GetAttr(name); ok && strings.HasPrefix(v, "/") && !strings.HasPrefix(v, "//") {
+			t.SetAttr(name, base+v)
+		}
+	}
+}
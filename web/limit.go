@@ -0,0 +1,189 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryAfter returns the value of a Retry-After header for a client that
+// should wait delay nanoseconds before retrying. The delay is rounded up to
+// whole seconds and is at least one second.
+func RetryAfter(delay int64) string {
+	sec := (delay + 1e9 - 1) / 1e9
+	if sec < 1 {
+		sec = 1
+	}
+	return strconv.Itoa64(sec)
+}
+
+// RetryAfterTime returns the value of a Retry-After header for a client that
+// should wait until t, specified in seconds since the epoch. The value is an
+// HTTP date.
+func RetryAfterTime(t int64) string {
+	return time.SecondsToUTC(t).Format(TimeLayout)
+}
+
+// ThrottleError responds to a request rejected by a rate limiter, load
+// shedder or circuit breaker. The status is typically StatusTooManyRequests
+// or StatusServiceUnavailable. The Retry-After header is set from delay
+// using RetryAfter. Compute delay from the limiter's state, for example the
+// time until a quota window resets.
+func ThrottleError(req *Request, status int, reason os.Error, delay int64) {
+	req.Error(status, reason, HeaderRetryAfter, RetryAfter(delay))
+}
+
+// RateLimiter counts events per key in fixed time windows. A RateLimiter is
+// safe for concurrent use. Windows are dropped when they expire, so memory
+// use is proportional to the number of keys active in the current period.
+type RateLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	nextSweep int64
+}
+
+type rateWindow struct {
+	start, period int64
+	count         int
+}
+
+// NewRateLimiter returns a new rate limiter.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{windows: make(map[string]*rateWindow)}
+}
+
+// Take counts an event for key against a limit of limit events per period
+// seconds. Take returns the number of events remaining in the current window
+// and the number of seconds until the window resets. The number of remaining
+// events is negative if the limit is exceeded.
+func (l *RateLimiter) Take(key string, limit int, period int64) (remaining int, reset int64) {
+	if period <= 0 {
+		period = 1
+	}
+	now := Seconds()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now >= l.nextSweep {
+		for k, w := range l.windows {
+			if now >= w.start+w.period {
+				l.windows[k] = nil, false
+			}
+		}
+		l.nextSweep = now + 60
+	}
+	w := l.windows[key]
+	if w == nil || now >= w.start+w.period {
+		w = &rateWindow{start: now, period: period}
+		l.windows[key] = w
+	}
+	w.count += 1
+	return limit - w.count, w.start + w.period - now
+}
+
+// ConcurrencyLimitOptions configures ConcurrencyLimitHandler.
+type ConcurrencyLimitOptions struct {
+	// Maximum number of concurrent requests for each key. The application is
+	// required to set this field.
+	Limit int
+
+	// Key returns the key used to group requests. If Key is nil, then all
+	// requests to the handler share a single limit. Use HostKey to limit
+	// requests per host.
+	Key func(req *Request) string
+
+	// Value of the Retry-After header in seconds. If RetryAfter is zero,
+	// the value is the average time to handle a request, which is the
+	// expected time for a slot to become free.
+	RetryAfter int
+}
+
+// HostKey returns the request's host. Use this function as the Key option to
+// ConcurrencyLimitHandler to limit concurrent requests per host.
+func HostKey(req *Request) string {
+	return req.URL.Host
+}
+
+// ConcurrencyLimitHandler returns a handler that limits the number of
+// concurrent requests to h. Requests exceeding the limit are rejected
+// immediately with status 503 and a Retry-After header.
+//
+// Wrap an individual route's handler to limit the route:
+//
+//  r.Register("/report", "GET", web.ConcurrencyLimitHandler(&web.ConcurrencyLimitOptions{Limit: 2}, reportHandler))
+func ConcurrencyLimitHandler(options *ConcurrencyLimitOptions, h Handler) Handler {
+	if options.Limit <= 0 {
+		panic("twister: ConcurrencyLimitHandler requires Limit option")
+	}
+	return &concurrencyLimitHandler{options: *options, h: h, active: make(map[string]int)}
+}
+
+type concurrencyLimitHandler struct {
+	options ConcurrencyLimitOptions
+	h       Handler
+
+	mu     sync.Mutex
+	active map[string]int
+
+	// Moving average of the time in nanoseconds to handle a request.
+	average int64
+}
+
+func (ch *concurrencyLimitHandler) acquire(key string) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	n := ch.active[key]
+	if n >= ch.options.Limit {
+		return false
+	}
+	ch.active[key] = n + 1
+	return true
+}
+
+func (ch *concurrencyLimitHandler) release(key string, elapsed int64) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.average += (elapsed - ch.average) / 8
+	if n := ch.active[key] - 1; n > 0 {
+		ch.active[key] = n
+	} else {
+		ch.active[key] = 0, false
+	}
+}
+
+func (ch *concurrencyLimitHandler) ServeWeb(req *Request) {
+	var key string
+	if ch.options.Key != nil {
+		key = ch.options.Key(req)
+	}
+	if !ch.acquire(key) {
+		delay := int64(ch.options.RetryAfter) * 1e9
+		if delay <= 0 {
+			ch.mu.Lock()
+			delay = ch.average
+			ch.mu.Unlock()
+		}
+		ThrottleError(req, StatusServiceUnavailable,
+			os.NewError("twister: concurrency limit exceeded"), delay)
+		return
+	}
+	start := Nanoseconds()
+	defer func() {
+		ch.release(key, Nanoseconds()-start)
+	}()
+	ch.h.ServeWeb(req)
+}
@@ -0,0 +1,97 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"testing"
+)
+
+func TestConcurrencyLimitHandler(t *testing.T) {
+	var h Handler
+	var inner []int
+	h = ConcurrencyLimitHandler(&ConcurrencyLimitOptions{Limit: 1, RetryAfter: 5}, HandlerFunc(func(req *Request) {
+		// Issue a nested request while the first request is active.
+		if req.URL.Path == "/outer" {
+			status, header, _ := RunHandler("/inner", "GET", nil, nil, h)
+			if header.Get(HeaderRetryAfter) != "5" {
+				t.Errorf("Retry-After=%q, want 5", header.Get(HeaderRetryAfter))
+			}
+			inner = append(inner, status)
+		}
+		req.Respond(StatusOK)
+	}))
+	status, _, _ := RunHandler("/outer", "GET", nil, nil, h)
+	if status != StatusOK {
+		t.Errorf("outer status=%d", status)
+	}
+	if len(inner) != 1 || inner[0] != StatusServiceUnavailable {
+		t.Errorf("inner status=%v, want 503", inner)
+	}
+	status, _, _ = RunHandler("/inner", "GET", nil, nil, h)
+	if status != StatusOK {
+		t.Errorf("status after release=%d", status)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := NewFakeClock(1000e9)
+	defer SetClock(SetClock(clock))
+
+	l := NewRateLimiter()
+	for i, want := range []int{1, 0, -1} {
+		if remaining, reset := l.Take("a", 2, 10); remaining != want || reset != 10 {
+			t.Errorf("%d: Take = %d, %d, want %d, 10", i, remaining, reset, want)
+		}
+	}
+	if remaining, _ := l.Take("b", 2, 10); remaining != 1 {
+		t.Errorf("other key remaining = %d, want 1", remaining)
+	}
+
+	clock.Advance(4e9)
+	if remaining, reset := l.Take("a", 2, 10); remaining != -2 || reset != 6 {
+		t.Errorf("Take = %d, %d, want -2, 6", remaining, reset)
+	}
+
+	clock.Advance(60e9)
+	if remaining, reset := l.Take("a", 2, 10); remaining != 1 || reset != 10 {
+		t.Errorf("after reset Take = %d, %d, want 1, 10", remaining, reset)
+	}
+	if n := len(l.windows); n != 1 {
+		t.Errorf("windows = %d, want 1 after sweep", n)
+	}
+}
+
+var retryAfterTests = []struct {
+	delay int64
+	value string
+}{
+	{-1, "1"},
+	{0, "1"},
+	{1, "1"},
+	{1e9, "1"},
+	{1e9 + 1, "2"},
+	{90e9, "90"},
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tt := range retryAfterTests {
+		if v := RetryAfter(tt.delay); v != tt.value {
+			t.Errorf("RetryAfter(%d) = %q, want %q", tt.delay, v, tt.value)
+		}
+	}
+	if v := RetryAfterTime(784111777); v != "Sun, 06 Nov 1994 08:49:37 GMT" {
+		t.Errorf("RetryAfterTime() = %q", v)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"http"
+	"sort"
+	"strconv"
+)
+
+// Link represents a link in a Link header as described in RFC 5988.
+type Link struct {
+	URL string
+	Rel string
+
+	// Additional parameters such as "as" or "type".
+	Param map[string]string
+}
+
+// String returns the link in Link header format.
+func (l Link) String() string {
+	var b bytes.Buffer
+	b.WriteByte('<')
+	b.WriteString(l.URL)
+	b.WriteByte('>')
+	if l.Rel != "" {
+		b.WriteString("; rel=")
+		b.WriteString(QuoteHeaderValue(l.Rel))
+	}
+	keys := make([]string, 0, len(l.Param))
+	for k := range l.Param {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("; ")
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(QuoteHeaderValueOrToken(l.Param[k]))
+	}
+	return b.String()
+}
+
+// AddLinks adds the links to the Link header.
+func (m Header) AddLinks(links ...Link) {
+	for _, l := range links {
+		m.Add(HeaderLink, l.String())
+	}
+}
+
+// GetLinks parses the Link header. Links with a syntax error are skipped.
+func (m Header) GetLinks() []Link {
+	var links []Link
+	for _, s := range m[HeaderLink] {
+		for {
+			s = skipSpace(s)
+			if s == "" {
+				break
+			}
+			if s[0] == ',' {
+				s = s[1:]
+				continue
+			}
+			if s[0] != '<' {
+				break
+			}
+			i := 1
+			for i < len(s) && s[i] != '>' {
+				i += 1
+			}
+			if i == len(s) {
+				break
+			}
+			l := Link{URL: s[1:i]}
+			param := map[string]string{}
+			s = skipSpace(s[i+1:])
+			if s != "" && s[0] == ';' {
+				param, s = splitParam(s[1:])
+			}
+			if rel, ok := param["rel"]; ok {
+				l.Rel = rel
+				param["rel"] = "", false
+			}
+			if len(param) > 0 {
+				l.Param = param
+			}
+			links = append(links, l)
+			// Skip to the next link.
+			for s != "" && s[0] != ',' {
+				s = s[1:]
+			}
+		}
+	}
+	return links
+}
+
+// PaginationLinks returns "first", "prev", "next" and "last" links for page
+// of a collection with total items and limit items per page. Pages are
+// numbered from 1. The links are formed from the request URL by setting the
+// "page" and "limit" query parameters.
+func PaginationLinks(req *Request, page, limit, total int) []Link {
+	if limit <= 0 {
+		return nil
+	}
+	last := (total + limit - 1) / limit
+	if last < 1 {
+		last = 1
+	}
+	prefix := linkPrefix(req, "page", "limit", "cursor")
+	link := func(rel string, p int) Link {
+		return Link{URL: prefix + "page=" + strconv.Itoa(p) + "&limit=" + strconv.Itoa(limit), Rel: rel}
+	}
+	links := []Link{link("first", 1)}
+	if page > 1 {
+		links = append(links, link("prev", page-1))
+	}
+	if page < last {
+		links = append(links, link("next", page+1))
+	}
+	return append(links, link("last", last))
+}
+
+// CursorLinks returns "first", "prev" and "next" links for a collection
+// paginated with cursors. The prev and next arguments are cursor tokens from
+// CursorCodec.Encode. The "prev" or "next" link is omitted if the
+// corresponding token is "". The links are formed from the request URL by
+// setting the "cursor" and "limit" query parameters.
+func CursorLinks(req *Request, limit int, prev, next string) []Link {
+	prefix := linkPrefix(req, "page", "limit", "cursor")
+	suffix := "limit=" + strconv.Itoa(limit)
+	links := []Link{{URL: prefix + suffix, Rel: "first"}}
+	if prev != "" {
+		links = append(links, Link{URL: prefix + "cursor=" + http.URLEscape(prev) + "&" + suffix, Rel: "prev"})
+	}
+	if next != "" {
+		links = append(links, Link{URL: prefix + "cursor=" + http.URLEscape(next) + "&" + suffix, Rel: "next"})
+	}
+	return links
+}
+
+// linkPrefix returns the request path and query with the named parameters
+// removed, ready for appending more parameters.
+func linkPrefix(req *Request, remove ...string) string {
+	query := make(Values)
+	query.ParseFormEncodedBytes([]byte(req.URL.RawQuery))
+	for _, name := range remove {
+		query[name] = nil, false
+	}
+	prefix := req.URL.Path + "?"
+	if len(query) > 0 {
+		prefix += query.FormEncodedString() + "&"
+	}
+	return prefix
+}
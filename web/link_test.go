@@ -0,0 +1,60 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	header := Header{}
+	header.AddLinks(
+		Link{URL: "/style.css", Rel: "preload", Param: map[string]string{"as": "style"}},
+		Link{URL: "/items?page=2", Rel: "next"})
+	want := []string{`</style.css>; rel="preload"; as=style`, `</items?page=2>; rel="next"`}
+	if !reflect.DeepEqual(header[HeaderLink], want) {
+		t.Errorf("AddLinks() = %q, want %q", header[HeaderLink], want)
+	}
+
+	header = NewHeader(HeaderLink, `</a,b>; rel="next", </c>;rel=prev; title="x y"`)
+	links := header.GetLinks()
+	wantLinks := []Link{
+		{URL: "/a,b", Rel: "next"},
+		{URL: "/c", Rel: "prev", Param: map[string]string{"title": "x y"}},
+	}
+	if !reflect.DeepEqual(links, wantLinks) {
+		t.Errorf("GetLinks() = %v, want %v", links, wantLinks)
+	}
+}
+
+func TestPaginationLinks(t *testing.T) {
+	var rels []string
+	RunHandler("/items?page=2&limit=10", "GET", nil, nil, HandlerFunc(func(req *Request) {
+		for _, l := range PaginationLinks(req, 2, 10, 25) {
+			rels = append(rels, l.Rel+" "+l.URL)
+		}
+		req.Respond(StatusOK)
+	}))
+	want := []string{
+		"first /items?page=1&limit=10",
+		"prev /items?page=1&limit=10",
+		"next /items?page=3&limit=10",
+		"last /items?page=3&limit=10",
+	}
+	if !reflect.DeepEqual(rels, want) {
+		t.Errorf("PaginationLinks() = %q, want %q", rels, want)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ListQueryOptions specifies the parameters accepted by ParseListQuery.
+type ListQueryOptions struct {
+	// Default number of items per page. The default is 20.
+	DefaultLimit int
+
+	// Maximum number of items per page. The default is 100.
+	MaxLimit int
+
+	// Fields that the list can be sorted by.
+	SortFields []string
+
+	// Sort order used when the request does not specify one, in the format
+	// of the sort parameter.
+	DefaultSort string
+
+	// Fields that the list can be filtered by.
+	FilterFields []string
+
+	// If not nil, the codec used to decode the cursor parameter.
+	CursorCodec *CursorCodec
+}
+
+// SortField is a field in the sort order of a list.
+type SortField struct {
+	Name       string
+	Descending bool
+}
+
+// Filter is a condition on a field. Op is one of "eq", "ne", "lt", "le",
+// "gt", "ge" or "prefix".
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// ListQuery is the pagination, sort order and filters for a list request.
+type ListQuery struct {
+	Page     int // page number starting from 1
+	Limit    int // number of items per page
+	Offset   int // (Page - 1) * Limit
+	Cursor   string
+	Position *Cursor // decoded cursor if the options specify a codec
+	Sort     []SortField
+	Filters  []Filter
+}
+
+var filterOps = map[string]bool{"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true, "prefix": true}
+
+// ParseListQuery parses the list parameters from the request query string:
+//
+//  page    page number starting from 1
+//  limit   number of items per page
+//  cursor  opaque position in the list, mutually exclusive with page
+//  sort    comma separated list of fields. A leading '-' sorts the
+//          field in descending order.
+//  filter  filter in the format field:value or field:op:value. The
+//          parameter can be repeated.
+//
+// Example:
+//
+//  /items?limit=10&sort=-created,name&filter=status:open&filter=price:lt:10
+//
+// The returned errors use the same format as Validate.
+func ParseListQuery(req *Request, options *ListQueryOptions) (*ListQuery, []ValidationError) {
+	o := *options
+	if o.DefaultLimit <= 0 {
+		o.DefaultLimit = 20
+	}
+	if o.MaxLimit <= 0 {
+		o.MaxLimit = 100
+	}
+
+	var errors []ValidationError
+	fail := func(name, message string) {
+		errors = append(errors, ValidationError{"param", name, message})
+	}
+
+	q := &ListQuery{Page: 1, Limit: o.DefaultLimit, Cursor: req.Param.Get("cursor")}
+
+	if s := req.Param.Get("page"); s != "" {
+		if n, err := strconv.Atoi(s); err != nil {
+			fail("page", "must be an integer")
+		} else if n < 1 {
+			fail("page", "must be at least 1")
+		} else {
+			q.Page = n
+		}
+		if q.Cursor != "" {
+			fail("page", "cannot be used with cursor")
+		}
+	}
+
+	if q.Cursor != "" && o.CursorCodec != nil {
+		if c, err := o.CursorCodec.Decode(q.Cursor); err != nil {
+			fail("cursor", "is not valid")
+		} else {
+			q.Position = c
+		}
+	}
+
+	if s := req.Param.Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err != nil {
+			fail("limit", "must be an integer")
+		} else if n < 1 {
+			fail("limit", "must be at least 1")
+		} else if n > o.MaxLimit {
+			fail("limit", "must be at most "+strconv.Itoa(o.MaxLimit))
+		} else {
+			q.Limit = n
+		}
+	}
+	q.Offset = (q.Page - 1) * q.Limit
+
+	sort := req.Param.Get("sort")
+	if sort == "" {
+		sort = o.DefaultSort
+	}
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f := SortField{Name: name}
+		if name[0] == '-' || name[0] == '+' {
+			f.Name = name[1:]
+			f.Descending = name[0] == '-'
+		}
+		if !containsString(o.SortFields, f.Name) {
+			fail("sort", "cannot sort by "+f.Name)
+			continue
+		}
+		q.Sort = append(q.Sort, f)
+	}
+
+	for _, s := range req.Param["filter"] {
+		i := strings.Index(s, ":")
+		if i < 0 {
+			fail("filter", "must have the format field:value or field:op:value")
+			continue
+		}
+		f := Filter{Field: s[:i], Op: "eq", Value: s[i+1:]}
+		if j := strings.Index(f.Value, ":"); j >= 0 && filterOps[f.Value[:j]] {
+			f.Op = f.Value[:j]
+			f.Value = f.Value[j+1:]
+		}
+		if !containsString(o.FilterFields, f.Field) {
+			fail("filter", "cannot filter by "+f.Field)
+			continue
+		}
+		q.Filters = append(q.Filters, f)
+	}
+
+	return q, errors
+}
+
+const listQueryEnvKey = "twister.web.ListQuery"
+
+// ListQueryHandler returns a handler that parses the list parameters with
+// ParseListQuery before calling h. If the parameters are not valid, the
+// handler responds with status 422 in the format used by ValidateHandler. Use
+// RequestListQuery to get the parsed parameters.
+func ListQueryHandler(options *ListQueryOptions, h Handler) Handler {
+	o := *options
+	return HandlerFunc(func(req *Request) {
+		q, errors := ParseListQuery(req, &o)
+		if len(errors) > 0 {
+			respondValidationErrors(req, errors)
+			return
+		}
+		req.Env[listQueryEnvKey] = q
+		h.ServeWeb(req)
+	})
+}
+
+// RequestListQuery returns the list parameters parsed by ListQueryHandler or
+// nil if the request was not handled by ListQueryHandler.
+func RequestListQuery(req *Request) *ListQuery {
+	q, _ := req.Env[listQueryEnvKey].(*ListQuery)
+	return q
+}
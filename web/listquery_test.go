@@ -0,0 +1,61 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"reflect"
+	"testing"
+)
+
+var listQueryTests = []struct {
+	url    string
+	expect *ListQuery
+}{
+	{"/", &ListQuery{Page: 1, Limit: 20, Sort: []SortField{{"created", true}}}},
+	{"/?page=3&limit=10&sort=name,-price", &ListQuery{Page: 3, Limit: 10, Offset: 20,
+		Sort: []SortField{{"name", false}, {"price", true}}}},
+	{"/?cursor=abc&filter=status:open&filter=price:lt:10&filter=created:ge:2011-07-01T10:00",
+		&ListQuery{Page: 1, Limit: 20, Cursor: "abc", Sort: []SortField{{"created", true}},
+			Filters: []Filter{{"status", "eq", "open"}, {"price", "lt", "10"}, {"created", "ge", "2011-07-01T10:00"}}}},
+	{"/?page=0", nil},
+	{"/?limit=101", nil},
+	{"/?page=2&cursor=abc", nil},
+	{"/?sort=secret", nil},
+	{"/?filter=secret:x", nil},
+	{"/?filter=status", nil},
+}
+
+func TestListQueryHandler(t *testing.T) {
+	options := &ListQueryOptions{
+		SortFields:   []string{"name", "price", "created"},
+		DefaultSort:  "-created",
+		FilterFields: []string{"status", "price", "created"},
+	}
+	for _, tt := range listQueryTests {
+		var q *ListQuery
+		status, _, _ := RunHandler(tt.url, "GET", nil, nil, ListQueryHandler(options, HandlerFunc(func(req *Request) {
+			q = RequestListQuery(req)
+			req.Respond(StatusOK)
+		})))
+		if tt.expect == nil {
+			if status != StatusUnprocessableEntity {
+				t.Errorf("%s status=%d, want %d", tt.url, status, StatusUnprocessableEntity)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(q, tt.expect) {
+			t.Errorf("%s query=%+v, want %+v", tt.url, q, tt.expect)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"json"
+	"log"
+	"sync"
+	"time"
+)
+
+// RequestIDEnvKey is the request Env key for the identifier returned by
+// RequestID. Access logs read the key to include the identifier when one was
+// assigned while handling the request.
+const RequestIDEnvKey = "twister.web.RequestID"
+
+const (
+	routeEnvKey   = "twister.web.Route"
+	logSinkEnvKey = "twister.web.LogSink"
+)
+
+// RequestID returns an identifier for the request. The identifier is taken
+// from the X-Request-Id header if present. Otherwise, a random identifier is
+// generated on the first call. The identifier is stored in the request Env.
+func RequestID(req *Request) string {
+	if id, ok := req.Env[RequestIDEnvKey].(string); ok {
+		return id
+	}
+	id := req.Header.Get("X-Request-Id")
+	if id == "" {
+		id = RandomHex(8)
+	}
+	req.Env[RequestIDEnvKey] = id
+	return id
+}
+
+// RequestRoute returns the pattern of the Router route that matched the
+// request or "" if the request was not dispatched by a Router.
+func RequestRoute(req *Request) string {
+	s, _ := req.Env[routeEnvKey].(string)
+	return s
+}
+
+// LogField is a key-value pair attached to a log message.
+type LogField struct {
+	Key, Value string
+}
+
+// LogSink writes log messages.
+type LogSink interface {
+	Log(fields []LogField, message string)
+}
+
+// LogSinkFunc is a type adapter to allow the use of ordinary functions as
+// LogSink.
+type LogSinkFunc func(fields []LogField, message string)
+
+// Log calls f(fields, message).
+func (f LogSinkFunc) Log(fields []LogField, message string) { f(fields, message) }
+
+// StandardLogSink writes messages to the standard logger in the format
+// "key=value key=value message".
+var StandardLogSink LogSink = LogSinkFunc(func(fields []LogField, message string) {
+	var b bytes.Buffer
+	for _, f := range fields {
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(f.Value)
+		b.WriteByte(' ')
+	}
+	b.WriteString(message)
+	log.Print(b.String())
+})
+
+// JSONLogSink returns a sink that writes messages to w as JSON objects, one
+// per line. Each object contains the time, the message and the fields.
+func JSONLogSink(w io.Writer) LogSink {
+	var mu sync.Mutex
+	return LogSinkFunc(func(fields []LogField, message string) {
+		m := make(map[string]interface{}, len(fields)+2)
+		for _, f := range fields {
+			m[f.Key] = f.Value
+		}
+		m["time"] = time.SecondsToUTC(Seconds()).Format(time.RFC3339)
+		m["message"] = message
+		p, err := json.Marshal(m)
+		if err != nil {
+			log.Print("twister: json log encode failed: ", err)
+			return
+		}
+		p = append(p, '\n')
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(p)
+	})
+}
+
+// LogSinkHandler returns a handler that sets the sink used by request loggers
+// for requests to h.
+func LogSinkHandler(sink LogSink, h Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		req.Env[logSinkEnvKey] = sink
+		h.ServeWeb(req)
+	})
+}
+
+// RequestLogger writes log messages tagged with request fields.
+type RequestLogger struct {
+	req    *Request
+	fields []LogField
+}
+
+// Logger returns a logger for the request. Messages are tagged with the
+// request ID, the remote address, the matched route and the country found by
+// GeoHandler. Messages are written to the sink set with LogSinkHandler or to
+// StandardLogSink if no sink is set.
+func (req *Request) Logger() *RequestLogger {
+	return &RequestLogger{req: req}
+}
+
+// With returns a logger with an additional field.
+func (l *RequestLogger) With(key, value string) *RequestLogger {
+	fields := make([]LogField, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &RequestLogger{req: l.req, fields: append(fields, LogField{key, value})}
+}
+
+func (l *RequestLogger) log(message string) {
+	fields := []LogField{
+		{"request_id", RequestID(l.req)},
+		{"remote_addr", l.req.RemoteAddr},
+	}
+	if route := RequestRoute(l.req); route != "" {
+		fields = append(fields, LogField{"route", route})
+	}
+	if loc := RequestGeo(l.req); loc != nil {
+		fields = append(fields, LogField{"country", loc.Country})
+	}
+	fields = append(fields, l.fields...)
+	sink, _ := l.req.Env[logSinkEnvKey].(LogSink)
+	if sink == nil {
+		sink = StandardLogSink
+	}
+	sink.Log(fields, message)
+}
+
+// Print logs a message. Arguments are handled in the manner of fmt.Print.
+func (l *RequestLogger) Print(v ...interface{}) {
+	l.log(fmt.Sprint(v...))
+}
+
+// Printf logs a message. Arguments are handled in the manner of fmt.Printf.
+func (l *RequestLogger) Printf(format string, v ...interface{}) {
+	l.log(fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,183 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"template"
+)
+
+// MaintenanceOptions configures a Maintenance switch.
+type MaintenanceOptions struct {
+	// Template for the 503 response page. The template is executed with a
+	// map containing "message" and "retryAfter". If Template is nil, a
+	// simple default page is used.
+	Template *template.Template
+
+	// Value of the Retry-After header in seconds when the end of maintenance
+	// is not known. The default is 300.
+	RetryAfter int
+
+	// Requests with a path that has one of these prefixes are passed to the
+	// wrapped handler while maintenance mode is enabled. Use Allow for health
+	// checks and admin pages.
+	Allow []string
+}
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html><head><title>Down for Maintenance</title></head>
+<body><h1>Down for Maintenance</h1><p>{message}</p></body></html>
+`
+
+var defaultMaintenanceTemplate = template.MustParse(defaultMaintenancePage,
+	template.FormatterMap{"": template.HTMLFormatter})
+
+// Maintenance is a switch for putting an application in maintenance mode.
+// While maintenance mode is enabled, handlers wrapped with Filter respond
+// with status 503.
+//
+// Register the Maintenance value as a handler on an admin path with
+// appropriate access control to switch the mode over HTTP:
+//
+//  m := web.NewMaintenance(&web.MaintenanceOptions{Allow: []string{"/healthz", "/admin/"}})
+//  r.Register("/admin/maintenance", "GET", m, "POST", m)
+//  server.Run(":8080", m.Filter(r))
+//
+// Applications that use signals to control the server can call SetEnabled
+// from their signal handling goroutine.
+type Maintenance struct {
+	options MaintenanceOptions
+
+	mu      sync.Mutex
+	enabled bool
+	message string
+	until   int64
+}
+
+// NewMaintenance returns a new maintenance switch. Maintenance mode is
+// initially disabled.
+func NewMaintenance(options *MaintenanceOptions) *Maintenance {
+	m := &Maintenance{options: *options}
+	if m.options.Template == nil {
+		m.options.Template = defaultMaintenanceTemplate
+	}
+	if m.options.RetryAfter <= 0 {
+		m.options.RetryAfter = 300
+	}
+	return m
+}
+
+// SetEnabled enables or disables maintenance mode. The message is displayed
+// on the maintenance page.
+func (m *Maintenance) SetEnabled(enabled bool, message string) {
+	m.SetEnabledUntil(enabled, message, 0)
+}
+
+// SetEnabledUntil enables or disables maintenance mode with the expected end
+// of maintenance in seconds since the epoch. While the end is in the future,
+// the Retry-After header is set to the end time as an HTTP date.
+func (m *Maintenance) SetEnabledUntil(enabled bool, message string, until int64) {
+	m.mu.Lock()
+	m.enabled = enabled
+	m.message = message
+	m.until = until
+	m.mu.Unlock()
+}
+
+// Enabled returns whether maintenance mode is enabled and the current
+// message.
+func (m *Maintenance) Enabled() (bool, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enabled, m.message
+}
+
+// ServeWeb serves the admin endpoint. POST with the parameter "enabled" set
+// to "true" or "false" switches maintenance mode. The optional parameter
+// "message" sets the message and the optional parameter "until" sets the
+// expected end of maintenance in seconds since the epoch. Both GET and POST
+// respond with the current state as plain text.
+func (m *Maintenance) ServeWeb(req *Request) {
+	if req.Method == "POST" {
+		switch req.Param.Get("enabled") {
+		case "true":
+			var until int64
+			if s := req.Param.Get("until"); s != "" {
+				var err os.Error
+				if until, err = strconv.Atoi64(s); err != nil {
+					req.Error(StatusBadRequest, err)
+					return
+				}
+			}
+			m.SetEnabledUntil(true, req.Param.Get("message"), until)
+		case "false":
+			m.SetEnabled(false, "")
+		default:
+			req.Error(StatusBadRequest, nil)
+			return
+		}
+	}
+	enabled, message := m.Enabled()
+	w := req.Respond(StatusOK, HeaderContentType, "text/plain; charset=utf-8")
+	w.Write([]byte("enabled: " + strconv.Btoa(enabled) + "\nmessage: " + message + "\n"))
+}
+
+func (m *Maintenance) allowed(path string) bool {
+	for _, prefix := range m.options.Allow {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns a handler that responds with status 503 and the maintenance
+// page while maintenance mode is enabled and calls h otherwise.
+func (m *Maintenance) Filter(h Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		m.mu.Lock()
+		enabled, message, until := m.enabled, m.message, m.until
+		m.mu.Unlock()
+		if !enabled || m.allowed(req.URL.Path) {
+			h.ServeWeb(req)
+			return
+		}
+		retryAfterSeconds := int64(m.options.RetryAfter)
+		retryAfter := strconv.Itoa(m.options.RetryAfter)
+		if now := Seconds(); until > now {
+			retryAfterSeconds = until - now
+			retryAfter = RetryAfterTime(until)
+		}
+		var b bytes.Buffer
+		err := m.options.Template.Execute(&b, map[string]interface{}{
+			"message":    message,
+			"retryAfter": retryAfterSeconds,
+		})
+		if err != nil {
+			req.Error(StatusServiceUnavailable, err, HeaderRetryAfter, retryAfter)
+			return
+		}
+		w := req.Respond(StatusServiceUnavailable,
+			HeaderContentType, "text/html; charset=utf-8",
+			HeaderContentLength, strconv.Itoa(b.Len()),
+			HeaderRetryAfter, retryAfter,
+			HeaderCacheControl, "no-cache")
+		w.Write(b.Bytes())
+	})
+}
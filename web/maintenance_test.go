@@ -0,0 +1,79 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	clock := NewFakeClock(1000e9)
+	defer SetClock(SetClock(clock))
+
+	m := NewMaintenance(&MaintenanceOptions{Allow: []string{"/healthz", "/admin/"}})
+	h := m.Filter(HandlerFunc(func(req *Request) {
+		if req.URL.Path == "/admin/maintenance" {
+			m.ServeWeb(req)
+		} else {
+			req.Respond(StatusOK)
+		}
+	}))
+
+	if status, _, _ := RunHandler("/page", "GET", nil, nil, h); status != StatusOK {
+		t.Errorf("disabled status=%d, want %d", status, StatusOK)
+	}
+
+	status, _, body := RunHandler("/admin/maintenance?enabled=true&message=%3Cupgrade%3E", "POST", nil, nil, h)
+	if status != StatusOK || string(body) != "enabled: true\nmessage: <upgrade>\n" {
+		t.Errorf("enable = %d %q", status, body)
+	}
+	if enabled, message := m.Enabled(); !enabled || message != "<upgrade>" {
+		t.Errorf("Enabled() = %v %q, want true %q", enabled, message, "<upgrade>")
+	}
+
+	status, header, body := RunHandler("/page", "GET", nil, nil, h)
+	if status != StatusServiceUnavailable || header.Get(HeaderRetryAfter) != "300" || header.Get(HeaderCacheControl) != "no-cache" {
+		t.Errorf("enabled = %d %v", status, header)
+	}
+	if s := string(body); !strings.Contains(s, "&lt;upgrade&gt;") || strings.Contains(s, "<upgrade>") {
+		t.Errorf("page does not contain escaped message: %q", s)
+	}
+	for _, path := range []string{"/healthz", "/admin/maintenance"} {
+		if status, _, _ := RunHandler(path, "GET", nil, nil, h); status != StatusOK {
+			t.Errorf("allowed path %s status=%d, want %d", path, status, StatusOK)
+		}
+	}
+
+	// Retry-After is the end of maintenance while the end is in the future.
+	RunHandler("/admin/maintenance?enabled=true&until=1060", "POST", nil, nil, h)
+	if _, header, _ := RunHandler("/page", "GET", nil, nil, h); header.Get(HeaderRetryAfter) != RetryAfterTime(1060) {
+		t.Errorf("Retry-After=%q, want %q", header.Get(HeaderRetryAfter), RetryAfterTime(1060))
+	}
+	clock.Advance(120e9)
+	if _, header, _ := RunHandler("/page", "GET", nil, nil, h); header.Get(HeaderRetryAfter) != "300" {
+		t.Errorf("Retry-After after end=%q, want 300", header.Get(HeaderRetryAfter))
+	}
+
+	for _, query := range []string{"enabled=maybe", "enabled=true&until=soon"} {
+		if status, _, _ := RunHandler("/admin/maintenance?"+query, "POST", nil, nil, h); status != StatusBadRequest {
+			t.Errorf("%s status=%d, want %d", query, status, StatusBadRequest)
+		}
+	}
+
+	RunHandler("/admin/maintenance?enabled=false", "POST", nil, nil, h)
+	if status, _, _ := RunHandler("/page", "GET", nil, nil, h); status != StatusOK {
+		t.Errorf("disabled again status=%d, want %d", status, StatusOK)
+	}
+}
@@ -17,6 +17,7 @@ package web
 import (
 	"io"
 	"os"
+	"strings"
 )
 
 type filterResponder struct {
@@ -65,6 +66,12 @@ func SetErrorHandler(e ErrorHandler, h Handler) Handler {
 // header is not present.
 //
 // The header names must be in canonical header name format.
+//
+// If the request has a Forwarded header as described in RFC 7239, the for and
+// proto parameters of the last element are preferred over the addrName and
+// schemeName headers. The last element is the one added by the proxy in front
+// of the application. As with the other headers, a Forwarded parameter is
+// used only when the corresponding header name is not "".
 // 
 // Here's an example of how to use this handler with Nginx. In the nginx proxy
 // configuration, specify a header for the IP address and scheme. The host
@@ -108,13 +115,28 @@ type proxyHeaderHandler struct {
 }
 
 func (h proxyHeaderHandler) ServeWeb(req *Request) {
-	if s := req.Header.Get(h.addrName); s != "" {
+	var addr, scheme string
+	if h.addrName != "" {
+		addr = req.Header.Get(h.addrName)
+	}
+	if h.schemeName != "" {
+		scheme = req.Header.Get(h.schemeName)
+	}
+	if f := lastForwarded(req.Header); f != nil {
+		if s := forwardedAddr(f["for"]); s != "" && h.addrName != "" {
+			addr = s
+		}
+		if s := f["proto"]; s != "" && h.schemeName != "" {
+			scheme = strings.ToLower(s)
+		}
+	}
+	if addr != "" {
 		req.Env["twister.web.OriginalRemoteAddr"] = req.RemoteAddr
-		req.RemoteAddr = s
+		req.RemoteAddr = addr
 	}
-	if s := req.Header.Get(h.schemeName); s != "" {
+	if scheme != "" {
 		req.Env["twister.web.OriginalScheme"] = req.URL.Scheme
-		req.URL.Scheme = s
+		req.URL.Scheme = scheme
 	}
 	h.h.ServeWeb(req)
 }
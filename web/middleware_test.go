@@ -17,6 +17,7 @@ package web
 import (
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -110,3 +111,214 @@ func TestXSRF(t *testing.T) {
 		}
 	}
 }
+
+var canonicalHostTests = []struct {
+	method   string
+	url      string
+	header   Header
+	status   int
+	location string
+}{
+	{"GET", "https://www.example.com/a", nil, StatusOK, ""},
+	{"GET", "http://example.com/a?b=c", nil, StatusMovedPermanently, "https://www.example.com/a?b=c"},
+	{"POST", "https://example.com/a", nil, StatusTemporaryRedirect, "https://www.example.com/a"},
+	{"GET", "http://www.example.com/a", NewHeader("X-Forwarded-Proto", "https"), StatusOK, ""},
+	{"GET", "http://www.example.com/a", NewHeader("X-Forwarded-Proto", "http"), StatusMovedPermanently, "https://www.example.com/a"},
+	{"GET", "http://www.example.com/a", NewHeader("X-Forwarded-Proto", "http", HeaderForwarded, "proto=https"), StatusOK, ""},
+	{"GET", "http://www.example.com/a", NewHeader(HeaderForwarded, "proto=https, for=10.0.0.1;proto=http"), StatusMovedPermanently, "https://www.example.com/a"},
+}
+
+func TestCanonicalHostHandler(t *testing.T) {
+	h := CanonicalHostHandler(&CanonicalHostOptions{
+		Host:         "www.example.com",
+		Scheme:       "https",
+		SchemeHeader: "X-Forwarded-Proto",
+	}, HandlerFunc(func(req *Request) { req.Respond(StatusOK) }))
+	for _, tt := range canonicalHostTests {
+		status, header, _ := RunHandler(tt.url, tt.method, tt.header, nil, h)
+		if status != tt.status || header.Get(HeaderLocation) != tt.location {
+			t.Errorf("%s %s status=%d location=%q, want %d %q", tt.method, tt.url, status, header.Get(HeaderLocation), tt.status, tt.location)
+		}
+	}
+}
+
+var proxyHeaderTests = []struct {
+	header     Header
+	remoteAddr string
+	scheme     string
+}{
+	{NewHeader(), "1.2.3.4", "http"},
+	{NewHeader("X-Real-Ip", "10.0.0.1", "X-Scheme", "https"), "10.0.0.1", "https"},
+	{NewHeader("X-Real-Ip", "10.0.0.1", HeaderForwarded, `for=10.0.0.2, for="[2001:db8::1]:80";proto=HTTPS`), "2001:db8::1", "https"},
+	{NewHeader("X-Real-Ip", "10.0.0.1", HeaderForwarded, "for=unknown"), "10.0.0.1", "http"},
+	{NewHeader(HeaderForwarded, "for=_hidden"), "1.2.3.4", "http"},
+}
+
+func TestProxyHeaderHandler(t *testing.T) {
+	var remoteAddr, scheme string
+	h := ProxyHeaderHandler("X-Real-Ip", "X-Scheme", HandlerFunc(func(req *Request) {
+		remoteAddr = req.RemoteAddr
+		scheme = req.URL.Scheme
+		req.Respond(StatusOK)
+	}))
+	for _, tt := range proxyHeaderTests {
+		RunHandler("http://example.com/", "GET", tt.header, nil, h)
+		if remoteAddr != tt.remoteAddr || scheme != tt.scheme {
+			t.Errorf("%v remoteAddr=%q scheme=%q, want %q %q", tt.header, remoteAddr, scheme, tt.remoteAddr, tt.scheme)
+		}
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var got []LogField
+	var message string
+	sink := LogSinkFunc(func(fields []LogField, m string) {
+		got = fields
+		message = m
+	})
+	h := LogSinkHandler(sink, NewRouter().Register("/items/<id>", "GET", func(req *Request) {
+		req.Logger().With("user", "alice").Printf("item %s", req.URLParam["id"])
+		req.Respond(StatusOK)
+	}))
+	RunHandler("http://example.com/items/7", "GET", NewHeader("X-Request-Id", "abc"), nil, h)
+	want := []LogField{
+		{"request_id", "abc"},
+		{"remote_addr", "1.2.3.4"},
+		{"route", "/items/<id>"},
+		{"user", "alice"},
+	}
+	if message != "item 7" {
+		t.Errorf("message=%q, want %q", message, "item 7")
+	}
+	if len(got) != len(want) {
+		t.Fatalf("fields=%v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("field %d=%v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestErrorReportHandler(t *testing.T) {
+	var reports []*ErrorReport
+	reporter := RateLimitReporter(ErrorReporterFunc(func(r *ErrorReport) { reports = append(reports, r) }), 2, 3600)
+	h := ErrorReportHandler(reporter, HandlerFunc(func(req *Request) {
+		switch req.URL.Path {
+		case "/ok":
+			req.Respond(StatusOK)
+		case "/error":
+			req.Error(StatusServiceUnavailable, os.NewError("backend down"))
+		case "/panic":
+			panic("boom")
+		}
+	}))
+	RunHandler("http://example.com/ok", "GET", nil, nil, h)
+	RunHandler("http://example.com/error", "GET", NewHeader(HeaderCookie, "session=secret", HeaderAccept, "text/html"), nil, h)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic not propagated")
+			}
+		}()
+		RunHandler("http://example.com/panic", "GET", nil, nil, h)
+	}()
+	RunHandler("http://example.com/error", "GET", nil, nil, h)
+	RunHandler("http://example.com/error", "GET", nil, nil, h)
+	if len(reports) != 2 {
+		t.Fatalf("len(reports)=%d, want 2", len(reports))
+	}
+	if r := reports[0]; r.Status != StatusServiceUnavailable || r.Error != "backend down" || r.URL != "http://example.com/error" {
+		t.Errorf("report 0 = %d %q %q", r.Status, r.Error, r.URL)
+	}
+	if r := reports[0]; r.Header.Get(HeaderCookie) != "[redacted]" || r.Header.Get(HeaderAccept) != "text/html" {
+		t.Errorf("report 0 header = %v", r.Header)
+	}
+	if r := reports[1]; r.Status != StatusInternalServerError || r.Error != "boom" || r.Stack == "" {
+		t.Errorf("report 1 = %d %q", r.Status, r.Error)
+	}
+}
+
+func TestAttributionHandler(t *testing.T) {
+	var a *Attribution
+	h := AttributionHandler(&AttributionOptions{Secret: "secret"}, HandlerFunc(func(req *Request) {
+		a = RequestAttribution(req)
+		req.Respond(StatusOK)
+	}))
+
+	_, header, _ := RunHandler("http://example.com/", "GET", NewHeader(HeaderReferer, "http://example.com/other"), nil, h)
+	if a != nil || header.Get(HeaderSetCookie) != "" {
+		t.Fatalf("internal referrer captured: %+v", a)
+	}
+
+	_, header, _ = RunHandler("http://example.com/landing?utm_source=news&utm_campaign=summer+sale", "GET",
+		NewHeader(HeaderReferer, "http://mail.example.org/"), nil, h)
+	expected := Attribution{Referrer: "http://mail.example.org/", Source: "news", Campaign: "summer sale", LandingPage: "/landing"}
+	if a == nil || *a != expected {
+		t.Fatalf("first visit attribution = %+v, want %+v", a, expected)
+	}
+	cookie := header.Get(HeaderSetCookie)
+	if i := strings.Index(cookie, ";"); i >= 0 {
+		cookie = cookie[:i]
+	}
+
+	a = nil
+	RunHandler("http://example.com/next", "GET", NewHeader(HeaderCookie, cookie), nil, h)
+	if a == nil || *a != expected {
+		t.Errorf("next visit attribution = %+v, want %+v", a, expected)
+	}
+}
+
+var resourceTests = []struct {
+	status    int    // status from handler, -1 for no response
+	openErr   bool   // true if Open returns an error
+	closeErr  bool   // true if Close returns an error
+	expStatus int    // expected response status
+	expLog    string // expected sequence of resource calls
+}{
+	{status: StatusOK, expStatus: StatusOK, expLog: "open a, open b, close b true, close a true"},
+	{status: StatusNotFound, expStatus: StatusNotFound, expLog: "open a, open b, close b false, close a false"},
+	{openErr: true, expStatus: StatusServiceUnavailable, expLog: "open a, open b"},
+	{status: StatusOK, closeErr: true, expStatus: StatusOK, expLog: "open a, open b, close b true, close a false"},
+	{status: -1, closeErr: true, expStatus: StatusInternalServerError, expLog: "open a, open b, close b true, close a false"},
+}
+
+func TestResourceHandler(t *testing.T) {
+	for _, tt := range resourceTests {
+		var log []string
+		newResource := func(name string) *Resource {
+			return &Resource{
+				Name: name,
+				Open: func(req *Request) (interface{}, os.Error) {
+					log = append(log, "open "+name)
+					if tt.openErr && name == "b" {
+						return nil, os.NewError("open")
+					}
+					return name + " value", nil
+				},
+				Close: func(req *Request, value interface{}, ok bool) os.Error {
+					log = append(log, "close "+name+" "+strconv.Btoa(ok))
+					if tt.closeErr && name == "b" {
+						return os.NewError("close")
+					}
+					return nil
+				},
+			}
+		}
+		h := ResourceHandler(HandlerFunc(func(req *Request) {
+			if v, _ := RequestResource(req, "a").(string); v != "a value" {
+				t.Errorf("RequestResource(a) = %q", v)
+			}
+			if tt.status > 0 {
+				req.Respond(tt.status)
+			}
+		}), newResource("a"), newResource("b"))
+		status, _, _ := RunHandler("http://example.com/", "GET", nil, nil, h)
+		if status != tt.expStatus {
+			t.Errorf("status=%d, want %d", status, tt.expStatus)
+		}
+		if s := strings.Join(log, ", "); s != tt.expLog {
+			t.Errorf("log=%q, want %q", s, tt.expLog)
+		}
+	}
+}
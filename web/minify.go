@@ -0,0 +1,292 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MinifyOptions configures MinifyHandler.
+type MinifyOptions struct {
+	// Responses larger than MaxSize bytes are sent unchanged. The default
+	// is 1 MB.
+	MaxSize int
+}
+
+// MinifyHandler returns a handler that minifies HTML, CSS and JavaScript
+// responses from h. The minifiers are conservative:
+//
+//  - HTML: comments other than conditional comments are removed and runs of
+//    whitespace between tags are collapsed. The contents of pre, textarea,
+//    script and style elements are not changed.
+//  - CSS: comments are removed and whitespace is collapsed outside of
+//    strings.
+//  - JavaScript: line indentation, trailing whitespace and blank lines are
+//    removed. Comments are not removed because that requires a parser to
+//    distinguish regular expression literals.
+//
+// Use MinifyHandler inside CompressHandler so that the minified response is
+// compressed:
+//
+//  h = web.CompressHandler(&web.CompressOptions{}, web.MinifyHandler(&web.MinifyOptions{}, h))
+func MinifyHandler(options *MinifyOptions, h Handler) Handler {
+	mh := &minifyHandler{options: *options, h: h}
+	if mh.options.MaxSize <= 0 {
+		mh.options.MaxSize = 1 << 20
+	}
+	return mh
+}
+
+type minifyHandler struct {
+	options MinifyOptions
+	h       Handler
+}
+
+func (mh *minifyHandler) ServeWeb(req *Request) {
+	if req.Method == "HEAD" {
+		// The length of the minified body is not known without the body.
+		mh.h.ServeWeb(req)
+		return
+	}
+	mr := &minifyResponder{Responder: req.Responder, max: mh.options.MaxSize}
+	req.Responder = mr
+	mh.h.ServeWeb(req)
+	mr.finish()
+}
+
+var minifiers = map[string]func([]byte) []byte{
+	"text/html":                minifyHTML,
+	"text/css":                 minifyCSS,
+	"text/javascript":          minifyJS,
+	"application/javascript":   minifyJS,
+	"application/x-javascript": minifyJS,
+}
+
+type minifyResponder struct {
+	Responder
+	max    int
+	minify func([]byte) []byte
+	status int
+	header Header
+	buf    bytes.Buffer
+	body   io.Writer // set when the response is passed through
+}
+
+func (mr *minifyResponder) Respond(status int, header Header) io.Writer {
+	contentType := header.Get(HeaderContentType)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	minify := minifiers[strings.ToLower(strings.TrimSpace(contentType))]
+	if minify == nil || status != StatusOK || header.Get(HeaderContentEncoding) != "" {
+		return mr.Responder.Respond(status, header)
+	}
+	mr.minify = minify
+	mr.status = status
+	mr.header = header
+	return mr
+}
+
+func (mr *minifyResponder) Write(p []byte) (int, os.Error) {
+	if mr.body != nil {
+		return mr.body.Write(p)
+	}
+	mr.buf.Write(p)
+	if mr.buf.Len() > mr.max {
+		// Too large to minify; send the buffered data unchanged.
+		mr.body = mr.Responder.Respond(mr.status, mr.header)
+		if _, err := mr.body.Write(mr.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		mr.buf.Reset()
+	}
+	return len(p), nil
+}
+
+func (mr *minifyResponder) finish() {
+	if mr.header == nil || mr.body != nil {
+		return
+	}
+	p := mr.minify(mr.buf.Bytes())
+	mr.header.Set(HeaderContentLength, strconv.Itoa(len(p)))
+	// The body differs from the representation identified by a strong
+	// entity tag and ranges of the original body.
+	if etag := mr.header.Get(HeaderEtag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		mr.header.Set(HeaderEtag, "W/"+etag)
+	}
+	mr.header[HeaderAcceptRanges] = nil, false
+	mr.Responder.Respond(mr.status, mr.header).Write(p)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// rawTextTags are elements whose contents are copied unchanged by minifyHTML.
+var rawTextTags = []string{"pre", "textarea", "script", "style"}
+
+// hasTagPrefix returns true if p starts with "<" + name followed by a
+// character that ends the tag name.
+func hasTagPrefix(p []byte, prefix, name string) bool {
+	n := len(prefix) + len(name)
+	if len(p) < n || string(p[:len(prefix)]) != prefix || strings.ToLower(string(p[len(prefix):n])) != name {
+		return false
+	}
+	return len(p) == n || p[n] == '>' || p[n] == '/' || isSpace(p[n])
+}
+
+func minifyHTML(p []byte) []byte {
+	var b bytes.Buffer
+	for len(p) > 0 {
+		switch {
+		case bytes.HasPrefix(p, []byte("<!--")) && !bytes.HasPrefix(p, []byte("<!--[if")):
+			i := bytes.Index(p[4:], []byte("-->"))
+			if i < 0 {
+				b.Write(p)
+				return b.Bytes()
+			}
+			p = p[4+i+3:]
+		case p[0] == '<':
+			raw := ""
+			for _, name := range rawTextTags {
+				if hasTagPrefix(p, "<", name) {
+					raw = name
+					break
+				}
+			}
+			if raw == "" {
+				i := bytes.IndexByte(p, '>')
+				if i < 0 {
+					b.Write(p)
+					return b.Bytes()
+				}
+				b.Write(p[:i+1])
+				p = p[i+1:]
+				continue
+			}
+			// Copy the element through the end tag unchanged.
+			end := len(p)
+			for i := 1; i < len(p); i++ {
+				if p[i] == '<' && hasTagPrefix(p[i:], "</", raw) {
+					if j := bytes.IndexByte(p[i:], '>'); j >= 0 {
+						end = i + j + 1
+					}
+					break
+				}
+			}
+			b.Write(p[:end])
+			p = p[end:]
+		case isSpace(p[0]):
+			i := 0
+			newline := false
+			for i < len(p) && isSpace(p[i]) {
+				newline = newline || p[i] == '\n'
+				i++
+			}
+			sep := byte(' ')
+			if newline {
+				sep = '\n'
+			}
+			// Merge with whitespace left before a removed comment.
+			if n := b.Len(); n > 0 && isSpace(b.Bytes()[n-1]) {
+				if sep == '\n' {
+					b.Bytes()[n-1] = sep
+				}
+			} else {
+				b.WriteByte(sep)
+			}
+			p = p[i:]
+		default:
+			i := 0
+			for i < len(p) && p[i] != '<' && !isSpace(p[i]) {
+				i++
+			}
+			b.Write(p[:i])
+			p = p[i:]
+		}
+	}
+	return bytes.TrimSpace(b.Bytes())
+}
+
+// isCSSPunct returns true if whitespace around c is not significant in CSS.
+// The colon is excluded because whitespace before a colon separates a
+// pseudo-class from a descendant selector.
+func isCSSPunct(c byte) bool {
+	return strings.IndexRune("{};,>", int(c)) >= 0
+}
+
+func minifyCSS(p []byte) []byte {
+	var b bytes.Buffer
+	space := false
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '/' && i+1 < len(p) && p[i+1] == '*':
+			j := bytes.Index(p[i+2:], []byte("*/"))
+			if j < 0 {
+				return b.Bytes()
+			}
+			i += 2 + j + 1
+			continue
+		case isSpace(c):
+			space = b.Len() > 0
+			continue
+		}
+		if space && !isCSSPunct(c) && !isCSSPunct(b.Bytes()[b.Len()-1]) {
+			b.WriteByte(' ')
+		}
+		space = false
+		if c == '"' || c == '\'' {
+			j := i + 1
+			for j < len(p) && p[j] != c {
+				if p[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(p) {
+				j = len(p) - 1
+			}
+			b.Write(p[i : j+1])
+			i = j
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.Bytes()
+}
+
+func minifyJS(p []byte) []byte {
+	var b bytes.Buffer
+	continued := false
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		line = bytes.TrimRight(line, " \t\r")
+		if !continued {
+			line = bytes.TrimLeft(line, " \t")
+		}
+		if len(line) == 0 && !continued {
+			continue
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+		// A line ending with a backslash continues a string literal.
+		continued = bytes.HasSuffix(line, []byte("\\"))
+	}
+	return b.Bytes()
+}
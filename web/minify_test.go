@@ -0,0 +1,118 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io"
+	"strconv"
+	"testing"
+)
+
+var minifyTests = []struct {
+	minify  func([]byte) []byte
+	in, out string
+}{
+	{minifyHTML,
+		"<html>\n  <head>\n    <!-- c -->\n<!--[if IE]>x<![endif]-->  <title> A  b </title>\n  </head>\n<pre>  a\n   b</pre>  <script>\n if (a  <  b) {}\n</script>  <p>x</p>\n</html>\n",
+		"<html>\n<head>\n<!--[if IE]>x<![endif]--> <title> A b </title>\n</head>\n<pre>  a\n   b</pre> <script>\n if (a  <  b) {}\n</script> <p>x</p>\n</html>"},
+	{minifyCSS,
+		"/* c */\nbody  {\n  color : red ;\n  font-family: \"A  B\", serif;\n}\na > b  c { margin: 0  auto }\n",
+		"body{color : red;font-family: \"A  B\",serif;}a>b c{margin: 0 auto}"},
+	{minifyCSS,
+		"div :first-child { color: red }\na:hover {}\n",
+		"div :first-child{color: red}a:hover{}"},
+	{minifyJS,
+		"function f() {\n    var s = \"a \\\n    b\";\n\n    return s;  \n}\n",
+		"function f() {\nvar s = \"a \\\n    b\";\nreturn s;\n}\n"},
+}
+
+func TestMinify(t *testing.T) {
+	for _, tt := range minifyTests {
+		if out := string(tt.minify([]byte(tt.in))); out != tt.out {
+			t.Errorf("minify(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestMinifyHandler(t *testing.T) {
+	for _, contentType := range []string{"text/html; charset=utf-8", "text/plain"} {
+		h := MinifyHandler(&MinifyOptions{}, HandlerFunc(func(req *Request) {
+			io.WriteString(req.Respond(StatusOK, HeaderContentType, contentType), "<p>  a  </p>\n\n")
+		}))
+		_, header, body := RunHandler("http://example.com/", "GET", nil, nil, h)
+		expected := "<p>  a  </p>\n\n"
+		if contentType != "text/plain" {
+			expected = "<p> a </p>"
+			if header.Get(HeaderContentLength) != strconv.Itoa(len(expected)) {
+				t.Errorf("%s: Content-Length = %q", contentType, header.Get(HeaderContentLength))
+			}
+		}
+		if string(body) != expected {
+			t.Errorf("%s: body = %q, want %q", contentType, body, expected)
+		}
+	}
+
+	h := MinifyHandler(&MinifyOptions{}, HandlerFunc(func(req *Request) {
+		const body = "<p>  a  </p>"
+		w := req.Respond(StatusOK,
+			HeaderContentType, "text/html",
+			HeaderContentLength, strconv.Itoa(len(body)),
+			HeaderEtag, `"x"`,
+			HeaderAcceptRanges, "bytes")
+		if req.Method != "HEAD" {
+			io.WriteString(w, body)
+		}
+	}))
+	_, header, _ := RunHandler("http://example.com/", "GET", nil, nil, h)
+	if etag := header.Get(HeaderEtag); etag != `W/"x"` {
+		t.Errorf("GET Etag = %q, want %q", etag, `W/"x"`)
+	}
+	if v := header.Get(HeaderAcceptRanges); v != "" {
+		t.Errorf("GET Accept-Ranges = %q, want none", v)
+	}
+	_, header, _ = RunHandler("http://example.com/", "HEAD", nil, nil, h)
+	if n := header.Get(HeaderContentLength); n != "12" {
+		t.Errorf("HEAD Content-Length = %q, want 12", n)
+	}
+}
+
+func TestHTMLRewriteHandler(t *testing.T) {
+	in := `<!DOCTYPE html><html><head><title>a<b></title><script src="/a.js">if (a<b) {}</script>` +
+		`<link rel=stylesheet href='/s.css'></head><body><!-- c --><img src="/i.png" alt="x > y"><p>a < b</p></body></html>`
+	expect := `<!DOCTYPE html><html><head><title>a<b></title><script src="https://cdn/a.js" nonce="n">if (a<b) {}</script>` +
+		`<link rel="stylesheet" href="https://cdn/s.css"></head><body><!-- c --><img src="https://cdn/i.png" alt="x > y"><p>a < b</p><x></body></html>`
+	for _, chunk := range []int{1, 3, 1000} {
+		h := HTMLRewriteHandler(HandlerFunc(func(req *Request) {
+			w := req.Respond(StatusOK, HeaderContentType, "text/html; charset=utf-8", HeaderContentLength, strconv.Itoa(len(in)))
+			for i := 0; i < len(in); i += chunk {
+				j := i + chunk
+				if j > len(in) {
+					j = len(in)
+				}
+				io.WriteString(w, in[i:j])
+			}
+		}),
+			InsertBeforeEndTag("body", "<x>"),
+			ScriptNonceFilter(func(req *Request) string { return "n" }),
+			AssetHostFilter("https://cdn/"))
+		_, header, body := RunHandler("/", "GET", nil, nil, h)
+		if string(body) != expect {
+			t.Errorf("chunk=%d body=\n%s\nwant\n%s", chunk, body, expect)
+		}
+		if header.Get(HeaderContentLength) != "" {
+			t.Errorf("chunk=%d Content-Length not removed", chunk)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"rand"
+	"runtime/debug"
+)
+
+// MirrorOptions specifies the behavior of MirrorHandler.
+type MirrorOptions struct {
+	// Handler that receives the mirrored requests. The response is
+	// discarded. To mirror requests to an upstream server, use a handler from
+	// the proxy package.
+	Handler Handler
+
+	// Fraction of requests mirrored. The default is 1.
+	SampleRate float64
+
+	// Requests with a body longer than MaxBodySize bytes or with a body of
+	// unknown length are not mirrored. The default is 64 KB.
+	MaxBodySize int
+
+	// Maximum number of mirrored requests in progress. Requests arriving
+	// while the limit is reached are not mirrored. The default is 10.
+	MaxConcurrent int
+}
+
+// MirrorHandler returns a handler that duplicates a sampled fraction of the
+// requests to a secondary handler before calling h. The mirrored request has
+// the same method, URL, headers and body as the original request. Mirrored
+// requests run in the background and do not affect the response to the
+// original request. Panics in the secondary handler are recovered and logged.
+// If reading the request body fails, MirrorHandler responds with an error and
+// does not call h.
+//
+// Use MirrorHandler to validate a new implementation against production
+// traffic.
+func MirrorHandler(options *MirrorOptions, h Handler) Handler {
+	m := &mirrorHandler{options: *options, h: h}
+	if m.options.Handler == nil {
+		panic("twister: MirrorHandler requires Handler option")
+	}
+	if m.options.SampleRate <= 0 || m.options.SampleRate > 1 {
+		m.options.SampleRate = 1
+	}
+	if m.options.MaxBodySize <= 0 {
+		m.options.MaxBodySize = 64 * 1024
+	}
+	if m.options.MaxConcurrent <= 0 {
+		m.options.MaxConcurrent = 10
+	}
+	m.sem = make(chan bool, m.options.MaxConcurrent)
+	return m
+}
+
+type mirrorHandler struct {
+	options MirrorOptions
+	h       Handler
+	sem     chan bool
+}
+
+func (m *mirrorHandler) ServeWeb(req *Request) {
+	if m.options.SampleRate == 1 || rand.Float64() < m.options.SampleRate {
+		if err := m.mirror(req); err != nil {
+			// The body is partially consumed, so h cannot handle the
+			// request.
+			req.Error(StatusBadRequest, err)
+			return
+		}
+	}
+	m.h.ServeWeb(req)
+}
+
+// mirror starts a mirrored request. An error is returned if the request body
+// cannot be read.
+func (m *mirrorHandler) mirror(req *Request) os.Error {
+	if req.ContentLength < 0 || req.ContentLength > m.options.MaxBodySize {
+		return nil
+	}
+	select {
+	case m.sem <- true:
+	default:
+		return nil
+	}
+
+	var body []byte
+	if req.ContentLength > 0 {
+		var err os.Error
+		if body, err = req.BufferBody(m.options.MaxBodySize); err != nil {
+			<-m.sem
+			return err
+		}
+	}
+
+	// Copy the request fields because the server can reuse the request
+	// after the handler returns.
+	header := make(Header, len(req.Header))
+	for k, v := range req.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	url := *req.URL
+	parent := &Request{RemoteAddr: req.RemoteAddr, URL: &url}
+	method := req.Method
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("twister: mirror handler panic for %s %s: %v\n%s", method, url.String(), r, debug.Stack())
+			}
+			<-m.sem
+		}()
+		dispatch(m.options.Handler, parent, method, url.String(), header, bytes.NewBuffer(body))
+	}()
+	return nil
+}
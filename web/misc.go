@@ -17,7 +17,6 @@ package web
 import (
 	"bytes"
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
 	"io"
@@ -35,7 +34,7 @@ const TimeLayout = "Mon, 02 Jan 2006 15:04:05 GMT"
 
 // FormatDeltaSeconds returns current time plus delta formatted per HTTP conventions.
 func FormatDeltaSeconds(delta int) string {
-	return time.SecondsToUTC(time.Seconds() + int64(delta)).Format(TimeLayout)
+	return time.SecondsToUTC(Seconds() + int64(delta)).Format(TimeLayout)
 }
 
 // FormatDeltaDays returns current time plus delta formatted per HTTP conventions.
@@ -60,6 +59,7 @@ const (
 	StatusNoContent                    = 204
 	StatusResetContent                 = 205
 	StatusPartialContent               = 206
+	StatusMultiStatus                  = 207 // RFC 4918
 	StatusMultipleChoices              = 300
 	StatusMovedPermanently             = 301
 	StatusFound                        = 302
@@ -85,6 +85,12 @@ const (
 	StatusUnsupportedMediaType         = 415
 	StatusRequestedRangeNotSatisfiable = 416
 	StatusExpectationFailed            = 417
+	StatusMisdirectedRequest           = 421 // RFC 7540
+	StatusUnprocessableEntity          = 422 // RFC 4918
+	StatusLocked                       = 423 // RFC 4918
+	StatusPreconditionRequired         = 428 // RFC 6585
+	StatusTooManyRequests              = 429 // RFC 6585
+	StatusUnavailableForLegalReasons   = 451 // RFC 7725
 	StatusInternalServerError          = 500
 	StatusNotImplemented               = 501
 	StatusBadGateway                   = 502
@@ -103,6 +109,7 @@ var statusText = map[int]string{
 	StatusNoContent:                    "No Content",
 	StatusResetContent:                 "Reset Content",
 	StatusPartialContent:               "Partial Content",
+	StatusMultiStatus:                  "Multi-Status",
 	StatusMultipleChoices:              "Multiple Choices",
 	StatusMovedPermanently:             "Moved Permanently",
 	StatusFound:                        "Found",
@@ -128,6 +135,12 @@ var statusText = map[int]string{
 	StatusUnsupportedMediaType:         "Unsupported Media Type",
 	StatusRequestedRangeNotSatisfiable: "Requested Range Not Satisfiable",
 	StatusExpectationFailed:            "Expectation Failed",
+	StatusMisdirectedRequest:           "Misdirected Request",
+	StatusUnprocessableEntity:          "Unprocessable Entity",
+	StatusLocked:                       "Locked",
+	StatusPreconditionRequired:         "Precondition Required",
+	StatusTooManyRequests:              "Too Many Requests",
+	StatusUnavailableForLegalReasons:   "Unavailable For Legal Reasons",
 	StatusInternalServerError:          "Internal Server Error",
 	StatusNotImplemented:               "Not Implemented",
 	StatusBadGateway:                   "Bad Gateway",
@@ -239,7 +252,7 @@ func signature(secret, key, expiration, value string) string {
 //      return web.VerifyValue(secret, "uid", req.Cookie.Get("uid"))
 //  }
 func SignValue(secret, context string, maxAgeSeconds int, value string) string {
-	expiration := strconv.Itob64(time.Seconds()+int64(maxAgeSeconds), 16)
+	expiration := strconv.Itob64(Seconds()+int64(maxAgeSeconds), 16)
 	sig := signature(secret, context, expiration, value)
 	return sig + "~" + expiration + "~" + value
 }
@@ -254,7 +267,7 @@ func VerifyValue(secret, context string, signedValue string) (string, os.Error)
 		return "", errVerificationFailure
 	}
 	expiration, err := strconv.Btoi64(a[1], 16)
-	if err != nil || expiration < time.Seconds() {
+	if err != nil || expiration < Seconds() {
 		return "", errVerificationFailure
 	}
 	expectedSig := signature(secret, context, a[1], a[2])
@@ -372,6 +385,52 @@ func (c *Cookie) String() string {
 	return buf.String()
 }
 
+// SetAttachment sets the Content-Disposition header to attachment with the
+// given filename. Non-ASCII filenames are encoded using the RFC 5987
+// filename* parameter along with an ASCII fallback filename parameter for
+// older browsers.
+func SetAttachment(header Header, filename string) {
+	header.Set(HeaderContentDisposition, attachmentValue(filename))
+}
+
+func attachmentValue(filename string) string {
+	var fallback, encoded bytes.Buffer
+	ascii := true
+	for i := 0; i < len(filename); i++ {
+		b := filename[i]
+		switch {
+		case b >= 0x80:
+			ascii = false
+			// Replace each UTF-8 sequence with a single '_'.
+			if b >= 0xc0 {
+				fallback.WriteByte('_')
+			}
+		case b < ' ' || b == 0x7f || b == '"' || b == '\\' || b == '/':
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteByte(b)
+		}
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			encoded.WriteByte('%')
+			encoded.WriteByte("0123456789ABCDEF"[b>>4])
+			encoded.WriteByte("0123456789ABCDEF"[b&15])
+		}
+	}
+	value := `attachment; filename="` + fallback.String() + `"`
+	if !ascii {
+		value += "; filename*=UTF-8''" + encoded.String()
+	}
+	return value
+}
+
+// isAttrChar returns true if b is an RFC 5987 attr-char.
+func isAttrChar(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') ||
+		strings.IndexRune("!#$&+-.^_`|~", int(b)) >= 0
+}
+
 // HTMLEscapeString returns s with special HTML characters escaped. 
 func HTMLEscapeString(s string) string {
 	escape := false
@@ -441,12 +500,7 @@ func CheckXSRF(req *Request, cookieName string, paramName string) os.Error {
 
 	// Create new XSRF token?
 	if len(expectedToken) != tokenLen {
-		p := make([]byte, tokenLen/2)
-		_, err := rand.Reader.Read(p)
-		if err != nil {
-			panic("twister: rand read failed")
-		}
-		expectedToken = hex.EncodeToString(p)
+		expectedToken = RandomHex(tokenLen / 2)
 		c := NewCookie(cookieName, expectedToken).String()
 		FilterRespond(req, func(status int, header Header) (int, Header) {
 			header.Add(HeaderSetCookie, c)
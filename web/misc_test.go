@@ -15,6 +15,7 @@
 package web
 
 import (
+	"http"
 	"reflect"
 	"testing"
 )
@@ -56,3 +57,80 @@ func TestSignValue(t *testing.T) {
 		t.Error("verify failed", err, actualValue)
 	}
 }
+
+func TestSignValueExpiration(t *testing.T) {
+	clock := NewFakeClock(1e18)
+	defer SetClock(SetClock(clock))
+	signed := SignValue("secret", "context", 60, "value")
+	clock.Advance(60e9)
+	if _, err := VerifyValue("secret", "context", signed); err != nil {
+		t.Errorf("verify at expiration failed: %v", err)
+	}
+	clock.Advance(1e9)
+	if _, err := VerifyValue("secret", "context", signed); err == nil {
+		t.Error("verify after expiration succeeded")
+	}
+}
+
+var attachmentTests = []struct {
+	filename, value string
+}{
+	{"report.csv", `attachment; filename="report.csv"`},
+	{`a"b\c.txt`, `attachment; filename="a_b_c.txt"`},
+	{"résumé.pdf", `attachment; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`},
+	{"my file.txt", `attachment; filename="my file.txt"`},
+}
+
+func TestSetAttachment(t *testing.T) {
+	for _, tt := range attachmentTests {
+		header := Header{}
+		SetAttachment(header, tt.filename)
+		if v := header.Get(HeaderContentDisposition); v != tt.value {
+			t.Errorf("SetAttachment(%q) = %q, want %q", tt.filename, v, tt.value)
+		}
+	}
+}
+
+func TestRequestValues(t *testing.T) {
+	k1 := NewValueKey("test")
+	k2 := NewValueKey("test")
+	req := &Request{}
+	if v := req.Get(k1); v != nil {
+		t.Errorf("Get() on empty request = %v", v)
+	}
+	req.Set(k1, "one")
+	req.Set(k2, 2)
+	if v, _ := req.Get(k1).(string); v != "one" {
+		t.Errorf("Get(k1) = %v, want one", req.Get(k1))
+	}
+	if v, _ := req.Get(k2).(int); v != 2 {
+		t.Errorf("Get(k2) = %v, want 2", req.Get(k2))
+	}
+	req.Set(k1, nil)
+	if v := req.Get(k1); v != nil {
+		t.Errorf("Get(k1) after delete = %v", v)
+	}
+
+	url, _ := http.ParseURL("http://example.com/")
+	req, _ = NewRequest("127.0.0.1", "GET", url, 1001, NewHeader())
+	req.Set(k1, "one")
+	req.Reset("127.0.0.1", "GET", url, 1001, NewHeader())
+	if v := req.Get(k1); v != nil {
+		t.Errorf("Get(k1) after Reset = %v", v)
+	}
+}
+
+func TestRandomSource(t *testing.T) {
+	defer SetRandomSource(SetRandomSource(NewFakeRandom()))
+	if s := RandomHex(4); s != "00010203" {
+		t.Errorf("RandomHex(4) = %q, want %q", s, "00010203")
+	}
+	var id string
+	RunHandler("/", "GET", nil, nil, HandlerFunc(func(req *Request) {
+		id = RequestID(req)
+		req.Respond(StatusOK)
+	}))
+	if id != "0405060708090a0b" {
+		t.Errorf("RequestID() = %q, want %q", id, "0405060708090a0b")
+	}
+}
@@ -113,13 +113,20 @@ func NewMultipartReader(req *Request, maxRequestBodyLen int) (*MultipartReader,
 		return nil, ErrNotMultipartFormData
 	}
 
+	return newMultipartReader(req, maxRequestBodyLen)
+}
+
+// newMultipartReader returns a reader for a multipart request body of any
+// subtype.
+func newMultipartReader(req *Request, maxRequestBodyLen int) (*MultipartReader, os.Error) {
+
 	boundary := req.ContentParam["boundary"]
 	if boundary == "" {
-		return nil, os.NewError("twister: multipart/form-data boundary missing")
+		return nil, os.NewError("twister: " + req.ContentType + " boundary missing")
 	}
 
 	if len(boundary) > 512 {
-		return nil, os.NewError("twister: multipart/form-data boundary too long")
+		return nil, os.NewError("twister: " + req.ContentType + " boundary too long")
 	}
 
 	if maxRequestBodyLen < 0 {
@@ -144,7 +151,7 @@ func NewMultipartReader(req *Request, maxRequestBodyLen int) (*MultipartReader,
 	}
 
 	if isPrefix || !bytes.Equal(p, m.boundary[2:]) {
-		return nil, os.NewError("twister: multipart/form-data body malformed")
+		return nil, os.NewError("twister: " + req.ContentType + " body malformed")
 	}
 
 	return m, nil
@@ -0,0 +1,119 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"path"
+	"strings"
+)
+
+// NormalizeURLOptions configures NormalizeURLHandler.
+type NormalizeURLOptions struct {
+	// Convert the request host to lowercase.
+	LowercaseHost bool
+}
+
+var defaultNormalizeURLOptions NormalizeURLOptions
+
+// NormalizeURLHandler returns a handler that normalizes the request URL before
+// calling h. Duplicate slashes in the path are collapsed, "." and ".."
+// segments are resolved and the default port for the scheme is removed from
+// the host. If options.LowercaseHost is true, the host is converted to
+// lowercase.
+//
+// GET and HEAD requests for a URL that is not normalized are redirected to the
+// normalized URL with status 301. The URL of other requests is rewritten in
+// place and the request is passed to h.
+func NormalizeURLHandler(options *NormalizeURLOptions, h Handler) Handler {
+	if options == nil {
+		options = &defaultNormalizeURLOptions
+	}
+	return &normalizeURLHandler{options: *options, h: h}
+}
+
+type normalizeURLHandler struct {
+	options NormalizeURLOptions
+	h       Handler
+}
+
+// normalizePath collapses duplicate slashes and resolves dot segments. A
+// trailing slash is preserved.
+func normalizePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	result := path.Clean("/" + p)
+	if p[len(p)-1] == '/' && result != "/" {
+		result += "/"
+	}
+	return result
+}
+
+// normalizeHost removes the default port for scheme from host.
+func normalizeHost(host, scheme string, lowercase bool) string {
+	if lowercase {
+		host = strings.ToLower(host)
+	}
+	switch {
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		host = host[:len(host)-len(":80")]
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		host = host[:len(host)-len(":443")]
+	}
+	return host
+}
+
+// escapePath escapes the bytes in p that are not allowed in a URL path.
+func escapePath(p string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexRune("-._~!$&'()*+,;=:@/", int(c)) >= 0 {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte("0123456789ABCDEF"[c>>4])
+			b.WriteByte("0123456789ABCDEF"[c&15])
+		}
+	}
+	return b.String()
+}
+
+func (nh *normalizeURLHandler) ServeWeb(req *Request) {
+	p := normalizePath(req.URL.Path)
+	host := normalizeHost(req.URL.Host, req.URL.Scheme, nh.options.LowercaseHost)
+	if p == req.URL.Path && host == req.URL.Host {
+		nh.h.ServeWeb(req)
+		return
+	}
+
+	if req.Method == "GET" || req.Method == "HEAD" {
+		url := escapePath(p)
+		if req.URL.RawQuery != "" {
+			url += "?" + req.URL.RawQuery
+		}
+		if host != req.URL.Host {
+			url = req.URL.Scheme + "://" + host + url
+		}
+		req.Redirect(url, true)
+		return
+	}
+
+	req.URL.Path = p
+	req.URL.Host = host
+	nh.h.ServeWeb(req)
+}
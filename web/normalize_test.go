@@ -0,0 +1,53 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"testing"
+)
+
+var normalizeURLTests = []struct {
+	method   string
+	url      string
+	status   int
+	location string
+	body     string
+}{
+	{"GET", "http://example.com/a/b", StatusOK, "", "GET /a/b example.com"},
+	{"GET", "http://example.com//a///b/", StatusMovedPermanently, "/a/b/", ""},
+	{"GET", "http://example.com/a/./c/../b?x=y", StatusMovedPermanently, "/a/b?x=y", ""},
+	{"GET", "http://example.com:80/a", StatusMovedPermanently, "http://example.com/a", ""},
+	{"GET", "http://Example.COM/a", StatusMovedPermanently, "http://example.com/a", ""},
+	{"POST", "http://example.com//a/../b", StatusOK, "", "POST /b example.com"},
+}
+
+func TestNormalizeURLHandler(t *testing.T) {
+	h := NormalizeURLHandler(&NormalizeURLOptions{LowercaseHost: true}, HandlerFunc(func(req *Request) {
+		w := req.Respond(StatusOK)
+		w.Write([]byte(req.Method + " " + req.URL.Path + " " + req.URL.Host))
+	}))
+	for _, tt := range normalizeURLTests {
+		status, header, body := RunHandler(tt.url, tt.method, nil, nil, h)
+		if status != tt.status {
+			t.Errorf("%s %s status=%d, want %d", tt.method, tt.url, status, tt.status)
+		}
+		if location := header.Get(HeaderLocation); location != tt.location {
+			t.Errorf("%s %s location=%q, want %q", tt.method, tt.url, location, tt.location)
+		}
+		if string(body) != tt.body {
+			t.Errorf("%s %s body=%q, want %q", tt.method, tt.url, body, tt.body)
+		}
+	}
+}
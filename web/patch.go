@@ -0,0 +1,357 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"json"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types for PATCH request bodies.
+const (
+	ContentTypeMergePatch = "application/merge-patch+json" // RFC 7386
+	ContentTypeJSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+// PatchError describes a JSON patch operation that cannot be applied.
+type PatchError struct {
+	// Index of the operation in the patch document.
+	Index   int
+	Op      string
+	Path    string
+	Message string
+}
+
+func (e *PatchError) String() string {
+	return "twister: patch operation " + strconv.Itoa(e.Index) + " (" + e.Op + " " + e.Path + "): " + e.Message
+}
+
+// ApplyMergePatch returns the result of applying a JSON merge patch as
+// described in RFC 7386 to doc. The arguments are values decoded from JSON to
+// interface{}. The doc argument is not modified.
+func ApplyMergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	result := make(map[string]interface{})
+	if d, ok := doc.(map[string]interface{}); ok {
+		for k, v := range d {
+			result[k] = v
+		}
+	}
+	for k, v := range p {
+		if v == nil {
+			result[k] = nil, false
+		} else {
+			result[k] = ApplyMergePatch(result[k], v)
+		}
+	}
+	return result
+}
+
+// ApplyJSONPatch returns the result of applying a JSON patch as described in
+// RFC 6902 to doc. The arguments are values decoded from JSON to
+// interface{}. The patch is applied atomically: doc is not modified and a
+// *PatchError is returned if any operation fails.
+func ApplyJSONPatch(doc, patch interface{}) (interface{}, os.Error) {
+	ops, ok := patch.([]interface{})
+	if !ok {
+		return nil, &PatchError{Message: "patch is not an array"}
+	}
+	doc = copyJSONValue(doc)
+	for i, v := range ops {
+		op, _ := v.(map[string]interface{})
+		name, _ := op["op"].(string)
+		path, _ := op["path"].(string)
+		fail := func(message string) (interface{}, os.Error) {
+			return nil, &PatchError{Index: i, Op: name, Path: path, Message: message}
+		}
+		if _, ok := op["path"].(string); !ok {
+			return fail("path missing")
+		}
+		tokens, ok := parseJSONPointer(path)
+		if !ok {
+			return fail("invalid path")
+		}
+		value, hasValue := op["value"]
+		var fromTokens []string
+		switch name {
+		case "add", "replace", "test":
+			if !hasValue {
+				return fail("value missing")
+			}
+		case "move", "copy":
+			from, ok := op["from"].(string)
+			if !ok {
+				return fail("from missing")
+			}
+			if fromTokens, ok = parseJSONPointer(from); !ok {
+				return fail("invalid from")
+			}
+		case "remove":
+		default:
+			return fail("unknown operation")
+		}
+		var err os.Error
+		switch name {
+		case "add":
+			doc, err = patchJSONValue(doc, tokens, addJSONValue(value))
+		case "remove":
+			doc, err = patchJSONValue(doc, tokens, removeJSONValue)
+		case "replace":
+			doc, err = patchJSONValue(doc, tokens, replaceJSONValue(value))
+		case "move":
+			if len(fromTokens) < len(tokens) && reflect.DeepEqual(fromTokens, tokens[:len(fromTokens)]) {
+				return fail("cannot move value into itself")
+			}
+			if value, err = getJSONValue(doc, fromTokens); err == nil {
+				if doc, err = patchJSONValue(doc, fromTokens, removeJSONValue); err == nil {
+					doc, err = patchJSONValue(doc, tokens, addJSONValue(value))
+				}
+			}
+		case "copy":
+			if value, err = getJSONValue(doc, fromTokens); err == nil {
+				doc, err = patchJSONValue(doc, tokens, addJSONValue(copyJSONValue(value)))
+			}
+		case "test":
+			var actual interface{}
+			if actual, err = getJSONValue(doc, tokens); err == nil && !reflect.DeepEqual(actual, value) {
+				err = os.NewError("test failed")
+			}
+		}
+		if err != nil {
+			return fail(err.String())
+		}
+	}
+	return doc, nil
+}
+
+var errPathNotFound = os.NewError("path not found")
+
+// parseJSONPointer splits a JSON pointer as described in RFC 6901 into
+// unescaped reference tokens.
+func parseJSONPointer(s string) ([]string, bool) {
+	if s == "" {
+		return nil, true
+	}
+	if s[0] != '/' {
+		return nil, false
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, true
+}
+
+// arrayIndex parses a JSON pointer array index. Indexes with leading zeros
+// or signs are not valid.
+func arrayIndex(token string, n int) (int, os.Error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || token[0] < '0' || token[0] > '9' {
+		return 0, os.NewError("invalid array index")
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i > n {
+		return 0, errPathNotFound
+	}
+	return i, nil
+}
+
+func getJSONValue(doc interface{}, tokens []string) (interface{}, os.Error) {
+	for _, t := range tokens {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, found := d[t]
+			if !found {
+				return nil, errPathNotFound
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(d)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, errPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// patchFunc modifies the member or element key of container and returns the
+// modified container. A nil container denotes the document root.
+type patchFunc func(container interface{}, key string) (interface{}, os.Error)
+
+// patchJSONValue applies fn to the container of the value at tokens and
+// returns the modified document.
+func patchJSONValue(doc interface{}, tokens []string, fn patchFunc) (interface{}, os.Error) {
+	if len(tokens) == 0 {
+		return fn(nil, "")
+	}
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	child, err := getJSONValue(doc, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = patchJSONValue(child, tokens[1:], fn); err != nil {
+		return nil, err
+	}
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		d[tokens[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(tokens[0], len(d)-1)
+		d[i] = child
+	}
+	return doc, nil
+}
+
+func addJSONValue(value interface{}) patchFunc {
+	return func(container interface{}, key string) (interface{}, os.Error) {
+		switch d := container.(type) {
+		case nil:
+			return value, nil
+		case map[string]interface{}:
+			d[key] = value
+			return d, nil
+		case []interface{}:
+			i := len(d)
+			if key != "-" {
+				var err os.Error
+				if i, err = arrayIndex(key, len(d)); err != nil {
+					return nil, err
+				}
+			}
+			d = append(d, nil)
+			copy(d[i+1:], d[i:])
+			d[i] = value
+			return d, nil
+		}
+		return nil, errPathNotFound
+	}
+}
+
+func removeJSONValue(container interface{}, key string) (interface{}, os.Error) {
+	switch d := container.(type) {
+	case map[string]interface{}:
+		if _, found := d[key]; !found {
+			return nil, errPathNotFound
+		}
+		d[key] = nil, false
+		return d, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(d)-1)
+		if err != nil {
+			return nil, err
+		}
+		return append(d[:i], d[i+1:]...), nil
+	}
+	return nil, errPathNotFound
+}
+
+func replaceJSONValue(value interface{}) patchFunc {
+	return func(container interface{}, key string) (interface{}, os.Error) {
+		if container != nil {
+			var err os.Error
+			if container, err = removeJSONValue(container, key); err != nil {
+				return nil, err
+			}
+		}
+		return addJSONValue(value)(container, key)
+	}
+}
+
+// copyJSONValue returns a deep copy of a value decoded from JSON.
+func copyJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = copyJSONValue(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = copyJSONValue(e)
+		}
+		return a
+	}
+	return v
+}
+
+// PatchJSON applies the body of a PATCH request to the JSON encoding of
+// current and decodes the patched document to result. The result argument is
+// typically a pointer to a new zero value of the same type as current. The
+// request body must have the type application/merge-patch+json or
+// application/json-patch+json.
+//
+// On failure, PatchJSON responds to the request and returns false. The
+// response status is 415 for other media types, 413 if the body is longer than
+// maxRequestBodyLen, 400 if the body is not valid JSON and 422 with a JSON
+// document describing the error if the patch cannot be applied or the
+// patched document does not decode to result.
+func PatchJSON(req *Request, maxRequestBodyLen int, current, result interface{}) bool {
+	contentType, _ := req.Header.GetValueParam(HeaderContentType)
+	if contentType != ContentTypeMergePatch && contentType != ContentTypeJSONPatch {
+		req.Error(StatusUnsupportedMediaType, nil,
+			HeaderAcceptPatch, ContentTypeMergePatch+", "+ContentTypeJSONPatch)
+		return false
+	}
+	p, err := req.BodyBytes(maxRequestBodyLen)
+	if err == ErrRequestEntityTooLarge {
+		req.Error(StatusRequestEntityTooLarge, err)
+		return false
+	} else if err != nil {
+		req.Error(StatusBadRequest, err)
+		return false
+	}
+	var patch interface{}
+	if err := json.Unmarshal(p, &patch); err != nil {
+		req.Error(StatusBadRequest, err)
+		return false
+	}
+	if p, err = json.Marshal(current); err != nil {
+		req.Error(StatusInternalServerError, err)
+		return false
+	}
+	var doc interface{}
+	if err := json.Unmarshal(p, &doc); err != nil {
+		req.Error(StatusInternalServerError, err)
+		return false
+	}
+	if contentType == ContentTypeMergePatch {
+		doc = ApplyMergePatch(doc, patch)
+	} else if doc, err = ApplyJSONPatch(doc, patch); err != nil {
+		e := err.(*PatchError)
+		respondValidationErrors(req, []ValidationError{{Source: "body", Name: e.Path, Message: e.Message}})
+		return false
+	}
+	if p, err = json.Marshal(doc); err != nil {
+		req.Error(StatusInternalServerError, err)
+		return false
+	}
+	if err := json.Unmarshal(p, result); err != nil {
+		respondValidationErrors(req, []ValidationError{{Source: "body", Message: err.String()}})
+		return false
+	}
+	return true
+}
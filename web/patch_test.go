@@ -0,0 +1,123 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"json"
+	"testing"
+)
+
+func decodeTestJSON(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+const patchTestDoc = `{"a":"b","c":{"d":"e","f":"g"},"l":[1,2,3]}`
+
+var applyJSONPatchTests = []struct {
+	patch  string
+	result string
+}{
+	{`[{"op":"add","path":"/l/1","value":9},{"op":"add","path":"/l/-","value":4}]`, `{"a":"b","c":{"d":"e","f":"g"},"l":[1,9,2,3,4]}`},
+	{`[{"op":"remove","path":"/l/0"},{"op":"replace","path":"/c/d","value":"E"}]`, `{"a":"b","c":{"d":"E","f":"g"},"l":[2,3]}`},
+	{`[{"op":"move","from":"/c/d","path":"/x"},{"op":"copy","from":"/l","path":"/c/l"}]`, `{"a":"b","c":{"f":"g","l":[1,2,3]},"l":[1,2,3],"x":"e"}`},
+	{`[{"op":"test","path":"/a","value":"b"},{"op":"add","path":"/c/a~1b","value":true}]`, `{"a":"b","c":{"a/b":true,"d":"e","f":"g"},"l":[1,2,3]}`},
+	{`[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	{`[{"op":"test","path":"/a","value":"x"}]`, ""},
+	{`[{"op":"remove","path":"/missing"}]`, ""},
+	{`[{"op":"add","path":"/l/01","value":1}]`, ""},
+	{`[{"op":"add","path":"/l/4","value":1}]`, ""},
+	{`[{"op":"move","from":"/c","path":"/c/z"}]`, ""},
+	{`[{"op":"add","path":"/c/d/q","value":1}]`, ""},
+	{`[{"op":"bogus","path":"/a"}]`, ""},
+	{`[{"op":"add","path":"/a"}]`, ""},
+	{`[{"op":"add","path":"/z","value":1},{"op":"remove","path":"/y"}]`, ""},
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	for _, tt := range applyJSONPatchTests {
+		doc := decodeTestJSON(patchTestDoc)
+		result, err := ApplyJSONPatch(doc, decodeTestJSON(tt.patch))
+		if p, _ := json.Marshal(doc); string(p) != patchTestDoc {
+			t.Errorf("%s modified doc to %s", tt.patch, p)
+		}
+		if tt.result == "" {
+			if _, ok := err.(*PatchError); !ok {
+				t.Errorf("%s err=%v, want *PatchError", tt.patch, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s err=%v", tt.patch, err)
+			continue
+		}
+		if p, _ := json.Marshal(result); string(p) != tt.result {
+			t.Errorf("%s result=%s, want %s", tt.patch, p, tt.result)
+		}
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	doc := decodeTestJSON(patchTestDoc)
+	result := ApplyMergePatch(doc, decodeTestJSON(`{"a":"z","c":{"f":null},"n":{"x":1}}`))
+	if p, _ := json.Marshal(result); string(p) != `{"a":"z","c":{"d":"e"},"l":[1,2,3],"n":{"x":1}}` {
+		t.Errorf("result=%s", p)
+	}
+	if p, _ := json.Marshal(doc); string(p) != patchTestDoc {
+		t.Errorf("modified doc to %s", p)
+	}
+}
+
+type patchTestItem struct {
+	Name string
+	Tags []string
+}
+
+var patchJSONTests = []struct {
+	contentType string
+	body        string
+	status      int
+	name        string
+}{
+	{ContentTypeMergePatch, `{"Name":"new"}`, StatusOK, "new"},
+	{ContentTypeJSONPatch, `[{"op":"replace","path":"/Name","value":"new"}]`, StatusOK, "new"},
+	{ContentTypeJSONPatch, `[{"op":"remove","path":"/Missing"}]`, StatusUnprocessableEntity, ""},
+	{ContentTypeMergePatch, `{"Name":7}`, StatusUnprocessableEntity, ""},
+	{ContentTypeMergePatch, `{"Name":`, StatusBadRequest, ""},
+	{"application/json", `{"Name":"new"}`, StatusUnsupportedMediaType, ""},
+}
+
+func TestPatchJSON(t *testing.T) {
+	for _, tt := range patchJSONTests {
+		var item patchTestItem
+		h := HandlerFunc(func(req *Request) {
+			if PatchJSON(req, 1000, &patchTestItem{Name: "old", Tags: []string{"x"}}, &item) {
+				req.Respond(StatusOK)
+			}
+		})
+		status, header, _ := RunHandler("/items/1", "PATCH", NewHeader(HeaderContentType, tt.contentType), []byte(tt.body), h)
+		if status != tt.status {
+			t.Errorf("%s %s status=%d, want %d", tt.contentType, tt.body, status, tt.status)
+		}
+		if status == StatusOK && (item.Name != tt.name || len(item.Tags) != 1) {
+			t.Errorf("%s %s item=%+v", tt.contentType, tt.body, item)
+		}
+		if status == StatusUnsupportedMediaType && header.Get(HeaderAcceptPatch) == "" {
+			t.Errorf("Accept-Patch header missing")
+		}
+	}
+}
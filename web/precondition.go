@@ -0,0 +1,95 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// EntityTag returns an unquoted strong entity tag computed from the
+// representation p. Use EntityTag for resources that do not have a version
+// number or other natural tag.
+func EntityTag(p []byte) string {
+	h := sha1.New()
+	h.Write(p)
+	return hex.EncodeToString(h.Sum()[:12])
+}
+
+// matchEntityTag returns true if the list of quoted entity tags contains etag
+// or "*". Weak tags match only if weak is true. An empty etag denotes a
+// resource that does not exist and matches nothing.
+func matchEntityTag(list []string, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, qetag := range list {
+		if strings.HasPrefix(qetag, "W/") {
+			if !weak {
+				continue
+			}
+			qetag = qetag[2:]
+		}
+		if qetag == "*" || UnquoteHeaderValue(qetag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckWritePreconditions evaluates the conditional headers of a request that
+// modifies a resource, for example PUT, PATCH or DELETE. The etag is the
+// unquoted entity tag of the current representation or "" if the resource
+// does not exist. The modtime is the modification time in seconds since the
+// epoch or 0 if not known.
+//
+// The If-Match header uses the strong comparison function. If-Unmodified-Since
+// is ignored when If-Match is present. If-None-Match: * fails when the
+// resource exists, which lets clients create a resource without replacing
+// an existing one.
+//
+// If require is true, requests without If-Match or If-Unmodified-Since fail
+// with status 428 so that clients cannot overwrite changes they have not
+// seen.
+//
+// On failure, CheckWritePreconditions responds to the request with status 412
+// or 428 and returns false. The response includes the current ETag so that
+// clients can refetch the resource and retry.
+func CheckWritePreconditions(req *Request, etag string, modtime int64, require bool) bool {
+	var headerKV []string
+	if etag != "" {
+		headerKV = []string{HeaderETag, QuoteHeaderValue(etag)}
+	}
+	if im := req.Header.GetList(HeaderIfMatch); len(im) > 0 {
+		if !matchEntityTag(im, etag, false) {
+			req.Error(StatusPreconditionFailed, nil, headerKV...)
+			return false
+		}
+	} else if s := req.Header.Get(HeaderIfUnmodifiedSince); s != "" {
+		if t, err := time.Parse(TimeLayout, s); err == nil && (modtime == 0 || modtime > t.Seconds()) {
+			req.Error(StatusPreconditionFailed, nil, headerKV...)
+			return false
+		}
+	} else if require {
+		req.Error(StatusPreconditionRequired, nil, headerKV...)
+		return false
+	}
+	if inm := req.Header.GetList(HeaderIfNoneMatch); len(inm) > 0 && matchEntityTag(inm, etag, true) {
+		req.Error(StatusPreconditionFailed, nil, headerKV...)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"testing"
+)
+
+var writePreconditionTests = []struct {
+	header  Header
+	etag    string
+	require bool
+	status  int
+}{
+	{NewHeader(), "v1", false, StatusOK},
+	{NewHeader(), "v1", true, StatusPreconditionRequired},
+	{NewHeader(HeaderIfMatch, `"v1"`), "v1", true, StatusOK},
+	{NewHeader(HeaderIfMatch, `"v0", "v1"`), "v1", true, StatusOK},
+	{NewHeader(HeaderIfMatch, `"v0"`), "v1", true, StatusPreconditionFailed},
+	{NewHeader(HeaderIfMatch, `W/"v1"`), "v1", false, StatusPreconditionFailed},
+	{NewHeader(HeaderIfMatch, "*"), "v1", false, StatusOK},
+	{NewHeader(HeaderIfMatch, "*"), "", false, StatusPreconditionFailed},
+	{NewHeader(HeaderIfNoneMatch, "*"), "", false, StatusOK},
+	{NewHeader(HeaderIfNoneMatch, "*"), "v1", false, StatusPreconditionFailed},
+	{NewHeader(HeaderIfUnmodifiedSince, "Thu, 01 Jan 1970 00:00:10 GMT"), "v1", true, StatusOK},
+	{NewHeader(HeaderIfUnmodifiedSince, "Thu, 01 Jan 1970 00:00:09 GMT"), "v1", true, StatusPreconditionFailed},
+}
+
+func TestCheckWritePreconditions(t *testing.T) {
+	for _, tt := range writePreconditionTests {
+		h := HandlerFunc(func(req *Request) {
+			if CheckWritePreconditions(req, tt.etag, 10, tt.require) {
+				req.Respond(StatusOK)
+			}
+		})
+		status, header, _ := RunHandler("/", "PUT", tt.header, nil, h)
+		if status != tt.status {
+			t.Errorf("%v etag=%q require=%v status=%d, want %d", tt.header, tt.etag, tt.require, status, tt.status)
+		}
+		if status != StatusOK && tt.etag != "" && header.Get(HeaderETag) != `"`+tt.etag+`"` {
+			t.Errorf("%v etag header=%q", tt.header, header.Get(HeaderETag))
+		}
+	}
+	if EntityTag([]byte("a")) != EntityTag([]byte("a")) || EntityTag([]byte("a")) == EntityTag([]byte("b")) {
+		t.Errorf("EntityTag not deterministic or not distinct")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	randomMu     sync.RWMutex
+	randomSource io.Reader = rand.Reader
+)
+
+// SetRandomSource sets the package random source and returns the previous
+// source. If r is nil, crypto/rand.Reader is used. Twister reads random bytes
+// for session identifiers, XSRF tokens, request identifiers, upload file
+// names and other tokens from the package source. SetRandomSource is
+// intended for tests that require deterministic tokens:
+//
+//  defer web.SetRandomSource(web.SetRandomSource(web.NewFakeRandom()))
+//
+// The source must be safe for concurrent use.
+func SetRandomSource(r io.Reader) io.Reader {
+	if r == nil {
+		r = rand.Reader
+	}
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	prev := randomSource
+	randomSource = r
+	return prev
+}
+
+// ReadRandom fills p with bytes from the package random source.
+func ReadRandom(p []byte) os.Error {
+	randomMu.RLock()
+	r := randomSource
+	randomMu.RUnlock()
+	_, err := io.ReadFull(r, p)
+	return err
+}
+
+// RandomHex returns n bytes from the package random source encoded as
+// hexadecimal. RandomHex panics if the source returns an error.
+func RandomHex(n int) string {
+	p := make([]byte, n)
+	if err := ReadRandom(p); err != nil {
+		panic("twister: rand read failed")
+	}
+	return hex.EncodeToString(p)
+}
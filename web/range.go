@@ -0,0 +1,309 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange is a range in a response entity.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.Itoa64(r.start) + "-" + strconv.Itoa64(r.start+r.length-1) + "/" + strconv.Itoa64(size)
+}
+
+var errBadRange = os.NewError("twister: bad range")
+
+// maxRanges is the maximum number of ranges in a Range header. Requests with
+// more ranges are served the full entity.
+const maxRanges = 16
+
+// parseRange parses a Range header value per RFC 2616 section 14.35. The
+// ranges that overlap the entity are returned sorted with overlapping and
+// adjacent ranges merged. An error is returned if the header is malformed or
+// has more than maxRanges ranges. A malformed header is ignored by the
+// caller. An empty slice is returned if no range overlaps the entity.
+func parseRange(s string, size int64) ([]byteRange, os.Error) {
+	if !strings.HasPrefix(s, "bytes=") {
+		return nil, errBadRange
+	}
+	specs := strings.Split(s[len("bytes="):], ",")
+	if len(specs) > maxRanges {
+		return nil, errBadRange
+	}
+	var ranges []byteRange
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, errBadRange
+		}
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+		var r byteRange
+		if first == "" {
+			// Suffix range.
+			n, err := strconv.Atoi64(last)
+			if err != nil || n < 0 {
+				return nil, errBadRange
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = n
+		} else {
+			start, err := strconv.Atoi64(first)
+			if err != nil || start < 0 {
+				return nil, errBadRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.Atoi64(last)
+				if err != nil || end < start {
+					return nil, errBadRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r.start = start
+			r.length = end - start + 1
+		}
+		if r.length > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	if ranges == nil {
+		ranges = []byteRange{}
+	}
+	return coalesceRanges(ranges), nil
+}
+
+// coalesceRanges sorts the ranges by start and merges ranges that overlap or
+// are adjacent so that no byte of the entity is sent more than once.
+func coalesceRanges(ranges []byteRange) []byteRange {
+	for i := 1; i < len(ranges); i++ {
+		for j := i; j > 0 && ranges[j].start < ranges[j-1].start; j-- {
+			ranges[j], ranges[j-1] = ranges[j-1], ranges[j]
+		}
+	}
+	n := 0
+	for _, r := range ranges {
+		if n > 0 {
+			last := &ranges[n-1]
+			if r.start <= last.start+last.length {
+				if end := r.start + r.length; end > last.start+last.length {
+					last.length = end - last.start
+				}
+				continue
+			}
+		}
+		ranges[n] = r
+		n++
+	}
+	return ranges[:n]
+}
+
+// checkNotModified returns true if the request's conditional headers match
+// the etag and modification time of the entity. If-None-Match uses the weak
+// comparison function.
+func checkNotModified(req *Request, etag string, modtime int64) bool {
+	if inm := req.Header.GetList(HeaderIfNoneMatch); len(inm) > 0 {
+		for _, qetag := range inm {
+			if strings.HasPrefix(qetag, "W/") {
+				qetag = qetag[2:]
+			}
+			if qetag == "*" || UnquoteHeaderValue(qetag) == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if s := req.Header.Get(HeaderIfModifiedSince); s != "" && modtime > 0 {
+		if t, err := time.Parse(TimeLayout, s); err == nil && modtime <= t.Seconds() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfRange returns true if the Range header should be honored given the
+// If-Range header. The If-Range header contains an entity tag or a date. Entity
+// tags use the strong comparison function, so weak tags never match.
+func checkIfRange(req *Request, etag string, modtime int64) bool {
+	s := req.Header.Get(HeaderIfRange)
+	if s == "" {
+		return true
+	}
+	if strings.HasPrefix(s, "W/") {
+		return false
+	}
+	if strings.HasPrefix(s, "\"") {
+		return etag != "" && UnquoteHeaderValue(s) == etag
+	}
+	t, err := time.Parse(TimeLayout, s)
+	return err == nil && modtime > 0 && modtime == t.Seconds()
+}
+
+// ServeContent responds to the request with size bytes of content read from
+// r. The header argument contains the entity headers other than
+// Content-Length and Content-Range. The etag is the unquoted entity tag or ""
+// and modtime is the modification time in seconds since the epoch or 0 if not
+// known.
+//
+// ServeContent sets the Accept-Ranges, ETag and Last-Modified headers and
+// handles conditional GET, If-Range, single range and multiple range
+// requests. HEAD requests receive the same headers as GET with no body. Use
+// ServeContent to implement handlers for files, blobs and other stored
+// content.
+func ServeContent(req *Request, header Header, etag string, modtime int64, size int64, r io.ReaderAt) {
+	header.Set(HeaderAcceptRanges, "bytes")
+	if etag != "" {
+		header.Set(HeaderETag, QuoteHeaderValue(etag))
+	}
+	if modtime > 0 {
+		header.Set(HeaderLastModified, time.SecondsToUTC(modtime).Format(TimeLayout))
+	}
+
+	if (req.Method == "GET" || req.Method == "HEAD") && checkNotModified(req, etag, modtime) {
+		for k := range header {
+			if strings.HasPrefix(k, "Content-") {
+				header[k] = nil, false
+			}
+		}
+		req.Responder.Respond(StatusNotModified, header)
+		return
+	}
+
+	var ranges []byteRange
+	if s := req.Header.Get(HeaderRange); s != "" && req.Method == "GET" && checkIfRange(req, etag, modtime) {
+		var err os.Error
+		ranges, err = parseRange(s, size)
+		if err == nil && len(ranges) == 0 {
+			header.Set(HeaderContentRange, "bytes */"+strconv.Itoa64(size))
+			header[HeaderContentType] = nil, false
+			req.Error(StatusRequestedRangeNotSatisfiable, nil, headerKeysAndValues(header)...)
+			return
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		header.Set(HeaderContentLength, strconv.Itoa64(size))
+		w := req.Responder.Respond(StatusOK, header)
+		if req.Method != "HEAD" {
+			io.Copy(w, io.NewSectionReader(r, 0, size))
+		}
+	case 1:
+		header.Set(HeaderContentRange, ranges[0].contentRange(size))
+		header.Set(HeaderContentLength, strconv.Itoa64(ranges[0].length))
+		w := req.Responder.Respond(StatusPartialContent, header)
+		io.Copy(w, io.NewSectionReader(r, ranges[0].start, ranges[0].length))
+	default:
+		serveMultiRange(req, header, size, ranges, r)
+	}
+}
+
+// serveMultiRange responds with a multipart/byteranges entity.
+func serveMultiRange(req *Request, header Header, size int64, ranges []byteRange, r io.ReaderAt) {
+	boundary := RandomHex(12)
+	contentType := header.Get(HeaderContentType)
+
+	// Compute the part headers up front to set the Content-Length.
+	partHeaders := make([][]byte, len(ranges))
+	length := int64(0)
+	for i, rng := range ranges {
+		var b bytes.Buffer
+		b.WriteString("\r\n--" + boundary + "\r\n")
+		if contentType != "" {
+			b.WriteString(HeaderContentType + ": " + contentType + "\r\n")
+		}
+		b.WriteString(HeaderContentRange + ": " + rng.contentRange(size) + "\r\n\r\n")
+		partHeaders[i] = b.Bytes()
+		length += int64(len(partHeaders[i])) + rng.length
+	}
+	trailer := "\r\n--" + boundary + "--\r\n"
+	length += int64(len(trailer))
+
+	header.Set(HeaderContentType, "multipart/byteranges; boundary="+boundary)
+	header.Set(HeaderContentLength, strconv.Itoa64(length))
+	w := req.Responder.Respond(StatusPartialContent, header)
+	for i, rng := range ranges {
+		if _, err := w.Write(partHeaders[i]); err != nil {
+			return
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(r, rng.start, rng.length)); err != nil {
+			return
+		}
+	}
+	io.WriteString(w, trailer)
+}
+
+func headerKeysAndValues(header Header) []string {
+	var kvs []string
+	for k, values := range header {
+		for _, v := range values {
+			kvs = append(kvs, k, v)
+		}
+	}
+	return kvs
+}
+
+// bytesReaderAt implements io.ReaderAt for a slice of bytes.
+type bytesReaderAt []byte
+
+func (p bytesReaderAt) ReadAt(b []byte, off int64) (int, os.Error) {
+	if off >= int64(len(p)) {
+		return 0, os.EOF
+	}
+	n := copy(b, p[off:])
+	if n < len(b) {
+		return n, os.EOF
+	}
+	return n, nil
+}
+
+// ServeBytes responds to the request with the content p. The name is used to
+// determine the content type. The modtime is the modification time of the
+// content in seconds since the epoch or 0 if the modification time is not
+// known. The entity tag is computed from a hash of p. ServeBytes handles
+// conditional GET and single and multiple range requests. Use ServeBytes to
+// serve content generated in memory.
+func ServeBytes(req *Request, name string, modtime int64, p []byte) {
+	header := Header{}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set(HeaderContentType, contentType)
+	}
+	ServeContent(req, header, EntityTag(p), modtime, int64(len(p)), bytesReaderAt(p))
+}
@@ -0,0 +1,95 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parseRangeTests = []struct {
+	s      string
+	ranges []byteRange
+	err    bool
+}{
+	{"bytes=0-4", []byteRange{{0, 5}}, false},
+	{"bytes=5-", []byteRange{{5, 5}}, false},
+	{"bytes=-3", []byteRange{{7, 3}}, false},
+	{"bytes=-30", []byteRange{{0, 10}}, false},
+	{"bytes=0-0, 8-20", []byteRange{{0, 1}, {8, 2}}, false},
+	{"bytes=8-, 0-0", []byteRange{{0, 1}, {8, 2}}, false},
+	{"bytes=0-,0-,0-", []byteRange{{0, 10}}, false},
+	{"bytes=0-3, 2-5, 6-6, 8-8", []byteRange{{0, 7}, {8, 1}}, false},
+	{"bytes=-2, 1-2", []byteRange{{1, 2}, {8, 2}}, false},
+	{"bytes=0-0,2-2,4-4,6-6,8-8,0-0,2-2,4-4,6-6,8-8,0-0,2-2,4-4,6-6,8-8,0-0,2-2", nil, true},
+	{"bytes=20-30", []byteRange{}, false},
+	{"bytes=4-3", nil, true},
+	{"bytes=x-3", nil, true},
+	{"items=0-3", nil, true},
+}
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range parseRangeTests {
+		ranges, err := parseRange(tt.s, 10)
+		if (err != nil) != tt.err {
+			t.Errorf("parseRange(%q) err=%v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(ranges, tt.ranges) {
+			t.Errorf("parseRange(%q)=%v, want %v", tt.s, ranges, tt.ranges)
+		}
+	}
+}
+
+var serveBytesTests = []struct {
+	header Header
+	status int
+	body   string
+}{
+	{nil, StatusOK, "0123456789"},
+	{NewHeader(HeaderRange, "bytes=2-4"), StatusPartialContent, "234"},
+	{NewHeader(HeaderRange, "bytes=20-"), StatusRequestedRangeNotSatisfiable, ""},
+	{NewHeader(HeaderRange, "bytes=0-1,8-"), StatusPartialContent, ""},
+	{NewHeader(HeaderRange, "bytes=0-,0-,0-"), StatusPartialContent, "0123456789"},
+	{NewHeader(HeaderRange, "bytes=0-0,1-1,2-2,3-3,4-4,5-5,6-6,7-7,8-8,9-9,0-0,1-1,2-2,3-3,4-4,5-5,6-6"), StatusOK, "0123456789"},
+	{NewHeader(HeaderIfNoneMatch, `"87acec17cd9dcd20a716cc2c"`), StatusNotModified, ""},
+	{NewHeader(HeaderIfModifiedSince, "Tue, 02 Jan 1990 00:00:00 GMT"), StatusOK, "0123456789"},
+	{NewHeader(HeaderRange, "bytes=2-4", HeaderIfRange, `"other"`), StatusOK, "0123456789"},
+}
+
+func TestServeBytes(t *testing.T) {
+	h := HandlerFunc(func(req *Request) { ServeBytes(req, "x.txt", 13368, []byte("0123456789")) })
+	for _, tt := range serveBytesTests {
+		status, header, body := RunHandler("/", "GET", tt.header, nil, h)
+		if status != tt.status {
+			t.Errorf("%v status=%d, want %d", tt.header, status, tt.status)
+		}
+		if tt.body != "" && string(body) != tt.body {
+			t.Errorf("%v body=%q, want %q", tt.header, body, tt.body)
+		}
+		if status == StatusPartialContent && tt.body == "" &&
+			!strings.HasPrefix(header.Get(HeaderContentType), "multipart/byteranges; boundary=") {
+			t.Errorf("%v content type=%q", tt.header, header.Get(HeaderContentType))
+		}
+	}
+
+	// Content of the same length and modification time is not matched.
+	h = HandlerFunc(func(req *Request) { ServeBytes(req, "x.txt", 0, []byte("abcdefghij")) })
+	status, _, _ := RunHandler("/", "GET", NewHeader(HeaderIfNoneMatch, `"87acec17cd9dcd20a716cc2c"`), nil, h)
+	if status != StatusOK {
+		t.Errorf("other content status=%d, want %d", status, StatusOK)
+	}
+}
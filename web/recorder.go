@@ -0,0 +1,222 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Transcript is a record of a request and response.
+type Transcript struct {
+	Time           int64 // nanoseconds since the epoch
+	RemoteAddr     string
+	Method         string
+	URL            string
+	Header         Header
+	Body           []byte
+	Status         int
+	ResponseHeader Header
+	ResponseBody   []byte
+	Truncated      bool // true if a body was larger than the recorder's limit
+}
+
+// Recorder records request and response transcripts for debugging. The most
+// recent transcripts are kept in a ring buffer. The values of credential
+// headers such as Authorization and Cookie are redacted. Recording can be enabled and
+// disabled while the application runs.
+//
+// The application should wrap handlers to record with the recorder's Filter
+// method and register the recorder as a handler on a debug path with
+// appropriate access control:
+//
+//  rec := web.NewRecorder(100, 64*1024)
+//  h = rec.Filter(h)
+//  r.Register("/debug/recorder", "GET", rec, "POST", rec)
+type Recorder struct {
+	maxBody int
+
+	mu      sync.Mutex
+	enabled bool
+	ring    []*Transcript
+	next    int
+}
+
+// NewRecorder returns a disabled recorder that keeps the last size
+// transcripts. Bodies are truncated to maxBody bytes.
+func NewRecorder(size int, maxBody int) *Recorder {
+	return &Recorder{maxBody: maxBody, ring: make([]*Transcript, size)}
+}
+
+// SetEnabled enables or disables recording.
+func (rec *Recorder) SetEnabled(enabled bool) {
+	rec.mu.Lock()
+	rec.enabled = enabled
+	rec.mu.Unlock()
+}
+
+// Enabled returns true if recording is enabled.
+func (rec *Recorder) Enabled() bool {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.enabled
+}
+
+// Transcripts returns the recorded transcripts from oldest to newest.
+func (rec *Recorder) Transcripts() []*Transcript {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var result []*Transcript
+	for i := range rec.ring {
+		if t := rec.ring[(rec.next+i)%len(rec.ring)]; t != nil {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// Clear removes all recorded transcripts.
+func (rec *Recorder) Clear() {
+	rec.mu.Lock()
+	for i := range rec.ring {
+		rec.ring[i] = nil
+	}
+	rec.mu.Unlock()
+}
+
+func (rec *Recorder) add(t *Transcript) {
+	rec.mu.Lock()
+	if len(rec.ring) > 0 {
+		rec.ring[rec.next] = t
+		rec.next = (rec.next + 1) % len(rec.ring)
+	}
+	rec.mu.Unlock()
+}
+
+// Dump writes the recorded transcripts to w in HTTP message format.
+func (rec *Recorder) Dump(w io.Writer) os.Error {
+	for _, t := range rec.Transcripts() {
+		fmt.Fprintf(w, "### %s %s\n", time.SecondsToUTC(t.Time/1e9).Format(TimeLayout), t.RemoteAddr)
+		fmt.Fprintf(w, "%s %s\r\n", t.Method, t.URL)
+		t.Header.WriteHttpHeader(w)
+		w.Write(t.Body)
+		fmt.Fprintf(w, "\n\n%d %s\r\n", t.Status, StatusText(t.Status))
+		t.ResponseHeader.WriteHttpHeader(w)
+		w.Write(t.ResponseBody)
+		if t.Truncated {
+			io.WriteString(w, "\n### truncated")
+		}
+		if _, err := io.WriteString(w, "\n\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeWeb serves the recorder's debug page. GET dumps the transcripts as
+// plain text. POST with the parameter "enabled" set to "true" or "false"
+// enables or disables recording. POST with the parameter "clear" removes
+// recorded transcripts.
+func (rec *Recorder) ServeWeb(req *Request) {
+	if req.Method == "POST" {
+		switch req.Param.Get("enabled") {
+		case "true":
+			rec.SetEnabled(true)
+		case "false":
+			rec.SetEnabled(false)
+		}
+		if req.Param.Get("clear") != "" {
+			rec.Clear()
+		}
+	}
+	w := req.Respond(StatusOK, HeaderContentType, "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "### enabled: %v\n\n", rec.Enabled())
+	rec.Dump(w)
+}
+
+// limitedBuffer accumulates up to max bytes.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, os.Error) {
+	n := len(p)
+	if room := b.max - b.buf.Len(); n > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+type recordingReader struct {
+	r   io.Reader
+	buf *limitedBuffer
+}
+
+func (r recordingReader) Read(p []byte) (int, os.Error) {
+	n, err := r.r.Read(p)
+	r.buf.Write(p[:n])
+	return n, err
+}
+
+type teeResponder struct {
+	Responder
+	t   *Transcript
+	buf *limitedBuffer
+}
+
+func (r *teeResponder) Respond(status int, header Header) io.Writer {
+	r.t.Status = status
+	r.t.ResponseHeader = redactHeader(header)
+	return io.MultiWriter(r.Responder.Respond(status, header), r.buf)
+}
+
+// Filter returns a handler that records requests to h when recording is
+// enabled.
+func (rec *Recorder) Filter(h Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		if !rec.Enabled() {
+			h.ServeWeb(req)
+			return
+		}
+		t := &Transcript{
+			Time:       Nanoseconds(),
+			RemoteAddr: req.RemoteAddr,
+			Method:     req.Method,
+			URL:        req.URL.String(),
+			Header:     redactHeader(req.Header),
+		}
+		reqBuf := &limitedBuffer{max: rec.maxBody}
+		respBuf := &limitedBuffer{max: rec.maxBody}
+		body := req.Body
+		req.Body = recordingReader{body, reqBuf}
+		responder := req.Responder
+		req.Responder = &teeResponder{Responder: responder, t: t, buf: respBuf}
+		h.ServeWeb(req)
+		req.Body = body
+		req.Responder = responder
+		t.Body = reqBuf.buf.Bytes()
+		t.ResponseBody = respBuf.buf.Bytes()
+		t.Truncated = reqBuf.truncated || respBuf.truncated
+		rec.add(t)
+	})
+}
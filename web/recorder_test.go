@@ -0,0 +1,91 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package web
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	clock := NewFakeClock(1000e9)
+	defer SetClock(SetClock(clock))
+
+	rec := NewRecorder(2, 4)
+	h := rec.Filter(HandlerFunc(func(req *Request) {
+		p, _ := ioutil.ReadAll(req.Body)
+		w := req.Respond(StatusOK, HeaderSetCookie, "session=secret")
+		w.Write(p)
+	}))
+	header := NewHeader(
+		HeaderAuthorization, "Basic dXNlcjpwYXNz",
+		HeaderCookie, "session=secret",
+		HeaderAccept, "text/plain",
+		HeaderContentLength, "2")
+
+	RunHandler("http://example.com/off", "POST", header, []byte("ab"), h)
+	if n := len(rec.Transcripts()); n != 0 {
+		t.Fatalf("disabled recorder has %d transcripts, want 0", n)
+	}
+
+	rec.SetEnabled(true)
+	header.Set(HeaderContentLength, "6")
+	status, _, body := RunHandler("http://example.com/a", "POST", header, []byte("abcdef"), h)
+	if status != StatusOK || string(body) != "abcdef" {
+		t.Errorf("response = %d %q, want %d %q", status, body, StatusOK, "abcdef")
+	}
+
+	ts := rec.Transcripts()
+	if len(ts) != 1 {
+		t.Fatalf("len(transcripts)=%d, want 1", len(ts))
+	}
+	tr := ts[0]
+	if tr.Time != 1000e9 || tr.Method != "POST" || tr.URL != "http://example.com/a" || tr.Status != StatusOK {
+		t.Errorf("transcript = %d %s %s %d", tr.Time, tr.Method, tr.URL, tr.Status)
+	}
+	if string(tr.Body) != "abcd" || string(tr.ResponseBody) != "abcd" || !tr.Truncated {
+		t.Errorf("transcript body=%q response body=%q truncated=%v", tr.Body, tr.ResponseBody, tr.Truncated)
+	}
+	for _, v := range []string{tr.Header.Get(HeaderAuthorization), tr.Header.Get(HeaderCookie), tr.ResponseHeader.Get(HeaderSetCookie)} {
+		if v != "[redacted]" {
+			t.Errorf("credential header value %q, want [redacted]", v)
+		}
+	}
+	if v := tr.Header.Get(HeaderAccept); v != "text/plain" {
+		t.Errorf("Accept=%q, want text/plain", v)
+	}
+
+	var b bytes.Buffer
+	if err := rec.Dump(&b); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.String(); strings.Contains(s, "secret") || strings.Contains(s, "dXNlcjpwYXNz") {
+		t.Errorf("dump contains credentials: %q", s)
+	}
+
+	// The ring buffer keeps the last two transcripts.
+	RunHandler("http://example.com/b", "GET", nil, nil, h)
+	RunHandler("http://example.com/c", "GET", nil, nil, h)
+	ts = rec.Transcripts()
+	if len(ts) != 2 || ts[0].URL != "http://example.com/b" || ts[1].URL != "http://example.com/c" {
+		t.Errorf("transcripts after wrap = %v", ts)
+	}
+
+	rec.Clear()
+	if n := len(rec.Transcripts()); n != 0 {
+		t.Errorf("len(transcripts) after Clear=%d, want 0", n)
+	}
+}
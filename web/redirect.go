@@ -0,0 +1,223 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bufio"
+	"io/ioutil"
+	"json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type redirectTarget struct {
+	url    string
+	status int
+}
+
+type redirectMapHandler struct {
+	filename string
+	fallback Handler
+
+	mu      sync.Mutex
+	targets map[string]redirectTarget
+	mtime   int64
+	checked int64
+}
+
+// redirectCheckInterval is the minimum number of nanoseconds between checks
+// for a modified redirect map file.
+const redirectCheckInterval = 1e9
+
+// RedirectMapHandler returns a handler that redirects requests using the map
+// of paths to URLs in the named file. Requests for paths not in the map are
+// passed to fallback. The file is reloaded when its modification time
+// changes. If a reload fails, the error is logged and the previous map is
+// used.
+//
+// If the file name has the suffix ".json", then the file contains a JSON
+// object mapping old paths to new URLs or an array of objects with the
+// members "from", "to" and optional "status". Otherwise, the file contains
+// CSV rows of the form:
+//
+//  old path,new URL[,status]
+//
+// The default status is 301. A status that is not a 3xx redirect status is an
+// error. The request query is appended to target URLs
+// that do not have a query.
+func RedirectMapHandler(filename string, fallback Handler) (Handler, os.Error) {
+	rh := &redirectMapHandler{filename: filename, fallback: fallback}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	rh.targets, err = loadRedirectMap(filename)
+	if err != nil {
+		return nil, err
+	}
+	rh.mtime = fi.Mtime_ns
+	return rh, nil
+}
+
+func loadRedirectMap(filename string) (map[string]redirectTarget, os.Error) {
+	p, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var targets map[string]redirectTarget
+	if strings.HasSuffix(filename, ".json") {
+		targets, err = parseRedirectJSON(p)
+	} else {
+		targets, err = parseRedirectCSV(p)
+	}
+	if err != nil {
+		return nil, os.NewError("twister: redirect map " + filename + ": " + err.String())
+	}
+	return targets, nil
+}
+
+func isRedirectStatus(status int) bool {
+	return 300 <= status && status < 400
+}
+
+func parseRedirectJSON(p []byte) (map[string]redirectTarget, os.Error) {
+	var v interface{}
+	if err := json.Unmarshal(p, &v); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]redirectTarget)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for from, to := range v {
+			s, ok := to.(string)
+			if !ok {
+				return nil, os.NewError("target for " + from + " is not a string")
+			}
+			targets[from] = redirectTarget{s, StatusMovedPermanently}
+		}
+	case []interface{}:
+		for _, item := range v {
+			m, _ := item.(map[string]interface{})
+			from, _ := m["from"].(string)
+			to, _ := m["to"].(string)
+			if from == "" || to == "" {
+				return nil, os.NewError("entry missing from or to")
+			}
+			status := StatusMovedPermanently
+			if f, ok := m["status"].(float64); ok {
+				status = int(f)
+			}
+			if !isRedirectStatus(status) {
+				return nil, os.NewError("status for " + from + " is not a redirect status")
+			}
+			targets[from] = redirectTarget{to, status}
+		}
+	default:
+		return nil, os.NewError("expected object or array")
+	}
+	return targets, nil
+}
+
+func parseRedirectCSV(p []byte) (map[string]redirectTarget, os.Error) {
+	targets := make(map[string]redirectTarget)
+	br := bufio.NewReader(strings.NewReader(string(p)))
+	for line := 1; ; line++ {
+		row, err := readCSVRow(br, ',')
+		if err == os.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(row) < 2 || len(row) > 3 {
+			return nil, os.NewError("line " + strconv.Itoa(line) + ": expected 2 or 3 fields")
+		}
+		status := StatusMovedPermanently
+		if len(row) == 3 {
+			status, err = strconv.Atoi(strings.TrimSpace(row[2]))
+			if err != nil || !isRedirectStatus(status) {
+				return nil, os.NewError("line " + strconv.Itoa(line) + ": bad status")
+			}
+		}
+		targets[strings.TrimSpace(row[0])] = redirectTarget{strings.TrimSpace(row[1]), status}
+	}
+	return targets, nil
+}
+
+// reload loads the map if the file changed. The file is read without holding
+// the lock so that requests are not blocked on disk IO.
+func (rh *redirectMapHandler) reload() {
+	rh.mu.Lock()
+	mtime := rh.mtime
+	rh.mu.Unlock()
+
+	fi, err := os.Stat(rh.filename)
+	if err != nil {
+		log.Println("twister: redirect map", err)
+		return
+	}
+	if fi.Mtime_ns == mtime {
+		return
+	}
+	targets, err := loadRedirectMap(rh.filename)
+	if err != nil {
+		// Keep the previous map and don't retry until the file changes again.
+		log.Println(err)
+	}
+
+	rh.mu.Lock()
+	if targets != nil {
+		rh.targets = targets
+	}
+	rh.mtime = fi.Mtime_ns
+	rh.mu.Unlock()
+}
+
+// lookup returns the redirect target for path, reloading the map if the file
+// changed.
+func (rh *redirectMapHandler) lookup(path string) (redirectTarget, bool) {
+	now := Nanoseconds()
+	rh.mu.Lock()
+	check := now-rh.checked >= redirectCheckInterval
+	if check {
+		// Only one request checks the file in each interval.
+		rh.checked = now
+	}
+	rh.mu.Unlock()
+
+	if check {
+		rh.reload()
+	}
+
+	rh.mu.Lock()
+	t, found := rh.targets[path]
+	rh.mu.Unlock()
+	return t, found
+}
+
+func (rh *redirectMapHandler) ServeWeb(req *Request) {
+	t, found := rh.lookup(req.URL.Path)
+	if !found {
+		rh.fallback.ServeWeb(req)
+		return
+	}
+	url := t.url
+	if req.URL.RawQuery != "" && strings.IndexRune(url, '?') < 0 {
+		url += "?" + req.URL.RawQuery
+	}
+	req.Respond(t.status, HeaderLocation, url)
+}
@@ -0,0 +1,95 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRedirectMapHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "redirects.csv")
+	ioutil.WriteFile(filename, []byte("/old,/new\n/temp,http://example.com/x?y=z,302\n"), 0666)
+
+	h, err := RedirectMapHandler(filename, routeTestHandler("fallback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url      string
+		status   int
+		location string
+	}{
+		{"/old?a=b", StatusMovedPermanently, "/new?a=b"},
+		{"/temp?a=b", StatusFound, "http://example.com/x?y=z"},
+		{"/other", StatusOK, ""},
+	}
+	for _, tt := range tests {
+		status, header, _ := RunHandler(tt.url, "GET", nil, nil, h)
+		if status != tt.status || header.Get(HeaderLocation) != tt.location {
+			t.Errorf("%s: status=%d location=%q, want %d %q", tt.url, status, header.Get(HeaderLocation), tt.status, tt.location)
+		}
+	}
+
+	// Reload after change.
+	ioutil.WriteFile(filename, []byte("/other,/elsewhere,307\n"), 0666)
+	os.Chtimes(filename, 0, 1e9)
+	h.(*redirectMapHandler).checked = 0
+	status, header, _ := RunHandler("/other", "GET", nil, nil, h)
+	if status != 307 || header.Get(HeaderLocation) != "/elsewhere" {
+		t.Errorf("after reload status=%d location=%q", status, header.Get(HeaderLocation))
+	}
+
+	// A reload with a bad status keeps the previous map.
+	ioutil.WriteFile(filename, []byte("/other,/bad,200\n"), 0666)
+	os.Chtimes(filename, 0, 2e9)
+	h.(*redirectMapHandler).checked = 0
+	status, header, _ = RunHandler("/other", "GET", nil, nil, h)
+	if status != 307 || header.Get(HeaderLocation) != "/elsewhere" {
+		t.Errorf("after bad reload status=%d location=%q", status, header.Get(HeaderLocation))
+	}
+}
+
+var badRedirectMapTests = []struct {
+	name    string
+	content string
+}{
+	{"bad.csv", "/old,/new,200\n"},
+	{"bad.csv", "/old,/new,abc\n"},
+	{"bad.json", `[{"from": "/old", "to": "/new", "status": 404}]`},
+	{"bad.json", `{"/old": 1}`},
+}
+
+func TestRedirectMapHandlerBadStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tt := range badRedirectMapTests {
+		filename := filepath.Join(dir, tt.name)
+		ioutil.WriteFile(filename, []byte(tt.content), 0666)
+		if _, err := RedirectMapHandler(filename, routeTestHandler("fallback")); err == nil {
+			t.Errorf("%s %q: expected error", tt.name, tt.content)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"json"
+	"log"
+	"os"
+	"runtime/debug"
+	"smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorReport is a snapshot of a request that failed with a panic or a 5xx
+// response status.
+type ErrorReport struct {
+	// Time of the error in seconds since the epoch.
+	Time int64
+
+	Status     int
+	Method     string
+	URL        string
+	RemoteAddr string
+	Header     Header
+	RequestID  string
+	Route      string
+
+	// Error is the panic value or the reason passed to Request.Error.
+	Error string
+
+	// Stack trace of the goroutine at the time of the error.
+	Stack string
+
+	// Number of reports dropped by RateLimitReporter since the previous
+	// report.
+	Suppressed int
+}
+
+func (r *ErrorReport) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d %s %s\n", r.Status, r.Method, r.URL)
+	fmt.Fprintf(&b, "Time: %s\n", time.SecondsToUTC(r.Time).Format(TimeLayout))
+	fmt.Fprintf(&b, "Error: %s\n", r.Error)
+	fmt.Fprintf(&b, "RemoteAddr: %s\n", r.RemoteAddr)
+	if r.RequestID != "" {
+		fmt.Fprintf(&b, "RequestID: %s\n", r.RequestID)
+	}
+	if r.Route != "" {
+		fmt.Fprintf(&b, "Route: %s\n", r.Route)
+	}
+	if r.Suppressed > 0 {
+		fmt.Fprintf(&b, "Suppressed: %d\n", r.Suppressed)
+	}
+	b.WriteString("\nHeader:\n")
+	for k, values := range r.Header {
+		for _, v := range values {
+			fmt.Fprintf(&b, "  %s: %s\n", k, v)
+		}
+	}
+	b.WriteString("\nStack:\n")
+	b.WriteString(r.Stack)
+	return b.String()
+}
+
+// sensitiveHeaders is the set of headers that carry credentials.
+var sensitiveHeaders = map[string]bool{
+	HeaderAuthorization:      true,
+	HeaderCookie:             true,
+	HeaderProxyAuthorization: true,
+	HeaderSetCookie:          true,
+}
+
+// redactHeader returns a copy of header with the values of credential
+// headers replaced by "[redacted]". Use redactHeader before a header leaves
+// the process in a report or transcript.
+func redactHeader(header Header) Header {
+	result := make(Header, len(header))
+	for k, v := range header {
+		if sensitiveHeaders[k] {
+			v = []string{"[redacted]"}
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// ErrorReporter receives error reports. ReportError is called on the
+// goroutine serving the request and should not block.
+type ErrorReporter interface {
+	ReportError(r *ErrorReport)
+}
+
+// ErrorReporterFunc is a type adapter to allow the use of ordinary functions
+// as ErrorReporter.
+type ErrorReporterFunc func(r *ErrorReport)
+
+// ReportError calls f(r).
+func (f ErrorReporterFunc) ReportError(r *ErrorReport) { f(r) }
+
+// ErrorReportHandler returns a handler that calls reporter when h panics or
+// responds with a 5xx status. Panics are reported and then propagated to the
+// caller. At most one report is made per request.
+func ErrorReportHandler(reporter ErrorReporter, h Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		reported := false
+		report := func(status int, reason string) {
+			if reported {
+				return
+			}
+			reported = true
+			reporter.ReportError(&ErrorReport{
+				Time:       Seconds(),
+				Status:     status,
+				Method:     req.Method,
+				URL:        req.URL.String(),
+				RemoteAddr: req.RemoteAddr,
+				Header:     redactHeader(req.Header),
+				RequestID:  RequestID(req),
+				Route:      RequestRoute(req),
+				Error:      reason,
+				Stack:      string(debug.Stack()),
+			})
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				report(StatusInternalServerError, fmt.Sprint(r))
+				panic(r)
+			}
+		}()
+		errorHandler := req.ErrorHandler
+		req.ErrorHandler = func(req *Request, status int, reason os.Error, header Header) {
+			if status >= 500 && reason != nil {
+				report(status, reason.String())
+			}
+			errorHandler(req, status, reason, header)
+		}
+		FilterRespond(req, func(status int, header Header) (int, Header) {
+			if status >= 500 {
+				report(status, StatusText(status))
+			}
+			return status, header
+		})
+		h.ServeWeb(req)
+	})
+}
+
+type rateLimitReporter struct {
+	reporter ErrorReporter
+	max      int
+	period   int64
+
+	mu         sync.Mutex
+	start      int64
+	count      int
+	suppressed int
+}
+
+// RateLimitReporter returns a reporter that passes at most max reports per
+// period seconds to reporter. Excess reports are dropped. The number of
+// dropped reports is recorded in the Suppressed field of the next report
+// passed through.
+func RateLimitReporter(reporter ErrorReporter, max int, period int64) ErrorReporter {
+	return &rateLimitReporter{reporter: reporter, max: max, period: period}
+}
+
+func (rl *rateLimitReporter) ReportError(r *ErrorReport) {
+	now := Seconds()
+	rl.mu.Lock()
+	if now >= rl.start+rl.period {
+		rl.start = now
+		rl.count = 0
+	}
+	if rl.count >= rl.max {
+		rl.suppressed += 1
+		rl.mu.Unlock()
+		return
+	}
+	rl.count += 1
+	r.Suppressed = rl.suppressed
+	rl.suppressed = 0
+	rl.mu.Unlock()
+	rl.reporter.ReportError(r)
+}
+
+// MailReporterOptions configures MailReporter.
+type MailReporterOptions struct {
+	// Address of the SMTP server in host:port format.
+	Addr string
+
+	// Authentication for the SMTP server or nil for no authentication.
+	Auth smtp.Auth
+
+	// Sender and recipients of the mail.
+	From string
+	To   []string
+
+	// Prefix for the subject line. The default is "[error]".
+	SubjectPrefix string
+}
+
+// MailReporter returns a reporter that sends reports by email. Mail is sent
+// on a separate goroutine. Wrap the reporter with RateLimitReporter to
+// protect the mail server from bursts of errors.
+func MailReporter(options *MailReporterOptions) ErrorReporter {
+	o := *options
+	if o.SubjectPrefix == "" {
+		o.SubjectPrefix = "[error]"
+	}
+	return ErrorReporterFunc(func(r *ErrorReport) {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "From: %s\r\n", o.From)
+		fmt.Fprintf(&b, "To: %s\r\n", strings.Join(o.To, ", "))
+		fmt.Fprintf(&b, "Subject: %s %d %s %s\r\n", o.SubjectPrefix, r.Status, r.Method, r.URL)
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		b.WriteString(strings.Replace(r.String(), "\n", "\r\n", -1))
+		go func() {
+			if err := smtp.SendMail(o.Addr, o.Auth, o.From, o.To, b.Bytes()); err != nil {
+				log.Printf("twister: error report mail failed: %s", err)
+			}
+		}()
+	})
+}
+
+// WebhookReporter returns a reporter that POSTs reports as JSON objects to
+// url. Requests are sent on a separate goroutine.
+func WebhookReporter(url string) ErrorReporter {
+	return ErrorReporterFunc(func(r *ErrorReport) {
+		p, err := json.Marshal(map[string]interface{}{
+			"time":       r.Time,
+			"status":     r.Status,
+			"method":     r.Method,
+			"url":        r.URL,
+			"remoteAddr": r.RemoteAddr,
+			"header":     r.Header,
+			"requestID":  r.RequestID,
+			"route":      r.Route,
+			"error":      r.Error,
+			"stack":      r.Stack,
+			"suppressed": r.Suppressed,
+		})
+		if err != nil {
+			log.Printf("twister: error report encode failed: %s", err)
+			return
+		}
+		go func() {
+			resp, err := http.Post(url, "application/json", bytes.NewBuffer(p))
+			if err != nil {
+				log.Printf("twister: error report post failed: %s", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	})
+}
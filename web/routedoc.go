@@ -0,0 +1,111 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// RouteDoc documents a route.
+type RouteDoc struct {
+	// Description of the route.
+	Description string
+
+	// Documentation for path parameters and request parameters, keyed by
+	// parameter name.
+	Params map[string]string
+
+	// Example requests, for example "GET /item/123".
+	Examples []string
+}
+
+// Doc attaches documentation to the most recently registered route. The
+// documentation is included in the output of Describe, DescriptionHandler
+// and HelpHandler.
+//
+//  r.Register("/item/<id:[0-9]+>", "GET", serveItem).Doc(&web.RouteDoc{
+//      Description: "Returns the item.",
+//      Params:      map[string]string{"id": "The item id."},
+//      Examples:    []string{"GET /item/123"},
+//  })
+func (router *Router) Doc(doc *RouteDoc) *Router {
+	if router.last == nil {
+		panic("twister: Doc called before Register")
+	}
+	router.last.doc = doc
+	return router
+}
+
+// HelpHandler returns a handler that responds with an HTML API reference
+// generated from the router description.
+func (router *Router) HelpHandler() Handler {
+	return HandlerFunc(func(req *Request) {
+		var b bytes.Buffer
+		b.WriteString("<!DOCTYPE html>\n<html><head><title>API Reference</title></head><body>\n<h1>API Reference</h1>\n")
+		for _, rd := range router.Describe() {
+			fmt.Fprintf(&b, "<h2><code>%s</code></h2>\n", HTMLEscapeString(rd.Pattern))
+			b.WriteString("<p>Methods:")
+			for _, md := range rd.Methods {
+				fmt.Fprintf(&b, " <code>%s</code>", HTMLEscapeString(md.Method))
+			}
+			b.WriteString("</p>\n")
+			if rd.Doc == nil {
+				continue
+			}
+			if rd.Doc.Description != "" {
+				fmt.Fprintf(&b, "<p>%s</p>\n", HTMLEscapeString(rd.Doc.Description))
+			}
+			if len(rd.Doc.Params) > 0 {
+				b.WriteString("<dl>\n")
+				for _, name := range rd.Params {
+					if doc, ok := rd.Doc.Params[name]; ok {
+						fmt.Fprintf(&b, "<dt><code>%s</code></dt><dd>%s</dd>\n", HTMLEscapeString(name), HTMLEscapeString(doc))
+					}
+				}
+				var names []string
+				for name := range rd.Doc.Params {
+					if !containsString(rd.Params, name) {
+						names = append(names, name)
+					}
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					fmt.Fprintf(&b, "<dt><code>%s</code></dt><dd>%s</dd>\n", HTMLEscapeString(name), HTMLEscapeString(rd.Doc.Params[name]))
+				}
+				b.WriteString("</dl>\n")
+			}
+			for _, example := range rd.Doc.Examples {
+				fmt.Fprintf(&b, "<pre>%s</pre>\n", HTMLEscapeString(example))
+			}
+		}
+		b.WriteString("</body></html>\n")
+		w := req.Respond(StatusOK,
+			HeaderContentType, "text/html; charset=utf-8",
+			HeaderContentLength, strconv.Itoa(b.Len()))
+		w.Write(b.Bytes())
+	})
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -17,7 +17,11 @@ package web
 import (
 	"bytes"
 	"http"
+	"json"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -36,7 +40,8 @@ import (
 // The pattern must begin with the character '/'.
 //
 // A router dispatches requests by matching the path component of the request
-// URL against the route patterns in the order that the routes were registered.
+// URL against the route patterns in priority order. Routes with the same
+// priority are matched in the order that the routes were registered.
 // If a matching route is found, then the router searches the route for a
 // handler using the request method, "GET" if the request method is "HEAD" and
 // "*". If a handler is not found, the router responds with HTTP status 405. If
@@ -48,10 +53,19 @@ import (
 // trailing slash to the URL with the trailing slash.
 //
 type Router struct {
+	// If true, Register panics if the new route duplicates or is shadowed
+	// by a previously registered route. See Check.
+	CheckRegister bool
+
 	routes []*route
+	last   *route
 }
 
 type route struct {
+	pattern  string
+	priority int
+	doc      *RouteDoc
+	cache    *CacheControl
 	addSlash bool
 	regexp   *regexp.Regexp
 	names    []string
@@ -61,10 +75,9 @@ type route struct {
 var parameterRegexp = regexp.MustCompile("<([A-Za-z0-9_]*)(:[^>]*)?>")
 
 // compilePattern compiles the pattern to a regexp and array of parameter names.
-func compilePattern(pattern string, addSlash bool, sep string) (*regexp.Regexp, []string) {
+func compilePattern(pattern string, addSlash bool, sep string) (*regexp.Regexp, []string, os.Error) {
 	var buf bytes.Buffer
-	names := make([]string, 8)
-	i := 0
+	var names []string
 	buf.WriteString("^")
 	for {
 		a := parameterRegexp.FindStringSubmatchIndex(pattern)
@@ -75,12 +88,20 @@ func compilePattern(pattern string, addSlash bool, sep string) (*regexp.Regexp,
 			buf.WriteString(regexp.QuoteMeta(pattern[0:a[0]]))
 			name := pattern[a[2]:a[3]]
 			if name != "" {
-				names[i] = pattern[a[2]:a[3]]
-				i += 1
+				for _, n := range names {
+					if n == name {
+						return nil, nil, os.NewError("duplicate parameter name " + name)
+					}
+				}
+				names = append(names, name)
 				buf.WriteString("(")
 			}
 			if a[4] >= 0 {
-				buf.WriteString(pattern[a[4]+1 : a[5]])
+				expr := pattern[a[4]+1 : a[5]]
+				if _, err := regexp.Compile(expr); err != nil {
+					return nil, nil, os.NewError("invalid regexp " + expr + " for parameter " + name + ": " + err.String())
+				}
+				buf.WriteString(expr)
 			} else {
 				buf.WriteString("[^" + sep + "]+")
 			}
@@ -94,7 +115,11 @@ func compilePattern(pattern string, addSlash bool, sep string) (*regexp.Regexp,
 		buf.WriteString("?")
 	}
 	buf.WriteString("$")
-	return regexp.MustCompile(buf.String()), names[0:i]
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return re, names, nil
 }
 
 // Register the route with the given pattern and handlers. The structure of the
@@ -104,7 +129,18 @@ func compilePattern(pattern string, addSlash bool, sep string) (*regexp.Regexp,
 //
 // where method is a string and handler is a Handler or a
 // func(*Request). Use "*" to match all methods.
+//
+// The route is registered with priority 0. See RegisterPriority.
 func (router *Router) Register(pattern string, handlers ...interface{}) *Router {
+	return router.RegisterPriority(0, pattern, handlers...)
+}
+
+// RegisterPriority registers a route with the given priority. Routes with
+// higher priority are matched before routes with lower priority. Routes with
+// equal priority are matched in the order that they were registered. Use a
+// higher priority to register a specific route such as "/users/new" after a
+// generic route such as "/users/<id>".
+func (router *Router) RegisterPriority(priority int, pattern string, handlers ...interface{}) *Router {
 	if pattern == "" || pattern[0] != '/' {
 		panic("twister: Invalid route pattern " + pattern)
 	}
@@ -112,9 +148,13 @@ func (router *Router) Register(pattern string, handlers ...interface{}) *Router
 		panic("twister: Invalid handlers for pattern " + pattern +
 			". Structure of handlers is [method handler]+.")
 	}
-	r := route{}
+	r := route{pattern: pattern, priority: priority}
 	r.addSlash = pattern[len(pattern)-1] == '/'
-	r.regexp, r.names = compilePattern(pattern, r.addSlash, "/")
+	var err os.Error
+	r.regexp, r.names, err = compilePattern(pattern, r.addSlash, "/")
+	if err != nil {
+		panic("twister: Invalid route pattern " + pattern + ": " + err.String())
+	}
 	r.handlers = make(map[string]Handler)
 	for i := 0; i < len(handlers); i += 2 {
 		method, ok := handlers[i].(string)
@@ -130,10 +170,65 @@ func (router *Router) Register(pattern string, handlers ...interface{}) *Router
 			panic("twister: Bad handler for pattern " + pattern + " and method " + method)
 		}
 	}
-	router.routes = append(router.routes, &r)
+
+	// Insert after routes with greater or equal priority.
+	i := 0
+	for i < len(router.routes) && router.routes[i].priority >= priority {
+		i += 1
+	}
+
+	if router.CheckRegister {
+		for _, prev := range router.routes[:i] {
+			if err := checkRoutes(prev, &r); err != nil {
+				panic("twister: " + err.String())
+			}
+		}
+		for _, next := range router.routes[i:] {
+			if err := checkRoutes(&r, next); err != nil {
+				panic("twister: " + err.String())
+			}
+		}
+	}
+
+	router.routes = append(router.routes, nil)
+	copy(router.routes[i+1:], router.routes[i:])
+	router.routes[i] = &r
+	router.last = &r
 	return router
 }
 
+// checkRoutes returns an error if route b can never be reached because
+// route a is checked first.
+func checkRoutes(a, b *route) os.Error {
+	switch {
+	case a.pattern == b.pattern:
+		return os.NewError("duplicate route pattern " + b.pattern)
+	case a.regexp.MatchString(b.pattern):
+		return os.NewError("route pattern " + b.pattern + " is shadowed by " + a.pattern)
+	}
+	return nil
+}
+
+// Check returns errors for duplicate route patterns and for routes that are
+// shadowed by an earlier route. A route is shadowed if the earlier route's
+// pattern matches the later route's pattern text. For example, "/users/<id>"
+// shadows "/users/new" and "/<path:.*>" shadows every later route.
+//
+// Invalid parameter regexps and duplicate parameter names are reported by
+// Register.
+func (router *Router) Check() []os.Error {
+	var errs []os.Error
+	for i, b := range router.routes {
+		for _, a := range router.routes[:i] {
+			if err := checkRoutes(a, b); err != nil {
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+	return errs
+}
+
 type routerError int
 
 func (status routerError) ServeWeb(req *Request) {
@@ -149,44 +244,55 @@ func addSlash(req *Request) {
 	req.Redirect(path, true)
 }
 
-// find the handler and path parameters given the path component of the request
-// URL and the request method.
-func (router *Router) find(path string, method string) (Handler, []string, []string) {
+// wrap applies the route's defaults to handler.
+func (r *route) wrap(handler Handler) Handler {
+	if r.cache != nil {
+		handler = CacheControlHandler(*r.cache, handler)
+	}
+	return handler
+}
+
+// find the handler, path parameters and route pattern given the path component
+// of the request URL and the request method.
+func (router *Router) find(path string, method string) (Handler, []string, []string, string) {
 	for _, r := range router.routes {
 		values := r.regexp.FindStringSubmatch(path)
 		if len(values) == 0 {
 			continue
 		}
 		if r.addSlash && path[len(path)-1] != '/' {
-			return HandlerFunc(addSlash), nil, nil
+			return HandlerFunc(addSlash), nil, nil, r.pattern
 		}
 		values = values[1:]
 		for j := 0; j < len(values); j++ {
 			if value, e := http.URLUnescape(values[j]); e != nil {
-				return routerError(StatusNotFound), nil, nil
+				return routerError(StatusNotFound), nil, nil, r.pattern
 			} else {
 				values[j] = value
 			}
 		}
 		if handler := r.handlers[method]; handler != nil {
-			return handler, r.names, values
+			return r.wrap(handler), r.names, values, r.pattern
 		}
 		if method == "HEAD" {
 			if handler := r.handlers["GET"]; handler != nil {
-				return handler, r.names, values
+				return r.wrap(handler), r.names, values, r.pattern
 			}
 		}
 		if handler := r.handlers["*"]; handler != nil {
-			return handler, r.names, values
+			return r.wrap(handler), r.names, values, r.pattern
 		}
-		return routerError(StatusMethodNotAllowed), nil, nil
+		return routerError(StatusMethodNotAllowed), nil, nil, r.pattern
 	}
-	return routerError(StatusNotFound), nil, nil
+	return routerError(StatusNotFound), nil, nil, ""
 }
 
 // ServeWeb dispatches the request to a registered handler.
 func (router *Router) ServeWeb(req *Request) {
-	handler, names, values := router.find(req.URL.Path, req.Method)
+	handler, names, values, pattern := router.find(req.URL.Path, req.Method)
+	if pattern != "" {
+		req.Env[routeEnvKey] = pattern
+	}
 	if req.URLParam == nil {
 		req.URLParam = make(map[string]string, len(values))
 	}
@@ -196,6 +302,112 @@ func (router *Router) ServeWeb(req *Request) {
 	handler.ServeWeb(req)
 }
 
+// RouteDescription describes a route registered with a router.
+type RouteDescription struct {
+	Pattern string
+	Params  []string
+	Methods []MethodDescription
+	Doc     *RouteDoc // documentation attached with Router.Doc, if any
+}
+
+// MethodDescription describes a (method, handler) pair in a route. Fields is
+// the validation declared with ValidateHandler, if any.
+type MethodDescription struct {
+	Method string
+	Fields []*Field
+}
+
+// Describe returns a description of the registered routes in the order that
+// the routes are matched.
+func (router *Router) Describe() []RouteDescription {
+	result := make([]RouteDescription, len(router.routes))
+	for i, r := range router.routes {
+		result[i].Pattern = r.pattern
+		result[i].Params = r.names
+		result[i].Doc = r.doc
+		var methods []string
+		for method := range r.handlers {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			md := MethodDescription{Method: method}
+			if vh, ok := r.handlers[method].(*validateHandler); ok {
+				md.Fields = vh.fields
+			}
+			result[i].Methods = append(result[i].Methods, md)
+		}
+	}
+	return result
+}
+
+func describeField(f *Field) map[string]interface{} {
+	m := map[string]interface{}{
+		"source":   f.Source,
+		"name":     f.Name,
+		"required": f.Required,
+		"type":     f.Type,
+	}
+	if f.HasMin {
+		m["min"] = f.MinValue
+	}
+	if f.HasMax {
+		m["max"] = f.MaxValue
+	}
+	if f.MaxLength > 0 {
+		m["maxLength"] = f.MaxLength
+	}
+	if f.Pattern != nil {
+		m["pattern"] = f.Pattern.String()
+	}
+	return m
+}
+
+// DescriptionHandler returns a handler that responds with the router
+// description as a JSON document. The document has the form:
+//
+//  {"routes": [{"pattern": "/item/<id:[0-9]+>", "params": ["id"],
+//      "methods": [{"method": "GET", "fields": [...]}],
+//      "description": "...", "paramDocs": {"id": "..."}, "examples": [...]}]}
+//
+// The description, paramDocs and examples members are included for routes
+// documented with Router.Doc.
+func (router *Router) DescriptionHandler() Handler {
+	return HandlerFunc(func(req *Request) {
+		var routes []interface{}
+		for _, rd := range router.Describe() {
+			var methods []interface{}
+			for _, md := range rd.Methods {
+				fields := make([]interface{}, len(md.Fields))
+				for i, f := range md.Fields {
+					fields[i] = describeField(f)
+				}
+				methods = append(methods, map[string]interface{}{"method": md.Method, "fields": fields})
+			}
+			route := map[string]interface{}{
+				"pattern": rd.Pattern,
+				"params":  rd.Params,
+				"methods": methods,
+			}
+			if rd.Doc != nil {
+				route["description"] = rd.Doc.Description
+				route["paramDocs"] = rd.Doc.Params
+				route["examples"] = rd.Doc.Examples
+			}
+			routes = append(routes, route)
+		}
+		p, err := json.Marshal(map[string]interface{}{"routes": routes})
+		if err != nil {
+			req.Error(StatusInternalServerError, err)
+			return
+		}
+		w := req.Respond(StatusOK,
+			HeaderContentType, "application/json; charset=utf-8",
+			HeaderContentLength, strconv.Itoa(len(p)))
+		w.Write(p)
+	})
+}
+
 // NewRouter allocates and initializes a new Router. 
 func NewRouter() *Router {
 	return &Router{}
@@ -235,7 +447,10 @@ func NewHostRouter(defaultHandler Handler) *HostRouter {
 
 // Register a handler for the given pattern.
 func (router *HostRouter) Register(hostPattern string, handler Handler) *HostRouter {
-	regex, names := compilePattern(hostPattern, false, ".")
+	regex, names, err := compilePattern(hostPattern, false, ".")
+	if err != nil {
+		panic("twister: Invalid host pattern " + hostPattern + ": " + err.String())
+	}
 	router.routes = append(router.routes, hostRoute{regexp: regex, names: names, handler: handler})
 	return router
 }
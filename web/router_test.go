@@ -16,6 +16,7 @@ package web
 
 import (
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -119,3 +120,139 @@ func TestHostRouter(t *testing.T) {
 		}
 	}
 }
+
+func TestDescribe(t *testing.T) {
+	r := NewRouter()
+	r.Register("/", "GET", routeTestHandler("home-get"))
+	r.Register("/item/<id:[0-9]+>", "PUT", ValidateHandler(routeTestHandler("item-put"), Param("name").Require()),
+		"GET", routeTestHandler("item-get"))
+
+	d := r.Describe()
+	if len(d) != 2 {
+		t.Fatalf("len(Describe())=%d, want 2", len(d))
+	}
+	if d[1].Pattern != "/item/<id:[0-9]+>" || len(d[1].Params) != 1 || d[1].Params[0] != "id" {
+		t.Errorf("Describe()[1]=%v", d[1])
+	}
+	if len(d[1].Methods) != 2 || d[1].Methods[0].Method != "GET" || d[1].Methods[1].Method != "PUT" {
+		t.Fatalf("Describe()[1].Methods=%v", d[1].Methods)
+	}
+	if len(d[1].Methods[1].Fields) != 1 || d[1].Methods[1].Fields[0].Name != "name" {
+		t.Errorf("Describe()[1].Methods[1].Fields=%v", d[1].Methods[1].Fields)
+	}
+
+	status, _, body := RunHandler("/", "GET", nil, nil, r.DescriptionHandler())
+	if status != StatusOK || len(body) == 0 {
+		t.Errorf("DescriptionHandler status=%d, body=%q", status, body)
+	}
+}
+
+func TestRouterCheck(t *testing.T) {
+	r := NewRouter()
+	r.Register("/users/<id>", "GET", routeTestHandler("user"))
+	r.Register("/users/new", "GET", routeTestHandler("new"))
+	r.Register("/about", "GET", routeTestHandler("about"))
+	r.Register("/about", "POST", routeTestHandler("about-post"))
+	r.Register("/static/<path:.*>", "GET", routeTestHandler("static"))
+	r.Register("/static/favicon.ico", "GET", routeTestHandler("favicon"))
+	r.Register("/users/<id>/posts", "GET", routeTestHandler("posts"))
+	errs := r.Check()
+	if len(errs) != 3 {
+		t.Errorf("Check() returned %v, want 3 errors", errs)
+	}
+
+	r = NewRouter()
+	r.CheckRegister = true
+	r.Register("/users/new", "GET", routeTestHandler("new"))
+	r.Register("/users/<id>", "GET", routeTestHandler("user"))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Register of shadowed route did not panic")
+			}
+		}()
+		r.Register("/users/new", "POST", routeTestHandler("new-post"))
+	}()
+}
+
+func TestRouterPriority(t *testing.T) {
+	r := NewRouter()
+	r.CheckRegister = true
+	r.Register("/users/<id>", "GET", routeTestHandler("user"))
+	r.RegisterPriority(1, "/users/new", "GET", routeTestHandler("new"))
+	r.RegisterPriority(-1, "/<path:.*>", "GET", routeTestHandler("default"))
+	for _, tt := range []struct{ url, body string }{
+		{"/users/new", "new"},
+		{"/users/123", "user id:123"},
+		{"/other", "default path:other"},
+	} {
+		_, _, body := RunHandler(tt.url, "GET", nil, nil, r)
+		if string(body) != tt.body {
+			t.Errorf("url=%s, body=%q, want %q", tt.url, body, tt.body)
+		}
+	}
+}
+
+func TestRouteDoc(t *testing.T) {
+	r := NewRouter()
+	r.Register("/item/<id>", "GET", routeTestHandler("item")).Doc(&RouteDoc{
+		Description: "Returns <the> item.",
+		Params:      map[string]string{"id": "The item id."},
+		Examples:    []string{"GET /item/1"},
+	})
+	r.Register("/other", "GET", routeTestHandler("other"))
+	rds := r.Describe()
+	if rds[0].Doc == nil || rds[0].Doc.Description != "Returns <the> item." || rds[1].Doc != nil {
+		t.Errorf("Describe() docs not attached to routes")
+	}
+	_, _, body := RunHandler("/help", "GET", nil, nil, r.HelpHandler())
+	if !strings.Contains(string(body), "Returns &lt;the&gt; item.") {
+		t.Errorf("help page missing escaped description: %s", body)
+	}
+}
+
+func TestRouterCache(t *testing.T) {
+	r := NewRouter()
+	r.Register("/static", "GET", routeTestHandler("static")).Cache(&CacheControl{Public: true, MaxAge: 60})
+	r.Register("/private", "GET", func(req *Request) {
+		req.Respond(StatusOK, HeaderCacheControl, CacheControl{Private: true, NoCache: true}.String())
+	}).Cache(&CacheControl{Public: true, MaxAge: 60})
+	r.Register("/none", "GET", routeTestHandler("none"))
+	for _, tt := range []struct{ url, cc string }{
+		{"/static", "public, max-age=60"},
+		{"/private", "private, no-cache"},
+		{"/none", ""},
+	} {
+		_, header, _ := RunHandler(tt.url, "GET", nil, nil, r)
+		if cc := header.Get(HeaderCacheControl); cc != tt.cc {
+			t.Errorf("url=%s, Cache-Control=%q, want %q", tt.url, cc, tt.cc)
+		}
+	}
+}
+
+func TestRegisterInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"/<a>/<a>", "/<a:[>"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) did not panic", pattern)
+				}
+			}()
+			NewRouter().Register(pattern, "GET", routeTestHandler("x"))
+		}()
+	}
+}
+
+func BenchmarkRouterFind(b *testing.B) {
+	b.StopTimer()
+	r := NewRouter()
+	for _, p := range []string{"/", "/about", "/users", "/users/<id:[0-9]+>", "/users/<id:[0-9]+>/posts/<post>", "/static/<path:.*>"} {
+		r.Register(p, "GET", routeTestHandler(p))
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if h, _, _, _ := r.find("/users/123/posts/hello", "GET"); h == nil {
+			b.Fatal("no handler")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+)
+
+// Resource describes a value that is opened before a request is handled and
+// closed after the request is handled. Examples of resources are database
+// connections and transactions.
+type Resource struct {
+	// Name of the resource. The value returned from Open is stored in the
+	// request Env with the key "twister.web.resource." + Name and is
+	// available to handlers through RequestResource.
+	Name string
+
+	// Open returns the resource value for the request. If Open returns an
+	// error, the handler is not called and the request fails with status 503.
+	Open func(req *Request) (interface{}, os.Error)
+
+	// Close releases the resource. The ok argument is true if the handler
+	// returned without panicking and responded with a status less than 400.
+	// Close typically commits a transaction when ok is true and rolls back
+	// the transaction when ok is false.
+	Close func(req *Request, value interface{}, ok bool) os.Error
+}
+
+func resourceEnvKey(name string) string {
+	return "twister.web.resource." + name
+}
+
+// RequestResource returns the value of the named resource opened by
+// ResourceHandler or nil if the resource is not open.
+func RequestResource(req *Request, name string) interface{} {
+	return req.Env[resourceEnvKey(name)]
+}
+
+// resourceResponder buffers the response until the resources are closed.
+type resourceResponder struct {
+	Responder
+	status    int
+	header    Header
+	body      bytes.Buffer
+	responded bool
+}
+
+func (r *resourceResponder) Respond(status int, header Header) io.Writer {
+	if r.responded {
+		return nullWriter{ErrInvalidState}
+	}
+	r.responded = true
+	r.status = status
+	r.header = header
+	return &r.body
+}
+
+// ResourceHandler returns a handler that opens the resources in order, calls
+// h and then closes the resources in reverse order.
+//
+// The response is buffered in memory until the resources are closed. If a
+// Close function returns an error when the resources are committed, then the
+// buffered response is discarded and the request fails with status 500. Errors
+// from rolling back the resources are logged.
+func ResourceHandler(h Handler, resources ...*Resource) Handler {
+	for _, r := range resources {
+		if r.Name == "" || r.Open == nil {
+			panic("twister: ResourceHandler requires Name and Open fields")
+		}
+	}
+	return HandlerFunc(func(req *Request) {
+		responder := req.Responder
+		rr := &resourceResponder{Responder: responder}
+		req.Responder = rr
+		var opened []*Resource
+		returned := false
+
+		defer func() {
+			req.Responder = responder
+			commit := returned && rr.status < 400
+			ok := commit
+			var closeErr os.Error
+			for i := len(opened) - 1; i >= 0; i-- {
+				r := opened[i]
+				key := resourceEnvKey(r.Name)
+				value := req.Env[key]
+				req.Env[key] = nil, false
+				if r.Close == nil {
+					continue
+				}
+				if err := r.Close(req, value, ok); err != nil {
+					// Roll back the remaining resources.
+					ok = false
+					if closeErr == nil {
+						closeErr = err
+					}
+				}
+			}
+			if !returned {
+				// The handler panicked. Discard the response.
+				if closeErr != nil {
+					log.Println("twister: close resource", closeErr)
+				}
+				return
+			}
+			if closeErr != nil {
+				if commit {
+					req.Error(StatusInternalServerError, closeErr)
+					return
+				}
+				log.Println("twister: close resource", closeErr)
+			}
+			if rr.responded {
+				w := responder.Respond(rr.status, rr.header)
+				w.Write(rr.body.Bytes())
+			}
+		}()
+
+		for _, r := range resources {
+			value, err := r.Open(req)
+			if err != nil {
+				req.Error(StatusServiceUnavailable, err)
+				returned = true
+				return
+			}
+			req.Env[resourceEnvKey(r.Name)] = value
+			opened = append(opened, r)
+		}
+
+		h.ServeWeb(req)
+		returned = true
+	})
+}
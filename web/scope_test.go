@@ -0,0 +1,107 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+var resourceHandlerTests = []struct {
+	path     string
+	openErr  bool
+	closeErr bool
+	status   int
+	events   string
+}{
+	{"/ok", false, false, StatusOK, "open a, open b, commit b, commit a"},
+	{"/error", false, false, StatusNotFound, "open a, open b, rollback b, rollback a"},
+	{"/ok", true, false, StatusServiceUnavailable, "open a, rollback a"},
+	{"/ok", false, true, StatusInternalServerError, "open a, open b, commit b, rollback a"},
+	{"/error", false, true, StatusNotFound, "open a, open b, rollback b, rollback a"},
+	{"/panic", false, false, 0, "open a, open b, rollback b, rollback a"},
+}
+
+func TestResourceHandler(t *testing.T) {
+	for _, tt := range resourceHandlerTests {
+		var events []string
+		newResource := func(name string, openErr, closeErr bool) *Resource {
+			return &Resource{
+				Name: name,
+				Open: func(req *Request) (interface{}, os.Error) {
+					if openErr {
+						return nil, os.NewError("open failed")
+					}
+					events = append(events, "open "+name)
+					return name + " value", nil
+				},
+				Close: func(req *Request, value interface{}, ok bool) os.Error {
+					if ok {
+						events = append(events, "commit "+name)
+					} else {
+						events = append(events, "rollback "+name)
+					}
+					if closeErr {
+						return os.NewError("close failed")
+					}
+					return nil
+				},
+			}
+		}
+		h := ResourceHandler(HandlerFunc(func(req *Request) {
+			if v := RequestResource(req, "a"); v != "a value" {
+				t.Errorf("%s resource a=%v", tt.path, v)
+			}
+			switch req.URL.Path {
+			case "/ok":
+				// The response must not be sent before the resources are
+				// committed.
+				if len(events) != 2 {
+					t.Errorf("%s events=%v before response", tt.path, events)
+				}
+				io.WriteString(req.Respond(StatusOK), "hello")
+			case "/error":
+				req.Error(StatusNotFound, nil)
+			case "/panic":
+				panic("boom")
+			}
+		}), newResource("a", false, false), newResource("b", tt.openErr, tt.closeErr))
+
+		var status int
+		var body []byte
+		func() {
+			defer func() {
+				r := recover()
+				if r != nil && tt.status != 0 {
+					t.Errorf("%s panic %v", tt.path, r)
+				} else if r == nil && tt.status == 0 {
+					t.Errorf("%s panic not propagated", tt.path)
+				}
+			}()
+			status, _, body = RunHandler("http://example.com"+tt.path, "GET", nil, nil, h)
+		}()
+		if status != tt.status {
+			t.Errorf("%s openErr=%v closeErr=%v status=%d, want %d", tt.path, tt.openErr, tt.closeErr, status, tt.status)
+		}
+		if tt.status == StatusOK && string(body) != "hello" {
+			t.Errorf("%s body=%q, want %q", tt.path, body, "hello")
+		}
+		if s := strings.Join(events, ", "); s != tt.events {
+			t.Errorf("%s openErr=%v closeErr=%v events=%q, want %q", tt.path, tt.openErr, tt.closeErr, s, tt.events)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RequestSignatureOptions configures SignatureHandler.
+type RequestSignatureOptions struct {
+	// Secret returns the shared secret for a key id. The application is
+	// required to set this field.
+	Secret func(keyID string) (secret string, found bool)
+
+	// Names of the request headers containing the key id, signature,
+	// timestamp and nonce. The defaults are "X-Signature-Key", "X-Signature",
+	// "X-Signature-Timestamp" and "X-Signature-Nonce".
+	KeyIDHeader     string
+	SignatureHeader string
+	TimestampHeader string
+	NonceHeader     string
+
+	// Maximum difference in seconds between the request timestamp and the
+	// server clock. The default is 300.
+	MaxSkew int
+
+	// Maximum length of the request body. The default is 1MB.
+	MaxRequestBodyLen int
+}
+
+// RequestSignature returns the hex encoded HMAC SHA-256 signature of a
+// request. The signature covers the request method, the request path
+// including the query, the timestamp, the nonce and the body.
+func RequestSignature(secret, method, path, timestamp, nonce string, body []byte) string {
+	hm := hmac.NewSHA256([]byte(secret))
+	for _, s := range []string{method, path, timestamp, nonce} {
+		hm.Write([]byte(s))
+		hm.Write([]byte{'\n'})
+	}
+	hm.Write(body)
+	return hex.EncodeToString(hm.Sum())
+}
+
+// SignatureHandler returns a handler that verifies HMAC request signatures
+// created with RequestSignature before calling h. Requests with missing or
+// bad signatures, timestamps outside of the allowed skew window or a nonce
+// used previously in the window are rejected with status 401.
+//
+// The request body is buffered in memory so that h can read the body after
+// the signature is verified. The key id is added to the request Env with the
+// key "twister.web.SignatureKeyID".
+func SignatureHandler(options *RequestSignatureOptions, h Handler) Handler {
+	if options.Secret == nil {
+		panic("twister: SignatureHandler requires Secret option")
+	}
+	sh := &signatureHandler{
+		options: *options,
+		h:       h,
+		nonces:  make(map[string]int64),
+	}
+	o := &sh.options
+	if o.KeyIDHeader == "" {
+		o.KeyIDHeader = "X-Signature-Key"
+	}
+	if o.SignatureHeader == "" {
+		o.SignatureHeader = "X-Signature"
+	}
+	if o.TimestampHeader == "" {
+		o.TimestampHeader = "X-Signature-Timestamp"
+	}
+	if o.NonceHeader == "" {
+		o.NonceHeader = "X-Signature-Nonce"
+	}
+	if o.MaxSkew <= 0 {
+		o.MaxSkew = 300
+	}
+	if o.MaxRequestBodyLen == 0 {
+		o.MaxRequestBodyLen = 1 << 20
+	}
+	return sh
+}
+
+type signatureHandler struct {
+	options RequestSignatureOptions
+	h       Handler
+
+	mu     sync.Mutex
+	nonces map[string]int64 // nonce -> expiration
+
+	// Nonces in order of expiration. The expiration is a fixed offset from
+	// the time the nonce is used, so appending keeps the queue ordered.
+	queue []nonceExpiration
+}
+
+type nonceExpiration struct {
+	nonce      string
+	expiration int64
+}
+
+var errBadSignature = os.NewError("twister: bad request signature")
+
+func (sh *signatureHandler) ServeWeb(req *Request) {
+	o := &sh.options
+	keyID := req.Header.Get(o.KeyIDHeader)
+	signature := req.Header.Get(o.SignatureHeader)
+	timestamp := req.Header.Get(o.TimestampHeader)
+	nonce := req.Header.Get(o.NonceHeader)
+	if keyID == "" || signature == "" || timestamp == "" || nonce == "" {
+		req.Error(StatusUnauthorized, os.NewError("twister: request signature missing"))
+		return
+	}
+
+	now := Seconds()
+	t, err := strconv.Atoi64(timestamp)
+	if err != nil || t < now-int64(o.MaxSkew) || t > now+int64(o.MaxSkew) {
+		req.Error(StatusUnauthorized, os.NewError("twister: request signature timestamp out of range"))
+		return
+	}
+
+	secret, found := o.Secret(keyID)
+	if !found {
+		req.Error(StatusUnauthorized, errBadSignature)
+		return
+	}
+
+	body, err := req.BufferBody(o.MaxRequestBodyLen)
+	if err != nil {
+		status := StatusBadRequest
+		if err == ErrRequestEntityTooLarge {
+			status = StatusRequestEntityTooLarge
+		}
+		req.Error(status, err)
+		return
+	}
+
+	path := req.URL.Path
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	expected := RequestSignature(secret, req.Method, path, timestamp, nonce, body)
+	if len(expected) != len(signature) ||
+		subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		req.Error(StatusUnauthorized, errBadSignature)
+		return
+	}
+
+	if !sh.useNonce(keyID+" "+nonce, now, now+2*int64(o.MaxSkew)) {
+		req.Error(StatusUnauthorized, os.NewError("twister: request signature replayed"))
+		return
+	}
+
+	req.Env["twister.web.SignatureKeyID"] = keyID
+	sh.h.ServeWeb(req)
+}
+
+// useNonce records the nonce and returns false if the nonce was seen before.
+func (sh *signatureHandler) useNonce(nonce string, now, expiration int64) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	i := 0
+	for ; i < len(sh.queue) && sh.queue[i].expiration < now; i++ {
+		sh.nonces[sh.queue[i].nonce] = 0, false
+	}
+	sh.queue = sh.queue[i:]
+	if _, found := sh.nonces[nonce]; found {
+		return false
+	}
+	sh.nonces[nonce] = expiration
+	sh.queue = append(sh.queue, nonceExpiration{nonce, expiration})
+	return true
+}
@@ -0,0 +1,92 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signatureTestHandler(req *Request) {
+	p, _ := ioutil.ReadAll(req.Body)
+	req.Respond(StatusOK).Write(p)
+}
+
+func TestSignatureHandler(t *testing.T) {
+	h := SignatureHandler(&RequestSignatureOptions{
+		Secret: func(keyID string) (string, bool) { return "secret", keyID == "k" },
+	}, HandlerFunc(signatureTestHandler))
+
+	now := strconv.Itoa64(time.Seconds())
+	old := strconv.Itoa64(time.Seconds() - 3600)
+	body := []byte("hello")
+
+	tests := []struct {
+		keyID, timestamp, nonce, signature string
+		status                             int
+	}{
+		{"k", now, "1", RequestSignature("secret", "POST", "/a?b=c", now, "1", body), StatusOK},
+		{"k", now, "1", RequestSignature("secret", "POST", "/a?b=c", now, "1", body), StatusUnauthorized},
+		{"k", now, "2", RequestSignature("secret", "POST", "/a", now, "2", body), StatusUnauthorized},
+		{"k", now, "3", RequestSignature("wrong", "POST", "/a?b=c", now, "3", body), StatusUnauthorized},
+		{"x", now, "4", RequestSignature("secret", "POST", "/a?b=c", now, "4", body), StatusUnauthorized},
+		{"k", old, "5", RequestSignature("secret", "POST", "/a?b=c", old, "5", body), StatusUnauthorized},
+		{"k", now, "6", "", StatusUnauthorized},
+	}
+
+	for i, tt := range tests {
+		header := NewHeader(
+			HeaderContentLength, strconv.Itoa(len(body)),
+			"X-Signature-Key", tt.keyID,
+			"X-Signature-Timestamp", tt.timestamp,
+			"X-Signature-Nonce", tt.nonce,
+			"X-Signature", tt.signature)
+		status, _, respBody := RunHandler("http://example.com/a?b=c", "POST", header, body, h)
+		if status != tt.status {
+			t.Errorf("test %d, status=%d, want %d", i, status, tt.status)
+		}
+		if status == StatusOK && string(respBody) != string(body) {
+			t.Errorf("test %d, body=%q, want %q", i, respBody, body)
+		}
+	}
+}
+
+func TestUseNonce(t *testing.T) {
+	sh := SignatureHandler(&RequestSignatureOptions{
+		Secret: func(keyID string) (string, bool) { return "", false },
+	}, nil).(*signatureHandler)
+
+	for i, tt := range []struct {
+		nonce string
+		now   int64
+		ok    bool
+	}{
+		{"a", 100, true},
+		{"b", 105, true},
+		{"a", 109, false},
+		{"a", 111, true},
+		{"b", 114, false},
+		{"b", 116, true},
+	} {
+		if ok := sh.useNonce(tt.nonce, tt.now, tt.now+10); ok != tt.ok {
+			t.Errorf("%d: useNonce(%q, %d) = %v, want %v", i, tt.nonce, tt.now, ok, tt.ok)
+		}
+	}
+	if len(sh.nonces) != 2 || len(sh.queue) != 2 {
+		t.Errorf("nonces=%d queue=%d, want 2 2", len(sh.nonces), len(sh.queue))
+	}
+}
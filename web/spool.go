@@ -0,0 +1,124 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// SpoolHandler returns a handler that spools response bodies from h and then
+// writes the response with an accurate Content-Length header. Bodies up to
+// maxMemory bytes are held in memory. Larger bodies are written to a
+// temporary file that is removed when the response is complete.
+//
+// Use this handler for generated downloads such as ZIP or CSV exports where
+// the length is not known in advance and chunked transfer encoding is not
+// supported by some clients. Responses that already include a Content-Length
+// header are not spooled.
+func SpoolHandler(maxMemory int, h Handler) Handler {
+	return &spoolHandler{maxMemory: maxMemory, h: h}
+}
+
+type spoolHandler struct {
+	maxMemory int
+	h         Handler
+}
+
+type spoolResponder struct {
+	Responder
+	maxMemory int
+	status    int
+	header    Header
+	spooling  bool
+	buf       bytes.Buffer
+	file      *os.File
+	size      int64
+	err       os.Error
+}
+
+func (r *spoolResponder) Respond(status int, header Header) io.Writer {
+	if header.Get(HeaderContentLength) != "" {
+		return r.Responder.Respond(status, header)
+	}
+	r.spooling = true
+	r.status = status
+	r.header = header
+	return r
+}
+
+func (r *spoolResponder) Write(p []byte) (int, os.Error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.file == nil && r.buf.Len()+len(p) > r.maxMemory {
+		r.file, r.err = ioutil.TempFile("", "twister-spool")
+		if r.err != nil {
+			return 0, r.err
+		}
+		if _, r.err = r.file.Write(r.buf.Bytes()); r.err != nil {
+			return 0, r.err
+		}
+		r.buf.Reset()
+	}
+	var n int
+	if r.file != nil {
+		n, r.err = r.file.Write(p)
+	} else {
+		n, r.err = r.buf.Write(p)
+	}
+	r.size += int64(n)
+	return n, r.err
+}
+
+func (r *spoolResponder) cleanup() {
+	if r.file != nil {
+		r.file.Close()
+		os.Remove(r.file.Name())
+	}
+}
+
+func (sh *spoolHandler) ServeWeb(req *Request) {
+	r := &spoolResponder{Responder: req.Responder, maxMemory: sh.maxMemory}
+	defer r.cleanup()
+	req.Responder = r
+	sh.h.ServeWeb(req)
+	req.Responder = r.Responder
+	if !r.spooling {
+		return
+	}
+	if r.err != nil {
+		req.Error(StatusInternalServerError, r.err)
+		return
+	}
+
+	var body io.Reader = &r.buf
+	if r.file != nil {
+		if _, err := r.file.Seek(0, 0); err != nil {
+			req.Error(StatusInternalServerError, err)
+			return
+		}
+		body = r.file
+	}
+
+	r.header.Set(HeaderContentLength, strconv.Itoa64(r.size))
+	w := req.Responder.Respond(r.status, r.header)
+	if req.Method != "HEAD" {
+		io.Copy(w, body)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSpoolHandler(t *testing.T) {
+	for _, n := range []int{0, 10, 100} {
+		body := strings.Repeat("x", n)
+		h := SpoolHandler(20, HandlerFunc(func(req *Request) {
+			w := req.Respond(StatusOK, HeaderContentType, "text/plain")
+			for i := 0; i < len(body); i += 7 {
+				j := i + 7
+				if j > len(body) {
+					j = len(body)
+				}
+				io.WriteString(w, body[i:j])
+			}
+		}))
+		status, header, p := RunHandler("/", "GET", nil, nil, h)
+		if status != StatusOK {
+			t.Errorf("%d: status=%d", n, status)
+		}
+		if cl := header.Get(HeaderContentLength); cl != strconv.Itoa(n) {
+			t.Errorf("%d: content-length=%q", n, cl)
+		}
+		if string(p) != body {
+			t.Errorf("%d: body=%q", n, p)
+		}
+	}
+}
@@ -21,8 +21,65 @@ import (
 	"io"
 	"net"
 	"os"
+	"sync"
 )
 
+// FakeClock is a Clock for tests. The time changes only when the test calls
+// Set or Advance. Install a fake clock with SetClock.
+type FakeClock struct {
+	mu sync.Mutex
+	ns int64
+}
+
+// NewFakeClock returns a fake clock set to ns nanoseconds since the epoch.
+func NewFakeClock(ns int64) *FakeClock {
+	return &FakeClock{ns: ns}
+}
+
+// Nanoseconds returns the clock's time.
+func (c *FakeClock) Nanoseconds() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ns
+}
+
+// Set sets the clock's time to ns nanoseconds since the epoch.
+func (c *FakeClock) Set(ns int64) {
+	c.mu.Lock()
+	c.ns = ns
+	c.mu.Unlock()
+}
+
+// Advance moves the clock forward by d nanoseconds.
+func (c *FakeClock) Advance(d int64) {
+	c.mu.Lock()
+	c.ns += d
+	c.mu.Unlock()
+}
+
+// FakeRandom is a deterministic random source for tests. FakeRandom returns
+// the sequence of bytes 0, 1, 2, ... 255, 0, 1, ... Install a fake random
+// source with SetRandomSource.
+type FakeRandom struct {
+	mu sync.Mutex
+	b  byte
+}
+
+// NewFakeRandom returns a new fake random source.
+func NewFakeRandom() *FakeRandom {
+	return &FakeRandom{}
+}
+
+func (r *FakeRandom) Read(p []byte) (int, os.Error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range p {
+		p[i] = r.b
+		r.b += 1
+	}
+	return len(p), nil
+}
+
 type testTransaction struct {
 	in, out bytes.Buffer
 	status  int
@@ -51,6 +108,14 @@ func (b testResponseBody) Flush() os.Error {
 	return nil
 }
 
+func (b testResponseBody) SetBufferSize(n int) os.Error {
+	return nil
+}
+
+func (b testResponseBody) DisableBuffering() os.Error {
+	return nil
+}
+
 func (b testResponseBody) Write(p []byte) (int, os.Error) {
 	return b.t.out.Write(p)
 }
@@ -0,0 +1,205 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"json"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Field declares constraints on a request parameter, URL parameter or header.
+// Fields are created with the Param, URLParamField and HeaderField functions
+// and configured using chained method calls:
+//
+//  web.Param("age").Require().Int().Min(0).Max(150)
+type Field struct {
+	Source   string // "param", "url" or "header"
+	Name     string
+	Required bool
+	Type     string // "string", "int", "float" or "bool"
+
+	HasMin, HasMax bool
+	MinValue       float64
+	MaxValue       float64
+	MaxLength      int
+	Pattern        *regexp.Regexp
+}
+
+// Param returns a field for the request parameter with the given name.
+func Param(name string) *Field { return &Field{Source: "param", Name: name, Type: "string"} }
+
+// URLParamField returns a field for the URL parameter with the given name.
+func URLParamField(name string) *Field { return &Field{Source: "url", Name: name, Type: "string"} }
+
+// HeaderField returns a field for the request header with the given name.
+func HeaderField(name string) *Field {
+	return &Field{Source: "header", Name: HeaderName(name), Type: "string"}
+}
+
+// Require marks the field as required.
+func (f *Field) Require() *Field { f.Required = true; return f }
+
+// Int constrains the field to integer values.
+func (f *Field) Int() *Field { f.Type = "int"; return f }
+
+// Float constrains the field to numeric values.
+func (f *Field) Float() *Field { f.Type = "float"; return f }
+
+// Bool constrains the field to the values "true", "false", "1" and "0".
+func (f *Field) Bool() *Field { f.Type = "bool"; return f }
+
+// Min sets the minimum value of a numeric field.
+func (f *Field) Min(v float64) *Field { f.HasMin = true; f.MinValue = v; return f }
+
+// Max sets the maximum value of a numeric field.
+func (f *Field) Max(v float64) *Field { f.HasMax = true; f.MaxValue = v; return f }
+
+// MaxLen sets the maximum length in bytes of the field value.
+func (f *Field) MaxLen(n int) *Field { f.MaxLength = n; return f }
+
+// Match constrains the field value to match the regular expression pattern.
+// The pattern is anchored to the start and end of the value.
+func (f *Field) Match(pattern string) *Field {
+	f.Pattern = regexp.MustCompile("^(" + pattern + ")$")
+	return f
+}
+
+// ValidationError describes a field that failed validation.
+type ValidationError struct {
+	Source  string
+	Name    string
+	Message string
+}
+
+func (f *Field) value(req *Request) (string, bool) {
+	switch f.Source {
+	case "url":
+		v, found := req.URLParam[f.Name]
+		return v, found
+	case "header":
+		v, found := req.Header[f.Name]
+		if !found || len(v) == 0 {
+			return "", false
+		}
+		return v[0], true
+	}
+	v, found := req.Param[f.Name]
+	if !found || len(v) == 0 {
+		return "", false
+	}
+	return v[0], true
+}
+
+// check returns a message describing why value is not valid or "" if the
+// value is valid.
+func (f *Field) check(value string) string {
+	if f.MaxLength > 0 && len(value) > f.MaxLength {
+		return "is longer than " + strconv.Itoa(f.MaxLength) + " bytes"
+	}
+	var n float64
+	switch f.Type {
+	case "int":
+		i, err := strconv.Atoi64(value)
+		if err != nil {
+			return "must be an integer"
+		}
+		n = float64(i)
+	case "float":
+		var err os.Error
+		if n, err = strconv.Atof64(value); err != nil {
+			return "must be a number"
+		}
+	case "bool":
+		if value != "true" && value != "false" && value != "1" && value != "0" {
+			return "must be a boolean"
+		}
+	}
+	if f.Type == "int" || f.Type == "float" {
+		if f.HasMin && n < f.MinValue {
+			return "must be at least " + strconv.Ftoa64(f.MinValue, 'g', -1)
+		}
+		if f.HasMax && n > f.MaxValue {
+			return "must be at most " + strconv.Ftoa64(f.MaxValue, 'g', -1)
+		}
+	}
+	if f.Pattern != nil && !f.Pattern.MatchString(value) {
+		return "has invalid format"
+	}
+	return ""
+}
+
+// Validate checks the request against fields and returns the errors found.
+func Validate(req *Request, fields []*Field) []ValidationError {
+	var errors []ValidationError
+	for _, f := range fields {
+		value, found := f.value(req)
+		if !found {
+			if f.Required {
+				errors = append(errors, ValidationError{f.Source, f.Name, "is required"})
+			}
+			continue
+		}
+		if msg := f.check(value); msg != "" {
+			errors = append(errors, ValidationError{f.Source, f.Name, msg})
+		}
+	}
+	return errors
+}
+
+// ValidateHandler returns a handler that validates the request against fields
+// before calling h. If validation fails, the handler responds with status 422
+// and a JSON document of the form:
+//
+//  {"errors": [{"source": "param", "name": "age", "message": "must be an integer"}]}
+//
+// Request parameters from a form body are only available if the request is
+// wrapped with FormHandler before ValidateHandler.
+func ValidateHandler(h Handler, fields ...*Field) Handler {
+	return &validateHandler{fields: fields, h: h}
+}
+
+type validateHandler struct {
+	fields []*Field
+	h      Handler
+}
+
+func (vh *validateHandler) ServeWeb(req *Request) {
+	errors := Validate(req, vh.fields)
+	if len(errors) == 0 {
+		vh.h.ServeWeb(req)
+		return
+	}
+	respondValidationErrors(req, errors)
+}
+
+// respondValidationErrors responds to the request with status 422 and a JSON
+// document describing the errors.
+func respondValidationErrors(req *Request, errors []ValidationError) {
+	doc := make([]map[string]string, len(errors))
+	for i, e := range errors {
+		doc[i] = map[string]string{"source": e.Source, "name": e.Name, "message": e.Message}
+	}
+	p, err := json.Marshal(map[string]interface{}{"errors": doc})
+	if err != nil {
+		req.Error(StatusInternalServerError, err)
+		return
+	}
+	w := req.Respond(StatusUnprocessableEntity,
+		HeaderContentType, "application/json; charset=utf-8",
+		HeaderContentLength, strconv.Itoa(len(p)))
+	w.Write(p)
+}
@@ -0,0 +1,48 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"testing"
+)
+
+var validateTests = []struct {
+	url    string
+	status int
+}{
+	{"/?age=20&name=bob", StatusOK},
+	{"/?age=20", StatusOK},
+	{"/?name=bob", StatusUnprocessableEntity},
+	{"/?age=x", StatusUnprocessableEntity},
+	{"/?age=200", StatusUnprocessableEntity},
+	{"/?age=-1", StatusUnprocessableEntity},
+	{"/?age=20&name=Bob", StatusUnprocessableEntity},
+	{"/?age=20&name=abcdefghijk", StatusUnprocessableEntity},
+}
+
+func TestValidateHandler(t *testing.T) {
+	h := ValidateHandler(HandlerFunc(func(req *Request) { req.Respond(StatusOK) }),
+		Param("age").Require().Int().Min(0).Max(150),
+		Param("name").Match("[a-z]+").MaxLen(10))
+	for _, tt := range validateTests {
+		status, header, _ := RunHandler(tt.url, "GET", nil, nil, h)
+		if status != tt.status {
+			t.Errorf("%s status=%d, want %d", tt.url, status, tt.status)
+		}
+		if status != StatusOK && header.Get(HeaderContentType) != "application/json; charset=utf-8" {
+			t.Errorf("%s content type=%q", tt.url, header.Get(HeaderContentType))
+		}
+	}
+}
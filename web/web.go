@@ -18,6 +18,7 @@ package web
 
 import (
 	"bufio"
+	"bytes"
 	"http"
 	"io"
 	"io/ioutil"
@@ -50,6 +51,12 @@ type Responder interface {
 }
 
 // Request represents an HTTP request to the server.
+//
+// A request and its Header, Param, Cookie, URLParam and Env maps are valid
+// until the handler returns. If the server is configured to reuse requests,
+// the same Request value is reset for the next request on the connection.
+// Handlers that pass the request to another goroutine or retain any of its
+// maps after returning must then copy the values they need.
 type Request struct {
 	// The response.
 	Responder Responder
@@ -95,8 +102,12 @@ type Request struct {
 	// The request body.
 	Body io.Reader
 
-	// Attributes attached to the request by middleware. 
+	// Attributes attached to the request by middleware. See also Set and
+	// Get.
 	Env map[string]interface{}
+
+	// Values stored with Set.
+	values map[*ValueKey]interface{}
 }
 
 // ErrorHandler handles request errors.
@@ -119,39 +130,117 @@ func (f HandlerFunc) ServeWeb(req *Request) { f(req) }
 // for the convenience of protocol adapters (fcgi, native http server, ...).
 func NewRequest(remoteAddr string, method string, url *http.URL, protocolVersion int, header Header) (req *Request, err os.Error) {
 	req = &Request{
-		RemoteAddr:      remoteAddr,
-		Method:          strings.ToUpper(method),
-		URL:             url,
-		ProtocolVersion: protocolVersion,
-		ErrorHandler:    defaultErrorHandler,
-		Param:           make(Values),
-		Header:          header,
-		Cookie:          make(Values),
-		Env:             make(map[string]interface{}),
+		Param:  make(Values),
+		Cookie: make(Values),
+		Env:    make(map[string]interface{}),
 	}
-
-	err = req.Param.ParseFormEncodedBytes([]byte(req.URL.RawQuery))
-	if err != nil {
+	if err := req.init(remoteAddr, method, url, protocolVersion, header); err != nil {
 		return nil, err
 	}
+	return req, nil
+}
 
-	err = parseCookieValues(header[HeaderCookie], req.Cookie)
-	if err != nil {
-		return nil, err
+// Reset reinitializes the request for reuse by a server on a keep-alive
+// connection. The Param, Cookie and Env maps and the values stored with Set
+// are cleared and reused. All other fields are set as in NewRequest.
+func (req *Request) Reset(remoteAddr string, method string, url *http.URL, protocolVersion int, header Header) os.Error {
+	param, cookie, env, values := req.Param, req.Cookie, req.Env, req.values
+	for k := range param {
+		param[k] = nil, false
+	}
+	for k := range cookie {
+		cookie[k] = nil, false
+	}
+	for k := range env {
+		env[k] = nil, false
+	}
+	for k := range values {
+		values[k] = nil, false
+	}
+	*req = Request{Param: param, Cookie: cookie, Env: env, values: values}
+	return req.init(remoteAddr, method, url, protocolVersion, header)
+}
+
+func (req *Request) init(remoteAddr string, method string, url *http.URL, protocolVersion int, header Header) os.Error {
+	req.RemoteAddr = remoteAddr
+	req.Method = strings.ToUpper(method)
+	req.URL = url
+	req.ProtocolVersion = protocolVersion
+	req.ErrorHandler = defaultErrorHandler
+	req.Header = header
+
+	if err := req.Param.ParseFormEncodedBytes([]byte(req.URL.RawQuery)); err != nil {
+		return err
+	}
+
+	if err := parseCookieValues(header[HeaderCookie], req.Cookie); err != nil {
+		return err
 	}
 
 	if s := req.Header.Get(HeaderContentLength); s != "" {
 		var err os.Error
 		req.ContentLength, err = strconv.Atoi(s)
 		if err != nil {
-			return nil, os.NewError("bad content length")
+			return os.NewError("bad content length")
 		}
 	} else if method != "HEAD" && method != "GET" {
 		req.ContentLength = -1
 	}
 
 	req.ContentType, req.ContentParam = req.Header.GetValueParam(HeaderContentType)
-	return req, nil
+	return nil
+}
+
+// ValueKey identifies a value stored on a request with Set. Keys are compared
+// by identity, so values stored by different packages cannot collide. A
+// package that stores a value typically declares the key as an unexported
+// variable and provides an accessor function for the value:
+//
+//  var principalKey = web.NewValueKey("auth.principal")
+//
+//  func RequestPrincipal(req *web.Request) *Principal {
+//      p, _ := req.Get(principalKey).(*Principal)
+//      return p
+//  }
+type ValueKey struct {
+	name string
+}
+
+// NewValueKey returns a new key. The name is used for debugging only.
+func NewValueKey(name string) *ValueKey {
+	return &ValueKey{name}
+}
+
+func (k *ValueKey) String() string {
+	return k.name
+}
+
+// Set stores value on the request with the given key, replacing any previous
+// value. Setting a nil value deletes the key.
+//
+// The package that declares a key owns the value stored with the key. Other
+// packages should treat the value as read only. Values live for the duration
+// of the request and are cleared when the server reuses the request, so
+// handlers must not retain references to request values after returning.
+// Values are not copied to requests created by Dispatch, MirrorHandler and
+// similar handlers.
+func (req *Request) Set(key *ValueKey, value interface{}) {
+	if value == nil {
+		if req.values != nil {
+			req.values[key] = nil, false
+		}
+		return
+	}
+	if req.values == nil {
+		req.values = make(map[*ValueKey]interface{})
+	}
+	req.values[key] = value
+}
+
+// Get returns the value stored on the request with the given key or nil if
+// there is no value.
+func (req *Request) Get(key *ValueKey) interface{} {
+	return req.values[key]
 }
 
 // Respond is a convenience function that adds (key, value) pairs in
@@ -226,6 +315,27 @@ func (req *Request) BodyBytes(maxLen int) ([]byte, os.Error) {
 	return p, nil
 }
 
+// BufferBody reads the request body into memory and replaces the request body
+// with a reader over the buffered bytes. The buffered bytes are returned.
+// BufferBody allows middleware to inspect the body without preventing the
+// handler from reading it. BufferBody is idempotent. See BodyBytes for a
+// description of maxLen.
+func (req *Request) BufferBody(maxLen int) ([]byte, os.Error) {
+	const key = "twister.web.bufferedBody"
+	if p, ok := req.Env[key].([]byte); ok {
+		req.Body = bytes.NewBuffer(p)
+		return p, nil
+	}
+	p, err := req.BodyBytes(maxLen)
+	if err != nil {
+		return nil, err
+	}
+	req.Env[key] = p
+	req.Body = bytes.NewBuffer(p)
+	req.ContentLength = len(p)
+	return p, nil
+}
+
 // ParseForm parses url-encoded form bodies. ParseForm is idempotent. Most
 // applications should use the FormHandler middleware instead of calling this
 // method directly.
@@ -254,3 +364,17 @@ func (req *Request) ParseForm(maxRequestBodyLen int) os.Error {
 type Flusher interface {
 	Flush() os.Error
 }
+
+// BufferController is implemented by response bodies that allow the HTTP
+// handler to control buffering of the response body. Streaming handlers use
+// this interface to ensure that small writes are sent to the network without
+// waiting for the buffer to fill.
+type BufferController interface {
+	// SetBufferSize flushes buffered data to the network and sets the size
+	// of the response buffer to n bytes.
+	SetBufferSize(n int) os.Error
+
+	// DisableBuffering flushes buffered data to the network and causes
+	// subsequent writes to be flushed to the network immediately.
+	DisableBuffering() os.Error
+}
@@ -0,0 +1,219 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Compression methods for ZipResponse entries.
+const (
+	ZipStore   = 0
+	ZipDeflate = 8
+)
+
+var errZipTooLarge = os.NewError("twister: zip archive larger than 4GB")
+
+// ZipResponse streams a ZIP archive to the client. Entry sizes and checksums
+// are written in data descriptors following each entry, so no part of the
+// archive is buffered in memory. Archives are limited to 4GB and 65535
+// entries.
+type ZipResponse struct {
+	w       zipCountWriter
+	entries []*zipEntry
+	current *zipEntry
+	err     os.Error
+}
+
+type zipCountWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *zipCountWriter) Write(p []byte) (int, os.Error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type zipEntry struct {
+	zr               *ZipResponse
+	name             string
+	method           uint16
+	dosTime, dosDate uint16
+	offset           int64
+	crc              hash.Hash32
+	compressedSize   int64
+	uncompressedSize int64
+	fw               io.WriteCloser
+	start            int64
+}
+
+// NewZipResponse responds to the request with status 200 and a ZIP archive
+// content type. The Content-Disposition header is set to attachment with the
+// given filename. The caller adds entries to the archive with Create and must
+// call Close when done.
+func NewZipResponse(req *Request, filename string) *ZipResponse {
+	w := req.Respond(StatusOK,
+		HeaderContentType, "application/zip",
+		HeaderContentDisposition, attachmentValue(filename))
+	return &ZipResponse{w: zipCountWriter{w: w}}
+}
+
+// Create adds an entry to the archive using the compression method ZipStore
+// or ZipDeflate. The returned writer is valid until the next call to Create
+// or Close.
+func (zr *ZipResponse) Create(name string, method int, mtime int64) (io.Writer, os.Error) {
+	if err := zr.closeEntry(); err != nil {
+		return nil, err
+	}
+	if method != ZipStore && method != ZipDeflate {
+		return nil, os.NewError("twister: unsupported zip method")
+	}
+	if len(zr.entries) >= 0xffff {
+		return nil, os.NewError("twister: too many zip entries")
+	}
+	e := &zipEntry{
+		zr:     zr,
+		name:   name,
+		method: uint16(method),
+		offset: zr.w.n,
+		crc:    crc32.NewIEEE(),
+	}
+	e.dosTime, e.dosDate = dosTime(mtime)
+
+	var h [30]byte
+	binary.LittleEndian.PutUint32(h[0:], 0x04034b50)
+	binary.LittleEndian.PutUint16(h[4:], 20)
+	binary.LittleEndian.PutUint16(h[6:], zipFlags)
+	binary.LittleEndian.PutUint16(h[8:], e.method)
+	binary.LittleEndian.PutUint16(h[10:], e.dosTime)
+	binary.LittleEndian.PutUint16(h[12:], e.dosDate)
+	// CRC and sizes are zero; the values are in the data descriptor.
+	binary.LittleEndian.PutUint16(h[26:], uint16(len(name)))
+	if err := zr.write(h[:], []byte(name)); err != nil {
+		return nil, err
+	}
+
+	e.start = zr.w.n
+	if e.method == ZipDeflate {
+		e.fw = flate.NewWriter(&zr.w, flate.DefaultCompression)
+	}
+	zr.entries = append(zr.entries, e)
+	zr.current = e
+	return e, nil
+}
+
+// Language encoding flag (UTF-8 names) and data descriptor flag.
+const zipFlags = 0x0800 | 0x0008
+
+func (e *zipEntry) Write(p []byte) (int, os.Error) {
+	if e.zr.current != e {
+		return 0, os.NewError("twister: write to closed zip entry")
+	}
+	if e.zr.err != nil {
+		return 0, e.zr.err
+	}
+	e.crc.Write(p)
+	e.uncompressedSize += int64(len(p))
+	var n int
+	if e.fw != nil {
+		n, e.zr.err = e.fw.Write(p)
+	} else {
+		n, e.zr.err = e.zr.w.Write(p)
+	}
+	return n, e.zr.err
+}
+
+func (zr *ZipResponse) write(ps ...[]byte) os.Error {
+	for _, p := range ps {
+		if zr.err != nil {
+			break
+		}
+		_, zr.err = zr.w.Write(p)
+	}
+	if zr.err == nil && zr.w.n > 0xffffffff {
+		zr.err = errZipTooLarge
+	}
+	return zr.err
+}
+
+func (zr *ZipResponse) closeEntry() os.Error {
+	e := zr.current
+	if e == nil {
+		return zr.err
+	}
+	zr.current = nil
+	if e.fw != nil && zr.err == nil {
+		zr.err = e.fw.Close()
+	}
+	e.compressedSize = zr.w.n - e.start
+	var d [16]byte
+	binary.LittleEndian.PutUint32(d[0:], 0x08074b50)
+	binary.LittleEndian.PutUint32(d[4:], e.crc.Sum32())
+	binary.LittleEndian.PutUint32(d[8:], uint32(e.compressedSize))
+	binary.LittleEndian.PutUint32(d[12:], uint32(e.uncompressedSize))
+	return zr.write(d[:])
+}
+
+// Close finishes the current entry and writes the archive's central
+// directory.
+func (zr *ZipResponse) Close() os.Error {
+	if err := zr.closeEntry(); err != nil {
+		return err
+	}
+	start := zr.w.n
+	for _, e := range zr.entries {
+		var h [46]byte
+		binary.LittleEndian.PutUint32(h[0:], 0x02014b50)
+		binary.LittleEndian.PutUint16(h[4:], 20)
+		binary.LittleEndian.PutUint16(h[6:], 20)
+		binary.LittleEndian.PutUint16(h[8:], zipFlags)
+		binary.LittleEndian.PutUint16(h[10:], e.method)
+		binary.LittleEndian.PutUint16(h[12:], e.dosTime)
+		binary.LittleEndian.PutUint16(h[14:], e.dosDate)
+		binary.LittleEndian.PutUint32(h[16:], e.crc.Sum32())
+		binary.LittleEndian.PutUint32(h[20:], uint32(e.compressedSize))
+		binary.LittleEndian.PutUint32(h[24:], uint32(e.uncompressedSize))
+		binary.LittleEndian.PutUint16(h[28:], uint16(len(e.name)))
+		binary.LittleEndian.PutUint32(h[42:], uint32(e.offset))
+		if err := zr.write(h[:], []byte(e.name)); err != nil {
+			return err
+		}
+	}
+	var end [22]byte
+	binary.LittleEndian.PutUint32(end[0:], 0x06054b50)
+	binary.LittleEndian.PutUint16(end[8:], uint16(len(zr.entries)))
+	binary.LittleEndian.PutUint16(end[10:], uint16(len(zr.entries)))
+	binary.LittleEndian.PutUint32(end[12:], uint32(zr.w.n-start))
+	binary.LittleEndian.PutUint32(end[16:], uint32(start))
+	return zr.write(end[:])
+}
+
+// dosTime converts seconds since the epoch to MS-DOS time and date.
+func dosTime(sec int64) (uint16, uint16) {
+	t := time.SecondsToUTC(sec)
+	if t.Year < 1980 {
+		return 0, 1<<5 | 1
+	}
+	return uint16(t.Hour<<11 | t.Minute<<5 | t.Second>>1),
+		uint16(int(t.Year-1980)<<9 | t.Month<<5 | t.Day)
+}
@@ -0,0 +1,81 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var zipEntries = []struct {
+	name   string
+	method int
+	body   string
+}{
+	{"a.txt", ZipStore, "hello"},
+	{"dir/b.txt", ZipDeflate, strings.Repeat("world ", 1000)},
+	{"empty", ZipDeflate, ""},
+}
+
+func TestZipResponse(t *testing.T) {
+	status, header, p := RunHandler("/", "GET", nil, nil, HandlerFunc(func(req *Request) {
+		zr := NewZipResponse(req, "files.zip")
+		for _, e := range zipEntries {
+			w, err := zr.Create(e.name, e.method, 1300000000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, e.body)
+		}
+		if err := zr.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	if status != StatusOK {
+		t.Fatalf("status=%d", status)
+	}
+	if cd := header.Get(HeaderContentDisposition); cd != `attachment; filename="files.zip"` {
+		t.Errorf("content-disposition=%q", cd)
+	}
+	r, err := zip.NewReader(bytesReaderAt(p), int64(len(p)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != len(zipEntries) {
+		t.Fatalf("len(files)=%d, want %d", len(r.File), len(zipEntries))
+	}
+	for i, f := range r.File {
+		e := zipEntries[i]
+		if f.Name != e.name {
+			t.Errorf("name=%q, want %q", f.Name, e.name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+		}
+		if string(b) != e.body {
+			t.Errorf("%s: body mismatch", e.name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package webdav
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// FileSystem is the storage backend for a WebDAV handler. Names passed to the
+// methods are slash separated, start with '/' and are cleaned with
+// path.Clean.
+type FileSystem interface {
+	// Stat returns information about the named file or directory.
+	Stat(name string) (*os.FileInfo, os.Error)
+
+	// ReadDir returns the entries in the named directory.
+	ReadDir(name string) ([]os.FileInfo, os.Error)
+
+	// Open opens the named file for reading.
+	Open(name string) (io.ReadCloser, os.Error)
+
+	// Create creates or truncates the named file for writing.
+	Create(name string) (io.WriteCloser, os.Error)
+
+	// Mkdir creates the named directory. The parent directory must exist.
+	Mkdir(name string) os.Error
+
+	// RemoveAll removes the named file or directory and any children.
+	RemoveAll(name string) os.Error
+
+	// Rename moves oldName to newName.
+	Rename(oldName, newName string) os.Error
+}
+
+// Dir implements FileSystem using the native file system restricted to a
+// specific directory tree.
+type Dir string
+
+func (d Dir) resolve(name string) string {
+	name = path.Clean("/" + name)
+	dir := string(d)
+	if dir == "" {
+		dir = "."
+	}
+	return path.Join(dir, name)
+}
+
+func (d Dir) Stat(name string) (*os.FileInfo, os.Error) {
+	return os.Stat(d.resolve(name))
+}
+
+func (d Dir) ReadDir(name string) ([]os.FileInfo, os.Error) {
+	f, err := os.Open(d.resolve(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+func (d Dir) Open(name string) (io.ReadCloser, os.Error) {
+	return os.Open(d.resolve(name))
+}
+
+func (d Dir) Create(name string) (io.WriteCloser, os.Error) {
+	return os.Create(d.resolve(name))
+}
+
+func (d Dir) Mkdir(name string) os.Error {
+	return os.Mkdir(d.resolve(name), 0755)
+}
+
+func (d Dir) RemoveAll(name string) os.Error {
+	if path.Clean("/"+name) == "/" {
+		return os.NewError("twister.webdav: cannot remove root")
+	}
+	return os.RemoveAll(d.resolve(name))
+}
+
+func (d Dir) Rename(oldName, newName string) os.Error {
+	return os.Rename(d.resolve(oldName), d.resolve(newName))
+}
+
+// copyAll copies src to dst in fs. If depthInfinity is false, then only the
+// collection itself is copied, not its members.
+func copyAll(fs FileSystem, src, dst string, depthInfinity bool) os.Error {
+	if strings.HasPrefix(dst+"/", src+"/") {
+		return os.NewError("twister.webdav: cannot copy collection into itself")
+	}
+	info, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDirectory() {
+		r, err := fs.Open(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		w, err := fs.Create(dst)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	if err := fs.Mkdir(dst); err != nil {
+		return err
+	}
+	if !depthInfinity {
+		return nil
+	}
+	infos, err := fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := copyAll(fs, path.Join(src, info.Name), path.Join(dst, info.Name), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,539 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package webdav implements a WebDAV (RFC 4918) server handler on top of a
+// pluggable FileSystem.
+//
+// The handler is mounted on a router prefix. The prefix is stripped from the
+// request path to find the resource name:
+//
+//  h := webdav.NewHandler("/dav", webdav.Dir("/var/dav"))
+//  r.Register("/dav<:(/.*)?>", "*", h)
+//
+// Locks are advisory. The LOCK and UNLOCK methods are implemented so that
+// clients requiring DAV class 2 can mount the file system, but the handler
+// does not prevent writes to locked resources. A LOCK request for a resource
+// with an active lock fails with status 423. Locks expire after the
+// timeout requested by the client, limited to one hour. A client can refresh
+// a lock by sending a LOCK request with the lock token in the If header.
+package webdav
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerDAV         = "Dav"
+	headerDepth       = "Depth"
+	headerDestination = "Destination"
+	headerIf          = "If"
+	headerLockToken   = "Lock-Token"
+	headerOverwrite   = "Overwrite"
+	headerTimeout     = "Timeout"
+)
+
+const (
+	// defaultLockTimeout is the lock timeout in seconds used when the
+	// client does not request a timeout or requests an infinite timeout.
+	defaultLockTimeout = 3600
+
+	// maxLockTimeout is the maximum lock timeout in seconds.
+	maxLockTimeout = 3600
+)
+
+const allowedMethods = "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, COPY, MOVE, PROPFIND, LOCK, UNLOCK"
+
+// Handler serves WebDAV requests.
+type Handler struct {
+	prefix string
+	fs     FileSystem
+
+	mu        sync.Mutex
+	locks     map[string]*lock  // token -> lock
+	names     map[string]string // resource name -> token
+	nextSweep int64
+}
+
+type lock struct {
+	name    string
+	expires int64
+}
+
+// NewHandler returns a handler that serves the file system fs. The prefix is
+// the path where the handler is mounted.
+func NewHandler(prefix string, fs FileSystem) *Handler {
+	return &Handler{
+		prefix: strings.TrimRight(prefix, "/"),
+		fs:     fs,
+		locks:  make(map[string]*lock),
+		names:  make(map[string]string),
+	}
+}
+
+// resourceName returns the resource name for the URL path p.
+func (h *Handler) resourceName(p string) (string, bool) {
+	if !strings.HasPrefix(p, h.prefix) {
+		return "", false
+	}
+	p = p[len(h.prefix):]
+	if p != "" && p[0] != '/' {
+		return "", false
+	}
+	return path.Clean("/" + p), true
+}
+
+// href returns the URL path for the resource name.
+func (h *Handler) href(name string, isDir bool) string {
+	s := h.prefix + name
+	if isDir && !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return (&http.URL{Path: s}).String()
+}
+
+// ServeWeb dispatches the request using the request method.
+func (h *Handler) ServeWeb(req *web.Request) {
+	name, ok := h.resourceName(req.URL.Path)
+	if !ok {
+		req.Error(web.StatusNotFound, os.NewError("twister.webdav: path outside of prefix"))
+		return
+	}
+	switch req.Method {
+	case "OPTIONS":
+		req.Respond(web.StatusOK,
+			web.HeaderAllow, allowedMethods,
+			headerDAV, "1, 2",
+			web.HeaderContentLength, "0")
+	case "GET", "HEAD":
+		h.serveGet(req, name)
+	case "PUT":
+		h.servePut(req, name)
+	case "DELETE":
+		h.serveDelete(req, name)
+	case "MKCOL":
+		h.serveMkcol(req, name)
+	case "COPY", "MOVE":
+		h.serveCopyMove(req, name)
+	case "PROPFIND":
+		h.servePropfind(req, name)
+	case "LOCK":
+		h.serveLock(req, name)
+	case "UNLOCK":
+		h.serveUnlock(req, name)
+	default:
+		req.Error(web.StatusMethodNotAllowed, nil, web.HeaderAllow, allowedMethods)
+	}
+}
+
+func (h *Handler) serveGet(req *web.Request, name string) {
+	info, err := h.fs.Stat(name)
+	if err != nil {
+		req.Error(web.StatusNotFound, err)
+		return
+	}
+	if info.IsDirectory() {
+		req.Error(web.StatusMethodNotAllowed, nil, web.HeaderAllow, allowedMethods)
+		return
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		req.Error(web.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+	header := web.NewHeader(
+		web.HeaderContentLength, strconv.Itoa64(info.Size),
+		web.HeaderLastModified, lastModified(info),
+		web.HeaderETag, etag(info))
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set(web.HeaderContentType, contentType)
+	}
+	w := req.Responder.Respond(web.StatusOK, header)
+	if req.Method != "HEAD" {
+		io.Copy(w, f)
+	}
+}
+
+func (h *Handler) servePut(req *web.Request, name string) {
+	if parent, err := h.fs.Stat(path.Dir(name)); err != nil || !parent.IsDirectory() {
+		req.Error(web.StatusConflict, err)
+		return
+	}
+	status := web.StatusCreated
+	var currentEtag string
+	var modtime int64
+	if info, err := h.fs.Stat(name); err == nil {
+		if info.IsDirectory() {
+			req.Error(web.StatusMethodNotAllowed, nil, web.HeaderAllow, allowedMethods)
+			return
+		}
+		status = web.StatusNoContent
+		currentEtag = web.UnquoteHeaderValue(etag(info))
+		modtime = info.Mtime_ns / 1e9
+	}
+	if !web.CheckWritePreconditions(req, currentEtag, modtime, false) {
+		return
+	}
+	w, err := h.fs.Create(name)
+	if err != nil {
+		req.Error(web.StatusForbidden, err)
+		return
+	}
+	_, err = io.Copy(w, req.Body)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	req.Respond(status, web.HeaderContentLength, "0")
+}
+
+func (h *Handler) serveDelete(req *web.Request, name string) {
+	info, err := h.fs.Stat(name)
+	if err != nil {
+		req.Error(web.StatusNotFound, err)
+		return
+	}
+	if !web.CheckWritePreconditions(req, web.UnquoteHeaderValue(etag(info)), info.Mtime_ns/1e9, false) {
+		return
+	}
+	if err := h.fs.RemoveAll(name); err != nil {
+		req.Error(web.StatusForbidden, err)
+		return
+	}
+	req.Respond(web.StatusNoContent)
+}
+
+func (h *Handler) serveMkcol(req *web.Request, name string) {
+	if req.ContentLength > 0 {
+		req.Error(web.StatusUnsupportedMediaType, nil)
+		return
+	}
+	if _, err := h.fs.Stat(name); err == nil {
+		req.Error(web.StatusMethodNotAllowed, nil, web.HeaderAllow, allowedMethods)
+		return
+	}
+	if parent, err := h.fs.Stat(path.Dir(name)); err != nil || !parent.IsDirectory() {
+		req.Error(web.StatusConflict, err)
+		return
+	}
+	if err := h.fs.Mkdir(name); err != nil {
+		req.Error(web.StatusForbidden, err)
+		return
+	}
+	req.Respond(web.StatusCreated, web.HeaderContentLength, "0")
+}
+
+func (h *Handler) serveCopyMove(req *web.Request, name string) {
+	u, err := http.ParseURL(req.Header.Get(headerDestination))
+	if err != nil || u.Path == "" {
+		req.Error(web.StatusBadRequest, os.NewError("twister.webdav: bad destination"))
+		return
+	}
+	if u.Host != "" && strings.ToLower(u.Host) != strings.ToLower(req.URL.Host) {
+		req.Error(web.StatusBadGateway, os.NewError("twister.webdav: destination on another server"))
+		return
+	}
+	dst, ok := h.resourceName(u.Path)
+	if !ok {
+		req.Error(web.StatusBadGateway, os.NewError("twister.webdav: destination outside of prefix"))
+		return
+	}
+	if dst == name {
+		req.Error(web.StatusForbidden, os.NewError("twister.webdav: source and destination are the same"))
+		return
+	}
+	// Check before removing the destination so that an overlapping request
+	// does not delete the source.
+	if isAncestor(dst, name) || isAncestor(name, dst) {
+		req.Error(web.StatusForbidden, os.NewError("twister.webdav: source and destination overlap"))
+		return
+	}
+	if _, err := h.fs.Stat(name); err != nil {
+		req.Error(web.StatusNotFound, err)
+		return
+	}
+	if parent, err := h.fs.Stat(path.Dir(dst)); err != nil || !parent.IsDirectory() {
+		req.Error(web.StatusConflict, err)
+		return
+	}
+	status := web.StatusCreated
+	if _, err := h.fs.Stat(dst); err == nil {
+		if req.Header.Get(headerOverwrite) == "F" {
+			req.Error(web.StatusPreconditionFailed, nil)
+			return
+		}
+		if err := h.fs.RemoveAll(dst); err != nil {
+			req.Error(web.StatusForbidden, err)
+			return
+		}
+		status = web.StatusNoContent
+	}
+	if req.Method == "MOVE" {
+		err = h.fs.Rename(name, dst)
+	} else {
+		err = copyAll(h.fs, name, dst, req.Header.Get(headerDepth) != "0")
+	}
+	if err != nil {
+		req.Error(web.StatusForbidden, err)
+		return
+	}
+	req.Respond(status, web.HeaderContentLength, "0")
+}
+
+// isAncestor returns true if the resource named a contains the resource named
+// b.
+func isAncestor(a, b string) bool {
+	return a == "/" || strings.HasPrefix(b, a+"/")
+}
+
+func (h *Handler) servePropfind(req *web.Request, name string) {
+	info, err := h.fs.Stat(name)
+	if err != nil {
+		req.Error(web.StatusNotFound, err)
+		return
+	}
+
+	// Only allprop is supported. The request body is ignored.
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<D:multistatus xmlns:D="DAV:">` + "\n")
+	h.writeResponse(&b, name, info)
+	if info.IsDirectory() && req.Header.Get(headerDepth) != "0" {
+		infos, err := h.fs.ReadDir(name)
+		if err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		for i := range infos {
+			h.writeResponse(&b, path.Join(name, infos[i].Name), &infos[i])
+		}
+	}
+	b.WriteString("</D:multistatus>\n")
+
+	w := req.Respond(web.StatusMultiStatus,
+		web.HeaderContentType, "application/xml; charset=utf-8",
+		web.HeaderContentLength, strconv.Itoa(b.Len()))
+	w.Write(b.Bytes())
+}
+
+func (h *Handler) writeResponse(b *bytes.Buffer, name string, info *os.FileInfo) {
+	fmt.Fprintf(b, "<D:response><D:href>%s</D:href><D:propstat><D:prop>", xmlEscape(h.href(name, info.IsDirectory())))
+	fmt.Fprintf(b, "<D:displayname>%s</D:displayname>", xmlEscape(path.Base(name)))
+	fmt.Fprintf(b, "<D:getlastmodified>%s</D:getlastmodified>", lastModified(info))
+	if info.IsDirectory() {
+		b.WriteString("<D:resourcetype><D:collection/></D:resourcetype>")
+	} else {
+		b.WriteString("<D:resourcetype/>")
+		fmt.Fprintf(b, "<D:getcontentlength>%d</D:getcontentlength>", info.Size)
+		fmt.Fprintf(b, "<D:getetag>%s</D:getetag>", xmlEscape(etag(info)))
+		if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+			fmt.Fprintf(b, "<D:getcontenttype>%s</D:getcontenttype>", xmlEscape(contentType))
+		}
+	}
+	b.WriteString("<D:supportedlock><D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry></D:supportedlock>")
+	b.WriteString("</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>\n")
+}
+
+// lockTimeout returns the timeout in seconds for the Timeout header value s.
+func lockTimeout(s string) int64 {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if !strings.HasPrefix(v, "Second-") {
+			continue
+		}
+		n, err := strconv.Atoi64(v[len("Second-"):])
+		if err != nil || n <= 0 {
+			continue
+		}
+		if n > maxLockTimeout {
+			n = maxLockTimeout
+		}
+		return n
+	}
+	return defaultLockTimeout
+}
+
+// ifToken returns the first lock token in the If header value s.
+func ifToken(s string) string {
+	i := strings.Index(s, "<opaquelocktoken:")
+	if i < 0 {
+		return ""
+	}
+	s = s[i+1:]
+	i = strings.Index(s, ">")
+	if i < 0 {
+		return ""
+	}
+	return s[:i]
+}
+
+// findLock returns the unexpired lock for token. The caller must hold h.mu.
+func (h *Handler) findLock(token string, now int64) *lock {
+	l := h.locks[token]
+	if l == nil {
+		return nil
+	}
+	if now >= l.expires {
+		h.removeLock(token, l)
+		return nil
+	}
+	return l
+}
+
+// removeLock removes the lock for token. The caller must hold h.mu.
+func (h *Handler) removeLock(token string, l *lock) {
+	h.locks[token] = nil, false
+	h.names[l.name] = "", false
+}
+
+// sweepLocks removes expired locks at most once a minute. The caller must
+// hold h.mu.
+func (h *Handler) sweepLocks(now int64) {
+	if now < h.nextSweep {
+		return
+	}
+	h.nextSweep = now + 60
+	for token, l := range h.locks {
+		if now >= l.expires {
+			h.removeLock(token, l)
+		}
+	}
+}
+
+func (h *Handler) serveLock(req *web.Request, name string) {
+	timeout := lockTimeout(req.Header.Get(headerTimeout))
+	now := web.Seconds()
+
+	// A LOCK request without a body refreshes the lock in the If header.
+	if req.ContentLength <= 0 {
+		if token := ifToken(req.Header.Get(headerIf)); token != "" {
+			h.mu.Lock()
+			l := h.findLock(token, now)
+			if l != nil && l.name == name {
+				l.expires = now + timeout
+			}
+			h.mu.Unlock()
+			if l == nil || l.name != name {
+				req.Error(web.StatusPreconditionFailed, os.NewError("twister.webdav: lock token not found"))
+				return
+			}
+			h.respondLock(req, name, token, timeout)
+			return
+		}
+	}
+
+	p := make([]byte, 16)
+	if err := web.ReadRandom(p); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	token := "opaquelocktoken:" + hex.EncodeToString(p)
+
+	h.mu.Lock()
+	h.sweepLocks(now)
+	locked := h.names[name] != "" && h.findLock(h.names[name], now) != nil
+	if !locked {
+		h.locks[token] = &lock{name: name, expires: now + timeout}
+		h.names[name] = token
+	}
+	h.mu.Unlock()
+	if locked {
+		req.Error(web.StatusLocked, os.NewError("twister.webdav: resource is locked"))
+		return
+	}
+
+	h.respondLock(req, name, token, timeout)
+}
+
+func (h *Handler) respondLock(req *web.Request, name string, token string, timeout int64) {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`)
+	b.WriteString("<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>")
+	fmt.Fprintf(&b, "<D:depth>%s</D:depth>", xmlEscape(depthOrInfinity(req)))
+	fmt.Fprintf(&b, "<D:timeout>Second-%d</D:timeout>", timeout)
+	fmt.Fprintf(&b, "<D:locktoken><D:href>%s</D:href></D:locktoken>", token)
+	fmt.Fprintf(&b, "<D:lockroot><D:href>%s</D:href></D:lockroot>", xmlEscape(h.href(name, false)))
+	b.WriteString("</D:activelock></D:lockdiscovery></D:prop>\n")
+
+	w := req.Respond(web.StatusOK,
+		web.HeaderContentType, "application/xml; charset=utf-8",
+		web.HeaderContentLength, strconv.Itoa(b.Len()),
+		headerLockToken, "<"+token+">")
+	w.Write(b.Bytes())
+}
+
+func (h *Handler) serveUnlock(req *web.Request, name string) {
+	token := strings.Trim(req.Header.Get(headerLockToken), "<>")
+	h.mu.Lock()
+	l := h.findLock(token, web.Seconds())
+	if l != nil && l.name == name {
+		h.removeLock(token, l)
+	}
+	h.mu.Unlock()
+	if l == nil || l.name != name {
+		req.Error(web.StatusConflict, os.NewError("twister.webdav: lock token not found"))
+		return
+	}
+	req.Respond(web.StatusNoContent)
+}
+
+func depthOrInfinity(req *web.Request) string {
+	if req.Header.Get(headerDepth) == "0" {
+		return "0"
+	}
+	return "infinity"
+}
+
+func lastModified(info *os.FileInfo) string {
+	return time.SecondsToUTC(info.Mtime_ns / 1e9).Format(web.TimeLayout)
+}
+
+func etag(info *os.FileInfo) string {
+	return web.QuoteHeaderValue(strconv.Itob64(info.Mtime_ns, 36))
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '&':
+			b.WriteString("&amp;")
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '"':
+			b.WriteString("&quot;")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package webdav
+
+import (
+	"github.com/garyburd/twister/web"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var webdavTests = []struct {
+	method string
+	url    string
+	header web.Header
+	body   string
+	status int
+}{
+	{"GET", "/dav/a.txt", nil, "", web.StatusNotFound},
+	{"PUT", "/dav/a.txt", nil, "hello", web.StatusCreated},
+	{"PUT", "/dav/a.txt", nil, "world", web.StatusNoContent},
+	{"PUT", "/dav/a.txt", web.NewHeader(web.HeaderIfNoneMatch, "*"), "again", web.StatusPreconditionFailed},
+	{"PUT", "/dav/a.txt", web.NewHeader(web.HeaderIfMatch, `"stale"`), "again", web.StatusPreconditionFailed},
+	{"PUT", "/dav/c.txt", web.NewHeader(web.HeaderIfNoneMatch, "*"), "new", web.StatusCreated},
+	{"GET", "/dav/a.txt", nil, "", web.StatusOK},
+	{"PUT", "/dav/x/a.txt", nil, "hello", web.StatusConflict},
+	{"MKCOL", "/dav/x", nil, "", web.StatusCreated},
+	{"MKCOL", "/dav/x", nil, "", web.StatusMethodNotAllowed},
+	{"COPY", "/dav/a.txt", web.NewHeader("Destination", "http://example.com/dav/x/b.txt"), "", web.StatusCreated},
+	{"MOVE", "/dav/a.txt", web.NewHeader("Destination", "/dav/x/b.txt", "Overwrite", "F"), "", web.StatusPreconditionFailed},
+	{"MOVE", "/dav/a.txt", web.NewHeader("Destination", "/dav/x/b.txt"), "", web.StatusNoContent},
+	{"GET", "/dav/a.txt", nil, "", web.StatusNotFound},
+	{"MOVE", "/dav/x/b.txt", web.NewHeader("Destination", "/dav/x"), "", web.StatusForbidden},
+	{"COPY", "/dav/x/b.txt", web.NewHeader("Destination", "/dav/x"), "", web.StatusForbidden},
+	{"COPY", "/dav/x", web.NewHeader("Destination", "/dav/x/b.txt"), "", web.StatusForbidden},
+	{"COPY", "/dav/x", web.NewHeader("Destination", "/dav/"), "", web.StatusForbidden},
+	{"GET", "/dav/x/b.txt", nil, "", web.StatusOK},
+	{"PROPFIND", "/dav/x", web.NewHeader("Depth", "1"), "", web.StatusMultiStatus},
+	{"DELETE", "/dav/x", nil, "", web.StatusNoContent},
+	{"DELETE", "/dav/x", nil, "", web.StatusNotFound},
+	{"GET", "/other", nil, "", web.StatusNotFound},
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webdav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := NewHandler("/dav", Dir(dir))
+	for _, tt := range webdavTests {
+		var body []byte
+		header := web.Header{}
+		for k, v := range tt.header {
+			header[k] = v
+		}
+		if tt.body != "" {
+			body = []byte(tt.body)
+			header.Set(web.HeaderContentLength, strconv.Itoa(len(body)))
+		}
+		status, _, respBody := web.RunHandler("http://example.com"+tt.url, tt.method, header, body, h)
+		if status != tt.status {
+			t.Errorf("%s %s status=%d, want %d", tt.method, tt.url, status, tt.status)
+		}
+		if tt.method == "PROPFIND" && status == web.StatusMultiStatus {
+			if !strings.Contains(string(respBody), "<D:href>/dav/x/b.txt</D:href>") {
+				t.Errorf("%s %s body=%q, want href for b.txt", tt.method, tt.url, respBody)
+			}
+		}
+	}
+}
+
+func lockToken(header web.Header) string {
+	return strings.Trim(header.Get("Lock-Token"), "<>")
+}
+
+func TestLockExpiration(t *testing.T) {
+	clock := web.NewFakeClock(1000e9)
+	defer web.SetClock(web.SetClock(clock))
+
+	h := NewHandler("/dav", Dir(os.TempDir()))
+	const url = "http://example.com/dav/a.txt"
+
+	status, header, body := web.RunHandler(url, "LOCK", web.NewHeader("Timeout", "Second-60"), nil, h)
+	if status != web.StatusOK {
+		t.Fatalf("LOCK status=%d, want %d", status, web.StatusOK)
+	}
+	if !strings.Contains(string(body), "<D:timeout>Second-60</D:timeout>") {
+		t.Errorf("LOCK body=%q, want timeout Second-60", body)
+	}
+	token := lockToken(header)
+
+	status, _, _ = web.RunHandler(url, "LOCK", nil, nil, h)
+	if status != web.StatusLocked {
+		t.Errorf("LOCK locked resource status=%d, want %d", status, web.StatusLocked)
+	}
+
+	status, _, body = web.RunHandler("http://example.com/dav/b.txt", "LOCK", web.NewHeader("Timeout", "Infinite"), nil, h)
+	if !strings.Contains(string(body), "<D:timeout>Second-3600</D:timeout>") {
+		t.Errorf("LOCK Infinite body=%q, want timeout Second-3600", body)
+	}
+
+	// Refresh the lock before it expires.
+	clock.Advance(50e9)
+	status, header, _ = web.RunHandler(url, "LOCK", web.NewHeader("Timeout", "Second-60", "If", "(<"+token+">)"), nil, h)
+	if status != web.StatusOK || lockToken(header) != token {
+		t.Errorf("refresh status=%d token=%q, want %d %q", status, lockToken(header), web.StatusOK, token)
+	}
+
+	clock.Advance(50e9)
+	status, _, _ = web.RunHandler(url, "UNLOCK", web.NewHeader("Lock-Token", "<"+token+">"), nil, h)
+	if status != web.StatusNoContent {
+		t.Errorf("UNLOCK status=%d, want %d", status, web.StatusNoContent)
+	}
+
+	status, header, _ = web.RunHandler(url, "LOCK", web.NewHeader("Timeout", "Second-60"), nil, h)
+	token = lockToken(header)
+	clock.Advance(61e9)
+	status, _, _ = web.RunHandler(url, "UNLOCK", web.NewHeader("Lock-Token", "<"+token+">"), nil, h)
+	if status != web.StatusConflict {
+		t.Errorf("UNLOCK expired status=%d, want %d", status, web.StatusConflict)
+	}
+	status, _, _ = web.RunHandler(url, "LOCK", web.NewHeader("If", "(<"+token+">)"), nil, h)
+	if status != web.StatusPreconditionFailed {
+		t.Errorf("refresh expired status=%d, want %d", status, web.StatusPreconditionFailed)
+	}
+	status, _, _ = web.RunHandler(url, "LOCK", nil, nil, h)
+	if status != web.StatusOK {
+		t.Errorf("LOCK after expiration status=%d, want %d", status, web.StatusOK)
+	}
+}
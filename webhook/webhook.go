@@ -0,0 +1,380 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package webhook delivers events to HTTP endpoints.
+//
+// Events are queued in memory and POSTed as JSON with an HMAC-SHA256
+// signature header. Failed deliveries are retried with exponential backoff.
+// The number of pending deliveries is bounded; Send drops events when the
+// limit is reached.
+// The dispatcher keeps a history of deliveries that can be inspected and
+// retried through an admin handler:
+//
+//  d := webhook.NewDispatcher(&webhook.Options{Secret: secret})
+//  d.Send("https://example.com/hooks", "order.created", order)
+//  r.Register("/admin/webhooks", "GET", d, "POST", d)
+package webhook
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/client"
+	"github.com/garyburd/twister/web"
+	"http"
+	"json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Send when the maximum number of pending
+// deliveries is reached.
+var ErrQueueFull = os.NewError("twister.webhook: queue full")
+
+// State is the state of a delivery.
+type State int
+
+const (
+	// Pending deliveries are queued or waiting for a retry.
+	Pending State = iota
+
+	// Delivered deliveries received a 2xx response.
+	Delivered
+
+	// Failed deliveries exhausted all attempts.
+	Failed
+)
+
+var stateNames = []string{"pending", "delivered", "failed"}
+
+func (s State) String() string { return stateNames[s] }
+
+// Attempt records one attempt to deliver an event.
+type Attempt struct {
+	// Time of the attempt in seconds since the epoch.
+	Time int64
+
+	// Response status or zero if the request failed.
+	Status int
+
+	// Error message or "" if the request completed.
+	Error string
+}
+
+// Delivery is an event delivery to an endpoint.
+type Delivery struct {
+	ID       string
+	URL      string
+	Event    string
+	Payload  []byte
+	State    State
+	Created  int64
+	Attempts []Attempt
+}
+
+// Options configures a Dispatcher.
+type Options struct {
+	// Secret used to sign payloads. If nil, payloads are not signed.
+	Secret []byte
+
+	// Name of the signature header. The default is "X-Webhook-Signature".
+	// The header value is "sha256=" followed by the hex encoded HMAC-SHA256
+	// of the payload.
+	SignatureHeader string
+
+	// Maximum number of attempts per delivery. The default is 8.
+	MaxAttempts int
+
+	// Delay in seconds before the first retry. The delay doubles with each
+	// retry. The default is 10.
+	Backoff int64
+
+	// Maximum time in seconds for each attempt. The default is 30.
+	Timeout int64
+
+	// Maximum number of pending deliveries, including deliveries waiting for
+	// a retry. The default is 1000.
+	MaxPending int
+
+	// Number of concurrent deliveries. The default is 4.
+	Workers int
+
+	// Number of completed deliveries kept in the history. The default is
+	// 1000.
+	History int
+
+	// Transport used to send requests. The default is http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// Dispatcher queues and delivers events.
+type Dispatcher struct {
+	options Options
+	client  *http.Client
+	queue   chan *Delivery
+	done    chan bool
+
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+	completed  []string
+	pending    int
+	dropped    int64
+}
+
+// NewDispatcher creates a dispatcher and starts its workers.
+func NewDispatcher(options *Options) *Dispatcher {
+	d := &Dispatcher{
+		options:    *options,
+		done:       make(chan bool),
+		deliveries: make(map[string]*Delivery),
+	}
+	if d.options.SignatureHeader == "" {
+		d.options.SignatureHeader = "X-Webhook-Signature"
+	}
+	if d.options.MaxAttempts <= 0 {
+		d.options.MaxAttempts = 8
+	}
+	if d.options.Backoff <= 0 {
+		d.options.Backoff = 10
+	}
+	if d.options.Timeout <= 0 {
+		d.options.Timeout = 30
+	}
+	if d.options.MaxPending <= 0 {
+		d.options.MaxPending = 1000
+	}
+	// The queue holds all pending deliveries so that enqueue does not block.
+	d.queue = make(chan *Delivery, d.options.MaxPending)
+	if d.options.Workers <= 0 {
+		d.options.Workers = 4
+	}
+	if d.options.History <= 0 {
+		d.options.History = 1000
+	}
+	t := &client.Transport{Transport: d.options.Transport, Timeout: d.options.Timeout * 1e9}
+	if d.options.Secret != nil {
+		t.Signers = []client.Signer{client.HMACSigner(d.options.SignatureHeader, d.options.Secret)}
+	}
+	d.client = &http.Client{Transport: t}
+	for i := 0; i < d.options.Workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Close stops the workers. Pending deliveries are abandoned.
+func (d *Dispatcher) Close() {
+	close(d.done)
+}
+
+func newID() string {
+	return web.RandomHex(8)
+}
+
+// Send queues delivery of the JSON encoding of v to url. The ID of the
+// delivery is returned. Send does not block. If the maximum number of pending
+// deliveries is reached, then the event is dropped and Send returns
+// ErrQueueFull.
+func (d *Dispatcher) Send(url, event string, v interface{}) (string, os.Error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	del := &Delivery{
+		ID:      newID(),
+		URL:     url,
+		Event:   event,
+		Payload: payload,
+		Created: web.Seconds(),
+	}
+	d.mu.Lock()
+	if d.pending >= d.options.MaxPending {
+		d.dropped += 1
+		d.mu.Unlock()
+		return "", ErrQueueFull
+	}
+	d.pending += 1
+	d.deliveries[del.ID] = del
+	d.mu.Unlock()
+	d.enqueue(del)
+	return del.ID, nil
+}
+
+func (d *Dispatcher) enqueue(del *Delivery) {
+	select {
+	case d.queue <- del:
+	case <-d.done:
+	}
+}
+
+func (d *Dispatcher) work() {
+	for {
+		select {
+		case del := <-d.queue:
+			d.deliver(del)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// deliver makes one attempt to deliver del and schedules a retry on failure.
+func (d *Dispatcher) deliver(del *Delivery) {
+	req, err := http.NewRequest("POST", del.URL, bytes.NewBuffer(del.Payload))
+	var attempt Attempt
+	attempt.Time = web.Seconds()
+	if err == nil {
+		req.Header.Set(web.HeaderContentType, "application/json")
+		req.Header.Set("X-Webhook-Event", del.Event)
+		req.Header.Set("X-Webhook-Delivery", del.ID)
+		var resp *http.Response
+		resp, err = d.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			attempt.Status = resp.StatusCode
+		}
+	}
+	if err != nil {
+		attempt.Error = err.String()
+	}
+
+	d.mu.Lock()
+	del.Attempts = append(del.Attempts, attempt)
+	n := len(del.Attempts)
+	switch {
+	case attempt.Status >= 200 && attempt.Status < 300:
+		del.State = Delivered
+		d.complete(del)
+	case n >= d.options.MaxAttempts:
+		del.State = Failed
+		d.complete(del)
+	}
+	state := del.State
+	d.mu.Unlock()
+
+	if state == Pending {
+		delay := d.options.Backoff << uint(n-1)
+		time.AfterFunc(delay*1e9, func() { d.enqueue(del) })
+	}
+}
+
+// complete adds del to the history and removes the oldest completed
+// deliveries. The caller must hold d.mu.
+func (d *Dispatcher) complete(del *Delivery) {
+	d.pending -= 1
+	d.completed = append(d.completed, del.ID)
+	for len(d.completed) > d.options.History {
+		d.deliveries[d.completed[0]] = nil, false
+		d.completed = d.completed[1:]
+	}
+}
+
+// Retry queues another attempt for a failed delivery.
+func (d *Dispatcher) Retry(id string) os.Error {
+	d.mu.Lock()
+	del := d.deliveries[id]
+	if del == nil || del.State != Failed {
+		d.mu.Unlock()
+		return os.NewError("twister.webhook: no failed delivery with id " + id)
+	}
+	if d.pending >= d.options.MaxPending {
+		d.mu.Unlock()
+		return ErrQueueFull
+	}
+	d.pending += 1
+	del.State = Pending
+	del.Attempts = nil
+	for i, s := range d.completed {
+		if s == id {
+			d.completed = append(d.completed[:i], d.completed[i+1:]...)
+			break
+		}
+	}
+	d.mu.Unlock()
+	d.enqueue(del)
+	return nil
+}
+
+type deliveriesByCreated []*Delivery
+
+func (p deliveriesByCreated) Len() int           { return len(p) }
+func (p deliveriesByCreated) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p deliveriesByCreated) Less(i, j int) bool { return p[i].Created > p[j].Created }
+
+// Dropped returns the number of events dropped by Send because the maximum
+// number of pending deliveries was reached.
+func (d *Dispatcher) Dropped() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dropped
+}
+
+// Deliveries returns copies of the deliveries in the history, newest first.
+func (d *Dispatcher) Deliveries() []*Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make([]*Delivery, 0, len(d.deliveries))
+	for _, del := range d.deliveries {
+		c := *del
+		c.Attempts = append([]Attempt(nil), del.Attempts...)
+		result = append(result, &c)
+	}
+	sort.Sort(deliveriesByCreated(result))
+	return result
+}
+
+// ServeWeb implements an admin handler for the dispatcher. GET requests
+// return the delivery history as JSON. The "state" request parameter filters
+// the history by state. POST requests retry the failed delivery with the ID
+// in the "id" request parameter.
+func (d *Dispatcher) ServeWeb(req *web.Request) {
+	switch req.Method {
+	case "POST":
+		if err := req.ParseForm(1024); err != nil {
+			req.Error(web.StatusBadRequest, err)
+			return
+		}
+		if err := d.Retry(req.Param.Get("id")); err == ErrQueueFull {
+			req.Error(web.StatusServiceUnavailable, err)
+			return
+		} else if err != nil {
+			req.Error(web.StatusNotFound, err)
+			return
+		}
+		req.Respond(web.StatusNoContent)
+	default:
+		state := req.Param.Get("state")
+		var result []interface{}
+		for _, del := range d.Deliveries() {
+			if state != "" && del.State.String() != state {
+				continue
+			}
+			result = append(result, map[string]interface{}{
+				"id":       del.ID,
+				"url":      del.URL,
+				"event":    del.Event,
+				"state":    del.State.String(),
+				"created":  del.Created,
+				"attempts": del.Attempts,
+			})
+		}
+		p, err := json.Marshal(map[string]interface{}{"deliveries": result, "dropped": d.Dropped()})
+		if err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		w := req.Respond(web.StatusOK, web.HeaderContentType, "application/json; charset=utf-8")
+		w.Write(p)
+	}
+}
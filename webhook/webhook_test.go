@@ -0,0 +1,121 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"github.com/garyburd/twister/web"
+	"http"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type transportFunc func(req *http.Request) (*http.Response, os.Error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, os.Error) { return f(req) }
+
+func TestDispatcher(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	d := NewDispatcher(&Options{
+		Secret:      []byte("secret"),
+		MaxAttempts: 1,
+		Transport: transportFunc(func(req *http.Request) (*http.Response, os.Error) {
+			requests <- req
+			status := web.StatusOK
+			if strings.HasSuffix(req.URL.Path, "/fail") {
+				status = web.StatusInternalServerError
+			}
+			return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewBuffer(nil))}, nil
+		}),
+	})
+	defer d.Close()
+
+	okID, _ := d.Send("http://example.com/ok", "test.event", map[string]int{"a": 1})
+	failID, _ := d.Send("http://example.com/fail", "test.event", map[string]int{"a": 2})
+	for i := 0; i < 2; i++ {
+		req := <-requests
+		if req.Header.Get("X-Webhook-Event") != "test.event" || !strings.HasPrefix(req.Header.Get("X-Webhook-Signature"), "sha256=") {
+			t.Errorf("header = %v", req.Header)
+		}
+	}
+
+	states := map[string]State{}
+	for i := 0; i < 100; i++ {
+		for _, del := range d.Deliveries() {
+			states[del.ID] = del.State
+		}
+		if states[okID] != Pending && states[failID] != Pending {
+			break
+		}
+		time.Sleep(1e6)
+	}
+	if states[okID] != Delivered || states[failID] != Failed {
+		t.Errorf("states = %v, want ok delivered and fail failed", states)
+	}
+
+	status, _, body := web.RunHandler("http://example.com/admin?state=failed", "GET", nil, nil, d)
+	if status != web.StatusOK || !bytes.Contains(body, []byte(failID)) || bytes.Contains(body, []byte(okID)) {
+		t.Errorf("admin GET status=%d body=%s", status, body)
+	}
+
+	status, _, _ = web.RunHandler("http://example.com/admin?id="+okID, "POST", nil, nil, d)
+	if status != web.StatusNotFound {
+		t.Errorf("retry delivered status=%d, want %d", status, web.StatusNotFound)
+	}
+	status, _, _ = web.RunHandler("http://example.com/admin?id="+failID, "POST", nil, nil, d)
+	if status != web.StatusNoContent {
+		t.Errorf("retry failed status=%d, want %d", status, web.StatusNoContent)
+	}
+	<-requests
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	release := make(chan bool)
+	d := NewDispatcher(&Options{
+		MaxPending: 1,
+		Transport: transportFunc(func(req *http.Request) (*http.Response, os.Error) {
+			<-release
+			return &http.Response{StatusCode: web.StatusOK, Body: ioutil.NopCloser(bytes.NewBuffer(nil))}, nil
+		}),
+	})
+	defer d.Close()
+
+	if _, err := d.Send("http://example.com/ok", "test.event", 1); err != nil {
+		t.Fatalf("Send returned %v", err)
+	}
+	if _, err := d.Send("http://example.com/ok", "test.event", 2); err != ErrQueueFull {
+		t.Errorf("Send returned %v, want ErrQueueFull", err)
+	}
+	if n := d.Dropped(); n != 1 {
+		t.Errorf("Dropped()=%d, want 1", n)
+	}
+	release <- true
+
+	// Send accepts events again after the pending delivery completes.
+	var err os.Error
+	for i := 0; i < 100; i++ {
+		if _, err = d.Send("http://example.com/ok", "test.event", 3); err != ErrQueueFull {
+			break
+		}
+		time.Sleep(1e6)
+	}
+	if err != nil {
+		t.Errorf("Send after delivery returned %v", err)
+	}
+	close(release)
+}
@@ -126,8 +126,7 @@ func Upgrade(req *web.Request, readBufSize, writeBufSize int, header web.Header)
 		return nil, os.NewError("twister.websocket: origin missing")
 	}
 
-	connection := strings.ToLower(req.Header.Get(web.HeaderConnection))
-	if connection != "upgrade" {
+	if !req.Header.HasConnectionToken("upgrade") {
 		req.Respond(web.StatusBadRequest)
 		return nil, os.NewError("twister.websocket: connection header missing or wrong value")
 	}
@@ -0,0 +1,232 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package websocket
+
+import (
+	"sync"
+)
+
+// Hub maintains a set of connections organized into rooms and broadcasts
+// messages to the connections in a room.
+//
+// Each connection registered with the hub has a buffered send queue serviced
+// by a dedicated goroutine. A connection that does not keep up with the
+// messages sent to it or that does not complete a write within WriteTimeout is
+// evicted from the hub and closed.
+type Hub struct {
+	// SendBufferSize is the number of messages queued for a connection before
+	// the connection is considered slow and evicted. The default is 16.
+	SendBufferSize int
+
+	// WriteTimeout is the time limit in nanoseconds for writing a message to
+	// a connection. The default is 10 seconds.
+	WriteTimeout int64
+
+	// OnJoin, if not nil, is called after a connection joins a room.
+	OnJoin func(c *HubConn, room string)
+
+	// OnLeave, if not nil, is called after a connection leaves a room,
+	// including when the connection is closed or evicted.
+	OnLeave func(c *HubConn, room string)
+
+	// OnClose, if not nil, is called after a connection is removed from the
+	// hub. The evicted argument is true if the connection was removed because
+	// the connection's send queue was full or a write failed.
+	OnClose func(c *HubConn, evicted bool)
+
+	mu    sync.Mutex
+	rooms map[string]map[*HubConn]bool
+}
+
+// HubConn is a connection registered with a hub.
+type HubConn struct {
+	// The underlying connection.
+	Conn *Conn
+
+	hub    *Hub
+	send   chan []byte
+	rooms  map[string]bool
+	closed bool
+}
+
+// NewHub returns a new hub.
+func NewHub() *Hub {
+	return &Hub{rooms: make(map[string]map[*HubConn]bool)}
+}
+
+// Register adds the connection to the hub and starts the goroutine that
+// writes queued messages to the connection. The hub closes conn when the
+// HubConn is closed. Register sets the write timeout on conn.
+func (h *Hub) Register(conn *Conn) *HubConn {
+	n := h.SendBufferSize
+	if n <= 0 {
+		n = 16
+	}
+	timeout := h.WriteTimeout
+	if timeout <= 0 {
+		timeout = 10e9
+	}
+	conn.conn.SetWriteTimeout(timeout)
+	c := &HubConn{Conn: conn, hub: h, send: make(chan []byte, n), rooms: make(map[string]bool)}
+	go c.writeLoop()
+	return c
+}
+
+func (c *HubConn) writeLoop() {
+	for p := range c.send {
+		if err := c.Conn.WriteMessage(p); err != nil {
+			c.close(true)
+			// Drain the queue so that senders never block.
+			for _ = range c.send {
+			}
+			break
+		}
+	}
+	c.Conn.Close()
+}
+
+// Join adds the connection to the room.
+func (c *HubConn) Join(room string) {
+	h := c.hub
+	h.mu.Lock()
+	if c.closed || c.rooms[room] {
+		h.mu.Unlock()
+		return
+	}
+	c.rooms[room] = true
+	conns := h.rooms[room]
+	if conns == nil {
+		conns = make(map[*HubConn]bool)
+		h.rooms[room] = conns
+	}
+	conns[c] = true
+	h.mu.Unlock()
+	if h.OnJoin != nil {
+		h.OnJoin(c, room)
+	}
+}
+
+// Leave removes the connection from the room.
+func (c *HubConn) Leave(room string) {
+	h := c.hub
+	h.mu.Lock()
+	found := c.rooms[room]
+	if found {
+		h.leaveLocked(c, room)
+	}
+	h.mu.Unlock()
+	if found && h.OnLeave != nil {
+		h.OnLeave(c, room)
+	}
+}
+
+func (h *Hub) leaveLocked(c *HubConn, room string) {
+	c.rooms[room] = false, false
+	conns := h.rooms[room]
+	conns[c] = false, false
+	if len(conns) == 0 {
+		h.rooms[room] = nil, false
+	}
+}
+
+// Send queues a message for the connection. If the send queue is full, then
+// the connection is evicted and Send returns false.
+func (c *HubConn) Send(p []byte) bool {
+	c.hub.mu.Lock()
+	ok := c.sendLocked(p)
+	c.hub.mu.Unlock()
+	if !ok {
+		c.close(true)
+	}
+	return ok
+}
+
+func (c *HubConn) sendLocked(p []byte) bool {
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- p:
+		return true
+	default:
+	}
+	return false
+}
+
+// Close removes the connection from all rooms and closes the connection after
+// queued messages are written.
+func (c *HubConn) Close() {
+	c.close(false)
+}
+
+func (c *HubConn) close(evicted bool) {
+	h := c.hub
+	h.mu.Lock()
+	if c.closed {
+		h.mu.Unlock()
+		return
+	}
+	c.closed = true
+	var rooms []string
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+		h.leaveLocked(c, room)
+	}
+	close(c.send)
+	h.mu.Unlock()
+
+	if evicted {
+		// Close the connection now to unblock a pending write and to discard
+		// the queued messages.
+		c.Conn.Close()
+	}
+
+	if h.OnLeave != nil {
+		for _, room := range rooms {
+			h.OnLeave(c, room)
+		}
+	}
+	if h.OnClose != nil {
+		h.OnClose(c, evicted)
+	}
+}
+
+// Broadcast queues the message for all connections in the room. Slow
+// connections are evicted. Broadcast returns the number of connections the
+// message was queued for.
+func (h *Hub) Broadcast(room string, p []byte) int {
+	var slow []*HubConn
+	n := 0
+	h.mu.Lock()
+	for c := range h.rooms[room] {
+		if c.sendLocked(p) {
+			n += 1
+		} else {
+			slow = append(slow, c)
+		}
+	}
+	h.mu.Unlock()
+	for _, c := range slow {
+		c.close(true)
+	}
+	return n
+}
+
+// Count returns the number of connections in the room.
+func (h *Hub) Count(room string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.rooms[room])
+}
@@ -0,0 +1,157 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type hubTestAddr string
+
+func (a hubTestAddr) Network() string { return "test" }
+func (a hubTestAddr) String() string  { return string(a) }
+
+// hubTestConn is a net.Conn that records writes. If block is true, then
+// writes block until the connection is closed.
+type hubTestConn struct {
+	block        bool
+	writeTimeout int64
+	mu           sync.Mutex
+	out          bytes.Buffer
+	isClosed     bool
+	closed       chan bool
+}
+
+func newHubTestConn(block bool) (*Conn, *hubTestConn) {
+	tc := &hubTestConn{block: block, closed: make(chan bool)}
+	return &Conn{conn: tc, br: bufio.NewReader(tc), bw: bufio.NewWriter(tc)}, tc
+}
+
+func (c *hubTestConn) Read(p []byte) (int, os.Error) {
+	<-c.closed
+	return 0, os.EOF
+}
+
+func (c *hubTestConn) Write(p []byte) (int, os.Error) {
+	if c.block {
+		<-c.closed
+		return 0, os.EPIPE
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.Write(p)
+}
+
+func (c *hubTestConn) Close() os.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.isClosed {
+		c.isClosed = true
+		close(c.closed)
+	}
+	return nil
+}
+
+func (c *hubTestConn) LocalAddr() net.Addr                 { return hubTestAddr("local") }
+func (c *hubTestConn) RemoteAddr() net.Addr                { return hubTestAddr("remote") }
+func (c *hubTestConn) SetTimeout(nsec int64) os.Error      { return nil }
+func (c *hubTestConn) SetReadTimeout(nsec int64) os.Error  { return nil }
+func (c *hubTestConn) SetWriteTimeout(nsec int64) os.Error { c.writeTimeout = nsec; return nil }
+
+func (c *hubTestConn) waitClosed(t *testing.T) {
+	select {
+	case <-c.closed:
+	case <-time.After(1e9):
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestHubBroadcast(t *testing.T) {
+	h := NewHub()
+	conn1, tc1 := newHubTestConn(false)
+	conn2, tc2 := newHubTestConn(false)
+	conn3, tc3 := newHubTestConn(false)
+	c1 := h.Register(conn1)
+	c2 := h.Register(conn2)
+	c3 := h.Register(conn3)
+	c1.Join("a")
+	c2.Join("a")
+	c3.Join("b")
+
+	if n := h.Broadcast("a", []byte("hello")); n != 2 {
+		t.Errorf("Broadcast returned %d, want 2", n)
+	}
+	if n := h.Count("a"); n != 2 {
+		t.Errorf("Count(a)=%d, want 2", n)
+	}
+
+	c1.Close()
+	c2.Close()
+	c3.Close()
+	for i, tc := range []*hubTestConn{tc1, tc2, tc3} {
+		tc.waitClosed(t)
+		if tc.writeTimeout != 10e9 {
+			t.Errorf("conn %d write timeout=%d, want %d", i+1, tc.writeTimeout, int64(10e9))
+		}
+	}
+	if s := tc1.out.String(); s != "\x00hello\xff" {
+		t.Errorf("conn 1 got %q, want %q", s, "\x00hello\xff")
+	}
+	if s := tc2.out.String(); s != "\x00hello\xff" {
+		t.Errorf("conn 2 got %q, want %q", s, "\x00hello\xff")
+	}
+	if s := tc3.out.String(); s != "" {
+		t.Errorf("conn 3 got %q, want no messages", s)
+	}
+	if n := h.Count("a"); n != 0 {
+		t.Errorf("Count(a)=%d after close, want 0", n)
+	}
+}
+
+func TestHubEvict(t *testing.T) {
+	evicted := make(chan bool, 1)
+	h := NewHub()
+	h.SendBufferSize = 1
+	h.OnClose = func(c *HubConn, e bool) { evicted <- e }
+
+	conn, tc := newHubTestConn(true)
+	c := h.Register(conn)
+	c.Join("a")
+	for i := 0; i < 3; i++ {
+		h.Broadcast("a", []byte("hello"))
+	}
+
+	// The slow connection is closed without waiting for the blocked write.
+	tc.waitClosed(t)
+	select {
+	case e := <-evicted:
+		if !e {
+			t.Error("OnClose evicted=false, want true")
+		}
+	case <-time.After(1e9):
+		t.Fatal("OnClose not called")
+	}
+	if n := h.Count("a"); n != 0 {
+		t.Errorf("Count(a)=%d after eviction, want 0", n)
+	}
+	if c.Send([]byte("hello")) {
+		t.Error("Send to evicted connection returned true")
+	}
+}